@@ -0,0 +1,254 @@
+// Package ansi filters ANSI and VT100 escape sequences out of console
+// output, so logged boot consoles read as plain text and expected strings
+// are not split by colours or cursor movement. Sequences split across
+// reads are handled.
+package ansi
+
+import "unicode/utf8"
+
+// Config configures a Filter
+type Config struct {
+	// Render each line as a terminal shows it, applying carriage returns,
+	// backspaces, cursor movement within the line and erasing, instead of
+	// only dropping the sequences. Lines are output when they end.
+	Interpret bool
+}
+
+// Parser States
+const (
+	stateGround = iota
+	stateEscape
+	// Intermediate Bytes of a two Byte Sequence such as ESC ( B
+	stateEscapeInter
+	stateCSI
+	// OSC, DCS, SOS, PM and APC Strings, ended by ST or BEL
+	stateString
+	stateStringEscape
+)
+
+// maxParams bounds the parameter bytes kept of a CSI sequence
+const maxParams = 32
+
+// Filter removes escape sequences from a stream. It is not safe for
+// concurrent use.
+type Filter struct {
+	interpret bool
+	state     int
+	params    []byte
+	// Interpreted Line and the Cursor Column within it
+	line []rune
+	col  int
+	// Bytes of an incomplete UTF-8 Character
+	partial []byte
+}
+
+// NewFilter returns a Filter. cfg may be nil.
+func NewFilter(cfg *Config) *Filter {
+	f := &Filter{}
+	if cfg != nil {
+		f.interpret = cfg.Interpret
+	}
+	return f
+}
+
+// Filter appends the text of src to dst and returns it. Without Interpret
+// escape sequences and control characters other than tab, CR and LF are
+// dropped.
+func (f *Filter) Filter(dst, src []byte) []byte {
+	for _, c := range src {
+		switch f.state {
+		case stateEscape:
+			switch {
+			case c == '[':
+				f.state = stateCSI
+				f.params = f.params[:0]
+			case c == ']' || c == 'P' || c == 'X' || c == '^' || c == '_':
+				f.state = stateString
+			case c >= 0x20 && c <= 0x2F:
+				f.state = stateEscapeInter
+			default:
+				f.state = stateGround
+			}
+			continue
+		case stateEscapeInter:
+			if c < 0x20 || c > 0x2F {
+				f.state = stateGround
+			}
+			continue
+		case stateCSI:
+			switch {
+			case c == 0x1B:
+				f.state = stateEscape
+			case c >= 0x40 && c <= 0x7E:
+				f.state = stateGround
+				if f.interpret {
+					f.csi(c)
+				}
+			case c >= 0x20:
+				if len(f.params) < maxParams {
+					f.params = append(f.params, c)
+				}
+			default:
+				// Control Characters act within a Sequence
+				dst = f.control(dst, c)
+			}
+			continue
+		case stateString:
+			switch c {
+			case 0x07:
+				f.state = stateGround
+			case 0x1B:
+				f.state = stateStringEscape
+			}
+			continue
+		case stateStringEscape:
+			// ESC \ is the String Terminator
+			if c == '\\' {
+				f.state = stateGround
+			} else {
+				f.state = stateString
+			}
+			continue
+		}
+		if c == 0x1B {
+			f.state = stateEscape
+			continue
+		}
+		if c < 0x20 || c == 0x7F {
+			dst = f.control(dst, c)
+			continue
+		}
+		if !f.interpret {
+			dst = append(dst, c)
+			continue
+		}
+		f.partial = append(f.partial, c)
+		for len(f.partial) > 0 && utf8.FullRune(f.partial) {
+			r, size := utf8.DecodeRune(f.partial)
+			f.put(r)
+			f.partial = append(f.partial[:0], f.partial[size:]...)
+		}
+	}
+	return dst
+}
+
+// control handles a control character
+func (f *Filter) control(dst []byte, c byte) []byte {
+	if !f.interpret {
+		switch c {
+		case '\t', '\r', '\n':
+			dst = append(dst, c)
+		}
+		return dst
+	}
+	switch c {
+	case '\n':
+		dst = f.Flush(dst)
+		dst = append(dst, '\n')
+	case '\r':
+		f.col = 0
+	case '\b':
+		if f.col > 0 {
+			f.col--
+		}
+	case '\t':
+		f.col = (f.col/8 + 1) * 8
+	}
+	return dst
+}
+
+// put writes r at the cursor
+func (f *Filter) put(r rune) {
+	for len(f.line) < f.col {
+		f.line = append(f.line, ' ')
+	}
+	if f.col < len(f.line) {
+		f.line[f.col] = r
+	} else {
+		f.line = append(f.line, r)
+	}
+	f.col++
+}
+
+// csi applies the sequence ending in final to the line; sequences that do
+// not act within one line, such as colours, are ignored
+func (f *Filter) csi(final byte) {
+	n := 0
+	for _, c := range f.params {
+		if c < '0' || c > '9' {
+			break
+		}
+		n = n*10 + int(c-'0')
+		if n > 1000 {
+			n = 1000
+		}
+	}
+	count := n
+	if count == 0 {
+		count = 1
+	}
+	switch final {
+	case 'C':
+		f.col += count
+	case 'D':
+		f.col -= count
+		if f.col < 0 {
+			f.col = 0
+		}
+	case 'G':
+		f.col = count - 1
+	case 'K':
+		switch n {
+		case 0:
+			if f.col < len(f.line) {
+				f.line = f.line[:f.col]
+			}
+		case 1:
+			for i := 0; i <= f.col && i < len(f.line); i++ {
+				f.line[i] = ' '
+			}
+		case 2:
+			f.line = f.line[:0]
+		}
+	case 'P':
+		if f.col < len(f.line) {
+			end := f.col + count
+			if end > len(f.line) {
+				end = len(f.line)
+			}
+			f.line = append(f.line[:f.col], f.line[end:]...)
+		}
+	case 'X':
+		for i := f.col; i < f.col+count && i < len(f.line); i++ {
+			f.line[i] = ' '
+		}
+	case '@':
+		if f.col < len(f.line) {
+			blanks := make([]rune, count)
+			for i := range blanks {
+				blanks[i] = ' '
+			}
+			f.line = append(f.line[:f.col], append(blanks, f.line[f.col:]...)...)
+		}
+	}
+}
+
+// Flush appends the line being interpreted to dst, without a line ending,
+// and starts a new one
+func (f *Filter) Flush(dst []byte) []byte {
+	for _, r := range f.line {
+		dst = append(dst, string(r)...)
+	}
+	f.line = f.line[:0]
+	f.col = 0
+	return dst
+}
+
+// Reset drops a partial sequence and line
+func (f *Filter) Reset() {
+	f.state = stateGround
+	f.params = f.params[:0]
+	f.line = f.line[:0]
+	f.col = 0
+	f.partial = f.partial[:0]
+}
@@ -0,0 +1,82 @@
+package ansi
+
+import (
+	"io"
+	"sync"
+
+	"github.com/packing/xserial"
+)
+
+// Writer filters what is written to it before passing it on, as for a
+// console log
+type Writer struct {
+	w   io.Writer
+	mx  sync.Mutex
+	f   *Filter
+	buf []byte
+}
+
+// NewWriter returns a Writer to w. cfg may be nil.
+func NewWriter(w io.Writer, cfg *Config) *Writer {
+	return &Writer{w: w, f: NewFilter(cfg)}
+}
+
+// Write filters p and writes the text to the underlying Writer, returning
+// len(p) once that succeeds
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mx.Lock()
+	defer w.mx.Unlock()
+	w.buf = w.f.Filter(w.buf[:0], p)
+	if len(w.buf) == 0 {
+		return len(p), nil
+	}
+	if _, err := w.w.Write(w.buf); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Flush writes the line held by Interpret
+func (w *Writer) Flush() error {
+	w.mx.Lock()
+	defer w.mx.Unlock()
+	w.buf = w.f.Flush(w.buf[:0])
+	if len(w.buf) == 0 {
+		return nil
+	}
+	_, err := w.w.Write(w.buf)
+	return err
+}
+
+// Port strips escape sequences from what is read from the wrapped Port,
+// for matching expected text. Writes pass unchanged.
+type Port struct {
+	xserial.Port
+	mx sync.Mutex
+	f  *Filter
+}
+
+// NewPort wraps p
+func NewPort(p xserial.Port) *Port {
+	return &Port{Port: p, f: NewFilter(nil)}
+}
+
+// Unwrap returns the wrapped Port
+func (p *Port) Unwrap() xserial.Port {
+	return p.Port
+}
+
+// Read reads into b and strips it, reading again while all that arrived
+// was escape sequences. Stripping never grows the text, so nothing is
+// held back from the next Read.
+func (p *Port) Read(b []byte) (int, error) {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	for {
+		n, err := p.Port.Read(b)
+		out := p.f.Filter(b[:0], b[:n])
+		if len(out) > 0 || n == 0 || err != nil {
+			return len(out), err
+		}
+	}
+}
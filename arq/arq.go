@@ -0,0 +1,347 @@
+// Package arq makes a lossy link reliable, as radio modems need: each
+// frame carries a sequence number and CRC, is acknowledged by the
+// receiver, and is sent again with growing timeouts until it is, while
+// retransmissions already delivered are dropped. It runs stop-and-wait
+// over any Framer. A Link's first Send resets the peer's duplicate
+// detection, so a restarted side is never taken for a retransmission.
+package arq
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/packing/xserial"
+	"github.com/packing/xserial/checksum"
+)
+
+// Frame Types
+const (
+	typeData = 0x01
+	typeAck  = 0x02
+	typeNak  = 0x03
+	// Starts a session: the receiver forgets the last sequence number seen
+	typeSync = 0x04
+)
+
+// Type, Sequence and CRC-16
+const overhead = 4
+
+var (
+	// ErrNoAck - the peer did not acknowledge a frame after all retries
+	ErrNoAck = errors.New("arq: frame not acknowledged")
+	// ErrClosed - the Link has been closed
+	ErrClosed = errors.New("arq: link closed")
+)
+
+// Framer sends and receives whole frames, such as hdlc.Conn
+type Framer interface {
+	WriteFrame(payload []byte) error
+	ReadFrameContext(ctx context.Context) ([]byte, error)
+}
+
+// portFramer frames a Port with an encoder and a FrameDecoder
+type portFramer struct {
+	p      xserial.Port
+	encode func([]byte) []byte
+	rd     *xserial.FrameReader
+}
+
+// NewFramer returns a Framer over p, wrapping each frame with encode and
+// cutting received ones with dec, such as slip.Encode and slip.NewDecoder
+func NewFramer(p xserial.Port, encode func([]byte) []byte, dec xserial.FrameDecoder) Framer {
+	return &portFramer{p: p, encode: encode, rd: xserial.NewFrameReader(p, dec)}
+}
+
+func (f *portFramer) WriteFrame(payload []byte) error {
+	_, err := f.p.Write(f.encode(payload))
+	return err
+}
+
+func (f *portFramer) ReadFrameContext(ctx context.Context) ([]byte, error) {
+	return f.rd.ReadFrameContext(ctx)
+}
+
+// Config configures a Link
+type Config struct {
+	// Time to wait for the first acknowledgement, defaults to 500ms
+	Timeout time.Duration
+	// Each retransmission waits Backoff times longer, defaults to 2, up
+	// to MaxTimeout, which defaults to 8 times Timeout
+	Backoff    float64
+	MaxTimeout time.Duration
+	// Retransmissions before Send gives up, defaults to 5; negative for
+	// none
+	Retries int
+	// Frames received and not yet taken by Receive, defaults to 16. Frames
+	// beyond are not acknowledged, so the peer sends them again later.
+	Queue int
+	// Optional - Called with the error that stopped the receiver
+	OnError func(err error)
+}
+
+// Link is a reliable link over a Framer. Send and Receive may be called
+// concurrently, a Send at a time.
+type Link struct {
+	f    Framer
+	cfg  Config
+	crc  *checksum.CRC16
+	recv chan []byte
+	done chan struct{}
+	stop context.CancelFunc
+
+	sendMx sync.Mutex
+	seq    byte
+	synced bool
+
+	mx sync.Mutex
+	// Sequence Number waiting for its Acknowledgement and where to signal
+	// ACK (true) or NAK (false) for it
+	waiting  bool
+	waitSeq  byte
+	ack      chan bool
+	haveLast bool
+	lastSeq  byte
+	err      error
+}
+
+// New starts a Link over f. Frames arriving are received in the
+// background until Close. cfg may be nil.
+func New(f Framer, cfg *Config) *Link {
+	var c Config
+	if cfg != nil {
+		c = *cfg
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = 500 * time.Millisecond
+	}
+	if c.Backoff < 1 {
+		c.Backoff = 2
+	}
+	if c.MaxTimeout <= 0 {
+		c.MaxTimeout = 8 * c.Timeout
+	}
+	if c.Retries < 0 {
+		c.Retries = 0
+	} else if c.Retries == 0 {
+		c.Retries = 5
+	}
+	if c.Queue <= 0 {
+		c.Queue = 16
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	l := &Link{
+		f:    f,
+		cfg:  c,
+		crc:  checksum.CCITT,
+		recv: make(chan []byte, c.Queue),
+		done: make(chan struct{}),
+		stop: cancel,
+		ack:  make(chan bool, 1),
+	}
+	go l.receive(ctx)
+	return l
+}
+
+// frame returns payload as a frame of typ with seq and its CRC
+func (l *Link) frame(typ, seq byte, payload []byte) []byte {
+	b := make([]byte, 2, len(payload)+overhead)
+	b[0], b[1] = typ, seq
+	b = append(b, payload...)
+	var crc [2]byte
+	binary.BigEndian.PutUint16(crc[:], l.crc.Checksum(b))
+	return append(b, crc[:]...)
+}
+
+// Send delivers payload to the peer, returning once it is acknowledged
+func (l *Link) Send(ctx context.Context, payload []byte) error {
+	l.sendMx.Lock()
+	defer l.sendMx.Unlock()
+	// A restarted Peer must not look like a Retransmission
+	if !l.synced {
+		if err := l.transmit(ctx, typeSync, nil); err != nil {
+			return err
+		}
+		l.synced = true
+	}
+	return l.transmit(ctx, typeData, payload)
+}
+
+// transmit sends a frame of typ with the next sequence number until it is
+// acknowledged. sendMx must be held.
+func (l *Link) transmit(ctx context.Context, typ byte, payload []byte) error {
+	l.seq++
+	seq := l.seq
+	b := l.frame(typ, seq, payload)
+
+	l.mx.Lock()
+	if l.err != nil {
+		l.mx.Unlock()
+		return l.err
+	}
+	l.waiting, l.waitSeq = true, seq
+	select {
+	case <-l.ack:
+	default:
+	}
+	l.mx.Unlock()
+	defer func() {
+		l.mx.Lock()
+		l.waiting = false
+		l.mx.Unlock()
+	}()
+
+	timeout := l.cfg.Timeout
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	<-timer.C
+	for attempt := 0; attempt <= l.cfg.Retries; attempt++ {
+		if err := l.f.WriteFrame(b); err != nil {
+			return err
+		}
+		timer.Reset(timeout)
+		select {
+		case ok := <-l.ack:
+			if !timer.Stop() {
+				<-timer.C
+			}
+			if ok {
+				return nil
+			}
+			// NAK - send again at once
+			continue
+		case <-timer.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-l.done:
+			return l.Err()
+		}
+		timeout = time.Duration(float64(timeout) * l.cfg.Backoff)
+		if timeout > l.cfg.MaxTimeout {
+			timeout = l.cfg.MaxTimeout
+		}
+	}
+	return ErrNoAck
+}
+
+// Receive returns the next payload from the peer
+func (l *Link) Receive(ctx context.Context) ([]byte, error) {
+	select {
+	case b := <-l.recv:
+		return b, nil
+	default:
+	}
+	select {
+	case b := <-l.recv:
+		return b, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-l.done:
+		return nil, l.Err()
+	}
+}
+
+// receive handles incoming frames until ctx is done or f fails
+func (l *Link) receive(ctx context.Context) {
+	defer close(l.done)
+	for {
+		b, err := l.f.ReadFrameContext(ctx)
+		if err == xserial.ErrReadTimeout {
+			if err = ctx.Err(); err == nil {
+				continue
+			}
+		}
+		if err != nil {
+			l.fail(err)
+			return
+		}
+		if len(b) < overhead {
+			continue
+		}
+		n := len(b) - 2
+		typ, seq := b[0], b[1]
+		if l.crc.Checksum(b[:n]) != binary.BigEndian.Uint16(b[n:]) {
+			if typ == typeData {
+				l.reply(typeNak, seq)
+			}
+			continue
+		}
+		switch typ {
+		case typeData:
+			l.data(seq, b[2:n])
+		case typeSync:
+			l.mx.Lock()
+			l.haveLast = false
+			l.mx.Unlock()
+			l.reply(typeAck, seq)
+		case typeAck, typeNak:
+			l.mx.Lock()
+			if l.waiting && seq == l.waitSeq {
+				select {
+				case l.ack <- typ == typeAck:
+				default:
+				}
+			}
+			l.mx.Unlock()
+		}
+	}
+}
+
+// data queues a payload unless it was delivered already, and acknowledges
+// it once queued
+func (l *Link) data(seq byte, payload []byte) {
+	l.mx.Lock()
+	dup := l.haveLast && seq == l.lastSeq
+	l.mx.Unlock()
+	if !dup {
+		select {
+		case l.recv <- append([]byte(nil), payload...):
+		default:
+			// Queue full - without an ACK the Peer tries again later
+			return
+		}
+		l.mx.Lock()
+		l.haveLast, l.lastSeq = true, seq
+		l.mx.Unlock()
+	}
+	l.reply(typeAck, seq)
+}
+
+// reply sends an ACK or NAK, whose loss the peer's retries cover
+func (l *Link) reply(typ, seq byte) {
+	l.f.WriteFrame(l.frame(typ, seq, nil))
+}
+
+// fail records err as ending the Link
+func (l *Link) fail(err error) {
+	l.mx.Lock()
+	if l.err == nil {
+		l.err = err
+	}
+	err = l.err
+	l.mx.Unlock()
+	if err != ErrClosed && l.cfg.OnError != nil {
+		l.cfg.OnError(err)
+	}
+}
+
+// Err returns the error that stopped the Link, nil while it runs
+func (l *Link) Err() error {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	return l.err
+}
+
+// Close stops the Link, leaving the Framer and its Port open
+func (l *Link) Close() error {
+	l.mx.Lock()
+	if l.err == nil {
+		l.err = ErrClosed
+	}
+	l.mx.Unlock()
+	l.stop()
+	<-l.done
+	return nil
+}
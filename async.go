@@ -0,0 +1,108 @@
+package xserial
+
+import (
+	"context"
+	"sync"
+)
+
+// AsyncConfig configures the background reader started by OnData
+type AsyncConfig struct {
+	// Size of the Read Buffer, defaults to 4096 bytes
+	BufferSize int
+	// Called once with the error that stopped reading (not for timeouts)
+	OnError func(err error)
+}
+
+// AsyncReader reads a Port in its own goroutine and hands every received
+// chunk to a callback
+type AsyncReader struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+	mx     sync.Mutex
+	err    error
+}
+
+// OnData starts reading p in the background and calls fn with each chunk
+// received. The slice passed to fn is reused once fn returns. cfg may be nil.
+func OnData(p Port, fn func([]byte), cfg *AsyncConfig) *AsyncReader {
+	var c AsyncConfig
+	if cfg != nil {
+		c = *cfg
+	}
+	if c.BufferSize <= 0 {
+		c.BufferSize = 4096
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &AsyncReader{cancel: cancel, done: make(chan struct{})}
+	go r.run(ctx, p, fn, c)
+	return r
+}
+
+func (r *AsyncReader) run(ctx context.Context, p Port, fn func([]byte), c AsyncConfig) {
+	defer close(r.done)
+	buf := make([]byte, c.BufferSize)
+	pollable := true
+	for {
+		// Wait for Data so Ports without a ReadTimeout don't spin
+		if pollable {
+			if _, err := Select(ctx, p); err == ErrNotPollable {
+				pollable = false
+			} else if err != nil {
+				r.stop(ctx, err, c)
+				return
+			}
+		}
+		n, err := p.Read(buf)
+		if n > 0 {
+			fn(buf[:n])
+		}
+		if err != nil && err != ErrReadTimeout {
+			r.stop(ctx, err, c)
+			return
+		}
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+func (r *AsyncReader) stop(ctx context.Context, err error, c AsyncConfig) {
+	// Errors caused by Stop are not reported
+	if ctx.Err() != nil {
+		return
+	}
+	r.mx.Lock()
+	r.err = err
+	r.mx.Unlock()
+	if c.OnError != nil {
+		c.OnError(err)
+	}
+}
+
+// Stop ends background reading and waits for the reader goroutine to exit
+func (r *AsyncReader) Stop() {
+	r.cancel()
+	<-r.done
+}
+
+// stopClosing ends background reading of p and closes p. Closing before
+// waiting ends a Read blocked in a Port that can be neither polled nor
+// interrupted, which Stop alone would wait on forever.
+func (r *AsyncReader) stopClosing(p Port) error {
+	r.cancel()
+	err := p.Close()
+	<-r.done
+	return err
+}
+
+// Done is closed when the reader goroutine has exited
+func (r *AsyncReader) Done() <-chan struct{} {
+	return r.done
+}
+
+// Err returns the error that stopped reading, if any
+func (r *AsyncReader) Err() error {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	return r.err
+}
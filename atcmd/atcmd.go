@@ -0,0 +1,405 @@
+// Package atcmd runs AT command sessions with modems: it sends commands,
+// collects their response lines up to the final result, hands unsolicited
+// result codes to handlers and serialises concurrent users of one modem.
+package atcmd
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/packing/xserial"
+)
+
+var (
+	// ErrClosed - the Conn has been closed
+	ErrClosed = errors.New("atcmd: closed")
+	// ErrNoResponse - the modem sent no final result in time
+	ErrNoResponse = errors.New("atcmd: no response")
+)
+
+// Text Entry Characters of two stage Commands such as AT+CMGS
+const (
+	ctrlZ = 0x1A
+	esc   = 0x1B
+)
+
+// Error is a final result other than OK and CONNECT
+type Error struct {
+	// Result Line such as "ERROR", "NO CARRIER" or "+CME ERROR: 10"
+	Result string
+	// Number of a +CME or +CMS ERROR, -1 for other results and errors
+	// reported as text
+	Code int
+}
+
+func (e *Error) Error() string {
+	return "atcmd: " + e.Result
+}
+
+// Response is what a command returned before its final result
+type Response struct {
+	// Information Lines without Echo and blank Lines
+	Lines []string
+	// Final Result such as "OK" or "CONNECT 115200"
+	Final string
+}
+
+// Value returns the text after prefix and its colon of the first line with
+// that prefix, so Value("+CSQ") of "+CSQ: 20,99" is "20,99"
+func (r *Response) Value(prefix string) (string, bool) {
+	for _, l := range r.Lines {
+		if strings.HasPrefix(l, prefix+":") {
+			return strings.TrimSpace(l[len(prefix)+1:]), true
+		}
+	}
+	return "", false
+}
+
+// Config configures a Conn
+type Config struct {
+	// How long a command waits for its final result when ctx carries no
+	// deadline, defaults to 5 seconds
+	Timeout time.Duration
+	// Optional - Called for unsolicited lines no handler claims
+	OnUnsolicited func(line string)
+}
+
+// handler takes unsolicited result codes starting with prefix and the
+// body lines following them
+type handler struct {
+	prefix string
+	body   int
+	fn     func(lines []string)
+}
+
+// command is the command in flight
+type command struct {
+	text   string
+	prefix string
+	lines  []string
+	final  string
+	err    error
+	// Wait for the "> " Prompt of a two stage Command
+	wantPrompt bool
+	prompt     chan struct{}
+	done       chan struct{}
+}
+
+// urc is an unsolicited result code waiting for its body lines
+type urc struct {
+	h     *handler
+	lines []string
+}
+
+// Conn is an AT command session on a Port
+type Conn struct {
+	p      xserial.Port
+	cfg    Config
+	reader *xserial.AsyncReader
+	// One Command in flight at a time
+	cmdMx  sync.Mutex
+	events chan func()
+	done   chan struct{}
+
+	mx       sync.Mutex
+	buf      []byte
+	cur      *command
+	urc      *urc
+	handlers []*handler
+	closed   bool
+	err      error
+}
+
+// New starts an AT session on p. p stays owned by the caller and is left
+// open by Close, so it can go on in data mode. cfg may be nil.
+func New(p xserial.Port, cfg *Config) *Conn {
+	var c Config
+	if cfg != nil {
+		c = *cfg
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = 5 * time.Second
+	}
+	conn := &Conn{
+		p:      p,
+		cfg:    c,
+		events: make(chan func(), 64),
+		done:   make(chan struct{}),
+	}
+	go conn.dispatch()
+	conn.mx.Lock()
+	conn.reader = xserial.OnData(p, conn.receive, &xserial.AsyncConfig{OnError: conn.shutdown})
+	conn.mx.Unlock()
+	return conn
+}
+
+// Handle calls fn with unsolicited result codes starting with prefix, such
+// as "RING" or "+CMTI:", and the body lines that follow them, such as 1 for
+// the PDU of "+CMT:". Handlers run one at a time on their own goroutine and
+// may issue commands. A later Handle for the same prefix replaces fn.
+func (c *Conn) Handle(prefix string, body int, fn func(lines []string)) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	for _, h := range c.handlers {
+		if h.prefix == prefix {
+			h.body, h.fn = body, fn
+			return
+		}
+	}
+	c.handlers = append(c.handlers, &handler{prefix: prefix, body: body, fn: fn})
+}
+
+// Command sends cmd, such as "AT+CSQ", and returns its response. Concurrent
+// callers take turns. A final result other than OK or CONNECT is returned
+// as an *Error together with the response.
+func (c *Conn) Command(ctx context.Context, cmd string) (*Response, error) {
+	return c.run(ctx, cmd, nil)
+}
+
+// CommandPrompt runs a two stage command such as AT+CMGS, sending data
+// once the modem prompts for it and ending it with Ctrl-Z
+func (c *Conn) CommandPrompt(ctx context.Context, cmd string, data []byte) (*Response, error) {
+	if data == nil {
+		data = []byte{}
+	}
+	return c.run(ctx, cmd, data)
+}
+
+func (c *Conn) run(ctx context.Context, cmd string, data []byte) (*Response, error) {
+	c.cmdMx.Lock()
+	defer c.cmdMx.Unlock()
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.cfg.Timeout)
+		defer cancel()
+	}
+	cm := &command{
+		text:       cmd,
+		prefix:     responsePrefix(cmd),
+		wantPrompt: data != nil,
+		prompt:     make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	c.mx.Lock()
+	if c.closed {
+		c.mx.Unlock()
+		return nil, ErrClosed
+	}
+	c.cur = cm
+	c.mx.Unlock()
+	defer func() {
+		c.mx.Lock()
+		if c.cur == cm {
+			c.cur = nil
+		}
+		c.mx.Unlock()
+	}()
+
+	if _, err := c.p.Write([]byte(cmd + "\r")); err != nil {
+		return nil, err
+	}
+	if data != nil {
+		select {
+		case <-cm.prompt:
+			if _, err := c.p.Write(append(data, ctrlZ)); err != nil {
+				return nil, err
+			}
+		case <-cm.done:
+		case <-ctx.Done():
+			// Leave Text Entry so the Modem takes Commands again
+			c.p.Write([]byte{esc})
+			return nil, ErrNoResponse
+		case <-c.done:
+			return nil, c.closedErr()
+		}
+	}
+	select {
+	case <-cm.done:
+		return &Response{Lines: cm.lines, Final: cm.final}, cm.err
+	case <-ctx.Done():
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, ErrNoResponse
+		}
+		return nil, ctx.Err()
+	case <-c.done:
+		return nil, c.closedErr()
+	}
+}
+
+// responsePrefix returns the prefix of a command's own information lines,
+// "+CREG" for "AT+CREG?", so they are not taken for unsolicited codes
+func responsePrefix(cmd string) string {
+	if len(cmd) < 3 || !strings.EqualFold(cmd[:2], "AT") {
+		return ""
+	}
+	name := cmd[2:]
+	if name[0] != '+' && name[0] != '^' && name[0] != '$' && name[0] != '%' {
+		return ""
+	}
+	if i := strings.IndexAny(name, "=?"); i >= 0 {
+		name = name[:i]
+	}
+	return strings.ToUpper(name)
+}
+
+// final parses a final result line
+func final(line string) (ok bool, err error) {
+	switch {
+	case line == "OK" || line == "CONNECT" || strings.HasPrefix(line, "CONNECT "):
+		return true, nil
+	case line == "ERROR" || line == "NO CARRIER" || line == "BUSY" ||
+		line == "NO ANSWER" || line == "NO DIALTONE" || line == "NO DIAL TONE":
+		return true, &Error{Result: line, Code: -1}
+	case strings.HasPrefix(line, "+CME ERROR:") || strings.HasPrefix(line, "+CMS ERROR:"):
+		code, err := strconv.Atoi(strings.TrimSpace(line[11:]))
+		if err != nil {
+			code = -1
+		}
+		return true, &Error{Result: line, Code: code}
+	}
+	return false, nil
+}
+
+// receive splits data into lines
+func (c *Conn) receive(data []byte) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	for _, b := range data {
+		switch {
+		case b == '\r' || b == '\n':
+			if len(c.buf) > 0 {
+				c.line(strings.TrimSpace(string(c.buf)))
+				c.buf = c.buf[:0]
+			}
+		case b == '>' && len(c.buf) == 0 && c.cur != nil && c.cur.wantPrompt:
+			// The Prompt has no Line End
+			c.cur.wantPrompt = false
+			close(c.cur.prompt)
+		default:
+			c.buf = append(c.buf, b)
+		}
+	}
+}
+
+// line handles one received line, with mx held
+func (c *Conn) line(l string) {
+	if l == "" {
+		return
+	}
+	if c.urc != nil {
+		c.urc.lines = append(c.urc.lines, l)
+		if len(c.urc.lines) > c.urc.h.body {
+			c.deliver(c.urc.h, c.urc.lines)
+			c.urc = nil
+		}
+		return
+	}
+	cm := c.cur
+	h := c.match(l)
+	if cm != nil && (h == nil || cm.prefix != "" && strings.HasPrefix(l, cm.prefix)) {
+		if l == cm.text {
+			// Echo
+			return
+		}
+		if ok, err := final(l); ok {
+			cm.final, cm.err = l, err
+			c.cur = nil
+			close(cm.done)
+			return
+		}
+		cm.lines = append(cm.lines, l)
+		return
+	}
+	if h == nil {
+		if fn := c.cfg.OnUnsolicited; fn != nil {
+			c.post(func() { fn(l) })
+		}
+		return
+	}
+	if h.body > 0 {
+		c.urc = &urc{h: h, lines: []string{l}}
+		return
+	}
+	c.deliver(h, []string{l})
+}
+
+// match returns the handler for an unsolicited line
+func (c *Conn) match(l string) *handler {
+	for _, h := range c.handlers {
+		if strings.HasPrefix(l, h.prefix) {
+			return h
+		}
+	}
+	return nil
+}
+
+func (c *Conn) deliver(h *handler, lines []string) {
+	fn := h.fn
+	c.post(func() { fn(lines) })
+}
+
+// post queues a handler call, dropping it if the handlers fall far behind
+func (c *Conn) post(fn func()) {
+	select {
+	case c.events <- fn:
+	default:
+	}
+}
+
+// dispatch runs handlers until the Conn ends
+func (c *Conn) dispatch() {
+	for {
+		select {
+		case fn := <-c.events:
+			fn()
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (c *Conn) closedErr() error {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	if c.err != nil {
+		return c.err
+	}
+	return ErrClosed
+}
+
+// shutdown ends the session
+func (c *Conn) shutdown(err error) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	if c.closed {
+		return
+	}
+	c.closed = true
+	c.err = err
+	close(c.done)
+}
+
+// Err returns the error that stopped the Conn, if any
+func (c *Conn) Err() error {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	return c.err
+}
+
+// Close ends the session, failing a command in flight, and stops reading
+// the Port
+func (c *Conn) Close() error {
+	c.mx.Lock()
+	closed := c.closed
+	c.mx.Unlock()
+	if closed {
+		return ErrClosed
+	}
+	c.shutdown(nil)
+	c.reader.Stop()
+	return nil
+}
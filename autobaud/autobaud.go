@@ -0,0 +1,130 @@
+// Package autobaud locks a receiver onto the baud rate of a sender that
+// repeats a known sync pattern, such as the break and 0x55 sync field of
+// each LIN header or the probe characters of an auto-bauding bootloader.
+//
+// The OS gives no timing of the bits received, so the rate is found by
+// listening at each candidate rate in turn: only at the sender's rate does
+// the pattern decode intact.
+package autobaud
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"time"
+
+	"github.com/packing/xserial"
+)
+
+// DefaultRates are tried in this order when Config.Rates is empty, the LIN
+// rates first
+var DefaultRates = []int{19200, 9600, 10400, 4800, 2400, 1200, 38400, 57600, 115200}
+
+var (
+	// ErrNotDetected - the sync pattern decoded at none of the rates
+	ErrNotDetected = errors.New("autobaud: baud rate not detected")
+	// ErrNoBaudSetter - the Port cannot change its baud rate while open
+	ErrNoBaudSetter = errors.New("autobaud: port cannot change baud rate")
+)
+
+// Config configures Detect
+type Config struct {
+	// Candidate rates in the order tried, defaults to DefaultRates
+	Rates []int
+	// Pattern the sender repeats, defaults to the LIN sync field 0x55. A
+	// LIN break reads as a zero byte, so {0x00, 0x55} also requires it.
+	Sync []byte
+	// How long to listen at each rate, defaults to 250ms; at least the
+	// time between two patterns
+	Window time.Duration
+	// Times Sync must be received at a rate to lock, defaults to 2
+	Confirm int
+	// Times to go through Rates, defaults to 1; negative for until ctx is
+	// done
+	Rounds int
+}
+
+// Detect listens on p at each rate until the sync pattern is received
+// Confirm times, and returns that rate with p left set to it. p must be a
+// BaudSetter, or wrap one. cfg may be nil.
+func Detect(ctx context.Context, p xserial.Port, cfg *Config) (int, error) {
+	var c Config
+	if cfg != nil {
+		c = *cfg
+	}
+	if len(c.Rates) == 0 {
+		c.Rates = DefaultRates
+	}
+	if len(c.Sync) == 0 {
+		c.Sync = []byte{0x55}
+	}
+	if c.Window <= 0 {
+		c.Window = 250 * time.Millisecond
+	}
+	if c.Confirm <= 0 {
+		c.Confirm = 2
+	}
+	if c.Rounds == 0 {
+		c.Rounds = 1
+	}
+	bs, ok := xserial.As[xserial.BaudSetter](p)
+	if !ok {
+		return 0, ErrNoBaudSetter
+	}
+	buf := make([]byte, 256)
+	for round := 0; c.Rounds < 0 || round < c.Rounds; round++ {
+		for _, baud := range c.Rates {
+			if err := bs.SetBaudRate(baud); err != nil {
+				return 0, err
+			}
+			// Bytes received at the previous Rate are Garbage
+			p.Flush()
+			ok, err := listen(ctx, p, buf, &c)
+			if err != nil {
+				return 0, err
+			}
+			if ok {
+				return baud, nil
+			}
+		}
+	}
+	return 0, ErrNotDetected
+}
+
+// listen reports whether Sync arrives Confirm times within the Window
+func listen(ctx context.Context, p xserial.Port, buf []byte, c *Config) (bool, error) {
+	wctx, cancel := context.WithTimeout(ctx, c.Window)
+	defer cancel()
+	var seen []byte
+	found := 0
+	for {
+		if err := ctx.Err(); err != nil {
+			return false, err
+		}
+		if wctx.Err() != nil {
+			return false, nil
+		}
+		n, err := xserial.ReadContext(wctx, p, buf)
+		if err != nil && err != xserial.ErrReadTimeout && wctx.Err() == nil {
+			return false, err
+		}
+		if n == 0 {
+			continue
+		}
+		seen = append(seen, buf[:n]...)
+		for {
+			i := bytes.Index(seen, c.Sync)
+			if i < 0 {
+				break
+			}
+			seen = seen[i+len(c.Sync):]
+			if found++; found >= c.Confirm {
+				return true, nil
+			}
+		}
+		// Keep a Tail long enough for a Match split across Reads
+		if len(seen) > len(c.Sync) {
+			seen = append(seen[:0], seen[len(seen)-len(c.Sync)+1:]...)
+		}
+	}
+}
@@ -0,0 +1,344 @@
+// Package bridge pumps bytes between a serial Port and network clients,
+// for the simple "serial device on the network" deployments.
+package bridge
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/packing/xserial"
+)
+
+var (
+	// ErrBusy - another client is connected and Takeover is off
+	ErrBusy = errors.New("bridge: another client is connected")
+	// ErrClosed - the Bridge has been closed
+	ErrClosed = errors.New("bridge: closed")
+	// ErrIdle - the connection was closed after IdleTimeout without traffic
+	ErrIdle = errors.New("bridge: idle timeout")
+)
+
+// Config configures a Bridge
+type Config struct {
+	// Close a connection with no traffic either way for this long, zero
+	// keeps idle connections
+	IdleTimeout time.Duration
+	// Give up on a client that does not take serial data for this long,
+	// defaults to 10 seconds
+	WriteTimeout time.Duration
+	// Delay before dialling again after a connection fails or ends,
+	// defaults to 1 second and doubles up to MaxRetryInterval
+	RetryInterval time.Duration
+	// Defaults to 30 seconds
+	MaxRetryInterval time.Duration
+	// A new client replaces the connected one instead of being refused
+	Takeover bool
+	// Optional - Called as clients come and go
+	OnConnect    func(addr net.Addr)
+	OnDisconnect func(addr net.Addr, err error)
+}
+
+// Counters are the running totals of a Bridge
+type Counters struct {
+	// Bytes from the Port sent to clients
+	ToNetwork uint64
+	// Bytes from clients written to the Port
+	ToSerial uint64
+	// Bytes from the Port that arrived with no client connected
+	Dropped uint64
+	// Connections served
+	Connections uint64
+	// Datagrams sent and received by a UDPBridge
+	DatagramsOut uint64
+	DatagramsIn  uint64
+}
+
+// Bridge connects one Port to one network client at a time. Serial data
+// arriving while no client is connected is dropped.
+type Bridge struct {
+	// Counters - First so 64-bit Atomics stay Aligned on 32-bit Platforms
+	counters Counters
+	p        xserial.Port
+	cfg      Config
+	reader   *xserial.AsyncReader
+
+	mx       sync.Mutex
+	conn     net.Conn
+	activity time.Time
+	closed   bool
+}
+
+// New starts reading p for a Bridge. p stays owned by the caller; Close
+// stops reading but leaves it open. cfg may be nil.
+func New(p xserial.Port, cfg *Config) *Bridge {
+	var c Config
+	if cfg != nil {
+		c = *cfg
+	}
+	if c.WriteTimeout <= 0 {
+		c.WriteTimeout = 10 * time.Second
+	}
+	if c.RetryInterval <= 0 {
+		c.RetryInterval = time.Second
+	}
+	if c.MaxRetryInterval <= 0 {
+		c.MaxRetryInterval = 30 * time.Second
+	}
+	if c.MaxRetryInterval < c.RetryInterval {
+		c.MaxRetryInterval = c.RetryInterval
+	}
+	b := &Bridge{p: p, cfg: c}
+	b.reader = xserial.OnData(p, b.fromSerial, nil)
+	return b
+}
+
+// Counters returns a snapshot of the running totals
+func (b *Bridge) Counters() Counters {
+	return Counters{
+		ToNetwork:   atomic.LoadUint64(&b.counters.ToNetwork),
+		ToSerial:    atomic.LoadUint64(&b.counters.ToSerial),
+		Dropped:     atomic.LoadUint64(&b.counters.Dropped),
+		Connections: atomic.LoadUint64(&b.counters.Connections),
+	}
+}
+
+// Err returns the error that stopped reading the Port, if any
+func (b *Bridge) Err() error {
+	return b.reader.Err()
+}
+
+// fromSerial forwards Port data to the client, on the reader goroutine
+func (b *Bridge) fromSerial(data []byte) {
+	b.mx.Lock()
+	conn := b.conn
+	if conn != nil {
+		b.activity = time.Now()
+	}
+	b.mx.Unlock()
+	if conn == nil {
+		atomic.AddUint64(&b.counters.Dropped, uint64(len(data)))
+		return
+	}
+	conn.SetWriteDeadline(time.Now().Add(b.cfg.WriteTimeout))
+	n, err := conn.Write(data)
+	atomic.AddUint64(&b.counters.ToNetwork, uint64(n))
+	if err != nil {
+		// The Client's Pump sees the Connection fail
+		conn.Close()
+	}
+}
+
+// attach makes conn the client, refusing or replacing a connected one
+func (b *Bridge) attach(conn net.Conn) error {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	if b.closed {
+		return ErrClosed
+	}
+	if b.conn != nil {
+		if !b.cfg.Takeover {
+			return ErrBusy
+		}
+		b.conn.Close()
+	}
+	b.conn = conn
+	b.activity = time.Now()
+	return nil
+}
+
+func (b *Bridge) detach(conn net.Conn) {
+	b.mx.Lock()
+	if b.conn == conn {
+		b.conn = nil
+	}
+	b.mx.Unlock()
+}
+
+// ServeConn pumps between the Port and conn until conn fails, goes idle or
+// ctx is done. conn is closed on return.
+func (b *Bridge) ServeConn(ctx context.Context, conn net.Conn) error {
+	if err := b.attach(conn); err != nil {
+		conn.Close()
+		return err
+	}
+	return b.serve(ctx, conn)
+}
+
+// serve pumps for the attached conn
+func (b *Bridge) serve(ctx context.Context, conn net.Conn) error {
+	atomic.AddUint64(&b.counters.Connections, 1)
+	if b.cfg.OnConnect != nil {
+		b.cfg.OnConnect(conn.RemoteAddr())
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-stop:
+		}
+	}()
+	idle := make(chan struct{})
+	if b.cfg.IdleTimeout > 0 {
+		go b.watchIdle(conn, stop, idle)
+	}
+
+	err := b.toSerial(conn)
+	b.detach(conn)
+	conn.Close()
+	select {
+	case <-idle:
+		err = ErrIdle
+	default:
+	}
+	if ctx.Err() != nil {
+		err = ctx.Err()
+	}
+	if b.cfg.OnDisconnect != nil {
+		b.cfg.OnDisconnect(conn.RemoteAddr(), err)
+	}
+	return err
+}
+
+// toSerial copies client data to the Port until the connection ends
+func (b *Bridge) toSerial(conn net.Conn) error {
+	buf := make([]byte, 4096)
+	for {
+		n, err := conn.Read(buf)
+		if n > 0 {
+			b.mx.Lock()
+			b.activity = time.Now()
+			b.mx.Unlock()
+			w, werr := b.p.Write(buf[:n])
+			atomic.AddUint64(&b.counters.ToSerial, uint64(w))
+			if werr != nil {
+				return werr
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// watchIdle closes conn once neither side has sent anything for IdleTimeout
+func (b *Bridge) watchIdle(conn net.Conn, stop, idle chan struct{}) {
+	t := time.NewTimer(b.cfg.IdleTimeout)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+		}
+		b.mx.Lock()
+		wait := time.Until(b.activity.Add(b.cfg.IdleTimeout))
+		b.mx.Unlock()
+		if wait <= 0 {
+			close(idle)
+			conn.Close()
+			return
+		}
+		t.Reset(wait)
+	}
+}
+
+// Serve accepts clients on l until ctx is done or l fails. Each is served
+// in turn; with Takeover a new client replaces the connected one. l is
+// closed on return.
+func (b *Bridge) Serve(ctx context.Context, l net.Listener) error {
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			l.Close()
+		case <-stop:
+		}
+	}()
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			l.Close()
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		// Attach here so Clients are taken in the Order they Connect
+		if err := b.attach(conn); err != nil {
+			conn.Close()
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b.serve(ctx, conn)
+		}()
+	}
+}
+
+// ListenAndServe listens on the TCP address addr and calls Serve
+func (b *Bridge) ListenAndServe(ctx context.Context, addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return b.Serve(ctx, l)
+}
+
+// DialAndServe connects to the TCP address addr and serves the connection,
+// dialling again with backoff whenever it fails or ends, until ctx is done
+func (b *Bridge) DialAndServe(ctx context.Context, addr string) error {
+	delay := b.cfg.RetryInterval
+	var d net.Dialer
+	for {
+		conn, err := d.DialContext(ctx, "tcp", addr)
+		if err == nil {
+			start := time.Now()
+			b.ServeConn(ctx, conn)
+			// A Connection that lasted restarts the Backoff
+			if time.Since(start) > b.cfg.MaxRetryInterval {
+				delay = b.cfg.RetryInterval
+			}
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		t := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+		if delay *= 2; delay > b.cfg.MaxRetryInterval {
+			delay = b.cfg.MaxRetryInterval
+		}
+	}
+}
+
+// Close stops reading the Port and hangs up on the client
+func (b *Bridge) Close() error {
+	b.mx.Lock()
+	if b.closed {
+		b.mx.Unlock()
+		return ErrClosed
+	}
+	b.closed = true
+	conn := b.conn
+	b.mx.Unlock()
+	if conn != nil {
+		conn.Close()
+	}
+	b.reader.Stop()
+	return nil
+}
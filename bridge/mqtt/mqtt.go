@@ -0,0 +1,409 @@
+// Package mqtt bridges a serial Port to an MQTT broker: frames received from
+// the Port are published to a topic and messages on a command topic are
+// written to the Port. It speaks MQTT 3.1.1 itself, QoS 0 and 1, so no
+// client library is needed.
+package mqtt
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/packing/xserial"
+)
+
+var (
+	// ErrRefused - the broker rejected the connection; the error text carries
+	// the CONNACK return code
+	ErrRefused = errors.New("mqtt: connection refused")
+	// ErrNotConnected - there is no broker session to publish on
+	ErrNotConnected = errors.New("mqtt: not connected")
+)
+
+// Config configures a Bridge
+type Config struct {
+	// Broker Address as host:port
+	Broker   string
+	ClientID string
+	Username string
+	Password string
+	// Topic the received frames are published to
+	Topic string
+	// Messages on this Topic are written to the Port, empty for none. It
+	// may be a filter with + and # wildcards.
+	CommandTopic string
+	// QoS for publishing and subscribing, 0 or 1
+	QoS    byte
+	Retain bool
+	// Defaults to 30 seconds
+	KeepAlive time.Duration
+	// Optional - Splits serial data into messages; without one, each chunk
+	// read is published as it arrives
+	Decoder xserial.FrameDecoder
+	// Optional - Connects to the Broker, for example over TLS; defaults to
+	// plain TCP
+	Dial func(ctx context.Context, addr string) (net.Conn, error)
+	// Delay before connecting again, defaults to 1 second and doubles up to
+	// MaxRetryInterval
+	RetryInterval time.Duration
+	// Defaults to 30 seconds
+	MaxRetryInterval time.Duration
+	// Optional - Called as the broker session comes and goes
+	OnConnect    func()
+	OnDisconnect func(err error)
+}
+
+// Counters are the running totals of a Bridge
+type Counters struct {
+	// Messages published from the Port
+	Published uint64
+	// Frames dropped while not connected or failing to publish
+	Dropped uint64
+	// Command Messages written to the Port
+	Commands uint64
+	// Broker Sessions established
+	Connections uint64
+}
+
+// Bridge connects a Port to an MQTT broker
+type Bridge struct {
+	// Counters - First so 64-bit Atomics stay Aligned on 32-bit Platforms
+	counters Counters
+	p        xserial.Port
+	cfg      Config
+	reader   *xserial.AsyncReader
+
+	mx   sync.Mutex
+	sess *session
+}
+
+// session is one broker connection
+type session struct {
+	conn   net.Conn
+	txMx   sync.Mutex
+	nextID uint16
+	// PUBACK / SUBACK Waiters by Packet ID
+	mx    sync.Mutex
+	acks  map[uint16]chan []byte
+	done  chan struct{}
+	err   error
+	close sync.Once
+}
+
+// New starts reading p. Nothing is published until Run connects; p stays
+// owned by the caller.
+func New(p xserial.Port, cfg *Config) *Bridge {
+	c := *cfg
+	if c.KeepAlive <= 0 {
+		c.KeepAlive = 30 * time.Second
+	}
+	if c.QoS > 1 {
+		c.QoS = 1
+	}
+	if c.RetryInterval <= 0 {
+		c.RetryInterval = time.Second
+	}
+	if c.MaxRetryInterval <= 0 {
+		c.MaxRetryInterval = 30 * time.Second
+	}
+	if c.MaxRetryInterval < c.RetryInterval {
+		c.MaxRetryInterval = c.RetryInterval
+	}
+	if c.Dial == nil {
+		var d net.Dialer
+		c.Dial = func(ctx context.Context, addr string) (net.Conn, error) {
+			return d.DialContext(ctx, "tcp", addr)
+		}
+	}
+	b := &Bridge{p: p, cfg: c}
+	b.reader = xserial.OnData(p, b.fromSerial, nil)
+	return b
+}
+
+// Counters returns a snapshot of the running totals
+func (b *Bridge) Counters() Counters {
+	return Counters{
+		Published:   atomic.LoadUint64(&b.counters.Published),
+		Dropped:     atomic.LoadUint64(&b.counters.Dropped),
+		Commands:    atomic.LoadUint64(&b.counters.Commands),
+		Connections: atomic.LoadUint64(&b.counters.Connections),
+	}
+}
+
+// Close stops reading the Port and drops the broker session
+func (b *Bridge) Close() error {
+	b.reader.Stop()
+	b.mx.Lock()
+	s := b.sess
+	b.mx.Unlock()
+	if s != nil {
+		s.shutdown(nil)
+	}
+	return nil
+}
+
+// Run keeps a broker session up, connecting again with backoff whenever it
+// fails, until ctx is done
+func (b *Bridge) Run(ctx context.Context) error {
+	delay := b.cfg.RetryInterval
+	for {
+		start := time.Now()
+		err := b.session(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if b.cfg.OnDisconnect != nil {
+			b.cfg.OnDisconnect(err)
+		}
+		// A Session that lasted restarts the Backoff
+		if time.Since(start) > b.cfg.MaxRetryInterval {
+			delay = b.cfg.RetryInterval
+		}
+		t := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+		if delay *= 2; delay > b.cfg.MaxRetryInterval {
+			delay = b.cfg.MaxRetryInterval
+		}
+	}
+}
+
+// session runs one broker connection until it fails
+func (b *Bridge) session(ctx context.Context) error {
+	conn, err := b.cfg.Dial(ctx, b.cfg.Broker)
+	if err != nil {
+		return err
+	}
+	s := &session{conn: conn, acks: make(map[uint16]chan []byte), done: make(chan struct{})}
+	defer s.shutdown(nil)
+	r := bufio.NewReader(conn)
+
+	// Connect
+	conn.SetDeadline(time.Now().Add(b.cfg.KeepAlive))
+	if err := s.write(connectPacket(&b.cfg, uint16(b.cfg.KeepAlive/time.Second))); err != nil {
+		return err
+	}
+	ack, err := readPacket(r)
+	if err != nil {
+		return err
+	}
+	if ack.kind != pktConnAck || len(ack.body) < 2 {
+		return errMalformed
+	}
+	if code := ack.body[1]; code != 0 {
+		return fmt.Errorf("%w: code %d", ErrRefused, code)
+	}
+	conn.SetDeadline(time.Time{})
+
+	go s.receive(b, r, b.cfg.KeepAlive*3/2)
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			// Run reports ctx.Err(); say Goodbye to the Broker
+			s.shutdown(nil)
+		case <-stop:
+		}
+	}()
+
+	if b.cfg.CommandTopic != "" {
+		id := s.id()
+		body := []byte{byte(id >> 8), byte(id)}
+		body = append(appendString(body, b.cfg.CommandTopic), b.cfg.QoS)
+		codes, err := s.request(id, encode(pktSubscribe, 0x02, body), b.cfg.KeepAlive)
+		if err != nil {
+			return err
+		}
+		if len(codes) == 0 || codes[0] == 0x80 {
+			return fmt.Errorf("mqtt: subscribing to %q refused", b.cfg.CommandTopic)
+		}
+	}
+
+	b.mx.Lock()
+	b.sess = s
+	b.mx.Unlock()
+	defer func() {
+		b.mx.Lock()
+		b.sess = nil
+		b.mx.Unlock()
+	}()
+	atomic.AddUint64(&b.counters.Connections, 1)
+	if b.cfg.OnConnect != nil {
+		b.cfg.OnConnect()
+	}
+
+	// Keep the Session Alive until it Fails
+	t := time.NewTicker(b.cfg.KeepAlive * 3 / 4)
+	defer t.Stop()
+	for {
+		select {
+		case <-s.done:
+			return s.failure()
+		case <-t.C:
+			if err := s.write(encode(pktPingReq, 0, nil)); err != nil {
+				s.shutdown(err)
+			}
+		}
+	}
+}
+
+// fromSerial publishes Port data, on the reader goroutine
+func (b *Bridge) fromSerial(data []byte) {
+	frames := [][]byte{data}
+	if b.cfg.Decoder != nil {
+		frames = b.cfg.Decoder.Feed(data)
+	}
+	for _, f := range frames {
+		if err := b.Publish(f); err != nil {
+			atomic.AddUint64(&b.counters.Dropped, 1)
+		}
+	}
+}
+
+// Publish sends payload to the Topic, waiting for the broker's PUBACK at
+// QoS 1
+func (b *Bridge) Publish(payload []byte) error {
+	b.mx.Lock()
+	s := b.sess
+	b.mx.Unlock()
+	if s == nil {
+		return ErrNotConnected
+	}
+	var err error
+	if b.cfg.QoS == 0 {
+		err = s.write(publishPacket(b.cfg.Topic, payload, 0, b.cfg.Retain, 0))
+	} else {
+		id := s.id()
+		_, err = s.request(id, publishPacket(b.cfg.Topic, payload, 1, b.cfg.Retain, id), b.cfg.KeepAlive)
+	}
+	if err != nil {
+		s.shutdown(err)
+		return err
+	}
+	atomic.AddUint64(&b.counters.Published, 1)
+	return nil
+}
+
+// receive handles packets from the broker until the session ends
+func (s *session) receive(b *Bridge, r *bufio.Reader, timeout time.Duration) {
+	for {
+		// Pings are answered well within the Timeout
+		s.conn.SetReadDeadline(time.Now().Add(timeout))
+		p, err := readPacket(r)
+		if err != nil {
+			s.shutdown(err)
+			return
+		}
+		switch p.kind {
+		case pktPublish:
+			topic, id, payload, err := parsePublish(p)
+			if err != nil {
+				s.shutdown(err)
+				return
+			}
+			if matchTopic(b.cfg.CommandTopic, topic) {
+				if _, err := b.p.Write(payload); err == nil {
+					atomic.AddUint64(&b.counters.Commands, 1)
+				}
+			}
+			if p.flags>>1&0x03 > 0 {
+				s.write(encode(pktPubAck, 0, []byte{byte(id >> 8), byte(id)}))
+			}
+		case pktPubAck, pktSubAck:
+			if len(p.body) >= 2 {
+				s.answer(binary.BigEndian.Uint16(p.body), p.body[2:])
+			}
+		}
+	}
+}
+
+func (s *session) write(b []byte) error {
+	s.txMx.Lock()
+	defer s.txMx.Unlock()
+	_, err := s.conn.Write(b)
+	return err
+}
+
+// id returns the next packet identifier, never zero
+func (s *session) id() uint16 {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	if s.nextID++; s.nextID == 0 {
+		s.nextID = 1
+	}
+	return s.nextID
+}
+
+// request sends pkt and waits for the acknowledgement of id
+func (s *session) request(id uint16, pkt []byte, timeout time.Duration) ([]byte, error) {
+	ch := make(chan []byte, 1)
+	s.mx.Lock()
+	s.acks[id] = ch
+	s.mx.Unlock()
+	defer func() {
+		s.mx.Lock()
+		delete(s.acks, id)
+		s.mx.Unlock()
+	}()
+	if err := s.write(pkt); err != nil {
+		return nil, err
+	}
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case r := <-ch:
+		return r, nil
+	case <-s.done:
+		return nil, s.failure()
+	case <-t.C:
+		return nil, fmt.Errorf("mqtt: no acknowledgement for packet %d", id)
+	}
+}
+
+func (s *session) answer(id uint16, body []byte) {
+	s.mx.Lock()
+	ch := s.acks[id]
+	s.mx.Unlock()
+	if ch != nil {
+		select {
+		case ch <- append([]byte(nil), body...):
+		default:
+		}
+	}
+}
+
+// shutdown ends the session with err
+func (s *session) shutdown(err error) {
+	s.close.Do(func() {
+		s.mx.Lock()
+		s.err = err
+		s.mx.Unlock()
+		if err == nil {
+			// Polite Goodbye, but never stall on a dead Broker
+			s.conn.SetWriteDeadline(time.Now().Add(time.Second))
+			s.write(encode(pktDisconnect, 0, nil))
+		}
+		s.conn.Close()
+		close(s.done)
+	})
+}
+
+func (s *session) failure() error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	return ErrNotConnected
+}
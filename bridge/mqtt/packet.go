@@ -0,0 +1,166 @@
+package mqtt
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+)
+
+// MQTT 3.1.1 Control Packet Types
+const (
+	pktConnect    = 1
+	pktConnAck    = 2
+	pktPublish    = 3
+	pktPubAck     = 4
+	pktSubscribe  = 8
+	pktSubAck     = 9
+	pktPingReq    = 12
+	pktPingResp   = 13
+	pktDisconnect = 14
+)
+
+var errMalformed = errors.New("mqtt: malformed packet")
+
+// packet is one decoded control packet
+type packet struct {
+	kind  byte
+	flags byte
+	body  []byte
+}
+
+// appendString appends an MQTT length prefixed string
+func appendString(b []byte, s string) []byte {
+	b = append(b, byte(len(s)>>8), byte(len(s)))
+	return append(b, s...)
+}
+
+// readString splits a length prefixed string off b
+func readString(b []byte) (string, []byte, error) {
+	if len(b) < 2 {
+		return "", nil, errMalformed
+	}
+	n := int(binary.BigEndian.Uint16(b))
+	if len(b) < 2+n {
+		return "", nil, errMalformed
+	}
+	return string(b[2 : 2+n]), b[2+n:], nil
+}
+
+// encode returns the packet with its fixed header
+func encode(kind, flags byte, body []byte) []byte {
+	b := []byte{kind<<4 | flags}
+	n := len(body)
+	for {
+		c := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			c |= 0x80
+		}
+		b = append(b, c)
+		if n == 0 {
+			break
+		}
+	}
+	return append(b, body...)
+}
+
+// readPacket reads one control packet
+func readPacket(r *bufio.Reader) (packet, error) {
+	h, err := r.ReadByte()
+	if err != nil {
+		return packet{}, err
+	}
+	n, shift := 0, 0
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			return packet{}, err
+		}
+		n |= int(c&0x7F) << shift
+		if c&0x80 == 0 {
+			break
+		}
+		if shift += 7; shift > 21 {
+			return packet{}, errMalformed
+		}
+	}
+	body := make([]byte, n)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return packet{}, err
+	}
+	return packet{kind: h >> 4, flags: h & 0x0F, body: body}, nil
+}
+
+// connectPacket builds CONNECT
+func connectPacket(cfg *Config, keepAlive uint16) []byte {
+	var flags byte = 0x02 // Clean Session
+	if cfg.Username != "" {
+		flags |= 0x80
+		if cfg.Password != "" {
+			flags |= 0x40
+		}
+	}
+	b := appendString(nil, "MQTT")
+	b = append(b, 4, flags, byte(keepAlive>>8), byte(keepAlive))
+	b = appendString(b, cfg.ClientID)
+	if cfg.Username != "" {
+		b = appendString(b, cfg.Username)
+		if cfg.Password != "" {
+			b = appendString(b, cfg.Password)
+		}
+	}
+	return encode(pktConnect, 0, b)
+}
+
+// publishPacket builds PUBLISH; id is only sent for QoS 1
+func publishPacket(topic string, payload []byte, qos byte, retain bool, id uint16) []byte {
+	flags := qos << 1
+	if retain {
+		flags |= 0x01
+	}
+	b := appendString(nil, topic)
+	if qos > 0 {
+		b = append(b, byte(id>>8), byte(id))
+	}
+	return encode(pktPublish, flags, append(b, payload...))
+}
+
+// parsePublish returns the topic, packet id and payload of a PUBLISH
+func parsePublish(p packet) (topic string, id uint16, payload []byte, err error) {
+	topic, rest, err := readString(p.body)
+	if err != nil {
+		return "", 0, nil, err
+	}
+	if qos := p.flags >> 1 & 0x03; qos > 0 {
+		if len(rest) < 2 {
+			return "", 0, nil, errMalformed
+		}
+		id, rest = binary.BigEndian.Uint16(rest), rest[2:]
+	}
+	return topic, id, rest, nil
+}
+
+// matchTopic reports whether topic matches the subscription filter, where
+// + stands for one level and a trailing # for any number, none included.
+// Wildcards at the first level do not match topics starting with $.
+func matchTopic(filter, topic string) bool {
+	if strings.HasPrefix(topic, "$") && (strings.HasPrefix(filter, "+") || strings.HasPrefix(filter, "#")) {
+		return false
+	}
+	f := strings.Split(filter, "/")
+	t := strings.Split(topic, "/")
+	for i, level := range f {
+		if level == "#" {
+			return i == len(f)-1
+		}
+		if i >= len(t) {
+			return false
+		}
+		if level != "+" && level != t[i] {
+			return false
+		}
+	}
+	return len(f) == len(t)
+}
@@ -0,0 +1,190 @@
+package bridge
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"github.com/packing/xserial"
+)
+
+// Largest UDP Payload over IPv4
+const maxDatagram = 65507
+
+// UDPConfig configures a UDPBridge
+type UDPConfig struct {
+	// Serial frames are sent here as datagrams; unicast, broadcast or a
+	// multicast group. May be empty with ReplyToSender.
+	Remote string
+	// Datagrams received here are written to the Port; a multicast group
+	// address joins the group. Empty sends only.
+	Listen string
+	// Interface for joining a multicast group, nil for the system default
+	Interface *net.Interface
+	// Also send frames to whoever sent the last datagram
+	ReplyToSender bool
+	// Optional - Splits serial data into frames; without one, each chunk
+	// read becomes a datagram as it arrives
+	Decoder xserial.FrameDecoder
+}
+
+// UDPBridge turns each serial frame into one datagram and writes each
+// datagram received to the Port. Being connectionless it suits lossy
+// telemetry links and feeding one NMEA stream to many listeners.
+type UDPBridge struct {
+	// Counters - First so 64-bit Atomics stay Aligned on 32-bit Platforms
+	counters Counters
+	p        xserial.Port
+	cfg      UDPConfig
+	remote   *net.UDPAddr
+	rx, tx   *net.UDPConn
+	reader   *xserial.AsyncReader
+
+	mx     sync.Mutex
+	sender *net.UDPAddr
+	closed bool
+}
+
+// NewUDP opens the sockets and starts reading p. p stays owned by the
+// caller; Close stops reading but leaves it open.
+func NewUDP(p xserial.Port, cfg *UDPConfig) (*UDPBridge, error) {
+	u := &UDPBridge{p: p, cfg: *cfg}
+	var err error
+	if cfg.Remote != "" {
+		if u.remote, err = net.ResolveUDPAddr("udp", cfg.Remote); err != nil {
+			return nil, err
+		}
+	}
+	group := false
+	if cfg.Listen != "" {
+		addr, err := net.ResolveUDPAddr("udp", cfg.Listen)
+		if err != nil {
+			return nil, err
+		}
+		if group = addr.IP.IsMulticast(); group {
+			if u.rx, err = net.ListenMulticastUDP("udp", cfg.Interface, addr); err != nil {
+				return nil, err
+			}
+		} else if u.rx, err = net.ListenUDP("udp", addr); err != nil {
+			return nil, err
+		}
+	}
+	// Group Members send from their own Socket
+	u.tx = u.rx
+	if u.tx == nil || group {
+		if u.tx, err = net.ListenUDP("udp", nil); err != nil {
+			if u.rx != nil {
+				u.rx.Close()
+			}
+			return nil, err
+		}
+	}
+	u.reader = xserial.OnData(p, u.fromSerial, nil)
+	return u, nil
+}
+
+// Counters returns a snapshot of the running totals
+func (u *UDPBridge) Counters() Counters {
+	return Counters{
+		ToNetwork:    atomic.LoadUint64(&u.counters.ToNetwork),
+		ToSerial:     atomic.LoadUint64(&u.counters.ToSerial),
+		Dropped:      atomic.LoadUint64(&u.counters.Dropped),
+		DatagramsOut: atomic.LoadUint64(&u.counters.DatagramsOut),
+		DatagramsIn:  atomic.LoadUint64(&u.counters.DatagramsIn),
+	}
+}
+
+// Err returns the error that stopped reading the Port, if any
+func (u *UDPBridge) Err() error {
+	return u.reader.Err()
+}
+
+// fromSerial sends Port data as datagrams, on the reader goroutine
+func (u *UDPBridge) fromSerial(data []byte) {
+	frames := [][]byte{data}
+	if u.cfg.Decoder != nil {
+		frames = u.cfg.Decoder.Feed(data)
+	}
+	u.mx.Lock()
+	sender := u.sender
+	u.mx.Unlock()
+	for _, f := range frames {
+		sent := false
+		if len(f) <= maxDatagram {
+			if u.remote != nil {
+				_, err := u.tx.WriteToUDP(f, u.remote)
+				sent = err == nil
+			}
+			if sender != nil && (u.remote == nil || sender.String() != u.remote.String()) {
+				if _, err := u.tx.WriteToUDP(f, sender); err == nil {
+					sent = true
+				}
+			}
+		}
+		if !sent {
+			atomic.AddUint64(&u.counters.Dropped, uint64(len(f)))
+			continue
+		}
+		atomic.AddUint64(&u.counters.ToNetwork, uint64(len(f)))
+		atomic.AddUint64(&u.counters.DatagramsOut, 1)
+	}
+}
+
+// Run writes received datagrams to the Port until ctx is done or the
+// socket fails. Without a Listen address it just waits for ctx.
+func (u *UDPBridge) Run(ctx context.Context) error {
+	if u.rx == nil {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			u.rx.Close()
+		case <-stop:
+		}
+	}()
+	buf := make([]byte, maxDatagram+1)
+	for {
+		n, from, err := u.rx.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		atomic.AddUint64(&u.counters.DatagramsIn, 1)
+		if u.cfg.ReplyToSender {
+			u.mx.Lock()
+			u.sender = from
+			u.mx.Unlock()
+		}
+		w, err := u.p.Write(buf[:n])
+		atomic.AddUint64(&u.counters.ToSerial, uint64(w))
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Close stops reading the Port and closes the sockets
+func (u *UDPBridge) Close() error {
+	u.mx.Lock()
+	if u.closed {
+		u.mx.Unlock()
+		return ErrClosed
+	}
+	u.closed = true
+	u.mx.Unlock()
+	u.reader.Stop()
+	if u.rx != nil {
+		u.rx.Close()
+	}
+	if u.tx != u.rx {
+		u.tx.Close()
+	}
+	return nil
+}
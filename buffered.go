@@ -0,0 +1,277 @@
+package xserial
+
+import (
+	"bufio"
+	"sync"
+	"time"
+)
+
+// BufferConfig configures a BufferedPort
+type BufferConfig struct {
+	// Ring Buffer Capacity, defaults to 64 KiB
+	Size int
+	// How long Read and Peek wait for data, zero waits forever
+	ReadTimeout time.Duration
+}
+
+// BufferedPort continuously drains a Port into a user-space ring buffer from
+// a background goroutine, so bursts are not lost while the application is
+// busy. Bytes arriving while the ring is full are dropped and counted.
+type BufferedPort struct {
+	Port
+	timeout time.Duration
+	reader  *AsyncReader
+
+	mx       sync.Mutex
+	ring     []byte
+	head     int // Index of the oldest byte
+	length   int // Number of buffered bytes
+	overruns uint64
+	err      error
+	closed   bool
+	// Signalled whenever bytes are added or the reader stops
+	notify chan struct{}
+	// Set for the Readers of a Tee, which share its Port and reader
+	detach func()
+}
+
+// NewBufferedPort wraps p and starts draining it. cfg may be nil.
+func NewBufferedPort(p Port, cfg *BufferConfig) *BufferedPort {
+	b := newBufferedPort(p, cfg)
+	b.reader = OnData(p, b.fill, &AsyncConfig{OnError: b.fail})
+	return b
+}
+
+// newBufferedPort returns a BufferedPort not yet fed by a reader
+func newBufferedPort(p Port, cfg *BufferConfig) *BufferedPort {
+	var c BufferConfig
+	if cfg != nil {
+		c = *cfg
+	}
+	if c.Size <= 0 {
+		c.Size = 64 * 1024
+	}
+	b := &BufferedPort{
+		Port:    p,
+		timeout: c.ReadTimeout,
+		ring:    make([]byte, c.Size),
+		notify:  make(chan struct{}, 1),
+	}
+	return b
+}
+
+func (b *BufferedPort) signal() {
+	select {
+	case b.notify <- struct{}{}:
+	default:
+	}
+}
+
+// fill appends received bytes to the ring
+func (b *BufferedPort) fill(p []byte) {
+	b.mx.Lock()
+	free := len(b.ring) - b.length
+	if len(p) > free {
+		b.overruns += uint64(len(p) - free)
+		p = p[:free]
+	}
+	for len(p) > 0 {
+		tail := (b.head + b.length) % len(b.ring)
+		end := len(b.ring)
+		if tail < b.head {
+			end = b.head
+		}
+		n := copy(b.ring[tail:end], p)
+		b.length += n
+		p = p[n:]
+	}
+	b.mx.Unlock()
+	b.signal()
+}
+
+func (b *BufferedPort) fail(err error) {
+	b.mx.Lock()
+	b.err = err
+	b.mx.Unlock()
+	b.signal()
+}
+
+// copyOut copies up to len(p) buffered bytes starting skip bytes in
+func (b *BufferedPort) copyOut(p []byte, skip int) int {
+	n := 0
+	for n < len(p) && skip+n < b.length {
+		i := (b.head + skip + n) % len(b.ring)
+		end := len(b.ring)
+		if end-i > b.length-skip-n {
+			end = i + b.length - skip - n
+		}
+		n += copy(p[n:], b.ring[i:end])
+	}
+	return n
+}
+
+func (b *BufferedPort) consume(n int) {
+	b.head = (b.head + n) % len(b.ring)
+	b.length -= n
+}
+
+// wait blocks with mx held until at least n bytes are buffered, the
+// deadline passes or reading has failed
+func (b *BufferedPort) wait(n int, deadline <-chan time.Time) error {
+	if b.closed {
+		return ErrPortClosed
+	}
+	for b.length < n {
+		if b.err != nil {
+			return b.err
+		}
+		b.mx.Unlock()
+		select {
+		case <-b.notify:
+			b.mx.Lock()
+		case <-b.reader.Done():
+			b.mx.Lock()
+			if b.length < n && b.err == nil {
+				return ErrPortClosed
+			}
+		case <-deadline:
+			b.mx.Lock()
+			if b.length < n {
+				return ErrReadTimeout
+			}
+		}
+	}
+	return nil
+}
+
+func (b *BufferedPort) deadline() (<-chan time.Time, func()) {
+	if b.timeout <= 0 {
+		return nil, func() {}
+	}
+	t := time.NewTimer(b.timeout)
+	return t.C, func() { t.Stop() }
+}
+
+// Read returns buffered bytes, waiting up to ReadTimeout for at least one
+func (b *BufferedPort) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	dl, stop := b.deadline()
+	defer stop()
+
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	if err := b.wait(1, dl); err != nil {
+		return 0, err
+	}
+	n := b.copyOut(p, 0)
+	b.consume(n)
+	return n, nil
+}
+
+// Peek returns the next n bytes without consuming them. It waits up to
+// ReadTimeout for them to arrive and returns fewer bytes with an error if
+// they do not; n is capped at the ring capacity. Together with Discard it
+// lets a detector look ahead, telling NMEA from UBX say, and leave the
+// bytes to whichever parser reads next.
+func (b *BufferedPort) Peek(n int) ([]byte, error) {
+	if n < 0 {
+		return nil, bufio.ErrNegativeCount
+	}
+	if n > len(b.ring) {
+		n = len(b.ring)
+	}
+	dl, stop := b.deadline()
+	defer stop()
+
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	err := b.wait(n, dl)
+	out := make([]byte, n)
+	return out[:b.copyOut(out, 0)], err
+}
+
+// Discard skips the next n bytes, returning how many were dropped. Like
+// bufio.Reader.Discard it waits for bytes not yet buffered, so a detector can
+// skip a whole frame it has only peeked the header of; if they do not all
+// arrive within ReadTimeout it returns fewer with an error.
+func (b *BufferedPort) Discard(n int) (int, error) {
+	if n < 0 {
+		return 0, bufio.ErrNegativeCount
+	}
+	dl, stop := b.deadline()
+	defer stop()
+
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	done := 0
+	for done < n {
+		if err := b.wait(1, dl); err != nil {
+			return done, err
+		}
+		k := n - done
+		if k > b.length {
+			k = b.length
+		}
+		b.consume(k)
+		done += k
+	}
+	return done, nil
+}
+
+// Buffered returns the number of bytes waiting in the ring
+func (b *BufferedPort) Buffered() int {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	return b.length
+}
+
+// Overruns returns the number of bytes dropped because the ring was full
+func (b *BufferedPort) Overruns() uint64 {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	return b.overruns
+}
+
+// Flush discards the ring and the underlying Port buffers. A Reader of a
+// Tee discards only its ring, as the other Readers have yet to see them.
+func (b *BufferedPort) Flush() error {
+	b.mx.Lock()
+	b.head, b.length = 0, 0
+	b.mx.Unlock()
+	if b.detach != nil {
+		return nil
+	}
+	return b.Port.Flush()
+}
+
+// FlushInput discards the ring and the underlying Port's input, leaving its
+// queued output. A Reader of a Tee discards only its ring.
+func (b *BufferedPort) FlushInput() error {
+	b.mx.Lock()
+	b.head, b.length = 0, 0
+	b.mx.Unlock()
+	if b.detach != nil {
+		return nil
+	}
+	return flushInput(b.Port)
+}
+
+// Close stops draining and closes the underlying Port. A Reader of a Tee
+// only leaves it, the Port staying open for the others.
+func (b *BufferedPort) Close() error {
+	b.mx.Lock()
+	if b.closed {
+		b.mx.Unlock()
+		return ErrPortClosed
+	}
+	b.closed = true
+	b.mx.Unlock()
+	b.signal()
+	if b.detach != nil {
+		b.detach()
+		return nil
+	}
+	return b.reader.stopClosing(b.Port)
+}
@@ -0,0 +1,169 @@
+// Package charset transcodes the text exchanged with devices that do not
+// speak UTF-8, such as displays, receipt printers and scales using CP437,
+// Latin-1 or Shift-JIS: a Port sends what is written as the device charset
+// and returns what is read as UTF-8.
+package charset
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/packing/xserial"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/ianaindex"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/transform"
+)
+
+// Common Device Charsets
+var (
+	CP437    encoding.Encoding = charmap.CodePage437
+	CP850    encoding.Encoding = charmap.CodePage850
+	Latin1   encoding.Encoding = charmap.ISO8859_1
+	Windows  encoding.Encoding = charmap.Windows1252
+	ShiftJIS encoding.Encoding = japanese.ShiftJIS
+)
+
+// ErrUnknown - the name is not a supported IANA charset
+var ErrUnknown = errors.New("charset: unknown charset")
+
+// Lookup returns the charset with the IANA name or alias, such as "IBM437",
+// "cp437", "latin1" or "Shift_JIS", ignoring case
+func Lookup(name string) (encoding.Encoding, error) {
+	e, err := ianaindex.IANA.Encoding(name)
+	if err != nil || e == nil {
+		return nil, ErrUnknown
+	}
+	return e, nil
+}
+
+// Config configures a Port
+type Config struct {
+	// Fail Writes of characters the charset lacks, before any of the Write
+	// is sent. Otherwise the charset's substitute, usually SUB, is sent.
+	Strict bool
+}
+
+// Port converts the UTF-8 written to the wrapped Port to its charset and
+// what is read from it back to UTF-8
+type Port struct {
+	xserial.Port
+	wmx  sync.Mutex
+	enc  transform.Transformer
+	wbuf []byte
+	// UTF-8 of a Character split across Writes
+	wpend []byte
+	rmx   sync.Mutex
+	dec   transform.Transformer
+	rbuf  []byte
+	// Start of a Multibyte Character split across Reads
+	rpend int
+}
+
+// NewPort wraps p for a device using e. cfg may be nil.
+func NewPort(p xserial.Port, e encoding.Encoding, cfg *Config) *Port {
+	var c Config
+	if cfg != nil {
+		c = *cfg
+	}
+	var enc transform.Transformer = e.NewEncoder()
+	if !c.Strict {
+		enc = encoding.ReplaceUnsupported(e.NewEncoder())
+	}
+	return &Port{Port: p, enc: enc, dec: e.NewDecoder()}
+}
+
+// Unwrap returns the wrapped Port
+func (p *Port) Unwrap() xserial.Port {
+	return p.Port
+}
+
+// Write converts b and writes it, returning len(b) once all of it is sent.
+// A character split across Writes is sent with its last byte.
+func (p *Port) Write(b []byte) (int, error) {
+	p.wmx.Lock()
+	defer p.wmx.Unlock()
+	src := b
+	if len(p.wpend) > 0 {
+		src = append(p.wpend, b...)
+	}
+	out, rest, err := convert(p.enc, p.wbuf[:0], src)
+	if err != nil {
+		p.enc.Reset()
+		return 0, err
+	}
+	p.wbuf = out
+	p.wpend = append(p.wpend[:0], rest...)
+	if len(out) > 0 {
+		if _, err := p.Port.Write(out); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// Read reads into b as UTF-8, reading again while all that arrived was the
+// start of a character. b must hold utf8.UTFMax bytes for each byte read,
+// so short buffers are io.ErrShortBuffer. Only undecoded bytes are held
+// back, so Select still sees all data not yet returned.
+func (p *Port) Read(b []byte) (int, error) {
+	p.rmx.Lock()
+	defer p.rmx.Unlock()
+	for {
+		room := len(b)/utf8.UTFMax - p.rpend
+		if room < 1 {
+			return 0, io.ErrShortBuffer
+		}
+		if cap(p.rbuf) < p.rpend+room {
+			nb := make([]byte, p.rpend+room)
+			copy(nb, p.rbuf[:p.rpend])
+			p.rbuf = nb
+		}
+		raw := p.rbuf[:p.rpend+room]
+		n, err := p.Port.Read(raw[p.rpend:])
+		nDst, nSrc, terr := p.dec.Transform(b, raw[:p.rpend+n], false)
+		p.rpend = copy(raw, raw[nSrc:p.rpend+n])
+		if err == nil && terr != nil && terr != transform.ErrShortSrc {
+			err = terr
+		}
+		if nDst > 0 || n == 0 || err != nil {
+			return nDst, err
+		}
+	}
+}
+
+// Flush discards buffered data and any partial character read
+func (p *Port) Flush() error {
+	p.rmx.Lock()
+	p.rpend = 0
+	p.dec.Reset()
+	p.rmx.Unlock()
+	return p.Port.Flush()
+}
+
+// convert appends src transformed by t to dst, returning the tail of src
+// that is the start of an incomplete character
+func convert(t transform.Transformer, dst, src []byte) ([]byte, []byte, error) {
+	short := false
+	for {
+		if short || cap(dst)-len(dst) < len(src)+utf8.UTFMax {
+			nd := make([]byte, len(dst), 2*cap(dst)+len(src)+utf8.UTFMax)
+			copy(nd, dst)
+			dst = nd
+		}
+		nDst, nSrc, err := t.Transform(dst[len(dst):cap(dst)], src, false)
+		dst = dst[:len(dst)+nDst]
+		src = src[nSrc:]
+		switch err {
+		case transform.ErrShortDst:
+			short = true
+		case transform.ErrShortSrc, nil:
+			return dst, src, nil
+		default:
+			return dst, src, err
+		}
+	}
+}
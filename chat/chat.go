@@ -0,0 +1,271 @@
+// Package chat runs expect/send conversations with modems in the manner of
+// the classic chat program, and dials out with them, leaving the Port in
+// data mode once the modem reports CONNECT.
+package chat
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/packing/xserial"
+)
+
+// ErrTimeout - the expected text did not arrive in time
+var ErrTimeout = errors.New("chat: timeout waiting for expected text")
+
+// AbortError ends a Script that received one of its Abort strings
+type AbortError struct {
+	Match string
+}
+
+func (e *AbortError) Error() string {
+	return "chat: aborted on " + strconv.Quote(e.Match)
+}
+
+// Step is one expect/send pair of a Script
+type Step struct {
+	// Text to wait for, empty to send at once
+	Expect string
+	// Pause before sending
+	Delay time.Duration
+	// Text to send as is, line ends included
+	Send string
+	// How long Expect waits, zero for the Script's Timeout
+	Timeout time.Duration
+}
+
+// Script is a chat conversation
+type Script struct {
+	Steps []Step
+	// Received text ending the conversation with an AbortError, such as
+	// "BUSY" or "NO CARRIER"
+	Abort []string
+	// How long each Expect waits by default, defaults to 45 seconds
+	Timeout time.Duration
+}
+
+// Parse reads a script in chat syntax: whitespace separated, optionally
+// quoted strings alternating expect and send, with ABORT <text> and
+// TIMEOUT <seconds> keywords. A send string ends with CR unless it ends in
+// \c; escapes \r, \n, \t, \s, \\, \" and \' stand for their characters, and
+// \d and \p pause for one second and a quarter second.
+func Parse(s string) (*Script, error) {
+	words, err := split(s)
+	if err != nil {
+		return nil, err
+	}
+	sc := &Script{}
+	var timeout time.Duration
+	expect := true
+	var step Step
+	for i := 0; i < len(words); i++ {
+		w := words[i]
+		if expect && (w == "ABORT" || w == "TIMEOUT") && i+1 < len(words) {
+			i++
+			if w == "ABORT" {
+				sc.Abort = append(sc.Abort, unescape(words[i]))
+				continue
+			}
+			secs, err := strconv.Atoi(words[i])
+			if err != nil || secs <= 0 {
+				return nil, errors.New("chat: invalid TIMEOUT " + strconv.Quote(words[i]))
+			}
+			timeout = time.Duration(secs) * time.Second
+			continue
+		}
+		if expect {
+			step = Step{Expect: unescape(w), Timeout: timeout}
+			expect = false
+			continue
+		}
+		sc.Steps = append(sc.Steps, sendSteps(step, w)...)
+		expect = true
+	}
+	if !expect {
+		sc.Steps = append(sc.Steps, step)
+	}
+	return sc, nil
+}
+
+// split cuts a script into words, honouring quotes
+func split(s string) ([]string, error) {
+	var words []string
+	for {
+		s = strings.TrimLeft(s, " \t\r\n")
+		if s == "" {
+			return words, nil
+		}
+		if q := s[0]; q == '"' || q == '\'' {
+			end := 1
+			for end < len(s) && s[end] != q {
+				if s[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(s) {
+				return nil, errors.New("chat: unterminated quote")
+			}
+			words = append(words, s[1:end])
+			s = s[end+1:]
+			continue
+		}
+		end := strings.IndexAny(s, " \t\r\n")
+		if end < 0 {
+			end = len(s)
+		}
+		words = append(words, s[:end])
+		s = s[end:]
+	}
+}
+
+// unescape replaces the character escapes of a string
+func unescape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'r':
+			b.WriteByte('\r')
+		case 'n':
+			b.WriteByte('\n')
+		case 't':
+			b.WriteByte('\t')
+		case 's':
+			b.WriteByte(' ')
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+// sendSteps turns a send string into steps, split where it pauses
+func sendSteps(first Step, send string) []Step {
+	cr := true
+	if strings.HasSuffix(send, `\c`) {
+		cr, send = false, strings.TrimSuffix(send, `\c`)
+	}
+	steps := []Step{first}
+	cur := &steps[0]
+	text := ""
+	for i := 0; i < len(send); i++ {
+		var pause time.Duration
+		if send[i] == '\\' && i+1 < len(send) {
+			switch send[i+1] {
+			case 'd':
+				pause = time.Second
+			case 'p':
+				pause = time.Second / 4
+			}
+		}
+		if pause == 0 {
+			text += send[i : i+1]
+			if send[i] == '\\' && i+1 < len(send) {
+				i++
+				text += send[i : i+1]
+			}
+			continue
+		}
+		i++
+		if text != "" {
+			cur.Send = unescape(text)
+			steps = append(steps, Step{})
+			cur = &steps[len(steps)-1]
+			text = ""
+		}
+		cur.Delay += pause
+	}
+	cur.Send = unescape(text)
+	if cr {
+		cur.Send += "\r"
+	}
+	return steps
+}
+
+// Run plays the script on p
+func (s *Script) Run(ctx context.Context, p xserial.Port) error {
+	for _, st := range s.Steps {
+		if st.Expect != "" {
+			timeout := st.Timeout
+			if timeout <= 0 {
+				timeout = s.Timeout
+			}
+			if timeout <= 0 {
+				timeout = 45 * time.Second
+			}
+			if err := s.expect(ctx, p, st.Expect, timeout); err != nil {
+				return err
+			}
+		}
+		if st.Delay > 0 {
+			t := time.NewTimer(st.Delay)
+			select {
+			case <-t.C:
+			case <-ctx.Done():
+				t.Stop()
+				return ctx.Err()
+			}
+		}
+		if st.Send != "" {
+			if _, err := p.Write([]byte(st.Send)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// expect reads until text or an Abort string has been received. Bytes are
+// read one at a time so nothing beyond the match is taken from p.
+func (s *Script) expect(ctx context.Context, p xserial.Port, text string, timeout time.Duration) error {
+	sctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	var seen []byte
+	for {
+		c, err := readByte(sctx, p)
+		if err == context.DeadlineExceeded && ctx.Err() == nil {
+			return ErrTimeout
+		}
+		if err != nil {
+			return err
+		}
+		seen = append(seen, c)
+		// Keep a Tail long enough for any Match
+		if len(seen) > 1024 {
+			seen = append(seen[:0], seen[512:]...)
+		}
+		if strings.HasSuffix(string(seen), text) {
+			return nil
+		}
+		for _, a := range s.Abort {
+			if strings.HasSuffix(string(seen), a) {
+				return &AbortError{Match: a}
+			}
+		}
+	}
+}
+
+// readByte returns the next byte from p
+func readByte(ctx context.Context, p xserial.Port) (byte, error) {
+	b := make([]byte, 1)
+	for {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+		n, err := xserial.ReadContext(ctx, p, b)
+		if n == 1 {
+			return b[0], nil
+		}
+		if err != nil && err != xserial.ErrReadTimeout {
+			return 0, err
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/packing/xserial"
+)
+
+var (
+	// ErrBusy - the called line was busy
+	ErrBusy = errors.New("chat: busy")
+	// ErrNoCarrier - the call failed or the remote modem did not answer
+	// in time
+	ErrNoCarrier = errors.New("chat: no carrier")
+	// ErrNoDialtone - the modem found no dial tone
+	ErrNoDialtone = errors.New("chat: no dial tone")
+	// ErrNoAnswer - the call was not answered
+	ErrNoAnswer = errors.New("chat: no answer")
+	// ErrModem - the modem rejected a command
+	ErrModem = errors.New("chat: modem error")
+)
+
+// dialAbort maps final results of a failed call to errors
+var dialAbort = []struct {
+	result string
+	err    error
+}{
+	{"BUSY", ErrBusy},
+	{"NO CARRIER", ErrNoCarrier},
+	{"NO DIALTONE", ErrNoDialtone},
+	{"NO DIAL TONE", ErrNoDialtone},
+	{"NO ANSWER", ErrNoAnswer},
+	{"ERROR", ErrModem},
+}
+
+// DialConfig configures Dial
+type DialConfig struct {
+	// Commands sent before dialling, each answered by OK; defaults to ATZ
+	Init []string
+	// Dial Command the number is appended to, defaults to "ATD"
+	Command string
+	// How long each init command waits for OK, defaults to 5 seconds
+	Timeout time.Duration
+	// How long to wait for CONNECT, defaults to 60 seconds
+	ConnectTimeout time.Duration
+}
+
+// Call is a connection made by Dial. The Port is in data mode: Reads and
+// Writes go to the remote end.
+type Call struct {
+	xserial.Port
+	// Text after CONNECT, usually the line speed
+	Connect string
+}
+
+// Dial initialises the modem on p, dials number and waits for CONNECT.
+// BUSY, NO CARRIER, NO DIALTONE and NO ANSWER are returned as ErrBusy and
+// the like. cfg may be nil.
+func Dial(ctx context.Context, p xserial.Port, number string, cfg *DialConfig) (*Call, error) {
+	var c DialConfig
+	if cfg != nil {
+		c = *cfg
+	}
+	if c.Init == nil {
+		c.Init = []string{"ATZ"}
+	}
+	if c.Command == "" {
+		c.Command = "ATD"
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = 5 * time.Second
+	}
+	if c.ConnectTimeout <= 0 {
+		c.ConnectTimeout = 60 * time.Second
+	}
+	s := &Script{Timeout: c.Timeout}
+	for _, a := range dialAbort {
+		s.Abort = append(s.Abort, a.result)
+	}
+	expect := ""
+	for _, cmd := range c.Init {
+		s.Steps = append(s.Steps, Step{Expect: expect, Send: cmd + "\r"})
+		expect = "OK"
+	}
+	s.Steps = append(s.Steps,
+		Step{Expect: expect, Send: c.Command + number + "\r"},
+		Step{Expect: "CONNECT", Timeout: c.ConnectTimeout},
+	)
+	// Stale Responses must not satisfy the first Expect
+	p.Flush()
+	if err := s.Run(ctx, p); err != nil {
+		if a, ok := err.(*AbortError); ok {
+			for _, d := range dialAbort {
+				if d.result == a.Match {
+					return nil, d.err
+				}
+			}
+		}
+		return nil, err
+	}
+	return &Call{Port: p, Connect: connectText(ctx, p, c.Timeout)}, nil
+}
+
+// connectText reads the rest of the CONNECT line, before data starts
+func connectText(ctx context.Context, p xserial.Port, timeout time.Duration) string {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	var rest []byte
+	for {
+		b, err := readByte(ctx, p)
+		if err != nil || b == '\r' || b == '\n' {
+			break
+		}
+		rest = append(rest, b)
+	}
+	return strings.TrimSpace(string(rest))
+}
+
+// Hangup ends the call by dropping DTR, or where the Port has no modem
+// lines by escaping to command mode and sending ATH
+func (c *Call) Hangup(ctx context.Context) error {
+	if l, ok := xserial.As[xserial.LineController](c.Port); ok {
+		if err := l.SetDTR(false); err != nil {
+			return err
+		}
+		time.Sleep(500 * time.Millisecond)
+		return l.SetDTR(true)
+	}
+	if err := Escape(ctx, c.Port, nil); err != nil {
+		return err
+	}
+	s := &Script{Steps: []Step{{Send: "ATH\r"}, {Expect: "OK"}}, Timeout: 5 * time.Second}
+	return s.Run(ctx, c.Port)
+}
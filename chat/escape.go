@@ -0,0 +1,122 @@
+package chat
+
+import (
+	"context"
+	"time"
+
+	"github.com/packing/xserial"
+)
+
+// EscapeConfig configures the Hayes escape to command mode
+type EscapeConfig struct {
+	// Escape Guard Time set in S12, defaults to 1 second. The line is kept
+	// silent a little longer on both sides of the escape sequence.
+	Guard time.Duration
+	// Escape Character set in S2, defaults to '+'
+	Char byte
+	// How long to wait for OK after the closing guard time, defaults to 2
+	// seconds
+	Timeout time.Duration
+	// Further attempts when the modem does not answer, defaults to 2;
+	// negative for none
+	Retries int
+}
+
+func (c *EscapeConfig) defaults() EscapeConfig {
+	var cfg EscapeConfig
+	if c != nil {
+		cfg = *c
+	}
+	if cfg.Guard <= 0 {
+		cfg.Guard = time.Second
+	}
+	if cfg.Char == 0 {
+		cfg.Char = '+'
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 2 * time.Second
+	}
+	if cfg.Retries < 0 {
+		cfg.Retries = 0
+	} else if cfg.Retries == 0 {
+		cfg.Retries = 2
+	}
+	return cfg
+}
+
+// sleep waits d or until ctx ends
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Escape switches an online modem on p to command mode, keeping the call:
+// it waits for written data to go out, keeps the line silent for the guard
+// time, sends the escape sequence and waits out the guard time for OK.
+// Data received from the remote end meanwhile is discarded. cfg may be nil.
+func Escape(ctx context.Context, p xserial.Port, cfg *EscapeConfig) error {
+	c := cfg.defaults()
+	// Margin over S12 for Modems that time loosely
+	guard := c.Guard + c.Guard/5
+	seq := []byte{c.Char, c.Char, c.Char}
+	s := &Script{Steps: []Step{{Expect: "OK", Timeout: guard + c.Timeout}}}
+	for attempt := 0; ; attempt++ {
+		if err := p.Drain(); err != nil {
+			return err
+		}
+		if err := sleep(ctx, guard); err != nil {
+			return err
+		}
+		// One Write keeps the Characters within the Guard Time of each other
+		if _, err := p.Write(seq); err != nil {
+			return err
+		}
+		err := s.Run(ctx, p)
+		if err != ErrTimeout || attempt >= c.Retries {
+			return err
+		}
+	}
+}
+
+// Online returns a modem in command mode to the call with ATO and
+// returns the text after CONNECT. A call that was lost meanwhile gives
+// ErrNoCarrier.
+func Online(ctx context.Context, p xserial.Port) (string, error) {
+	s := &Script{
+		Steps:   []Step{{Send: "ATO\r"}, {Expect: "CONNECT"}},
+		Abort:   []string{"NO CARRIER", "ERROR"},
+		Timeout: 5 * time.Second,
+	}
+	if err := s.Run(ctx, p); err != nil {
+		if a, ok := err.(*AbortError); ok {
+			if a.Match == "ERROR" {
+				return "", ErrModem
+			}
+			return "", ErrNoCarrier
+		}
+		return "", err
+	}
+	return connectText(ctx, p, 5*time.Second), nil
+}
+
+// CommandMode escapes the call to command mode, runs fn with the Port, for
+// example with an atcmd session to query the signal, and returns to data
+// mode even when fn fails. cfg may be nil.
+func (c *Call) CommandMode(ctx context.Context, cfg *EscapeConfig, fn func(p xserial.Port) error) error {
+	if err := Escape(ctx, c.Port, cfg); err != nil {
+		return err
+	}
+	ferr := fn(c.Port)
+	connect, err := Online(ctx, c.Port)
+	if err != nil {
+		return err
+	}
+	c.Connect = connect
+	return ferr
+}
@@ -0,0 +1,200 @@
+// Package checksum provides the CRCs and simple checks serial protocols
+// use. CRCs are table driven and named after the catalogue of parametrised
+// CRC algorithms, with polynomials given in normal (MSB first) form.
+package checksum
+
+// CRC16 is a 16 bit CRC algorithm
+type CRC16 struct {
+	table     [256]uint16
+	init      uint16
+	xorOut    uint16
+	reflected bool
+}
+
+// NewCRC16 returns the CRC with the given polynomial, initial register,
+// final XOR and bit order. Reflected CRCs process bytes LSB first, as on
+// the wire of a UART.
+func NewCRC16(poly, init, xorOut uint16, reflected bool) *CRC16 {
+	c := &CRC16{init: init, xorOut: xorOut, reflected: reflected}
+	if reflected {
+		// Reflected Table and Register, so Update never Shifts Back
+		rpoly := reverse16(poly)
+		for i := range c.table {
+			r := uint16(i)
+			for j := 0; j < 8; j++ {
+				if r&1 != 0 {
+					r = r>>1 ^ rpoly
+				} else {
+					r >>= 1
+				}
+			}
+			c.table[i] = r
+		}
+		c.init = reverse16(init)
+		return c
+	}
+	for i := range c.table {
+		r := uint16(i) << 8
+		for j := 0; j < 8; j++ {
+			if r&0x8000 != 0 {
+				r = r<<1 ^ poly
+			} else {
+				r <<= 1
+			}
+		}
+		c.table[i] = r
+	}
+	return c
+}
+
+// Checksum returns the CRC of b
+func (c *CRC16) Checksum(b []byte) uint16 {
+	return c.Update(c.init^c.xorOut, b)
+}
+
+// Update returns the CRC of the data crc was computed over followed by b,
+// so a CRC can be built up in pieces starting from Checksum(nil)
+func (c *CRC16) Update(crc uint16, b []byte) uint16 {
+	r := crc ^ c.xorOut
+	if c.reflected {
+		for _, v := range b {
+			r = r>>8 ^ c.table[byte(r)^v]
+		}
+	} else {
+		for _, v := range b {
+			r = r<<8 ^ c.table[byte(r>>8)^v]
+		}
+	}
+	return r ^ c.xorOut
+}
+
+// CRC8 is an 8 bit CRC algorithm
+type CRC8 struct {
+	table  [256]byte
+	init   byte
+	xorOut byte
+}
+
+// NewCRC8 returns the CRC with the given polynomial, initial register,
+// final XOR and bit order
+func NewCRC8(poly, init, xorOut byte, reflected bool) *CRC8 {
+	c := &CRC8{init: init, xorOut: xorOut}
+	if reflected {
+		// Reflected Table and Register, so Update never Shifts Back
+		rpoly := reverse8(poly)
+		for i := range c.table {
+			r := byte(i)
+			for j := 0; j < 8; j++ {
+				if r&1 != 0 {
+					r = r>>1 ^ rpoly
+				} else {
+					r >>= 1
+				}
+			}
+			c.table[i] = r
+		}
+		c.init = reverse8(init)
+		return c
+	}
+	for i := range c.table {
+		r := byte(i)
+		for j := 0; j < 8; j++ {
+			if r&0x80 != 0 {
+				r = r<<1 ^ poly
+			} else {
+				r <<= 1
+			}
+		}
+		c.table[i] = r
+	}
+	return c
+}
+
+// Checksum returns the CRC of b
+func (c *CRC8) Checksum(b []byte) byte {
+	return c.Update(c.init^c.xorOut, b)
+}
+
+// Update returns the CRC of the data crc was computed over followed by b
+func (c *CRC8) Update(crc byte, b []byte) byte {
+	r := crc ^ c.xorOut
+	for _, v := range b {
+		r = c.table[r^v]
+	}
+	return r ^ c.xorOut
+}
+
+func reverse16(v uint16) uint16 {
+	var r uint16
+	for i := 0; i < 16; i++ {
+		r = r<<1 | v&1
+		v >>= 1
+	}
+	return r
+}
+
+func reverse8(v byte) byte {
+	var r byte
+	for i := 0; i < 8; i++ {
+		r = r<<1 | v&1
+		v >>= 1
+	}
+	return r
+}
+
+// Common CRCs
+var (
+	// CRC-16/MODBUS, sent low byte first
+	Modbus = NewCRC16(0x8005, 0xFFFF, 0x0000, true)
+	// CRC-16/CCITT-FALSE, often just called CRC-16/CCITT
+	CCITT = NewCRC16(0x1021, 0xFFFF, 0x0000, false)
+	// CRC-16/XMODEM, also used by YMODEM and ZMODEM
+	XModem = NewCRC16(0x1021, 0x0000, 0x0000, false)
+	// CRC-16/X-25, the FCS of HDLC and PPP, sent low byte first
+	X25 = NewCRC16(0x1021, 0xFFFF, 0xFFFF, true)
+	// CRC-16/MCRF4XX, X.25 without the final XOR, used by MAVLink
+	MCRF4XX = NewCRC16(0x1021, 0xFFFF, 0x0000, true)
+	// CRC-16/KERMIT
+	Kermit = NewCRC16(0x1021, 0x0000, 0x0000, true)
+	// CRC-16/DNP, used by DNP3, sent low byte first
+	DNP = NewCRC16(0x3D65, 0x0000, 0xFFFF, true)
+	// CRC-8/MAXIM, the Dallas 1-Wire CRC
+	Dallas = NewCRC8(0x31, 0x00, 0x00, true)
+	// CRC-8/SMBUS, the plain CRC-8
+	SMBus = NewCRC8(0x07, 0x00, 0x00, false)
+)
+
+// XOR returns all bytes of b XORed together, the block check character of
+// IEC 62056-21 and NMEA sentences
+func XOR(b []byte) byte {
+	var x byte
+	for _, v := range b {
+		x ^= v
+	}
+	return x
+}
+
+// Sum returns the sum of b modulo 256
+func Sum(b []byte) byte {
+	var s byte
+	for _, v := range b {
+		s += v
+	}
+	return s
+}
+
+// LRC returns the longitudinal redundancy check of Modbus ASCII, the two's
+// complement of Sum
+func LRC(b []byte) byte {
+	return -Sum(b)
+}
+
+// Fletcher8 returns the 8 bit Fletcher checksum of b as used by u-blox UBX,
+// two running sums modulo 256 sent ck_a first
+func Fletcher8(b []byte) (ckA, ckB byte) {
+	for _, v := range b {
+		ckA += v
+		ckB += ckA
+	}
+	return ckA, ckB
+}
@@ -0,0 +1,48 @@
+package xserial
+
+import (
+	"context"
+)
+
+// ChunkConfig configures WriteChunked
+type ChunkConfig struct {
+	// Bytes per Chunk, defaults to 256
+	ChunkSize int
+	// Called after each Chunk has been drained to the wire
+	Progress func(written, total int)
+}
+
+// WriteChunked writes data in chunks, draining the output after each one so
+// progress reflects bytes actually transmitted. It stops between chunks when
+// ctx is done and returns the number of bytes sent. cfg may be nil.
+func WriteChunked(ctx context.Context, p Port, data []byte, cfg *ChunkConfig) (int, error) {
+	var c ChunkConfig
+	if cfg != nil {
+		c = *cfg
+	}
+	if c.ChunkSize <= 0 {
+		c.ChunkSize = 256
+	}
+	written := 0
+	for written < len(data) {
+		if err := ctx.Err(); err != nil {
+			return written, err
+		}
+		end := written + c.ChunkSize
+		if end > len(data) {
+			end = len(data)
+		}
+		n, err := p.Write(data[written:end])
+		written += n
+		if err != nil {
+			return written, err
+		}
+		if err := p.Drain(); err != nil {
+			return written, err
+		}
+		if c.Progress != nil {
+			c.Progress(written, len(data))
+		}
+	}
+	return written, nil
+}
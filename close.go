@@ -0,0 +1,34 @@
+package xserial
+
+import (
+	"time"
+)
+
+// CloseWithTimeout waits up to d for written data to be transmitted and then
+// closes p, so the last response to a device is not cut off. If the output
+// has not drained by then it is discarded, which also stops Close blocking
+// in the driver, and ErrDrainTimeout is returned once p is closed. A device
+// that has been removed has nothing left to drain and is just closed.
+func CloseWithTimeout(p Port, d time.Duration) error {
+	drained := make(chan error, 1)
+	go func() { drained <- p.Drain() }()
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case err := <-drained:
+		cerr := p.Close()
+		if err != nil && err != ErrNotOpen && removedError(err) != ErrDeviceRemoved {
+			return err
+		}
+		return cerr
+	case <-t.C:
+	}
+	// Discarding the Output releases the blocked Drain
+	p.Flush()
+	<-drained
+	if err := p.Close(); err != nil {
+		return err
+	}
+	return ErrDrainTimeout
+}
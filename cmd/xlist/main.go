@@ -0,0 +1,101 @@
+// Command xlist prints the serial ports present with their USB details, as
+// a table or with -json as a JSON array for scripts. The -vid, -pid and
+// -serial flags keep only matching ports; xlist exits with status 1 when
+// none is left.
+//
+// Usage:
+//
+//	xlist [-json] [-usb] [-vid 0403] [-pid 6001] [-serial A50285BI]
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/packing/xserial"
+)
+
+// port is a PortInfo as written by -json
+type port struct {
+	Name         string `json:"name"`
+	Driver       string `json:"driver,omitempty"`
+	USB          bool   `json:"usb"`
+	VID          string `json:"vid,omitempty"`
+	PID          string `json:"pid,omitempty"`
+	SerialNumber string `json:"serial_number,omitempty"`
+	Manufacturer string `json:"manufacturer,omitempty"`
+	Product      string `json:"product,omitempty"`
+	Interface    string `json:"interface,omitempty"`
+	ByID         string `json:"by_id,omitempty"`
+	ByPath       string `json:"by_path,omitempty"`
+}
+
+func main() {
+	asJSON := flag.Bool("json", false, "print a JSON array")
+	usbOnly := flag.Bool("usb", false, "only list USB adapters")
+	vid := flag.String("vid", "", "only list ports with this USB vendor ID")
+	pid := flag.String("pid", "", "only list ports with this USB product ID")
+	serial := flag.String("serial", "", "only list ports with this USB serial number")
+	flag.Parse()
+	if flag.NArg() != 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	infos, err := xserial.ListPorts()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "xlist:", err)
+		os.Exit(1)
+	}
+	filtered := *usbOnly || *vid != "" || *pid != "" || *serial != ""
+	var ports []port
+	for _, info := range infos {
+		if *usbOnly && !info.USB ||
+			*vid != "" && !strings.EqualFold(info.VID, *vid) ||
+			*pid != "" && !strings.EqualFold(info.PID, *pid) ||
+			*serial != "" && info.SerialNumber != *serial {
+			continue
+		}
+		ports = append(ports, port(info))
+	}
+
+	if *asJSON {
+		if ports == nil {
+			ports = []port{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(ports)
+	} else {
+		printTable(ports)
+	}
+	if filtered && len(ports) == 0 {
+		os.Exit(1)
+	}
+}
+
+func printTable(ports []port) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tVID:PID\tSERIAL\tDRIVER\tDESCRIPTION")
+	for _, p := range ports {
+		id := "-"
+		if p.USB {
+			id = p.VID + ":" + p.PID
+		}
+		desc := strings.TrimSpace(p.Manufacturer + " " + p.Product)
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", p.Name, id, dash(p.SerialNumber), dash(p.Driver), dash(desc))
+	}
+	w.Flush()
+}
+
+// dash stands in for empty table cells
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,336 @@
+// Command xterm is a small interactive serial terminal in the spirit of
+// miniterm. Keys go to the Port unchanged and its output goes to the
+// screen, as text or as hex. Ctrl-] quits and Ctrl-T opens the escape menu
+// for the modem lines, breaks, the hex view and the session log.
+//
+// Usage:
+//
+//	xterm [flags] port
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/packing/xserial"
+	"golang.org/x/term"
+)
+
+// Control Keys
+const (
+	keyQuit = 0x1d // Ctrl-]
+	keyMenu = 0x14 // Ctrl-T
+)
+
+const menuHelp = `--- Ctrl-T Menu ---
+ Ctrl-T  send Ctrl-T         Ctrl-]  send Ctrl-]
+ d       toggle DTR          r       toggle RTS
+ b       send a break        m       show the modem lines
+ h       toggle the hex view l       start or stop the log
+ q       quit                ?       this help
+`
+
+// terminal is one session: the Port, the screen and the log
+type terminal struct {
+	p        xserial.Port
+	brk      time.Duration
+	logName  string
+	dtr, rts bool
+
+	// Guards the Screen and the Log, shared with the Reader
+	mx  sync.Mutex
+	out io.Writer
+	hex bool
+	// Bytes on the current Hex Line
+	col int
+	log *os.File
+}
+
+func main() {
+	baud := flag.Int("b", 115200, "baud rate")
+	parity := flag.String("parity", "N", "parity: N, E, O, M or S")
+	stop := flag.Int("stop", 1, "stop bits: 1 or 2")
+	flow := flag.String("flow", "none", "flow control: none, hard or soft")
+	hex := flag.Bool("hex", false, "start in the hex view")
+	logName := flag.String("log", "", "append everything received to this file")
+	brk := flag.Duration("break", 250*time.Millisecond, "length of a break")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: xterm [flags] port\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	// Log Records would land in the middle of the Session
+	xserial.SetLogger(nil)
+	cfg := &xserial.Config{Name: flag.Arg(0), Baud: *baud, Parity: strings.ToUpper(*parity), StopBits: *stop}
+	switch *flow {
+	case "none":
+		cfg.Flow = xserial.FlowNone
+	case "hard":
+		cfg.Flow = xserial.FlowHardware
+	case "soft":
+		cfg.Flow = xserial.FlowSoft
+	default:
+		fatal(fmt.Errorf("unknown flow control %q", *flow))
+	}
+	p, err := xserial.OpenPort(cfg)
+	if err != nil {
+		fatal(err)
+	}
+
+	t := &terminal{p: p, brk: *brk, logName: *logName, dtr: true, rts: true, out: os.Stdout, hex: *hex}
+	if t.logName != "" {
+		if err := t.openLog(); err != nil {
+			fatal(err)
+		}
+		defer t.closeLog()
+	}
+
+	// Raw Mode so every Key reaches the Port, Ctrl-C included
+	fd := int(os.Stdin.Fd())
+	if term.IsTerminal(fd) {
+		state, err := term.MakeRaw(fd)
+		if err != nil {
+			fatal(err)
+		}
+		defer term.Restore(fd, state)
+	}
+	t.notef("--- %s %d,%s,%d --- Ctrl-] quit, Ctrl-T menu ---", cfg.Name, cfg.Baud, cfg.Parity, cfg.StopBits)
+
+	errs := make(chan error, 2)
+	r := xserial.OnData(p, t.received, &xserial.AsyncConfig{OnError: func(err error) { errs <- err }})
+	defer func() {
+		// Closing first ends a Read the reader would otherwise wait in
+		p.Close()
+		r.Stop()
+	}()
+	go func() { errs <- t.keyboard(os.Stdin) }()
+	if err := <-errs; err != nil && err != io.EOF {
+		t.notef("--- %v ---", err)
+	}
+}
+
+// fatal reports err and exits, for errors before the terminal is raw
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "xterm:", err)
+	os.Exit(1)
+}
+
+// keyboard sends keys to the Port until Ctrl-], q in the menu, the end of
+// input or a write error
+func (t *terminal) keyboard(in io.Reader) error {
+	buf := make([]byte, 256)
+	menu := false
+	for {
+		n, err := in.Read(buf)
+		if err != nil {
+			return err
+		}
+		out := buf[:0]
+		for _, c := range buf[:n] {
+			switch {
+			case menu:
+				menu = false
+				if t.command(c, &out) {
+					return nil
+				}
+			case c == keyQuit:
+				if _, err := t.p.Write(out); err != nil {
+					return err
+				}
+				return nil
+			case c == keyMenu:
+				menu = true
+			default:
+				out = append(out, c)
+			}
+		}
+		if len(out) > 0 {
+			if _, err := t.p.Write(out); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// command runs the menu key c, appending it to out if it is a control key
+// to send, and reports whether to quit
+func (t *terminal) command(c byte, out *[]byte) bool {
+	switch c {
+	case keyMenu, keyQuit:
+		*out = append(*out, c)
+	case 'q', 'Q':
+		return true
+	case 'd', 'D':
+		lc, ok := t.lines()
+		if !ok {
+			break
+		}
+		if err := lc.SetDTR(!t.dtr); err != nil {
+			t.notef("--- DTR: %v ---", err)
+			break
+		}
+		t.dtr = !t.dtr
+		t.notef("--- DTR %s ---", onOff(t.dtr))
+	case 'r', 'R':
+		lc, ok := t.lines()
+		if !ok {
+			break
+		}
+		if err := lc.SetRTS(!t.rts); err != nil {
+			t.notef("--- RTS: %v ---", err)
+			break
+		}
+		t.rts = !t.rts
+		t.notef("--- RTS %s ---", onOff(t.rts))
+	case 'b', 'B':
+		lc, ok := t.lines()
+		if !ok {
+			break
+		}
+		if err := lc.SendBreak(t.brk); err != nil {
+			t.notef("--- break: %v ---", err)
+			break
+		}
+		t.notef("--- break sent ---")
+	case 'm', 'M':
+		t.modemLines()
+	case 'h', 'H':
+		t.mx.Lock()
+		t.hex = !t.hex
+		t.col = 0
+		hex := t.hex
+		t.mx.Unlock()
+		t.notef("--- hex view %s ---", onOff(hex))
+	case 'l', 'L':
+		t.toggleLog()
+	case '?':
+		t.notef("%s", strings.TrimSuffix(menuHelp, "\n"))
+	default:
+		t.notef("--- unknown menu key, Ctrl-T ? for help ---")
+	}
+	return false
+}
+
+// lines returns the LineController of the Port, saying so if it has none
+func (t *terminal) lines() (xserial.LineController, bool) {
+	lc, ok := t.p.(xserial.LineController)
+	if !ok {
+		t.notef("--- modem lines not supported ---")
+	}
+	return lc, ok
+}
+
+func (t *terminal) modemLines() {
+	lc, ok := t.lines()
+	if !ok {
+		return
+	}
+	lines, err := lc.ModemLines()
+	if err != nil {
+		t.notef("--- modem lines: %v ---", err)
+		return
+	}
+	names := []struct {
+		bit  int
+		name string
+	}{
+		{xserial.LineCTS, "CTS"}, {xserial.LineDSR, "DSR"}, {xserial.LineRI, "RI"},
+		{xserial.LineDCD, "DCD"}, {xserial.LineDTR, "DTR"}, {xserial.LineRTS, "RTS"},
+	}
+	var b strings.Builder
+	for _, n := range names {
+		fmt.Fprintf(&b, " %s %s", n.name, onOff(lines&n.bit != 0))
+	}
+	t.notef("---%s ---", b.String())
+}
+
+// received shows and logs what the Port sent, on the reader goroutine
+func (t *terminal) received(data []byte) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	if t.log != nil {
+		t.log.Write(data)
+	}
+	if !t.hex {
+		t.out.Write(data)
+		return
+	}
+	var b strings.Builder
+	for _, c := range data {
+		fmt.Fprintf(&b, "%02x ", c)
+		if t.col++; t.col == 16 {
+			b.WriteString("\r\n")
+			t.col = 0
+		}
+	}
+	io.WriteString(t.out, b.String())
+}
+
+// notef prints a message of the terminal itself on lines of its own
+func (t *terminal) notef(format string, args ...interface{}) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	t.col = 0
+	// The Screen is Raw, so Lines need their "\r"
+	msg := strings.Replace(fmt.Sprintf(format, args...), "\n", "\r\n", -1)
+	fmt.Fprintf(t.out, "\r\n%s\r\n", msg)
+}
+
+func (t *terminal) openLog() error {
+	f, err := os.OpenFile(t.logName, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	t.mx.Lock()
+	t.log = f
+	t.mx.Unlock()
+	return nil
+}
+
+func (t *terminal) closeLog() {
+	t.mx.Lock()
+	f := t.log
+	t.log = nil
+	t.mx.Unlock()
+	if f != nil {
+		f.Close()
+	}
+}
+
+// toggleLog stops the log, or starts it with the name given by -log or a
+// name from the time
+func (t *terminal) toggleLog() {
+	t.mx.Lock()
+	logging := t.log != nil
+	t.mx.Unlock()
+	if logging {
+		t.closeLog()
+		t.notef("--- log %s closed ---", t.logName)
+		return
+	}
+	if t.logName == "" {
+		t.logName = time.Now().Format("xterm-20060102-150405.log")
+	}
+	if err := t.openLog(); err != nil {
+		t.notef("--- log: %v ---", err)
+		return
+	}
+	t.notef("--- logging to %s ---", t.logName)
+}
+
+func onOff(on bool) string {
+	if on {
+		return "on"
+	}
+	return "off"
+}
@@ -0,0 +1,363 @@
+package cmux
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/packing/xserial"
+)
+
+// Channel is one virtual Port of a Mux. It also implements
+// xserial.LineController through the Modem Status Command and
+// xserial.ReadWaiter.
+type Channel struct {
+	m    *Mux
+	dlci int
+
+	mx     sync.Mutex
+	rx     []byte
+	notify chan struct{} // Closed whenever Data, Flow or State change
+	lines  int           // Our DTR / RTS and the Modem's Signals as Line* Bits
+	fc     bool          // Modem not Ready to Receive on this Channel
+	eof    bool          // Modem Closed the Channel or the Mux stopped
+	closed bool
+	stats  xserial.Stats
+	events chan xserial.Event
+	// RX Event delivered and not yet Read
+	rxQueued bool
+}
+
+var (
+	_ xserial.Port           = (*Channel)(nil)
+	_ xserial.LineController = (*Channel)(nil)
+	_ xserial.ReadWaiter     = (*Channel)(nil)
+)
+
+func newChannel(m *Mux, dlci int) *Channel {
+	return &Channel{m: m, dlci: dlci, notify: make(chan struct{}), lines: xserial.LineDTR | xserial.LineRTS}
+}
+
+// DLCI returns the channel number
+func (c *Channel) DLCI() int {
+	return c.dlci
+}
+
+// wake releases all waiters with mx held
+func (c *Channel) wake() {
+	close(c.notify)
+	c.notify = make(chan struct{})
+}
+
+func (c *Channel) wakeup() {
+	c.mx.Lock()
+	c.wake()
+	c.mx.Unlock()
+}
+
+// deliver queues data received for the channel
+func (c *Channel) deliver(data []byte) {
+	if len(data) == 0 {
+		return
+	}
+	c.mx.Lock()
+	c.rx = append(c.rx, data...)
+	c.wake()
+	c.mx.Unlock()
+	c.event(xserial.Event{Type: xserial.EventRXAvailable})
+}
+
+// hangup ends the channel from the far side; Reads return io.EOF once the
+// received data is consumed
+func (c *Channel) hangup(err error) {
+	c.mx.Lock()
+	c.eof = true
+	c.wake()
+	c.mx.Unlock()
+	if err == nil {
+		err = io.EOF
+	}
+	c.event(xserial.Event{Type: xserial.EventDisconnect, Err: err})
+}
+
+// remoteStatus applies the V.24 signals of an MSC from the modem
+func (c *Channel) remoteStatus(v24 byte) {
+	lines := 0
+	if v24&v24RTC != 0 {
+		lines |= xserial.LineDSR
+	}
+	if v24&v24RTR != 0 {
+		lines |= xserial.LineCTS
+	}
+	if v24&v24IC != 0 {
+		lines |= xserial.LineRI
+	}
+	if v24&v24DV != 0 {
+		lines |= xserial.LineDCD
+	}
+	c.mx.Lock()
+	lines |= c.lines & (xserial.LineDTR | xserial.LineRTS)
+	changed := lines != c.lines
+	c.lines = lines
+	c.fc = v24&v24FC != 0
+	c.wake()
+	c.mx.Unlock()
+	if changed {
+		c.event(xserial.Event{Type: xserial.EventLineStatus, Lines: lines})
+	}
+}
+
+// signals returns the V.24 octet for our side of the channel
+func (c *Channel) signals() byte {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	var v byte
+	if c.lines&xserial.LineDTR != 0 {
+		v |= v24RTC
+	}
+	if c.lines&xserial.LineRTS != 0 {
+		v |= v24RTR
+	}
+	return v
+}
+
+// event sends e if Events has been called; RX Events are not repeated
+// until the next Read
+func (c *Channel) event(e xserial.Event) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	if c.events == nil || c.closed {
+		return
+	}
+	if e.Type == xserial.EventRXAvailable {
+		if c.rxQueued {
+			return
+		}
+		c.rxQueued = true
+	}
+	e.Time = time.Now()
+	select {
+	case c.events <- e:
+	default:
+	}
+}
+
+// Read returns received bytes, waiting up to the Mux ReadTimeout for some
+// to arrive. Once the channel is closed by the modem and the data consumed
+// it returns io.EOF.
+func (c *Channel) Read(b []byte) (int, error) {
+	var deadline <-chan time.Time
+	if c.m.cfg.ReadTimeout > 0 {
+		t := time.NewTimer(c.m.cfg.ReadTimeout)
+		defer t.Stop()
+		deadline = t.C
+	}
+	for {
+		c.mx.Lock()
+		c.rxQueued = false
+		if c.closed {
+			c.mx.Unlock()
+			return 0, xserial.ErrNotOpen
+		}
+		if len(c.rx) > 0 {
+			n := copy(b, c.rx)
+			c.rx = c.rx[n:]
+			c.stats.BytesRead += uint64(n)
+			c.mx.Unlock()
+			return n, nil
+		}
+		if c.eof {
+			c.mx.Unlock()
+			return 0, io.EOF
+		}
+		notify := c.notify
+		if deadline == nil {
+			c.mx.Unlock()
+			return 0, nil
+		}
+		c.mx.Unlock()
+		select {
+		case <-notify:
+		case <-deadline:
+			c.mx.Lock()
+			c.stats.ReadTimeouts++
+			c.mx.Unlock()
+			return 0, xserial.ErrReadTimeout
+		}
+	}
+}
+
+// WaitReadable blocks until Read would return data or EOF
+func (c *Channel) WaitReadable(ctx context.Context) error {
+	for {
+		c.mx.Lock()
+		if c.closed {
+			c.mx.Unlock()
+			return xserial.ErrNotOpen
+		}
+		if len(c.rx) > 0 || c.eof {
+			c.mx.Unlock()
+			return nil
+		}
+		notify := c.notify
+		c.mx.Unlock()
+		select {
+		case <-notify:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Write sends b in UIH frames of at most the Mux FrameSize, waiting while
+// the modem has switched flow off
+func (c *Channel) Write(b []byte) (n int, err error) {
+	for n < len(b) {
+		if err = c.waitFlow(); err != nil {
+			break
+		}
+		chunk := b[n:]
+		if len(chunk) > c.m.cfg.FrameSize {
+			chunk = chunk[:c.m.cfg.FrameSize]
+		}
+		if err = c.m.send(Frame{DLCI: c.dlci, CR: true, Type: TypeUIH, Data: chunk}); err != nil {
+			break
+		}
+		n += len(chunk)
+	}
+	c.mx.Lock()
+	c.stats.BytesWritten += uint64(n)
+	if err != nil {
+		c.stats.WriteErrors++
+	}
+	c.mx.Unlock()
+	return n, err
+}
+
+// waitFlow blocks while flow is off for the channel or the whole Mux
+func (c *Channel) waitFlow() error {
+	for {
+		c.mx.Lock()
+		switch {
+		case c.closed:
+			c.mx.Unlock()
+			return xserial.ErrNotOpen
+		case c.eof:
+			c.mx.Unlock()
+			return io.ErrClosedPipe
+		}
+		notify := c.notify
+		ready := !c.fc
+		c.mx.Unlock()
+		if ready && !c.m.stopped() {
+			return nil
+		}
+		select {
+		case <-notify:
+		case <-c.m.done:
+		}
+	}
+}
+
+// Close disconnects the channel; the rest of the Mux carries on
+func (c *Channel) Close() error {
+	c.mx.Lock()
+	if c.closed {
+		c.mx.Unlock()
+		return xserial.ErrPortClosed
+	}
+	c.closed = true
+	eof := c.eof
+	c.wake()
+	if c.events != nil {
+		close(c.events)
+	}
+	c.mx.Unlock()
+	if eof {
+		return nil
+	}
+	_, err := c.m.command(c.dlci, Frame{DLCI: c.dlci, CR: true, Type: TypeDISC, PF: true})
+	c.m.forget(c)
+	if err == ErrClosed {
+		return nil
+	}
+	return err
+}
+
+// SetParity is not supported, channels have no framing of their own
+func (c *Channel) SetParity(parity string, stopbits int) error {
+	return xserial.ErrNotImplemented
+}
+
+// Flush discards received data not yet Read
+func (c *Channel) Flush() error {
+	c.mx.Lock()
+	c.rx = nil
+	c.mx.Unlock()
+	return nil
+}
+
+// Drain waits for the physical Port to send everything written
+func (c *Channel) Drain() error {
+	return c.m.p.Drain()
+}
+
+// Events returns RX, modem line and disconnect Events; the channel is
+// closed by Close
+func (c *Channel) Events() <-chan xserial.Event {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	if c.events == nil {
+		c.events = make(chan xserial.Event, 16)
+		if c.closed {
+			close(c.events)
+		}
+	}
+	return c.events
+}
+
+// Stats returns the traffic counters of the channel
+func (c *Channel) Stats() xserial.Stats {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	return c.stats
+}
+
+// setLine changes one of our signals and reports it to the modem
+func (c *Channel) setLine(line int, on bool) error {
+	c.mx.Lock()
+	if on {
+		c.lines |= line
+	} else {
+		c.lines &^= line
+	}
+	c.mx.Unlock()
+	return c.m.msc(c.dlci, c.signals(), 0)
+}
+
+func (c *Channel) SetDTR(on bool) error {
+	return c.setLine(xserial.LineDTR, on)
+}
+
+func (c *Channel) SetRTS(on bool) error {
+	return c.setLine(xserial.LineRTS, on)
+}
+
+// ModemLines returns our DTR and RTS and the signals last reported by the
+// modem
+func (c *Channel) ModemLines() (int, error) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	return c.lines, nil
+}
+
+// SendBreak asks the modem to send a break of d on its side, in 200ms
+// units of up to 3 seconds
+func (c *Channel) SendBreak(d time.Duration) error {
+	units := d / (200 * time.Millisecond)
+	if units > 15 {
+		units = 15
+	}
+	return c.m.msc(c.dlci, c.signals(), byte(units)<<4|0x03)
+}
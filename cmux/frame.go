@@ -0,0 +1,214 @@
+package cmux
+
+import (
+	"errors"
+)
+
+// Basic Option Flag Sequence
+const flag = 0xF9
+
+// Frame Types, the Control Field without the P/F Bit
+const (
+	TypeSABM = 0x2F
+	TypeUA   = 0x63
+	TypeDM   = 0x0F
+	TypeDISC = 0x43
+	TypeUIH  = 0xEF
+	TypeUI   = 0x03
+)
+
+// Poll / Final Bit of the Control Field
+const pfBit = 0x10
+
+// Largest Information Field the two byte Length can carry
+const maxInfo = 0x7FFF
+
+var errBadFrame = errors.New("cmux: malformed frame")
+
+// crcTable drives the reflected CRC-8 (x^8 + x^2 + x + 1) of TS 27.010
+var crcTable = func() (t [256]byte) {
+	for i := range t {
+		c := byte(i)
+		for b := 0; b < 8; b++ {
+			if c&1 != 0 {
+				c = c>>1 ^ 0xE0
+			} else {
+				c >>= 1
+			}
+		}
+		t[i] = c
+	}
+	return t
+}()
+
+// fcs returns the Frame Check Sequence over b
+func fcs(b []byte) byte {
+	c := byte(0xFF)
+	for _, v := range b {
+		c = crcTable[c^v]
+	}
+	return 0xFF - c
+}
+
+// Frame is one basic option frame
+type Frame struct {
+	DLCI int
+	// Command / Response Bit of the Address
+	CR bool
+	// One of the Type* values
+	Type byte
+	// Poll / Final Bit
+	PF   bool
+	Data []byte
+}
+
+// Marshal encodes f with its flags and FCS
+func (f *Frame) Marshal() []byte {
+	b := make([]byte, 0, len(f.Data)+7)
+	b = append(b, flag, byte(f.DLCI<<2|1))
+	if f.CR {
+		b[1] |= 0x02
+	}
+	ctrl := f.Type
+	if f.PF {
+		ctrl |= pfBit
+	}
+	b = append(b, ctrl)
+	if n := len(f.Data); n <= 0x7F {
+		b = append(b, byte(n<<1|1))
+	} else {
+		b = append(b, byte(n<<1), byte(n>>7))
+	}
+	hdr := len(b)
+	b = append(b, f.Data...)
+	// UIH Frames only Check the Header
+	if f.Type == TypeUIH {
+		b = append(b, fcs(b[1:hdr]))
+	} else {
+		b = append(b, fcs(b[1:]))
+	}
+	return append(b, flag)
+}
+
+// ParseFrame decodes a complete frame as returned by a Decoder
+func ParseFrame(b []byte) (Frame, error) {
+	var f Frame
+	if len(b) < 6 || b[0] != flag || b[len(b)-1] != flag || b[1]&1 == 0 {
+		return f, errBadFrame
+	}
+	f.DLCI = int(b[1] >> 2)
+	f.CR = b[1]&0x02 != 0
+	f.Type = b[2] &^ pfBit
+	f.PF = b[2]&pfBit != 0
+	n, hdr := int(b[3]>>1), 4
+	if b[3]&1 == 0 {
+		if len(b) < 7 {
+			return f, errBadFrame
+		}
+		n |= int(b[4]) << 7
+		hdr = 5
+	}
+	if len(b) != hdr+n+2 {
+		return f, errBadFrame
+	}
+	checked := b[1:hdr]
+	if f.Type != TypeUIH {
+		checked = b[1 : hdr+n]
+	}
+	if fcs(checked) != b[hdr+n] {
+		return f, errBadFrame
+	}
+	f.Data = b[hdr : hdr+n]
+	return f, nil
+}
+
+// Decoder is an xserial.FrameDecoder for basic option frames. It returns
+// each frame with its flags, ready for ParseFrame; frames with a bad FCS
+// are dropped and counted as resyncs.
+type Decoder struct {
+	buf     []byte
+	resyncs uint64
+	// Discarding Bytes outside a Frame
+	stray bool
+}
+
+// NewDecoder returns an empty Decoder
+func NewDecoder() *Decoder {
+	return &Decoder{}
+}
+
+// need returns the full length of the frame being collected, or zero while
+// the header is incomplete
+func (d *Decoder) need() int {
+	if len(d.buf) < 4 {
+		return 0
+	}
+	if d.buf[3]&1 != 0 {
+		return 4 + int(d.buf[3]>>1) + 2
+	}
+	if len(d.buf) < 5 {
+		return 0
+	}
+	return 5 + (int(d.buf[3]>>1) | int(d.buf[4])<<7) + 2
+}
+
+func (d *Decoder) Feed(p []byte) [][]byte {
+	var frames [][]byte
+	for _, b := range p {
+		frames = d.step(b, frames)
+	}
+	return frames
+}
+
+// step consumes one byte and appends a completed frame to frames
+func (d *Decoder) step(b byte, frames [][]byte) [][]byte {
+	if len(d.buf) == 0 {
+		if b != flag {
+			if !d.stray {
+				d.stray = true
+				d.resyncs++
+			}
+			return frames
+		}
+		d.stray = false
+		d.buf = append(d.buf, b)
+		return frames
+	}
+	// Repeated Flags between Frames
+	if len(d.buf) == 1 && b == flag {
+		return frames
+	}
+	d.buf = append(d.buf, b)
+	if n := d.need(); n == 0 || len(d.buf) < n {
+		return frames
+	}
+	if _, err := ParseFrame(d.buf); err == nil {
+		frames = append(frames, append([]byte(nil), d.buf...))
+		// The Closing Flag may open the next Frame
+		d.buf = append(d.buf[:0], flag)
+		return frames
+	}
+	// Bad Frame - Rescan from the next Flag after the Opening one
+	d.resyncs++
+	rest := append([]byte(nil), d.buf[1:]...)
+	d.buf = d.buf[:0]
+	d.stray = true
+	for i, c := range rest {
+		if c == flag {
+			for _, c := range rest[i:] {
+				frames = d.step(c, frames)
+			}
+			break
+		}
+	}
+	return frames
+}
+
+func (d *Decoder) Resyncs() uint64 {
+	return d.resyncs
+}
+
+func (d *Decoder) Reset() {
+	d.buf = d.buf[:0]
+	d.stray = false
+}
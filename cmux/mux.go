@@ -0,0 +1,434 @@
+// Package cmux implements the 3GPP TS 27.010 (GSM 07.10) multiplexer in
+// user space, turning one physical modem Port into several virtual Ports,
+// for example an AT channel, a PPP data channel and an NMEA channel used at
+// the same time.
+//
+// Only the basic option is supported. Switch the modem into multiplexer
+// mode first, typically with AT+CMUX=0, and hand the Port to New.
+package cmux
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/packing/xserial"
+)
+
+// Control Channel Message Types with the EA Bit set and the C/R Bit clear
+const (
+	msgPN    = 0x81 // Parameter Negotiation
+	msgPSC   = 0x41 // Power Saving Control
+	msgCLD   = 0xC1 // Multiplexer Close Down
+	msgTest  = 0x21
+	msgFCon  = 0xA1 // Flow Control On for all Channels
+	msgFCoff = 0x61 // Flow Control Off for all Channels
+	msgMSC   = 0xE1 // Modem Status Command
+	msgNSC   = 0x11 // Non Supported Command Response
+	msgCR    = 0x02 // Command Bit of the Message Type
+)
+
+// V.24 Signals of the MSC
+const (
+	v24FC  = 0x02 // Flow Control - Receiver not Ready
+	v24RTC = 0x04 // DTR / DSR
+	v24RTR = 0x08 // RTS / CTS
+	v24IC  = 0x40 // Ring Indicator
+	v24DV  = 0x80 // Data Valid - DCD
+)
+
+var (
+	// ErrNoResponse - the modem did not answer a command within the
+	// configured retries
+	ErrNoResponse = errors.New("cmux: no response from modem")
+	// ErrRefused - the modem answered with DM, the DLCI is not available
+	ErrRefused = errors.New("cmux: channel refused")
+	// ErrChannelOpen - the DLCI is already open on this Mux
+	ErrChannelOpen = errors.New("cmux: channel already open")
+	// ErrInvalidDLCI - DLCIs 1 to 62 carry channels
+	ErrInvalidDLCI = errors.New("cmux: invalid DLCI")
+	// ErrClosed - the Mux has been closed or the modem closed it down
+	ErrClosed = errors.New("cmux: multiplexer closed")
+)
+
+// Config configures a Mux
+type Config struct {
+	// Largest Information Field per Frame (N1), must match the AT+CMUX
+	// setting; defaults to 31
+	FrameSize int
+	// How long to wait for an answer to a command (T1), defaults to 1 second
+	Timeout time.Duration
+	// Further attempts after a command goes unanswered (N2), defaults to 3;
+	// negative for none
+	Retries int
+	// How long Channel Reads wait for data; zero returns at once like a
+	// Port opened without a ReadTimeout
+	ReadTimeout time.Duration
+}
+
+// Mux runs the multiplexer protocol over a physical Port
+type Mux struct {
+	p      xserial.Port
+	cfg    Config
+	dec    *Decoder
+	reader *xserial.AsyncReader
+	// One Command in flight at a time
+	cmdMx sync.Mutex
+	done  chan struct{}
+
+	mx       sync.Mutex
+	channels map[int]*Channel
+	// Pending Commands by DLCI, or 64 + Message Type for Control Messages
+	waits   map[int]chan Frame
+	flowOff bool
+	closed  bool
+	err     error
+}
+
+// New starts the multiplexer on p, which must already be in multiplexer
+// mode, and opens the control channel. p stays owned by the caller and is
+// not closed by the Mux. cfg may be nil.
+func New(p xserial.Port, cfg *Config) (*Mux, error) {
+	var c Config
+	if cfg != nil {
+		c = *cfg
+	}
+	if c.FrameSize <= 0 {
+		c.FrameSize = 31
+	}
+	if c.FrameSize > maxInfo {
+		c.FrameSize = maxInfo
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = time.Second
+	}
+	if c.Retries < 0 {
+		c.Retries = 0
+	} else if c.Retries == 0 {
+		c.Retries = 3
+	}
+	m := &Mux{
+		p:        p,
+		cfg:      c,
+		dec:      NewDecoder(),
+		done:     make(chan struct{}),
+		channels: make(map[int]*Channel),
+		waits:    make(map[int]chan Frame),
+	}
+	// Frames arriving before reader is Set wait for mx in handle
+	m.mx.Lock()
+	m.reader = xserial.OnData(p, m.receive, &xserial.AsyncConfig{OnError: m.shutdown})
+	m.mx.Unlock()
+
+	r, err := m.command(0, Frame{DLCI: 0, CR: true, Type: TypeSABM, PF: true})
+	if err == nil && r.Type == TypeDM {
+		err = ErrRefused
+	}
+	if err != nil {
+		m.shutdown(err)
+		m.reader.Stop()
+		return nil, err
+	}
+	return m, nil
+}
+
+// Open opens the channel on dlci, 1 to 62, and raises its DTR and RTS
+func (m *Mux) Open(dlci int) (*Channel, error) {
+	if dlci < 1 || dlci > 62 {
+		return nil, ErrInvalidDLCI
+	}
+	c := newChannel(m, dlci)
+	m.mx.Lock()
+	if m.closed {
+		m.mx.Unlock()
+		return nil, ErrClosed
+	}
+	if _, ok := m.channels[dlci]; ok {
+		m.mx.Unlock()
+		return nil, ErrChannelOpen
+	}
+	// Registered before SABM so Data following the UA is kept
+	m.channels[dlci] = c
+	m.mx.Unlock()
+
+	r, err := m.command(dlci, Frame{DLCI: dlci, CR: true, Type: TypeSABM, PF: true})
+	if err == nil && r.Type == TypeDM {
+		err = ErrRefused
+	}
+	if err != nil {
+		m.forget(c)
+		return nil, err
+	}
+	// Modems that never answer the MSC still carry Data
+	m.msc(dlci, c.signals(), 0)
+	return c, nil
+}
+
+// Err returns the error that stopped the Mux, if any
+func (m *Mux) Err() error {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	return m.err
+}
+
+// Done is closed once the Mux has stopped
+func (m *Mux) Done() <-chan struct{} {
+	return m.done
+}
+
+// Close closes the multiplexer down and all its channels. The modem
+// returns to AT command mode; the physical Port is left open.
+func (m *Mux) Close() error {
+	m.mx.Lock()
+	closed := m.closed
+	m.mx.Unlock()
+	if closed {
+		return ErrClosed
+	}
+	_, err := m.control(msgCLD, nil)
+	m.shutdown(nil)
+	m.reader.Stop()
+	return err
+}
+
+// shutdown stops the Mux and ends every channel
+func (m *Mux) shutdown(err error) {
+	m.mx.Lock()
+	if m.closed {
+		m.mx.Unlock()
+		return
+	}
+	m.closed = true
+	m.err = err
+	channels := m.channels
+	m.channels = make(map[int]*Channel)
+	close(m.done)
+	m.mx.Unlock()
+	for _, c := range channels {
+		c.hangup(ErrClosed)
+	}
+}
+
+// closedDown ends the Mux after the modem closed it, from the reader
+// goroutine, which cannot wait for itself to stop
+func (m *Mux) closedDown() {
+	m.shutdown(ErrClosed)
+	go func() {
+		m.mx.Lock()
+		r := m.reader
+		m.mx.Unlock()
+		r.Stop()
+	}()
+}
+
+// forget removes c from the channel table
+func (m *Mux) forget(c *Channel) {
+	m.mx.Lock()
+	if m.channels[c.dlci] == c {
+		delete(m.channels, c.dlci)
+	}
+	m.mx.Unlock()
+}
+
+// send writes one frame; Port Writes are atomic, so frames never interleave
+func (m *Mux) send(f Frame) error {
+	_, err := m.p.Write(f.Marshal())
+	return err
+}
+
+// command sends f and waits for the answer registered under key
+func (m *Mux) command(key int, f Frame) (Frame, error) {
+	m.cmdMx.Lock()
+	defer m.cmdMx.Unlock()
+	ch := make(chan Frame, 1)
+	m.mx.Lock()
+	if m.closed {
+		m.mx.Unlock()
+		return Frame{}, ErrClosed
+	}
+	m.waits[key] = ch
+	m.mx.Unlock()
+	defer func() {
+		m.mx.Lock()
+		delete(m.waits, key)
+		m.mx.Unlock()
+	}()
+
+	t := time.NewTimer(m.cfg.Timeout)
+	defer t.Stop()
+	for attempt := 0; attempt <= m.cfg.Retries; attempt++ {
+		if err := m.send(f); err != nil {
+			return Frame{}, err
+		}
+		if attempt > 0 {
+			t.Reset(m.cfg.Timeout)
+		}
+		select {
+		case r := <-ch:
+			return r, nil
+		case <-m.done:
+			return Frame{}, ErrClosed
+		case <-t.C:
+		}
+	}
+	return Frame{}, ErrNoResponse
+}
+
+// control sends a control channel command and waits for its response
+func (m *Mux) control(typ byte, values []byte) ([]byte, error) {
+	r, err := m.command(64+int(typ), Frame{DLCI: 0, CR: true, Type: TypeUIH, Data: controlMessage(typ|msgCR, values)})
+	return r.Data, err
+}
+
+// msc sends the Modem Status Command for dlci; brk is the break octet or 0
+func (m *Mux) msc(dlci int, signals, brk byte) error {
+	values := []byte{byte(dlci<<2 | 0x03), signals | 0x01}
+	if brk != 0 {
+		values = append(values, brk)
+	}
+	_, err := m.control(msgMSC, values)
+	return err
+}
+
+// controlMessage encodes a control channel message
+func controlMessage(typ byte, values []byte) []byte {
+	b := []byte{typ}
+	if n := len(values); n <= 0x7F {
+		b = append(b, byte(n<<1|1))
+	} else {
+		b = append(b, byte(n<<1), byte(n>>7<<1|1))
+	}
+	return append(b, values...)
+}
+
+// parseControl splits a control channel message
+func parseControl(b []byte) (typ byte, values []byte, ok bool) {
+	if len(b) < 2 {
+		return 0, nil, false
+	}
+	typ = b[0]
+	n, i := 0, 1
+	for shift := 0; i < len(b); shift += 7 {
+		n |= int(b[i]>>1) << shift
+		i++
+		if b[i-1]&1 != 0 {
+			if i+n > len(b) {
+				return 0, nil, false
+			}
+			return typ, b[i : i+n], true
+		}
+	}
+	return 0, nil, false
+}
+
+// receive handles bytes from the physical Port, on the reader goroutine
+func (m *Mux) receive(b []byte) {
+	for _, raw := range m.dec.Feed(b) {
+		f, err := ParseFrame(raw)
+		if err != nil {
+			continue
+		}
+		m.handle(f)
+	}
+}
+
+func (m *Mux) handle(f Frame) {
+	m.mx.Lock()
+	c := m.channels[f.DLCI]
+	m.mx.Unlock()
+	switch f.Type {
+	case TypeUA, TypeDM:
+		if m.answer(f.DLCI, f) {
+			return
+		}
+		if f.Type == TypeDM && c != nil {
+			// Unsolicited DM - the Channel is gone
+			m.forget(c)
+			c.hangup(nil)
+		}
+	case TypeSABM:
+		// Channels are only opened from this End
+		m.send(Frame{DLCI: f.DLCI, Type: TypeDM, PF: f.PF})
+	case TypeDISC:
+		m.send(Frame{DLCI: f.DLCI, Type: TypeUA, PF: f.PF})
+		if f.DLCI == 0 {
+			m.closedDown()
+		} else if c != nil {
+			m.forget(c)
+			c.hangup(nil)
+		}
+	case TypeUIH, TypeUI:
+		if f.DLCI == 0 {
+			m.handleControl(f.Data)
+		} else if c != nil {
+			c.deliver(f.Data)
+		}
+	}
+}
+
+// answer hands a reply to a waiting command
+func (m *Mux) answer(key int, f Frame) bool {
+	m.mx.Lock()
+	ch, ok := m.waits[key]
+	m.mx.Unlock()
+	if !ok {
+		return false
+	}
+	select {
+	case ch <- f:
+	default:
+	}
+	return true
+}
+
+// handleControl processes a control channel message from the modem
+func (m *Mux) handleControl(b []byte) {
+	typ, values, ok := parseControl(b)
+	if !ok {
+		return
+	}
+	if typ&msgCR == 0 {
+		// Response to one of our Commands
+		m.answer(64+int(typ), Frame{Data: values})
+		return
+	}
+	typ &^= msgCR
+	reply := values
+	switch typ {
+	case msgMSC:
+		if len(values) < 2 {
+			return
+		}
+		m.mx.Lock()
+		c := m.channels[int(values[0]>>2)]
+		m.mx.Unlock()
+		if c != nil {
+			c.remoteStatus(values[1])
+		}
+	case msgFCon, msgFCoff:
+		m.mx.Lock()
+		m.flowOff = typ == msgFCoff
+		channels := make([]*Channel, 0, len(m.channels))
+		for _, c := range m.channels {
+			channels = append(channels, c)
+		}
+		m.mx.Unlock()
+		for _, c := range channels {
+			c.wakeup()
+		}
+	case msgCLD:
+		defer m.closedDown()
+	case msgTest, msgPN, msgPSC:
+		// Echo Test Data and accept the offered Parameters
+	default:
+		typ, reply = msgNSC, []byte{b[0]}
+	}
+	m.send(Frame{DLCI: 0, CR: true, Type: TypeUIH, Data: controlMessage(typ, reply)})
+}
+
+// stopped reports whether the modem has switched off flow on all channels
+func (m *Mux) stopped() bool {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	return m.flowOff
+}
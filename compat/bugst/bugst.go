@@ -0,0 +1,210 @@
+// Package bugst adapts between xserial Ports and go.bug.st/serial Ports, so
+// code written against either package can be moved over one piece at a time.
+package bugst
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/packing/xserial"
+	"go.bug.st/serial"
+)
+
+var parities = map[serial.Parity]string{
+	serial.NoParity:    "N",
+	serial.OddParity:   "O",
+	serial.EvenParity:  "E",
+	serial.MarkParity:  "M",
+	serial.SpaceParity: "S",
+}
+
+// configFor converts a go.bug.st Mode into an xserial Config
+func configFor(name string, mode *serial.Mode) (xserial.Config, error) {
+	c := xserial.Config{Name: name, Baud: 9600, Parity: "N", StopBits: 1}
+	if mode == nil {
+		return c, nil
+	}
+	if mode.BaudRate != 0 {
+		c.Baud = mode.BaudRate
+	}
+	if mode.DataBits != 0 && mode.DataBits != 8 {
+		return c, fmt.Errorf("bugst: %d data bits not supported", mode.DataBits)
+	}
+	var ok bool
+	if c.Parity, ok = parities[mode.Parity]; !ok {
+		return c, fmt.Errorf("bugst: invalid parity %d", mode.Parity)
+	}
+	switch mode.StopBits {
+	case serial.OneStopBit:
+		c.StopBits = 1
+	case serial.TwoStopBits:
+		c.StopBits = 2
+	default:
+		return c, fmt.Errorf("bugst: 1.5 stop bits not supported")
+	}
+	return c, nil
+}
+
+// port presents an xserial Port as a go.bug.st/serial Port
+type port struct {
+	mx      sync.Mutex
+	p       xserial.Port
+	cfg     xserial.Config
+	timeout time.Duration
+}
+
+// Open opens name with xserial and returns it as a go.bug.st/serial Port,
+// as a drop-in replacement for serial.Open
+func Open(name string, mode *serial.Mode) (serial.Port, error) {
+	c, err := configFor(name, mode)
+	if err != nil {
+		return nil, err
+	}
+	p, err := xserial.OpenPort(&c)
+	if err != nil {
+		return nil, err
+	}
+	return &port{p: p, cfg: c, timeout: serial.NoTimeout}, nil
+}
+
+// ToBugst presents p as a go.bug.st/serial Port. cfg describes how p was
+// opened and may be nil, in which case SetMode can only change the framing.
+func ToBugst(p xserial.Port, cfg *xserial.Config) serial.Port {
+	a := &port{p: p, timeout: serial.NoTimeout}
+	if cfg != nil {
+		a.cfg = *cfg
+	}
+	return a
+}
+
+func (a *port) port() xserial.Port {
+	a.mx.Lock()
+	defer a.mx.Unlock()
+	return a.p
+}
+
+// SetMode changes the framing in place, and reopens the Port when the
+// baud rate changes and the adapter knows the device name
+func (a *port) SetMode(mode *serial.Mode) error {
+	c, err := configFor(a.cfg.Name, mode)
+	if err != nil {
+		return err
+	}
+	a.mx.Lock()
+	defer a.mx.Unlock()
+	if mode.BaudRate == 0 || mode.BaudRate == a.cfg.Baud {
+		if err = a.p.SetParity(c.Parity, c.StopBits); err == nil {
+			a.cfg.Parity, a.cfg.StopBits = c.Parity, c.StopBits
+		}
+		return err
+	}
+	if a.cfg.Name == "" {
+		return fmt.Errorf("bugst: changing the baud rate needs the port config")
+	}
+	next := a.cfg
+	next.Baud, next.Parity, next.StopBits = c.Baud, c.Parity, c.StopBits
+	a.p.Close()
+	p, err := xserial.OpenPort(&next)
+	if err != nil {
+		return err
+	}
+	a.p, a.cfg = p, next
+	return nil
+}
+
+// Read blocks until at least one byte arrives. Once the read timeout has
+// passed it returns 0 with no error, like go.bug.st/serial.
+func (a *port) Read(b []byte) (int, error) {
+	a.mx.Lock()
+	p, timeout := a.p, a.timeout
+	a.mx.Unlock()
+
+	ctx := context.Background()
+	if timeout >= 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	for {
+		n, err := xserial.ReadContext(ctx, p, b)
+		if err == context.DeadlineExceeded {
+			return 0, nil
+		}
+		if err == xserial.ErrReadTimeout {
+			err = nil
+		}
+		if n > 0 || err != nil || ctx.Err() != nil {
+			return n, err
+		}
+	}
+}
+
+func (a *port) Write(b []byte) (int, error) {
+	return a.port().Write(b)
+}
+
+// ResetInputBuffer flushes the Port; xserial discards both directions
+func (a *port) ResetInputBuffer() error {
+	return a.port().Flush()
+}
+
+// ResetOutputBuffer flushes the Port; xserial discards both directions
+func (a *port) ResetOutputBuffer() error {
+	return a.port().Flush()
+}
+
+func (a *port) SetDTR(dtr bool) error {
+	l, ok := xserial.As[xserial.LineController](a.port())
+	if !ok {
+		return fmt.Errorf("bugst: port has no modem control")
+	}
+	return l.SetDTR(dtr)
+}
+
+func (a *port) SetRTS(rts bool) error {
+	l, ok := xserial.As[xserial.LineController](a.port())
+	if !ok {
+		return fmt.Errorf("bugst: port has no modem control")
+	}
+	return l.SetRTS(rts)
+}
+
+func (a *port) GetModemStatusBits() (*serial.ModemStatusBits, error) {
+	l, ok := xserial.As[xserial.LineController](a.port())
+	if !ok {
+		return nil, fmt.Errorf("bugst: port has no modem control")
+	}
+	lines, err := l.ModemLines()
+	if err != nil {
+		return nil, err
+	}
+	return &serial.ModemStatusBits{
+		CTS: lines&xserial.LineCTS != 0,
+		DSR: lines&xserial.LineDSR != 0,
+		RI:  lines&xserial.LineRI != 0,
+		DCD: lines&xserial.LineDCD != 0,
+	}, nil
+}
+
+// SetReadTimeout bounds Read, serial.NoTimeout blocks until data arrives
+func (a *port) SetReadTimeout(t time.Duration) error {
+	a.mx.Lock()
+	a.timeout = t
+	a.mx.Unlock()
+	return nil
+}
+
+func (a *port) Close() error {
+	return a.port().Close()
+}
+
+// Unwrap returns the xserial Port behind a Port from Open or ToBugst
+func Unwrap(p serial.Port) (xserial.Port, bool) {
+	a, ok := p.(*port)
+	if !ok {
+		return nil, false
+	}
+	return a.port(), true
+}
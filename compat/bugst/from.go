@@ -0,0 +1,167 @@
+package bugst
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/packing/xserial"
+	"go.bug.st/serial"
+)
+
+// fromPort presents a go.bug.st/serial Port as an xserial Port
+type fromPort struct {
+	p serial.Port
+
+	mx     sync.Mutex
+	mode   serial.Mode
+	stats  xserial.Stats
+	events chan xserial.Event
+	closed bool
+}
+
+var _ xserial.LineController = (*fromPort)(nil)
+
+// FromBugst presents p as an xserial Port. mode is the Mode p was opened
+// with, which SetParity starts from; nil means go.bug.st's defaults. Events
+// never delivers, and Drain returns at once as go.bug.st cannot wait for
+// the transmitter.
+func FromBugst(p serial.Port, mode *serial.Mode) xserial.Port {
+	f := &fromPort{p: p, mode: serial.Mode{BaudRate: 9600, DataBits: 8}}
+	if mode != nil {
+		f.mode = *mode
+	}
+	return f
+}
+
+func (f *fromPort) count(fn func(s *xserial.Stats)) {
+	f.mx.Lock()
+	fn(&f.stats)
+	f.mx.Unlock()
+}
+
+func (f *fromPort) Read(b []byte) (int, error) {
+	n, err := f.p.Read(b)
+	f.count(func(s *xserial.Stats) {
+		s.BytesRead += uint64(n)
+		if err != nil {
+			s.ReadErrors++
+		}
+	})
+	return n, err
+}
+
+func (f *fromPort) Write(b []byte) (int, error) {
+	n, err := f.p.Write(b)
+	f.count(func(s *xserial.Stats) {
+		s.BytesWritten += uint64(n)
+		if err != nil {
+			s.WriteErrors++
+		}
+	})
+	return n, err
+}
+
+func (f *fromPort) Close() error {
+	f.mx.Lock()
+	if f.closed {
+		f.mx.Unlock()
+		return xserial.ErrPortClosed
+	}
+	f.closed = true
+	if f.events != nil {
+		close(f.events)
+	}
+	f.mx.Unlock()
+	return f.p.Close()
+}
+
+func (f *fromPort) SetParity(parity string, stopbits int) error {
+	var m serial.Mode
+	f.mx.Lock()
+	m = f.mode
+	f.mx.Unlock()
+	found := false
+	for k, v := range parities {
+		if v == parity {
+			m.Parity, found = k, true
+		}
+	}
+	if !found {
+		return fmt.Errorf("bugst: invalid parity %q", parity)
+	}
+	switch stopbits {
+	case 0, 1:
+		m.StopBits = serial.OneStopBit
+	case 2:
+		m.StopBits = serial.TwoStopBits
+	default:
+		return fmt.Errorf("bugst: invalid stop bits %d", stopbits)
+	}
+	if err := f.p.SetMode(&m); err != nil {
+		return err
+	}
+	f.mx.Lock()
+	f.mode = m
+	f.mx.Unlock()
+	return nil
+}
+
+func (f *fromPort) Flush() error {
+	if err := f.p.ResetInputBuffer(); err != nil {
+		return err
+	}
+	return f.p.ResetOutputBuffer()
+}
+
+func (f *fromPort) Drain() error {
+	return nil
+}
+
+func (f *fromPort) Events() <-chan xserial.Event {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	if f.events == nil {
+		f.events = make(chan xserial.Event)
+		if f.closed {
+			close(f.events)
+		}
+	}
+	return f.events
+}
+
+func (f *fromPort) Stats() xserial.Stats {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	return f.stats
+}
+
+func (f *fromPort) SetDTR(on bool) error {
+	return f.p.SetDTR(on)
+}
+
+func (f *fromPort) SetRTS(on bool) error {
+	return f.p.SetRTS(on)
+}
+
+func (f *fromPort) ModemLines() (int, error) {
+	bits, err := f.p.GetModemStatusBits()
+	if err != nil {
+		return 0, err
+	}
+	lines := 0
+	for _, b := range []struct {
+		on   bool
+		line int
+	}{{bits.CTS, xserial.LineCTS}, {bits.DSR, xserial.LineDSR}, {bits.RI, xserial.LineRI}, {bits.DCD, xserial.LineDCD}} {
+		if b.on {
+			lines |= b.line
+		}
+	}
+	return lines, nil
+}
+
+// SendBreak is not offered by go.bug.st/serial v1.3
+func (f *fromPort) SendBreak(d time.Duration) error {
+	return fmt.Errorf("bugst: break not supported")
+}
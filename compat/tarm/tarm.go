@@ -0,0 +1,178 @@
+// Package tarm adapts between xserial Ports and github.com/tarm/serial, so
+// code written against either package can be moved over one piece at a time.
+package tarm
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/packing/xserial"
+	"github.com/tarm/serial"
+)
+
+// Port is the method set of a tarm/serial Port. Both *serial.Port and
+// every xserial Port satisfy it.
+type Port interface {
+	io.ReadWriteCloser
+	Flush() error
+}
+
+var (
+	_ Port = (*serial.Port)(nil)
+	_ Port = xserial.Port(nil)
+)
+
+// configFor converts a tarm Config into an xserial Config
+func configFor(c *serial.Config) (xserial.Config, error) {
+	x := xserial.Config{Name: c.Name, Baud: c.Baud, Parity: "N", StopBits: 1}
+	if c.Size != 0 && c.Size != serial.DefaultSize {
+		return x, fmt.Errorf("tarm: %d data bits not supported", c.Size)
+	}
+	switch c.Parity {
+	case 0:
+	case serial.ParityNone, serial.ParityOdd, serial.ParityEven, serial.ParityMark, serial.ParitySpace:
+		x.Parity = string(rune(c.Parity))
+	default:
+		return x, fmt.Errorf("tarm: invalid parity %q", c.Parity)
+	}
+	switch c.StopBits {
+	case 0, serial.Stop1:
+	case serial.Stop2:
+		x.StopBits = 2
+	default:
+		return x, fmt.Errorf("tarm: 1.5 stop bits not supported")
+	}
+	// xserial counts ReadTimeout in Milliseconds
+	if c.ReadTimeout > 0 {
+		x.ReadTimeout = (c.ReadTimeout + time.Millisecond - 1) / time.Millisecond
+	}
+	return x, nil
+}
+
+// OpenPort opens a Port with xserial from a tarm Config, as a drop-in
+// replacement for serial.OpenPort. A Read that times out returns 0 with no
+// error, as tarm/serial does on Windows.
+func OpenPort(c *serial.Config) (xserial.Port, error) {
+	x, err := configFor(c)
+	if err != nil {
+		return nil, err
+	}
+	p, err := xserial.OpenPort(&x)
+	if err != nil {
+		return nil, err
+	}
+	return &timeoutPort{Port: p}, nil
+}
+
+// timeoutPort reports Read timeouts the tarm way
+type timeoutPort struct {
+	xserial.Port
+}
+
+func (t *timeoutPort) Unwrap() xserial.Port {
+	return t.Port
+}
+
+func (t *timeoutPort) Read(b []byte) (int, error) {
+	n, err := t.Port.Read(b)
+	if err == xserial.ErrReadTimeout {
+		err = nil
+	}
+	return n, err
+}
+
+// fromPort presents a tarm/serial Port as an xserial Port
+type fromPort struct {
+	p Port
+
+	mx     sync.Mutex
+	stats  xserial.Stats
+	events chan xserial.Event
+	closed bool
+}
+
+// FromTarm presents p, usually a *serial.Port, as an xserial Port.
+// tarm/serial cannot change framing after opening, so SetParity fails;
+// Events never delivers and Drain returns at once.
+func FromTarm(p Port) xserial.Port {
+	return &fromPort{p: p}
+}
+
+func (f *fromPort) count(fn func(s *xserial.Stats)) {
+	f.mx.Lock()
+	fn(&f.stats)
+	f.mx.Unlock()
+}
+
+func (f *fromPort) Read(b []byte) (int, error) {
+	n, err := f.p.Read(b)
+	f.count(func(s *xserial.Stats) {
+		s.BytesRead += uint64(n)
+		if err != nil && err != io.EOF {
+			s.ReadErrors++
+		}
+	})
+	// tarm/serial reports a Timeout as io.EOF on POSIX
+	if n == 0 && err == io.EOF {
+		f.count(func(s *xserial.Stats) { s.ReadTimeouts++ })
+		return 0, xserial.ErrReadTimeout
+	}
+	return n, err
+}
+
+func (f *fromPort) Write(b []byte) (int, error) {
+	n, err := f.p.Write(b)
+	f.count(func(s *xserial.Stats) {
+		s.BytesWritten += uint64(n)
+		if err != nil {
+			s.WriteErrors++
+		}
+	})
+	return n, err
+}
+
+func (f *fromPort) Close() error {
+	f.mx.Lock()
+	if f.closed {
+		f.mx.Unlock()
+		return xserial.ErrPortClosed
+	}
+	f.closed = true
+	if f.events != nil {
+		close(f.events)
+	}
+	f.mx.Unlock()
+	return f.p.Close()
+}
+
+func (f *fromPort) SetParity(parity string, stopbits int) error {
+	return fmt.Errorf("tarm: framing cannot be changed after opening")
+}
+
+func (f *fromPort) Flush() error {
+	return f.p.Flush()
+}
+
+func (f *fromPort) Drain() error {
+	return nil
+}
+
+func (f *fromPort) Events() <-chan xserial.Event {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	if f.events == nil {
+		f.events = make(chan xserial.Event)
+		if f.closed {
+			close(f.events)
+		}
+	}
+	return f.events
+}
+
+func (f *fromPort) Stats() xserial.Stats {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	return f.stats
+}
@@ -0,0 +1,143 @@
+package console
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// keyOptions are the authorized_keys options of a key line that the Server
+// honours. Options that restrict what no console session can do anyway,
+// such as no-port-forwarding, are accepted and need nothing more.
+type keyOptions struct {
+	from   string
+	expiry time.Time
+	noPTY  bool
+}
+
+// parseKeyOptions reads the options of one authorized_keys line. Options the
+// Server cannot enforce are an error, so the key is refused rather than let
+// in with less restriction than its line asks for.
+func parseKeyOptions(options []string) (keyOptions, error) {
+	var o keyOptions
+	pty := false
+	for _, opt := range options {
+		name, value := opt, ""
+		if i := strings.IndexByte(opt, '='); i >= 0 {
+			name, value = opt[:i], unquote(opt[i+1:])
+		}
+		switch strings.ToLower(name) {
+		case "from":
+			o.from = value
+		case "expiry-time":
+			t, err := parseExpiry(value)
+			if err != nil {
+				return o, err
+			}
+			o.expiry = t
+		case "restrict", "no-pty":
+			o.noPTY = true
+		case "pty":
+			pty = true
+		case "no-port-forwarding", "no-agent-forwarding", "no-x11-forwarding", "no-user-rc":
+			// Nothing a Console Session offers
+		default:
+			return o, fmt.Errorf("console: unsupported key option %q", name)
+		}
+	}
+	if pty {
+		o.noPTY = false
+	}
+	return o, nil
+}
+
+// allows reports whether the options let a client in from addr now
+func (o keyOptions) allows(addr net.Addr, now time.Time) bool {
+	if !o.expiry.IsZero() && !now.Before(o.expiry) {
+		return false
+	}
+	return o.from == "" || matchFrom(o.from, addr)
+}
+
+// unquote strips the double quotes around an option value
+func unquote(v string) string {
+	if len(v) >= 2 && v[0] == '"' && v[len(v)-1] == '"' {
+		v = strings.ReplaceAll(v[1:len(v)-1], `\"`, `"`)
+	}
+	return v
+}
+
+// parseExpiry reads an expiry-time of YYYYMMDD[HHMM[SS]], in local time
+// unless it ends with Z
+func parseExpiry(v string) (time.Time, error) {
+	loc := time.Local
+	if strings.HasSuffix(v, "Z") {
+		v, loc = v[:len(v)-1], time.UTC
+	}
+	for _, layout := range []string{"20060102", "200601021504", "20060102150405"} {
+		if len(v) == len(layout) {
+			if t, err := time.ParseInLocation(layout, v, loc); err == nil {
+				return t, nil
+			}
+		}
+	}
+	return time.Time{}, fmt.Errorf("console: bad expiry-time %q", v)
+}
+
+// matchFrom matches the client's address against a from= pattern list of
+// addresses with * and ? wildcards and CIDR blocks. A negated pattern that
+// matches refuses the client whatever else matches. Host names are not
+// looked up, so patterns naming hosts never match.
+func matchFrom(list string, addr net.Addr) bool {
+	host := addr.String()
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	ip := net.ParseIP(host)
+	matched := false
+	for _, pattern := range strings.Split(list, ",") {
+		negated := strings.HasPrefix(pattern, "!")
+		if negated {
+			pattern = pattern[1:]
+		}
+		var ok bool
+		if strings.Contains(pattern, "/") {
+			_, block, err := net.ParseCIDR(pattern)
+			ok = err == nil && ip != nil && block.Contains(ip)
+		} else {
+			ok = matchWildcard(strings.ToLower(pattern), strings.ToLower(host))
+		}
+		if ok && negated {
+			return false
+		}
+		matched = matched || ok
+	}
+	return matched
+}
+
+// matchWildcard matches s against a pattern where * matches any run of
+// characters and ? any one
+func matchWildcard(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for i := len(s); i >= 0; i-- {
+				if matchWildcard(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+		default:
+			if len(s) == 0 || s[0] != pattern[0] {
+				return false
+			}
+		}
+		pattern, s = pattern[1:], s[1:]
+	}
+	return len(s) == 0
+}
@@ -0,0 +1,288 @@
+// Package console serves Ports as interactive sessions over SSH, a light
+// console server in the spirit of conserver. The SSH user name picks the
+// Port, so "ssh router1@consoles" attaches to the Port named router1.
+// Clients log in with keys from an authorized_keys file and can send a
+// break with the OpenSSH ~B escape.
+package console
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/packing/xserial"
+	"golang.org/x/crypto/ssh"
+)
+
+// ErrServerClosed is returned by Serve after Close
+var ErrServerClosed = errors.New("console: server closed")
+
+// Config configures a Server
+type Config struct {
+	// Keys the Server identifies itself with, at least one
+	HostKeys []ssh.Signer
+	// Path of an authorized_keys file, read at every login so edits apply
+	// without a restart
+	AuthorizedKeys string
+	// Ports by the user name that reaches them. With a single Port any
+	// user name reaches it.
+	Ports map[string]xserial.Port
+	// Directory receiving one log file per session with everything the
+	// Port sent and the client typed, empty for none
+	LogDir string
+	// Default Break for clients that do not give a length, defaults to
+	// 250ms
+	Break time.Duration
+}
+
+// Server accepts SSH connections and attaches them to Ports. Every session
+// on a Port sees its output and may type to it.
+type Server struct {
+	cfg      Config
+	ssh      *ssh.ServerConfig
+	consoles map[string]*console
+
+	mx        sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[*ssh.ServerConn]struct{}
+	closed    bool
+}
+
+// NewServer starts reading the Ports. They stay owned by the caller; Close
+// stops reading but leaves them open.
+func NewServer(cfg *Config) (*Server, error) {
+	c := *cfg
+	if len(c.HostKeys) == 0 {
+		return nil, errors.New("console: no host key")
+	}
+	if len(c.Ports) == 0 {
+		return nil, errors.New("console: no ports")
+	}
+	if c.Break <= 0 {
+		c.Break = 250 * time.Millisecond
+	}
+	s := &Server{
+		cfg:       c,
+		consoles:  make(map[string]*console),
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[*ssh.ServerConn]struct{}),
+	}
+	s.ssh = &ssh.ServerConfig{PublicKeyCallback: s.authorize}
+	for _, k := range c.HostKeys {
+		s.ssh.AddHostKey(k)
+	}
+	for name, p := range c.Ports {
+		s.consoles[name] = newConsole(name, p)
+	}
+	return s, nil
+}
+
+// authorize accepts keys listed in the authorized_keys file, honouring the
+// from=, expiry-time, restrict and no-pty options of their line. A line with
+// options the Server cannot enforce does not authorize its key.
+func (s *Server) authorize(meta ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+	if _, ok := s.console(meta.User()); !ok {
+		return nil, fmt.Errorf("console: no port %q", meta.User())
+	}
+	data, err := ioutil.ReadFile(s.cfg.AuthorizedKeys)
+	if err != nil {
+		return nil, err
+	}
+	want := key.Marshal()
+	for len(data) > 0 {
+		k, _, options, rest, err := ssh.ParseAuthorizedKey(data)
+		if err != nil {
+			break
+		}
+		data = rest
+		if !bytes.Equal(k.Marshal(), want) {
+			continue
+		}
+		o, err := parseKeyOptions(options)
+		if err != nil || !o.allows(meta.RemoteAddr(), time.Now()) {
+			continue
+		}
+		ext := map[string]string{"fingerprint": ssh.FingerprintSHA256(key)}
+		if o.noPTY {
+			ext["no-pty"] = ""
+		}
+		return &ssh.Permissions{Extensions: ext}, nil
+	}
+	return nil, fmt.Errorf("console: key %s not authorized", ssh.FingerprintSHA256(key))
+}
+
+// console returns the console a user name reaches
+func (s *Server) console(user string) (*console, bool) {
+	if c, ok := s.consoles[user]; ok {
+		return c, true
+	}
+	if len(s.consoles) == 1 {
+		for _, c := range s.consoles {
+			return c, true
+		}
+	}
+	return nil, false
+}
+
+// ListenAndServe listens on the TCP address addr and calls Serve
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve accepts connections on l until l fails or the Server is closed
+func (s *Server) Serve(l net.Listener) error {
+	s.mx.Lock()
+	if s.closed {
+		s.mx.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	s.listeners[l] = struct{}{}
+	s.mx.Unlock()
+	defer func() {
+		s.mx.Lock()
+		delete(s.listeners, l)
+		s.mx.Unlock()
+	}()
+	for {
+		nc, err := l.Accept()
+		if err != nil {
+			s.mx.Lock()
+			closed := s.closed
+			s.mx.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+		go s.handle(nc)
+	}
+}
+
+// handle runs the SSH handshake and the sessions of one connection
+func (s *Server) handle(nc net.Conn) {
+	nc.SetDeadline(time.Now().Add(30 * time.Second))
+	conn, chans, reqs, err := ssh.NewServerConn(nc, s.ssh)
+	if err != nil {
+		nc.Close()
+		return
+	}
+	nc.SetDeadline(time.Time{})
+	s.mx.Lock()
+	if s.closed {
+		s.mx.Unlock()
+		conn.Close()
+		return
+	}
+	s.conns[conn] = struct{}{}
+	s.mx.Unlock()
+	defer func() {
+		s.mx.Lock()
+		delete(s.conns, conn)
+		s.mx.Unlock()
+		conn.Close()
+	}()
+
+	go ssh.DiscardRequests(reqs)
+	c, _ := s.console(conn.User())
+	for nch := range chans {
+		if nch.ChannelType() != "session" {
+			nch.Reject(ssh.UnknownChannelType, "only sessions are supported")
+			continue
+		}
+		ch, creqs, err := nch.Accept()
+		if err != nil {
+			continue
+		}
+		go s.session(c, conn, ch, creqs)
+	}
+}
+
+// session serves one SSH session channel
+func (s *Server) session(c *console, conn *ssh.ServerConn, ch ssh.Channel, reqs <-chan *ssh.Request) {
+	defer ch.Close()
+	var started bool
+	var sess *session
+	for req := range reqs {
+		ok := false
+		switch req.Type {
+		case "pty-req":
+			// The Terminal is the Device's, nothing to set up
+			_, noPTY := conn.Permissions.Extensions["no-pty"]
+			ok = !noPTY
+		case "env", "window-change":
+			ok = true
+		case "shell":
+			if !started {
+				started, ok = true, true
+				var err error
+				if sess, err = c.attach(ch, conn, s.cfg.LogDir); err != nil {
+					fmt.Fprintf(ch.Stderr(), "console: %v\r\n", err)
+					req.Reply(false, nil)
+					return
+				}
+				fmt.Fprintf(ch, "[attached to %s, ~B sends a break, ~. disconnects]\r\n", c.name)
+				go func() {
+					sess.pump()
+					ch.CloseWrite()
+					ch.Close()
+				}()
+			}
+		case "break":
+			// RFC 4335 - Length in Milliseconds
+			d := s.cfg.Break
+			if len(req.Payload) >= 4 {
+				if ms := binary.BigEndian.Uint32(req.Payload); ms > 0 {
+					d = time.Duration(ms) * time.Millisecond
+				}
+			}
+			if l, found := xserial.As[xserial.LineController](c.p); found {
+				ok = l.SendBreak(d) == nil
+			}
+		}
+		if req.WantReply {
+			req.Reply(ok, nil)
+		}
+	}
+	if sess != nil {
+		c.detach(sess)
+	}
+}
+
+// Close stops accepting, ends all sessions and stops reading the Ports
+func (s *Server) Close() error {
+	s.mx.Lock()
+	if s.closed {
+		s.mx.Unlock()
+		return ErrServerClosed
+	}
+	s.closed = true
+	for l := range s.listeners {
+		l.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mx.Unlock()
+	for _, c := range s.consoles {
+		c.stop()
+	}
+	return nil
+}
+
+// logFile creates the log of a session on port for user
+func logFile(dir, port, user string) (*os.File, error) {
+	name := fmt.Sprintf("%s-%s-%s.log", port, user, time.Now().Format("20060102-150405.000"))
+	return os.OpenFile(filepath.Join(dir, filepath.Base(name)), os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0o640)
+}
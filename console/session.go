@@ -0,0 +1,147 @@
+package console
+
+import (
+	"os"
+	"sync"
+
+	"github.com/packing/xserial"
+	"golang.org/x/crypto/ssh"
+)
+
+// Chunks a session may fall behind before Port output is dropped for it
+const sessionBacklog = 256
+
+// console is one Port and the sessions attached to it
+type console struct {
+	name   string
+	p      xserial.Port
+	reader *xserial.AsyncReader
+
+	mx       sync.Mutex
+	sessions map[*session]struct{}
+}
+
+// session is one SSH client attached to a console
+type session struct {
+	c    *console
+	ch   ssh.Channel
+	out  chan []byte
+	done chan struct{}
+	once sync.Once
+
+	logMx sync.Mutex
+	log   *os.File
+}
+
+func newConsole(name string, p xserial.Port) *console {
+	c := &console{name: name, p: p, sessions: make(map[*session]struct{})}
+	c.reader = xserial.OnData(p, c.fromSerial, nil)
+	return c
+}
+
+// fromSerial hands Port output to every session, on the reader goroutine.
+// A session that cannot keep up misses output rather than stalling the rest.
+func (c *console) fromSerial(data []byte) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	for s := range c.sessions {
+		buf := append([]byte(nil), data...)
+		select {
+		case s.out <- buf:
+		default:
+		}
+	}
+}
+
+// attach adds a session for the client on ch
+func (c *console) attach(ch ssh.Channel, conn *ssh.ServerConn, logDir string) (*session, error) {
+	s := &session{c: c, ch: ch, out: make(chan []byte, sessionBacklog), done: make(chan struct{})}
+	if logDir != "" {
+		f, err := logFile(logDir, c.name, conn.User())
+		if err != nil {
+			return nil, err
+		}
+		s.log = f
+	}
+	c.mx.Lock()
+	c.sessions[s] = struct{}{}
+	c.mx.Unlock()
+	return s, nil
+}
+
+// detach removes s and closes its log
+func (c *console) detach(s *session) {
+	c.mx.Lock()
+	delete(c.sessions, s)
+	c.mx.Unlock()
+	s.once.Do(func() {
+		close(s.done)
+		if s.log != nil {
+			s.logMx.Lock()
+			s.log.Close()
+			s.logMx.Unlock()
+		}
+	})
+}
+
+// stop stops reading the Port and ends every session
+func (c *console) stop() {
+	c.reader.Stop()
+	c.mx.Lock()
+	sessions := make([]*session, 0, len(c.sessions))
+	for s := range c.sessions {
+		sessions = append(sessions, s)
+	}
+	c.mx.Unlock()
+	for _, s := range sessions {
+		s.ch.Close()
+		c.detach(s)
+	}
+}
+
+// pump copies between the client and the Port until either side ends
+func (s *session) pump() {
+	go func() {
+		for {
+			select {
+			case <-s.done:
+				return
+			case data := <-s.out:
+				s.record(data)
+				if _, err := s.ch.Write(data); err != nil {
+					s.ch.Close()
+					return
+				}
+			}
+		}
+	}()
+	buf := make([]byte, 1024)
+	for {
+		n, err := s.ch.Read(buf)
+		if n > 0 {
+			s.record(buf[:n])
+			if _, werr := s.c.p.Write(buf[:n]); werr != nil {
+				break
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	s.c.detach(s)
+}
+
+// record appends data to the session log
+func (s *session) record(data []byte) {
+	if s.log == nil {
+		return
+	}
+	s.logMx.Lock()
+	defer s.logMx.Unlock()
+	select {
+	case <-s.done:
+		// Log already Closed
+	default:
+		s.log.Write(data)
+	}
+}
@@ -0,0 +1,44 @@
+// Copyright 2021 Abhijit Bose. All rights reserved.
+
+//go:build linux
+// +build linux
+
+package xserial
+
+import (
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// serialIcounter is struct serial_icounter_struct of linux/serial.h
+type serialIcounter struct {
+	CTS, DSR, RNG, DCD int32
+	RX, TX             int32
+	Frame, Overrun     int32
+	Parity, Brk        int32
+	BufOverrun         int32
+	reserved           [9]int32
+}
+
+// Counters returns the line errors the driver counted since it was loaded
+// or the device appeared, as TIOCGICOUNT reports them
+func (s *serialPort) Counters() (LineCounters, error) {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	// Check If its Open
+	if !s.opened {
+		return LineCounters{}, ErrNotOpen
+	}
+	var ic serialIcounter
+	if _, _, e1 := unix.Syscall(unix.SYS_IOCTL, uintptr(s.fd), uintptr(unix.TIOCGICOUNT), uintptr(unsafe.Pointer(&ic))); e1 != 0 {
+		return LineCounters{}, e1
+	}
+	return LineCounters{
+		Frame:         uint64(uint32(ic.Frame)),
+		Parity:        uint64(uint32(ic.Parity)),
+		Overrun:       uint64(uint32(ic.Overrun)),
+		BufferOverrun: uint64(uint32(ic.BufOverrun)),
+		Break:         uint64(uint32(ic.Brk)),
+	}, nil
+}
@@ -0,0 +1,111 @@
+// Package cp210x toggles the spare GPIO pins of Silicon Labs CP210x USB to
+// UART bridges, often wired to a target's reset and boot pins, through the
+// IOCTL_GPIOGET and IOCTL_GPIOSET requests of the Silicon Labs VCP driver.
+// Mainline Linux kernels expose these pins as a gpiochip instead and
+// reject the requests.
+package cp210x
+
+import (
+	"encoding/binary"
+	"errors"
+	"syscall"
+	"unsafe"
+
+	"github.com/packing/xserial"
+)
+
+// VCP Driver Requests
+const (
+	ioctlGPIOGet = 0x8000
+	ioctlGPIOSet = 0x8001
+)
+
+// Pins is the number of GPIO pins the 8 bit latch of the CP2102N, CP2103,
+// CP2104 and each CP2105 interface holds
+const Pins = 8
+
+var (
+	// ErrNoGPIO - the Port has no VCP driver GPIO requests
+	ErrNoGPIO = errors.New("cp210x: port has no GPIO requests")
+	// ErrPin - the pin is outside the latch
+	ErrPin = errors.New("cp210x: invalid GPIO pin")
+)
+
+// GPIO drives the latch of the bridge behind a Port
+type GPIO struct {
+	io xserial.Ioctler
+}
+
+// New returns the GPIO of the bridge behind p, which must be an Ioctler or
+// wrap one. The driver is queried only by the first request.
+func New(p xserial.Port) (*GPIO, error) {
+	io, ok := xserial.As[xserial.Ioctler](p)
+	if !ok {
+		return nil, ErrNoGPIO
+	}
+	return &GPIO{io: io}, nil
+}
+
+// Get returns the latch, bit n the level of GPIO n
+func (g *GPIO) Get() (byte, error) {
+	var buf [8]byte
+	if _, err := g.io.Ioctl(ioctlGPIOGet, xserial.IoctlBuffer(buf[:])); err != nil {
+		return 0, gpioError(err)
+	}
+	return buf[0], nil
+}
+
+// Set drives the pins in mask to the levels of the same bits in latch,
+// leaving the others as they are
+func (g *GPIO) Set(mask, latch byte) error {
+	// The Driver reads an unsigned long holding wValue of WRITE_LATCH
+	var buf [unsafe.Sizeof(uintptr(0))]byte
+	v := uint64(latch)<<8 | uint64(mask)
+	if len(buf) == 8 {
+		nativeEndian.PutUint64(buf[:], v)
+	} else {
+		nativeEndian.PutUint32(buf[:], uint32(v))
+	}
+	if _, err := g.io.Ioctl(ioctlGPIOSet, xserial.IoctlBuffer(buf[:])); err != nil {
+		return gpioError(err)
+	}
+	return nil
+}
+
+// SetPin drives GPIO pin high or low
+func (g *GPIO) SetPin(pin int, high bool) error {
+	if pin < 0 || pin >= Pins {
+		return ErrPin
+	}
+	var latch byte
+	if high {
+		latch = 1 << pin
+	}
+	return g.Set(1<<pin, latch)
+}
+
+// Pin returns the level of GPIO pin
+func (g *GPIO) Pin(pin int) (bool, error) {
+	if pin < 0 || pin >= Pins {
+		return false, ErrPin
+	}
+	latch, err := g.Get()
+	return latch&(1<<pin) != 0, err
+}
+
+// gpioError maps the refusal of drivers without the requests to ErrNoGPIO
+func gpioError(err error) error {
+	if err == syscall.ENOTTY || err == syscall.EINVAL {
+		return ErrNoGPIO
+	}
+	return err
+}
+
+// nativeEndian is the byte order of the unsigned long the driver reads
+var nativeEndian binary.ByteOrder = func() binary.ByteOrder {
+	x := uint16(1)
+	if *(*byte)(unsafe.Pointer(&x)) == 1 {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}()
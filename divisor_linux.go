@@ -0,0 +1,139 @@
+// Copyright 2021 Abhijit Bose. All rights reserved.
+
+//go:build linux
+// +build linux
+
+package xserial
+
+import (
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// serial_struct Speed Flags
+const (
+	asyncSpdMask = 0x1030
+	// B38400 stands for baud_base / custom_divisor
+	asyncSpdCust = 0x0030
+)
+
+// serialStruct is struct serial_struct of linux/serial.h
+type serialStruct struct {
+	Type          int32
+	Line          int32
+	Port          uint32
+	IRQ           int32
+	Flags         int32
+	XmitFIFOSize  int32
+	CustomDivisor int32
+	BaudBase      int32
+	CloseDelay    uint16
+	IOType        int8
+	reservedChar  int8
+	Hub6          int32
+	ClosingWait   uint16
+	ClosingWait2  uint16
+	IOMemBase     uintptr
+	IOMemRegShift uint16
+	PortHigh      uint32
+	IOMapBase     uintptr
+}
+
+// Largest Deviation of a Divided Rate from the one Asked for, in Percent
+const maxBaudError = 2
+
+// setTermiosBaud applies t, whose speed setSpeed set to baud. UARTs whose
+// drivers reject or ignore BOTHER get the rate through the legacy custom
+// divisor instead, as old 16550 class drivers need for rates like 31250.
+func (s *serialPort) setTermiosBaud(t unix.Termios, baud int) error {
+	err := s.SetTermios(t)
+	if _, std := baudRates[baud]; std {
+		// A Divisor left by an earlier Rate would stand in for 38400
+		if err == nil && baud == 38400 {
+			s.clearCustomDivisor()
+		}
+		return err
+	}
+	if err == nil {
+		if got, gerr := s.GetTermios(); gerr != nil || baudClose(int(got.Ospeed), baud) {
+			return nil
+		}
+	}
+	cerr := s.setCustomDivisor(t, baud)
+	if cerr == unix.ENOTTY || cerr == unix.EINVAL {
+		// No serial_struct - the Driver's Rate stands
+		return err
+	}
+	return cerr
+}
+
+// baudClose reports whether got is within maxBaudError of want
+func baudClose(got, want int) bool {
+	d := got - want
+	if d < 0 {
+		d = -d
+	}
+	return d*100 <= want*maxBaudError
+}
+
+func (s *serialPort) getSerial() (ss serialStruct, err error) {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	// Check If its Open
+	if !s.opened {
+		return ss, ErrNotOpen
+	}
+	if _, _, e1 := unix.Syscall(unix.SYS_IOCTL, uintptr(s.fd), uintptr(unix.TIOCGSERIAL), uintptr(unsafe.Pointer(&ss))); e1 != 0 {
+		return ss, e1
+	}
+	return ss, nil
+}
+
+func (s *serialPort) setSerial(ss serialStruct) error {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	// Check If its Open
+	if !s.opened {
+		return ErrNotOpen
+	}
+	if _, _, e1 := unix.Syscall(unix.SYS_IOCTL, uintptr(s.fd), uintptr(unix.TIOCSSERIAL), uintptr(unsafe.Pointer(&ss))); e1 != 0 {
+		return e1
+	}
+	return nil
+}
+
+// setCustomDivisor sets baud as baud_base / custom_divisor, which the
+// driver applies in place of B38400
+func (s *serialPort) setCustomDivisor(t unix.Termios, baud int) error {
+	ss, err := s.getSerial()
+	if err != nil {
+		return err
+	}
+	if ss.BaudBase <= 0 {
+		return unix.EINVAL
+	}
+	div := (int(ss.BaudBase) + baud/2) / baud
+	if div == 0 || !baudClose(int(ss.BaudBase)/div, baud) {
+		return &ConfigError{Setting: "baud", Value: baud}
+	}
+	ss.Flags = ss.Flags&^asyncSpdMask | asyncSpdCust
+	ss.CustomDivisor = int32(div)
+	if err := s.setSerial(ss); err != nil {
+		return err
+	}
+	setSpeed(&t, 38400)
+	return s.SetTermios(t)
+}
+
+// clearCustomDivisor makes B38400 mean 38400 again, where the driver has a
+// serial_struct
+func (s *serialPort) clearCustomDivisor() {
+	ss, err := s.getSerial()
+	if err != nil || ss.Flags&asyncSpdMask != asyncSpdCust {
+		return
+	}
+	ss.Flags &^= asyncSpdMask
+	ss.CustomDivisor = 0
+	s.setSerial(ss)
+}
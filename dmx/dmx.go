@@ -0,0 +1,168 @@
+// Package dmx transmits DMX512 lighting data: frames of a start code and
+// up to 512 channel values at 250000 baud 8N2, each led by a break and a
+// mark after break, as USB dongles built on an FTDI UART expect.
+package dmx
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/packing/xserial"
+)
+
+const (
+	// Baud is the DMX512 line speed
+	Baud = 250000
+	// Channels is the number of channels in a universe
+	Channels = 512
+	// MinBreak and MinMarkAfterBreak are the shortest a transmitter may
+	// send under ANSI E1.11
+	MinBreak          = 92 * time.Microsecond
+	MinMarkAfterBreak = 12 * time.Microsecond
+)
+
+var (
+	// ErrNoBreak - the Port cannot send a break
+	ErrNoBreak = errors.New("dmx: port cannot send a break")
+	// ErrChannel - the channel is outside 1 to 512
+	ErrChannel = errors.New("dmx: channel out of range")
+)
+
+// PortConfig returns the Config to open name with for DMX output, 250000
+// baud 8N2
+func PortConfig(name string) *xserial.Config {
+	return &xserial.Config{Name: name, Baud: Baud, Parity: "N", StopBits: 2}
+}
+
+// Config configures a Transmitter
+type Config struct {
+	// Length of the break before each frame, defaults to 176µs. Timer
+	// granularity lengthens short breaks, which receivers accept.
+	Break time.Duration
+	// Mark after break, defaults to MinMarkAfterBreak
+	MarkAfterBreak time.Duration
+	// Channels sent in each frame, defaults to 512; fewer refresh faster
+	// but must be at least 24
+	Channels int
+	// Start code of each frame, zero for dimmer levels
+	StartCode byte
+	// Frames per second sent by Run, defaults to 40. Receivers expect a
+	// frame at least once a second.
+	Rate int
+}
+
+// Transmitter sends a universe of channel values on a Port opened with
+// PortConfig. Set and Run may be called concurrently.
+type Transmitter struct {
+	p     xserial.Port
+	lines xserial.LineController
+	cfg   Config
+	mx    sync.Mutex
+	// Start Code and Channel Values
+	frame []byte
+}
+
+// NewTransmitter returns a Transmitter on p with every channel at zero.
+// cfg may be nil.
+func NewTransmitter(p xserial.Port, cfg *Config) (*Transmitter, error) {
+	var c Config
+	if cfg != nil {
+		c = *cfg
+	}
+	if c.Break <= 0 {
+		c.Break = 176 * time.Microsecond
+	} else if c.Break < MinBreak {
+		c.Break = MinBreak
+	}
+	if c.MarkAfterBreak < MinMarkAfterBreak {
+		c.MarkAfterBreak = MinMarkAfterBreak
+	}
+	if c.Channels <= 0 {
+		c.Channels = Channels
+	}
+	if c.Channels < 24 || c.Channels > Channels {
+		return nil, &xserial.ConfigError{Setting: "dmx channels", Value: c.Channels}
+	}
+	if c.Rate <= 0 {
+		c.Rate = 40
+	}
+	lines, ok := xserial.As[xserial.LineController](p)
+	if !ok {
+		return nil, ErrNoBreak
+	}
+	t := &Transmitter{p: p, lines: lines, cfg: c, frame: make([]byte, 1+c.Channels)}
+	t.frame[0] = c.StartCode
+	return t, nil
+}
+
+// Set sets channel, numbered from 1, to value for the following frames
+func (t *Transmitter) Set(channel int, value byte) error {
+	return t.SetRange(channel, []byte{value})
+}
+
+// SetRange sets the channels from first on to values
+func (t *Transmitter) SetRange(first int, values []byte) error {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	if first < 1 || first+len(values)-1 >= len(t.frame) {
+		return ErrChannel
+	}
+	copy(t.frame[first:], values)
+	return nil
+}
+
+// Get returns the value of channel, numbered from 1
+func (t *Transmitter) Get(channel int) byte {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	if channel < 1 || channel >= len(t.frame) {
+		return 0
+	}
+	return t.frame[channel]
+}
+
+// Blackout sets every channel to zero
+func (t *Transmitter) Blackout() {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	for i := 1; i < len(t.frame); i++ {
+		t.frame[i] = 0
+	}
+}
+
+// WriteFrame sends one frame: break, mark after break, start code and the
+// channel values. It waits for the previous frame to leave the UART first
+// so the break does not cut it short.
+func (t *Transmitter) WriteFrame() error {
+	t.mx.Lock()
+	frame := append([]byte(nil), t.frame...)
+	t.mx.Unlock()
+	if err := t.p.Drain(); err != nil {
+		return err
+	}
+	if err := t.lines.SendBreak(t.cfg.Break); err != nil {
+		return err
+	}
+	time.Sleep(t.cfg.MarkAfterBreak)
+	_, err := t.p.Write(frame)
+	return err
+}
+
+// Run sends frames at the configured Rate until ctx is done, returning
+// ctx.Err(), or until a frame fails
+func (t *Transmitter) Run(ctx context.Context) error {
+	tick := time.NewTicker(time.Second / time.Duration(t.cfg.Rate))
+	defer tick.Stop()
+	for {
+		if err := t.WriteFrame(); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-tick.C:
+		}
+	}
+}
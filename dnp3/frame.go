@@ -0,0 +1,239 @@
+// Package dnp3 implements the DNP3 data link layer over a Port: FT3 frames
+// with their 0x0564 start, per block CRCs and link addressing, and a Link
+// handling resets, confirmations and the frame count bit for masters and
+// outstations alike.
+package dnp3
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/packing/xserial/checksum"
+)
+
+// Start Bytes of every Frame
+const (
+	start0 = 0x05
+	start1 = 0x64
+)
+
+// Control Field Bits
+const (
+	ctrlDIR = 0x80
+	ctrlPRM = 0x40
+	ctrlFCB = 0x20
+	// FCV from a Primary, DFC from a Secondary
+	ctrlFCV = 0x10
+)
+
+// Primary Function Codes
+const (
+	FuncResetLinkStates     = 0x0
+	FuncTestLinkStates      = 0x2
+	FuncConfirmedUserData   = 0x3
+	FuncUnconfirmedUserData = 0x4
+	FuncRequestLinkStatus   = 0x9
+)
+
+// Secondary Function Codes
+const (
+	FuncAck          = 0x0
+	FuncNack         = 0x1
+	FuncLinkStatus   = 0xB
+	FuncNotSupported = 0xF
+)
+
+// MaxData is the most user data one frame carries
+const MaxData = 250
+
+// Addresses from 0xFFFD up are broadcasts
+const (
+	BroadcastConfirm   = 0xFFFD
+	BroadcastOptional  = 0xFFFE
+	BroadcastNoConfirm = 0xFFFF
+)
+
+// Header Length - Start, LEN, CTRL, DEST, SRC and CRC
+const headerLen = 10
+
+// User Data Block Size, each followed by a CRC
+const blockLen = 16
+
+var (
+	errBadFrame = errors.New("dnp3: malformed frame")
+	// ErrTooLarge - user data over MaxData
+	ErrTooLarge = errors.New("dnp3: user data too large")
+)
+
+// Frame is one link layer frame
+type Frame struct {
+	// Direction - set on frames from a master
+	DIR bool
+	// Primary - set on frames initiating a transaction
+	PRM bool
+	// Frame Count Bit and Frame Count Valid of a primary frame
+	FCB bool
+	FCV bool
+	// Data Flow Control of a secondary frame - the receiver is full
+	DFC      bool
+	Function byte
+	Dest     uint16
+	Src      uint16
+	Data     []byte
+}
+
+// control returns the Control Field of f
+func (f *Frame) control() byte {
+	c := f.Function & 0x0F
+	if f.DIR {
+		c |= ctrlDIR
+	}
+	if f.PRM {
+		c |= ctrlPRM
+		if f.FCB {
+			c |= ctrlFCB
+		}
+		if f.FCV {
+			c |= ctrlFCV
+		}
+	} else if f.DFC {
+		c |= ctrlFCV
+	}
+	return c
+}
+
+// appendCRC appends the CRC of b, low byte first
+func appendCRC(dst, b []byte) []byte {
+	crc := checksum.DNP.Checksum(b)
+	return append(dst, byte(crc), byte(crc>>8))
+}
+
+// Marshal encodes f with its CRCs
+func (f *Frame) Marshal() ([]byte, error) {
+	if len(f.Data) > MaxData {
+		return nil, ErrTooLarge
+	}
+	hdr := make([]byte, 8, headerLen)
+	hdr[0], hdr[1], hdr[2], hdr[3] = start0, start1, byte(5+len(f.Data)), f.control()
+	binary.LittleEndian.PutUint16(hdr[4:], f.Dest)
+	binary.LittleEndian.PutUint16(hdr[6:], f.Src)
+	b := appendCRC(hdr, hdr)
+	for data := f.Data; len(data) > 0; {
+		n := len(data)
+		if n > blockLen {
+			n = blockLen
+		}
+		b = appendCRC(append(b, data[:n]...), data[:n])
+		data = data[n:]
+	}
+	return b, nil
+}
+
+// frameLength returns the full length of a frame with the given LEN field
+func frameLength(l byte) int {
+	n := int(l) - 5
+	return headerLen + n + 2*((n+blockLen-1)/blockLen)
+}
+
+// checkCRC reports whether b ends in a good CRC of the rest
+func checkCRC(b []byte) bool {
+	n := len(b) - 2
+	return checksum.DNP.Checksum(b[:n]) == binary.LittleEndian.Uint16(b[n:])
+}
+
+// ParseFrame decodes a complete frame as returned by a Decoder
+func ParseFrame(b []byte) (Frame, error) {
+	var f Frame
+	if len(b) < headerLen || b[0] != start0 || b[1] != start1 || b[2] < 5 || len(b) != frameLength(b[2]) {
+		return f, errBadFrame
+	}
+	if !checkCRC(b[:headerLen]) {
+		return f, errBadFrame
+	}
+	c := b[3]
+	f.DIR = c&ctrlDIR != 0
+	f.PRM = c&ctrlPRM != 0
+	if f.PRM {
+		f.FCB = c&ctrlFCB != 0
+		f.FCV = c&ctrlFCV != 0
+	} else {
+		f.DFC = c&ctrlFCV != 0
+	}
+	f.Function = c & 0x0F
+	f.Dest = binary.LittleEndian.Uint16(b[4:])
+	f.Src = binary.LittleEndian.Uint16(b[6:])
+	rest := b[headerLen:]
+	for len(rest) > 0 {
+		n := len(rest)
+		if n > blockLen+2 {
+			n = blockLen + 2
+		}
+		if !checkCRC(rest[:n]) {
+			return Frame{}, errBadFrame
+		}
+		f.Data = append(f.Data, rest[:n-2]...)
+		rest = rest[n:]
+	}
+	return f, nil
+}
+
+// Decoder is an xserial.FrameDecoder for link layer frames. It returns each
+// frame with its CRCs, ready for ParseFrame; damaged frames are dropped and
+// counted as resyncs, and the search restarts after their first byte.
+type Decoder struct {
+	buf     []byte
+	resyncs uint64
+	// Discarding Bytes outside a Frame
+	stray bool
+}
+
+// NewDecoder returns an empty Decoder
+func NewDecoder() *Decoder {
+	return &Decoder{}
+}
+
+func (d *Decoder) Feed(p []byte) [][]byte {
+	var frames [][]byte
+	d.buf = append(d.buf, p...)
+	i := 0
+	for i < len(d.buf) {
+		b := d.buf[i:]
+		ok := b[0] == start0 && (len(b) < 2 || b[1] == start1)
+		if ok && len(b) >= headerLen {
+			ok = b[2] >= 5 && checkCRC(b[:headerLen])
+		}
+		if ok && len(b) >= headerLen {
+			n := frameLength(b[2])
+			if len(b) < n {
+				break
+			}
+			if _, err := ParseFrame(b[:n]); err == nil {
+				frames = append(frames, append([]byte(nil), b[:n]...))
+				d.stray = false
+				i += n
+				continue
+			}
+			ok = false
+		}
+		if !ok {
+			if !d.stray {
+				d.stray = true
+				d.resyncs++
+			}
+			i++
+			continue
+		}
+		break
+	}
+	d.buf = append(d.buf[:0], d.buf[i:]...)
+	return frames
+}
+
+func (d *Decoder) Resyncs() uint64 {
+	return d.resyncs
+}
+
+func (d *Decoder) Reset() {
+	d.buf = d.buf[:0]
+	d.stray = false
+}
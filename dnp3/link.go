@@ -0,0 +1,257 @@
+package dnp3
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/packing/xserial"
+)
+
+var (
+	// ErrNack - the remote station refused the frame
+	ErrNack = errors.New("dnp3: frame refused")
+	// ErrNoResponse - the remote station did not answer
+	ErrNoResponse = errors.New("dnp3: no response")
+	// ErrNotSupported - the remote station does not implement the function
+	ErrNotSupported = errors.New("dnp3: function not supported by remote")
+)
+
+// Config configures a Link
+type Config struct {
+	// This Station's Address
+	Address uint16
+	// Set on a master, to fill the DIR bit
+	Master bool
+	// How long to wait for a confirmation, defaults to 1 second
+	Timeout time.Duration
+	// Further attempts after a confirmation times out, defaults to 3;
+	// negative for none
+	Retries int
+}
+
+// Link runs the data link layer of one station over a Port. As secondary
+// it answers resets, tests and link status requests and confirms user data,
+// suppressing repeats by their frame count bit. Its methods must not be
+// called concurrently.
+type Link struct {
+	p   xserial.Port
+	cfg Config
+	rd  *xserial.FrameReader
+	// Next FCB to send per Remote, present once the Link to it is Reset
+	sendFCB map[uint16]bool
+	// Next FCB expected per Remote, present once it has Reset the Link
+	recvFCB map[uint16]bool
+	// User Data received while waiting for a Confirmation
+	queue []Frame
+}
+
+// NewLink returns a Link on p. cfg may be nil.
+func NewLink(p xserial.Port, cfg *Config) *Link {
+	var c Config
+	if cfg != nil {
+		c = *cfg
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = time.Second
+	}
+	if c.Retries < 0 {
+		c.Retries = 0
+	} else if c.Retries == 0 {
+		c.Retries = 3
+	}
+	return &Link{
+		p:       p,
+		cfg:     c,
+		rd:      xserial.NewFrameReader(p, NewDecoder()),
+		sendFCB: make(map[uint16]bool),
+		recvFCB: make(map[uint16]bool),
+	}
+}
+
+// Send writes f from this station as is, with no confirmation
+func (l *Link) Send(f *Frame) error {
+	f.Src = l.cfg.Address
+	f.DIR = l.cfg.Master
+	b, err := f.Marshal()
+	if err != nil {
+		return err
+	}
+	_, err = l.p.Write(b)
+	return err
+}
+
+// SendUnconfirmed sends user data to dest without a link confirmation
+func (l *Link) SendUnconfirmed(dest uint16, data []byte) error {
+	return l.Send(&Frame{PRM: true, Function: FuncUnconfirmedUserData, Dest: dest, Data: data})
+}
+
+// SendConfirmed sends user data to dest and waits for its confirmation,
+// sending again with the same frame count bit until it comes. The link is
+// reset first if needed, or if dest refuses the data.
+func (l *Link) SendConfirmed(ctx context.Context, dest uint16, data []byte) error {
+	for reset := false; ; reset = true {
+		fcb, ok := l.sendFCB[dest]
+		if !ok {
+			if err := l.ResetLink(ctx, dest); err != nil {
+				return err
+			}
+			fcb = l.sendFCB[dest]
+		}
+		f := &Frame{PRM: true, FCB: fcb, FCV: true, Function: FuncConfirmedUserData, Dest: dest, Data: data}
+		err := l.request(ctx, f, FuncAck)
+		if err == nil {
+			l.sendFCB[dest] = !fcb
+			return nil
+		}
+		if err != ErrNack || reset {
+			return err
+		}
+		// The Remote lost the Link State
+		delete(l.sendFCB, dest)
+	}
+}
+
+// ResetLink resets the link to dest so confirmed data can be sent
+func (l *Link) ResetLink(ctx context.Context, dest uint16) error {
+	if err := l.request(ctx, &Frame{PRM: true, Function: FuncResetLinkStates, Dest: dest}, FuncAck); err != nil {
+		return err
+	}
+	l.sendFCB[dest] = true
+	return nil
+}
+
+// RequestLinkStatus checks that dest is reachable
+func (l *Link) RequestLinkStatus(ctx context.Context, dest uint16) error {
+	return l.request(ctx, &Frame{PRM: true, Function: FuncRequestLinkStatus, Dest: dest}, FuncLinkStatus)
+}
+
+// request sends f and waits for a secondary frame with function want
+func (l *Link) request(ctx context.Context, f *Frame, want byte) error {
+	for attempt := 0; attempt <= l.cfg.Retries; attempt++ {
+		if err := l.Send(f); err != nil {
+			return err
+		}
+		actx, cancel := context.WithTimeout(ctx, l.cfg.Timeout)
+		r, err := l.response(actx, f.Dest)
+		cancel()
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			if err == context.DeadlineExceeded || err == xserial.ErrReadTimeout {
+				continue
+			}
+			return err
+		}
+		switch r.Function {
+		case want:
+			return nil
+		case FuncNack:
+			return ErrNack
+		case FuncNotSupported:
+			return ErrNotSupported
+		}
+	}
+	return ErrNoResponse
+}
+
+// response returns the next secondary frame from remote, serving anything
+// else that arrives meanwhile
+func (l *Link) response(ctx context.Context, remote uint16) (Frame, error) {
+	for {
+		f, err := l.next(ctx)
+		if err != nil {
+			return f, err
+		}
+		if !f.PRM && f.Src == remote {
+			return f, nil
+		}
+		if f.PRM {
+			l.queue = append(l.queue, f)
+		}
+	}
+}
+
+// ReadFrame returns the next user data frame for this station, answering
+// link service requests and confirming data on the way
+func (l *Link) ReadFrame(ctx context.Context) (Frame, error) {
+	for len(l.queue) == 0 {
+		f, err := l.next(ctx)
+		if err != nil {
+			return f, err
+		}
+		if f.PRM {
+			l.queue = append(l.queue, f)
+		}
+	}
+	f := l.queue[0]
+	l.queue = l.queue[1:]
+	return f, nil
+}
+
+// next returns the next frame for this station that carries user data or
+// is secondary, handling the link services itself
+func (l *Link) next(ctx context.Context) (Frame, error) {
+	for {
+		b, err := l.rd.ReadFrameContext(ctx)
+		if err != nil {
+			return Frame{}, err
+		}
+		f, err := ParseFrame(b)
+		if err != nil {
+			continue
+		}
+		broadcast := f.Dest >= BroadcastConfirm
+		if f.Dest != l.cfg.Address && !broadcast {
+			continue
+		}
+		if !f.PRM {
+			return f, nil
+		}
+		if deliver := l.serve(&f, broadcast); deliver {
+			return f, nil
+		}
+	}
+}
+
+// serve performs the secondary duties for primary frame f and reports
+// whether its user data is for the caller
+func (l *Link) serve(f *Frame, broadcast bool) bool {
+	answer := func(fn byte) {
+		// Broadcasts are never Answered by the Link
+		if !broadcast {
+			l.Send(&Frame{Function: fn, Dest: f.Src})
+		}
+	}
+	switch f.Function {
+	case FuncResetLinkStates:
+		l.recvFCB[f.Src] = true
+		answer(FuncAck)
+	case FuncTestLinkStates:
+		if fcb, ok := l.recvFCB[f.Src]; ok && f.FCB == fcb {
+			l.recvFCB[f.Src] = !fcb
+		}
+		answer(FuncAck)
+	case FuncRequestLinkStatus:
+		answer(FuncLinkStatus)
+	case FuncConfirmedUserData:
+		fcb, ok := l.recvFCB[f.Src]
+		if !ok {
+			answer(FuncNack)
+			return false
+		}
+		answer(FuncAck)
+		if f.FCB != fcb {
+			// Repeat of Data already Delivered
+			return false
+		}
+		l.recvFCB[f.Src] = !fcb
+		return true
+	case FuncUnconfirmedUserData:
+		return true
+	default:
+		answer(FuncNotSupported)
+	}
+	return false
+}
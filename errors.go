@@ -0,0 +1,104 @@
+package xserial
+
+import (
+	"fmt"
+	"io"
+	"net"
+)
+
+// sentinelError is the type of the package Err values. Each can also match
+// a standard library error with errors.Is, and ErrReadTimeout reports itself
+// as a timeout to os.IsTimeout and net.Error.
+type sentinelError struct {
+	msg     string
+	is      error
+	timeout bool
+}
+
+var _ net.Error = (*sentinelError)(nil)
+
+func newError(msg string, is error, timeout bool) error {
+	return &sentinelError{msg: msg, is: is, timeout: timeout}
+}
+
+func (e *sentinelError) Error() string {
+	return e.msg
+}
+
+func (e *sentinelError) Is(target error) bool {
+	return e.is != nil && target == e.is
+}
+
+// Timeout reports whether the error is ErrReadTimeout
+func (e *sentinelError) Timeout() bool {
+	return e.timeout
+}
+
+// Temporary reports whether retrying may succeed, which is only true for
+// timeouts
+func (e *sentinelError) Temporary() bool {
+	return e.timeout
+}
+
+// PortError records the operation and Port that failed along with the
+// underlying error, usually a syscall.Errno. Use errors.Is and errors.As to
+// look at the cause.
+type PortError struct {
+	Op   string
+	Port string
+	Err  error
+}
+
+func (e *PortError) Error() string {
+	if e.Port == "" {
+		return e.Op + ": " + e.Err.Error()
+	}
+	return e.Op + " " + e.Port + ": " + e.Err.Error()
+}
+
+func (e *PortError) Unwrap() error {
+	return e.Err
+}
+
+// Timeout reports whether the underlying error is a timeout
+func (e *PortError) Timeout() bool {
+	t, ok := e.Err.(interface{ Timeout() bool })
+	return ok && t.Timeout()
+}
+
+// Temporary reports whether the underlying error is temporary
+func (e *PortError) Temporary() bool {
+	t, ok := e.Err.(interface{ Temporary() bool })
+	return ok && t.Temporary()
+}
+
+// ConfigError reports a setting that is invalid or not supported. It
+// matches ErrInvalidConfig with errors.Is.
+type ConfigError struct {
+	Setting string
+	Value   interface{}
+}
+
+func (e *ConfigError) Error() string {
+	if v, ok := e.Value.(string); ok {
+		return fmt.Sprintf("invalid or not supported %s %q", e.Setting, v)
+	}
+	return fmt.Sprintf("invalid or not supported %s %v", e.Setting, e.Value)
+}
+
+func (e *ConfigError) Is(target error) bool {
+	return target == ErrInvalidConfig
+}
+
+// portError wraps OS errors from op in a PortError, leaving package errors
+// and io.EOF as they are so they can still be compared directly
+func portError(op, name string, err error) error {
+	switch err.(type) {
+	case nil, *sentinelError, *PortError, *ConfigError:
+		return err
+	}
+	if err == io.EOF || err == io.ErrShortWrite {
+		return err
+	}
+	return &PortError{Op: op, Port: name, Err: err}
+}
@@ -0,0 +1,411 @@
+// Package esp talks to the ROM bootloader of ESP8266 and ESP32 chips as
+// esptool does: it resets the chip into download mode with DTR and RTS,
+// synchronises over SLIP and switches the baud rate, the groundwork for
+// flashing and monitoring tools.
+package esp
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/packing/xserial"
+	"github.com/packing/xserial/slip"
+)
+
+// Bootloader Commands
+const (
+	CmdFlashBegin      = 0x02
+	CmdFlashData       = 0x03
+	CmdFlashEnd        = 0x04
+	CmdMemBegin        = 0x05
+	CmdMemEnd          = 0x06
+	CmdMemData         = 0x07
+	CmdSync            = 0x08
+	CmdWriteReg        = 0x09
+	CmdReadReg         = 0x0A
+	CmdSPISetParams    = 0x0B
+	CmdSPIAttach       = 0x0D
+	CmdChangeBaudrate  = 0x0F
+	CmdFlashDeflBegin  = 0x10
+	CmdFlashDeflData   = 0x11
+	CmdFlashDeflEnd    = 0x12
+	CmdSPIFlashMD5     = 0x13
+	CmdGetSecurityInfo = 0x14
+)
+
+const (
+	request  = 0x00
+	response = 0x01
+	// Seed of the Checksum over Data Commands
+	checksumSeed = 0xEF
+	// Register holding a Value unique to each Chip Family
+	chipMagicReg = 0x40001000
+	// How long to wait for each SYNC Response
+	syncTimeout = 100 * time.Millisecond
+)
+
+var (
+	// ErrNoResponse - the bootloader did not answer in time
+	ErrNoResponse = errors.New("esp: no response")
+	// ErrNoLines - the Port cannot drive DTR and RTS to reset the chip
+	ErrNoLines = errors.New("esp: port has no DTR/RTS control")
+	// ErrNoBaudSetter - the Port cannot change its baud rate while open
+	ErrNoBaudSetter = errors.New("esp: port cannot change baud rate")
+	// ErrProtocol - the bootloader sent a malformed response
+	ErrProtocol = errors.New("esp: malformed response")
+)
+
+// CommandError is the status of a command the bootloader refused
+type CommandError struct {
+	Op   byte
+	Code byte
+}
+
+func (e *CommandError) Error() string {
+	return fmt.Sprintf("esp: command 0x%02X failed, error 0x%02X", e.Op, e.Code)
+}
+
+// ResetMode selects how the chip is reset into download mode
+type ResetMode int
+
+const (
+	// Classic drives EN with RTS and IO0 with DTR through the two
+	// transistor circuit of most development boards
+	ResetClassic ResetMode = iota
+	// USBJTAG is the sequence the USB-Serial-JTAG peripheral of the
+	// ESP32-C3 and ESP32-S3 expects
+	ResetUSBJTAG
+	// None leaves the chip alone, for boards put into download mode by hand
+	ResetNone
+)
+
+// Config configures a Loader
+type Config struct {
+	// How the chip is reset into download mode, defaults to ResetClassic
+	Reset ResetMode
+	// How long IO0 is held low once EN is released, defaults to 50ms.
+	// Attempts alternate with a longer delay for slow boards.
+	ResetDelay time.Duration
+	// How long to wait for each response, defaults to 3 seconds
+	Timeout time.Duration
+	// Further reset and sync attempts, defaults to 7; negative for none
+	Retries int
+}
+
+// Loader is a synchronised session with the ROM bootloader
+type Loader struct {
+	p   xserial.Port
+	cfg Config
+	dec *slip.Decoder
+	buf []byte
+	// Packets decoded but not yet Handled
+	pending [][]byte
+	// Status Bytes ending each Response: 2 on the ESP8266, 4 on the ESP32
+	status int
+}
+
+// Connect resets the chip on p into download mode and synchronises with
+// its bootloader. p must be open at the bootloader's rate, 115200 baud
+// for the first contact. cfg may be nil.
+func Connect(ctx context.Context, p xserial.Port, cfg *Config) (*Loader, error) {
+	var c Config
+	if cfg != nil {
+		c = *cfg
+	}
+	if c.ResetDelay <= 0 {
+		c.ResetDelay = 50 * time.Millisecond
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = 3 * time.Second
+	}
+	if c.Retries < 0 {
+		c.Retries = 0
+	} else if c.Retries == 0 {
+		c.Retries = 7
+	}
+	l := &Loader{p: p, cfg: c, dec: slip.NewDecoder(0), buf: make([]byte, 1024)}
+	for attempt := 0; ; attempt++ {
+		delay := c.ResetDelay
+		if attempt%2 == 1 {
+			// Boards with a large Capacitor on EN come up late
+			delay += 500 * time.Millisecond
+		}
+		if err := Reset(p, c.Reset, delay); err != nil {
+			return nil, err
+		}
+		err := l.sync(ctx)
+		if err == nil {
+			return l, nil
+		}
+		if err != ErrNoResponse || attempt >= c.Retries {
+			return nil, err
+		}
+	}
+}
+
+// Reset puts the chip on p into download mode with the given sequence,
+// holding IO0 low for delay after EN is released
+func Reset(p xserial.Port, mode ResetMode, delay time.Duration) error {
+	if mode == ResetNone {
+		return nil
+	}
+	lines, ok := xserial.As[xserial.LineController](p)
+	if !ok {
+		return ErrNoLines
+	}
+	var steps []func() error
+	switch mode {
+	case ResetClassic:
+		steps = []func() error{
+			// IO0 high, EN low
+			func() error { return lines.SetDTR(false) },
+			func() error { return lines.SetRTS(true) },
+			pause(100 * time.Millisecond),
+			// IO0 low, EN high
+			func() error { return lines.SetDTR(true) },
+			func() error { return lines.SetRTS(false) },
+			pause(delay),
+			// Release IO0
+			func() error { return lines.SetDTR(false) },
+		}
+	case ResetUSBJTAG:
+		steps = []func() error{
+			func() error { return lines.SetRTS(false) },
+			func() error { return lines.SetDTR(false) },
+			pause(100 * time.Millisecond),
+			func() error { return lines.SetDTR(true) },
+			func() error { return lines.SetRTS(false) },
+			pause(100 * time.Millisecond),
+			// The Peripheral latches DTR on the RTS Edge
+			func() error { return lines.SetRTS(true) },
+			func() error { return lines.SetDTR(false) },
+			func() error { return lines.SetRTS(true) },
+			pause(100 * time.Millisecond),
+			func() error { return lines.SetDTR(false) },
+			func() error { return lines.SetRTS(false) },
+		}
+	default:
+		return &xserial.ConfigError{Setting: "reset mode", Value: int(mode)}
+	}
+	for _, step := range steps {
+		if err := step(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// HardReset restarts the chip on p into its application by pulsing EN
+// with RTS, as a monitor does after flashing
+func HardReset(p xserial.Port) error {
+	lines, ok := xserial.As[xserial.LineController](p)
+	if !ok {
+		return ErrNoLines
+	}
+	if err := lines.SetDTR(false); err != nil {
+		return err
+	}
+	if err := lines.SetRTS(true); err != nil {
+		return err
+	}
+	time.Sleep(100 * time.Millisecond)
+	return lines.SetRTS(false)
+}
+
+func pause(d time.Duration) func() error {
+	return func() error {
+		time.Sleep(d)
+		return nil
+	}
+}
+
+// sync sends SYNC until the bootloader has locked onto the baud rate,
+// then drops the further responses it sends to one SYNC
+func (l *Loader) sync(ctx context.Context) error {
+	l.p.Flush()
+	l.dec.Reset()
+	l.pending = nil
+	data := make([]byte, 36)
+	data[0], data[1], data[2], data[3] = 0x07, 0x07, 0x12, 0x20
+	for i := 4; i < len(data); i++ {
+		data[i] = 0x55
+	}
+	for try := 0; try < 5; try++ {
+		if err := l.write(CmdSync, data, 0); err != nil {
+			return err
+		}
+		_, body, err := l.response(ctx, CmdSync, syncTimeout)
+		if err == ErrNoResponse || err == ErrProtocol {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		// The Status Length tells the ESP8266 ROM from the ESP32 ROM
+		l.status = len(body)
+		if l.status != 2 && l.status != 4 {
+			l.status = 2
+		}
+		if body[0] != 0 {
+			continue
+		}
+		for {
+			if _, _, err := l.response(ctx, CmdSync, syncTimeout); err != nil {
+				break
+			}
+		}
+		return ctx.Err()
+	}
+	return ErrNoResponse
+}
+
+// Command runs op with data and returns the value and data of the
+// response without its status. chk is the checksum data commands carry,
+// see Checksum, and zero otherwise.
+func (l *Loader) Command(ctx context.Context, op byte, data []byte, chk uint32) (uint32, []byte, error) {
+	if err := l.write(op, data, chk); err != nil {
+		return 0, nil, err
+	}
+	value, body, err := l.response(ctx, op, l.cfg.Timeout)
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(body) < l.status {
+		return 0, nil, ErrProtocol
+	}
+	status := body[len(body)-l.status:]
+	if status[0] != 0 {
+		return 0, nil, &CommandError{Op: op, Code: status[1]}
+	}
+	return value, body[:len(body)-l.status], nil
+}
+
+// Checksum returns the checksum of the payload of a data command
+func Checksum(data []byte) uint32 {
+	x := byte(checksumSeed)
+	for _, v := range data {
+		x ^= v
+	}
+	return uint32(x)
+}
+
+// write sends a request packet: direction, op, data size and checksum
+func (l *Loader) write(op byte, data []byte, chk uint32) error {
+	b := make([]byte, 8+len(data))
+	b[0], b[1] = request, op
+	binary.LittleEndian.PutUint16(b[2:], uint16(len(data)))
+	binary.LittleEndian.PutUint32(b[4:], chk)
+	copy(b[8:], data)
+	_, err := l.p.Write(slip.Encode(b))
+	return err
+}
+
+// response waits for the response to op, skipping boot messages and
+// responses to earlier commands
+func (l *Loader) response(ctx context.Context, op byte, timeout time.Duration) (uint32, []byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	for {
+		r, err := l.packet(ctx)
+		if err != nil {
+			if err == context.DeadlineExceeded {
+				return 0, nil, ErrNoResponse
+			}
+			return 0, nil, err
+		}
+		if len(r) < 8 || r[0] != response || r[1] != op {
+			continue
+		}
+		size := int(binary.LittleEndian.Uint16(r[2:]))
+		if size > len(r)-8 || size < 2 {
+			return 0, nil, ErrProtocol
+		}
+		return binary.LittleEndian.Uint32(r[4:]), r[8 : 8+size], nil
+	}
+}
+
+// packet returns the next SLIP packet from the Port
+func (l *Loader) packet(ctx context.Context) ([]byte, error) {
+	for len(l.pending) == 0 {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		n, err := xserial.ReadContext(ctx, l.p, l.buf)
+		if n > 0 {
+			l.pending = l.dec.Feed(l.buf[:n])
+		}
+		if err != nil && err != xserial.ErrReadTimeout && ctx.Err() == nil {
+			return nil, err
+		}
+	}
+	r := l.pending[0]
+	l.pending = l.pending[1:]
+	return r, nil
+}
+
+// ReadReg returns the 32-bit register at addr
+func (l *Loader) ReadReg(ctx context.Context, addr uint32) (uint32, error) {
+	data := make([]byte, 4)
+	binary.LittleEndian.PutUint32(data, addr)
+	v, _, err := l.Command(ctx, CmdReadReg, data, 0)
+	return v, err
+}
+
+// WriteReg sets the bits of mask in the register at addr to value
+func (l *Loader) WriteReg(ctx context.Context, addr, value, mask uint32) error {
+	data := make([]byte, 16)
+	binary.LittleEndian.PutUint32(data, addr)
+	binary.LittleEndian.PutUint32(data[4:], value)
+	binary.LittleEndian.PutUint32(data[8:], mask)
+	_, _, err := l.Command(ctx, CmdWriteReg, data, 0)
+	return err
+}
+
+// ChangeBaud switches the bootloader and its Port to baud. The Port must
+// be a BaudSetter, or wrap one; the ESP8266 ROM does not support it.
+func (l *Loader) ChangeBaud(ctx context.Context, baud int) error {
+	bs, ok := xserial.As[xserial.BaudSetter](l.p)
+	if !ok {
+		return ErrNoBaudSetter
+	}
+	// The old Rate is only read by the Flasher Stub, the ROM takes zero
+	data := make([]byte, 8)
+	binary.LittleEndian.PutUint32(data, uint32(baud))
+	if _, _, err := l.Command(ctx, CmdChangeBaudrate, data, 0); err != nil {
+		return err
+	}
+	if err := bs.SetBaudRate(baud); err != nil {
+		return err
+	}
+	// Let the Chip switch over before talking at the new Rate
+	time.Sleep(50 * time.Millisecond)
+	l.dec.Reset()
+	l.pending = nil
+	return l.p.Flush()
+}
+
+// Chip families told apart by the register at 0x40001000
+var chipMagic = map[uint32]string{
+	0xFFF0C101: "ESP8266",
+	0x00F01D83: "ESP32",
+	0x000007C6: "ESP32-S2",
+	0x00000009: "ESP32-S3",
+	0x6921506F: "ESP32-C3",
+	0x1B31506F: "ESP32-C3",
+}
+
+// Chip returns the chip family, such as "ESP32", or the magic value for
+// families it does not know
+func (l *Loader) Chip(ctx context.Context) (string, error) {
+	v, err := l.ReadReg(ctx, chipMagicReg)
+	if err != nil {
+		return "", err
+	}
+	if name, ok := chipMagic[v]; ok {
+		return name, nil
+	}
+	return fmt.Sprintf("unknown (magic 0x%08X)", v), nil
+}
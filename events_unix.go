@@ -0,0 +1,215 @@
+// Copyright 2021 Abhijit Bose. All rights reserved.
+
+//go:build linux || darwin
+// +build linux darwin
+
+package xserial
+
+import (
+	"bytes"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// How often Modem Lines are sampled by the Event Monitor
+const eventLinePollInterval = 50 * time.Millisecond
+
+// How often the Output Queue is sampled while a TX Empty Event is Armed
+const eventTXPollInterval = time.Millisecond
+
+var modemBits = []struct{ tiocm, line int }{
+	{unix.TIOCM_CTS, LineCTS},
+	{unix.TIOCM_DSR, LineDSR},
+	{unix.TIOCM_RNG, LineRI},
+	{unix.TIOCM_CAR, LineDCD},
+	{unix.TIOCM_DTR, LineDTR},
+	{unix.TIOCM_RTS, LineRTS},
+}
+
+// modemLines reads the Modem Control Lines of fd as Line* bits
+func modemLines(fd int) (int, error) {
+	v, err := unix.IoctlGetInt(fd, unix.TIOCMGET)
+	if err != nil {
+		return 0, err
+	}
+	lines := 0
+	for _, b := range modemBits {
+		if v&b.tiocm != 0 {
+			lines |= b.line
+		}
+	}
+	return lines, nil
+}
+
+type eventMonitor struct {
+	ch chan Event
+	// Self Pipe to Stop the Monitor
+	stopR, stopW int
+	done         chan struct{}
+	// Set while an RX Event is Outstanding
+	rxPending int32
+	// Set from a Write until the Output has Drained
+	txPending int32
+}
+
+func (s *serialPort) Events() <-chan Event {
+	s.evMx.Lock()
+	defer s.evMx.Unlock()
+	if s.events != nil {
+		return s.events.ch
+	}
+
+	m := &eventMonitor{ch: make(chan Event, 16), done: make(chan struct{})}
+	s.events = m
+	var pipe [2]int
+	if !s.opened {
+		close(m.ch)
+		close(m.done)
+		return m.ch
+	}
+	if err := unix.Pipe(pipe[:]); err != nil {
+		m.ch <- Event{Type: EventError, Err: err, Time: time.Now()}
+		close(m.ch)
+		close(m.done)
+		return m.ch
+	}
+	m.stopR, m.stopW = pipe[0], pipe[1]
+	go m.run(s.fd)
+	return m.ch
+}
+
+func (m *eventMonitor) run(fd int) {
+	defer close(m.done)
+	defer close(m.ch)
+	defer unix.Close(m.stopR)
+
+	send := func(e Event) bool {
+		e.Time = time.Now()
+		select {
+		case m.ch <- e:
+			return true
+		default:
+		}
+		// Block only until Stopped
+		fds := []unix.PollFd{{Fd: int32(m.stopR), Events: unix.POLLIN}}
+		for {
+			select {
+			case m.ch <- e:
+				return true
+			default:
+			}
+			if n, _ := unix.Poll(fds, int(eventLinePollInterval/time.Millisecond)); n > 0 && m.stopped() {
+				return false
+			}
+		}
+	}
+
+	lines, _ := modemLines(fd)
+	fds := []unix.PollFd{{Fd: int32(m.stopR), Events: unix.POLLIN}, {Fd: int32(fd)}}
+	for {
+		fds[1].Events = 0
+		if atomic.LoadInt32(&m.rxPending) == 0 {
+			fds[1].Events = unix.POLLIN
+		}
+		timeout := eventLinePollInterval
+		if atomic.LoadInt32(&m.txPending) != 0 {
+			timeout = eventTXPollInterval
+		}
+		_, err := unix.Poll(fds, int(timeout/time.Millisecond))
+		if err != nil && err != unix.EINTR {
+			send(Event{Type: EventError, Err: err})
+			return
+		}
+		if fds[0].Revents != 0 && m.stopped() {
+			return
+		}
+		// POLLNVAL only follows a local Close, a Hangup the Device going away
+		if fds[1].Revents&unix.POLLNVAL != 0 {
+			send(Event{Type: EventDisconnect, Err: ErrPortClosed})
+			return
+		}
+		if fds[1].Revents&(unix.POLLHUP|unix.POLLERR) != 0 {
+			send(Event{Type: EventDisconnect, Err: ErrDeviceRemoved})
+			return
+		}
+		if fds[1].Revents&unix.POLLIN != 0 {
+			atomic.StoreInt32(&m.rxPending, 1)
+			if !send(Event{Type: EventRXAvailable}) {
+				return
+			}
+		}
+		now, err := modemLines(fd)
+		if removedError(err) == ErrDeviceRemoved {
+			send(Event{Type: EventDisconnect, Err: ErrDeviceRemoved})
+			return
+		}
+		if err == nil && now != lines {
+			lines = now
+			if !send(Event{Type: EventLineStatus, Lines: lines}) {
+				return
+			}
+		}
+		// Disarm before sampling, so a Write finishing meanwhile Arms again
+		if atomic.SwapInt32(&m.txPending, 0) != 0 {
+			empty, err := outputEmpty(fd)
+			if err == nil && !empty {
+				atomic.StoreInt32(&m.txPending, 1)
+			} else if err == nil && !send(Event{Type: EventTXEmpty}) {
+				return
+			}
+		}
+	}
+}
+
+// stopped drains the Self Pipe and reports whether it held the Stop byte
+// rather than only wake ups from Writes
+func (m *eventMonitor) stopped() bool {
+	var b [8]byte
+	n, err := unix.Read(m.stopR, b[:])
+	if err == unix.EINTR {
+		return false
+	}
+	return n <= 0 || bytes.IndexByte(b[:n], 0) >= 0
+}
+
+// rearmEvents allows the next RX Event to be delivered
+func (s *serialPort) rearmEvents() {
+	s.evMx.Lock()
+	if s.events != nil {
+		atomic.StoreInt32(&s.events.rxPending, 0)
+	}
+	s.evMx.Unlock()
+}
+
+// armTXEmpty has the Event Monitor report EventTXEmpty once what was just
+// written has been sent
+func (s *serialPort) armTXEmpty() {
+	s.evMx.Lock()
+	defer s.evMx.Unlock()
+	m := s.events
+	if m == nil || m.stopW == 0 {
+		return
+	}
+	if atomic.CompareAndSwapInt32(&m.txPending, 0, 1) {
+		// Wake the Monitor from its slow Poll
+		unix.Write(m.stopW, []byte{1})
+	}
+}
+
+// stopEvents shuts the Event Monitor down and closes its channel
+func (s *serialPort) stopEvents() {
+	s.evMx.Lock()
+	m := s.events
+	s.events = nil
+	s.evMx.Unlock()
+	if m == nil {
+		return
+	}
+	if m.stopW != 0 {
+		unix.Write(m.stopW, []byte{0})
+		<-m.done
+		unix.Close(m.stopW)
+	}
+}
@@ -0,0 +1,150 @@
+// Copyright 2021 Abhijit Bose. All rights reserved.
+
+package xserial
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// How often the Input Queue and Modem Lines are sampled by the Event Monitor
+const eventLinePollInterval = 50 * time.Millisecond
+
+// How often the Output Queue is sampled while a TX Empty Event is Armed
+const eventTXPollInterval = time.Millisecond
+
+type eventMonitor struct {
+	ch   chan Event
+	stop chan struct{}
+	done chan struct{}
+	// Wakes the Monitor when a Write Arms a TX Empty Event
+	kick chan struct{}
+	// Set while an RX Event is Outstanding
+	rxPending int32
+	// Set from a Write until the Output has Drained
+	txPending int32
+}
+
+func (s *serialPort) Events() <-chan Event {
+	s.evMx.Lock()
+	defer s.evMx.Unlock()
+	if s.events != nil {
+		return s.events.ch
+	}
+
+	m := &eventMonitor{ch: make(chan Event, 16), stop: make(chan struct{}), done: make(chan struct{}),
+		kick: make(chan struct{}, 1)}
+	s.events = m
+	if !s.opened {
+		close(m.ch)
+		close(m.done)
+		return m.ch
+	}
+	go m.run(s)
+	return m.ch
+}
+
+func (m *eventMonitor) run(s *serialPort) {
+	defer close(m.done)
+	defer close(m.ch)
+
+	send := func(e Event) bool {
+		e.Time = time.Now()
+		select {
+		case m.ch <- e:
+			return true
+		case <-m.stop:
+			return false
+		}
+	}
+
+	t := time.NewTicker(eventLinePollInterval)
+	defer t.Stop()
+	var lines int
+	if !s.pipe {
+		lines, _ = s.modemLines()
+	}
+	for {
+		var tx <-chan time.Time
+		if atomic.LoadInt32(&m.txPending) != 0 {
+			tx = time.After(eventTXPollInterval)
+		}
+		select {
+		case <-m.stop:
+			return
+		case <-t.C:
+		case <-m.kick:
+		case <-tx:
+		}
+		// Disarm before sampling, so a Write finishing meanwhile Arms again
+		if atomic.SwapInt32(&m.txPending, 0) != 0 {
+			empty, err := s.outputEmpty()
+			if err == nil && !empty {
+				atomic.StoreInt32(&m.txPending, 1)
+			} else if err == nil && !send(Event{Type: EventTXEmpty}) {
+				return
+			}
+		}
+		if atomic.LoadInt32(&m.rxPending) == 0 {
+			n, err := s.available()
+			if err != nil {
+				send(Event{Type: EventDisconnect, Err: s.pipeError(err)})
+				return
+			}
+			if n > 0 {
+				atomic.StoreInt32(&m.rxPending, 1)
+				if !send(Event{Type: EventRXAvailable}) {
+					return
+				}
+			}
+		}
+		if s.pipe {
+			continue
+		}
+		if now, err := s.modemLines(); err == nil && now != lines {
+			lines = now
+			if !send(Event{Type: EventLineStatus, Lines: lines}) {
+				return
+			}
+		}
+	}
+}
+
+// rearmEvents allows the next RX Event to be delivered
+func (s *serialPort) rearmEvents() {
+	s.evMx.Lock()
+	if s.events != nil {
+		atomic.StoreInt32(&s.events.rxPending, 0)
+	}
+	s.evMx.Unlock()
+}
+
+// armTXEmpty has the Event Monitor report EventTXEmpty once what was just
+// written has been sent
+func (s *serialPort) armTXEmpty() {
+	s.evMx.Lock()
+	defer s.evMx.Unlock()
+	m := s.events
+	if m == nil {
+		return
+	}
+	if atomic.CompareAndSwapInt32(&m.txPending, 0, 1) {
+		select {
+		case m.kick <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// stopEvents shuts the Event Monitor down and closes its channel
+func (s *serialPort) stopEvents() {
+	s.evMx.Lock()
+	m := s.events
+	s.events = nil
+	s.evMx.Unlock()
+	if m == nil {
+		return
+	}
+	close(m.stop)
+	<-m.done
+}
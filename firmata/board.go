@@ -0,0 +1,390 @@
+package firmata
+
+import (
+	"context"
+	"time"
+
+	"github.com/packing/xserial"
+)
+
+// Config configures a Board
+type Config struct {
+	// How long Open waits for the firmware to answer, defaults to 5
+	// seconds as most boards reset when the Port opens
+	Timeout time.Duration
+}
+
+// Pin is what the board reported about one pin
+type Pin struct {
+	// Supported modes and their resolution in bits
+	Modes map[byte]int
+	// Analog channel of the pin, or -1
+	Channel int
+	// Mode last set with PinMode, ModeIgnore until then
+	Mode byte
+	// Last value reported or written
+	Value int
+}
+
+// Message is one message received from the board
+type Message struct {
+	// Message type, such as DigitalMessage, or StartSysex
+	Type byte
+	// Port of a DigitalMessage, or pin of an AnalogMessage and of
+	// ExtendedAnalog
+	Pin int
+	// Port bits or analog value
+	Value int
+	// Sysex command and its data, still 7-bit
+	Sysex byte
+	Data  []byte
+}
+
+// Board drives a board running Firmata on a Port. Messages arriving while
+// a method waits for its answer are applied and kept for Next. Its methods
+// must not be called concurrently.
+type Board struct {
+	p       xserial.Port
+	cfg     Config
+	rd      *xserial.FrameReader
+	pending []Message
+	// Firmware Name and Version from REPORT_FIRMWARE
+	name         string
+	major, minor byte
+	pins         []Pin
+	// Analog Channel to Pin
+	channels map[int]int
+	// Output Bits of each Port for DigitalMessage
+	ports map[int]byte
+}
+
+// Open waits for the firmware on p to identify itself, then reads the pin
+// capabilities and analog mapping. cfg may be nil.
+func Open(ctx context.Context, p xserial.Port, cfg *Config) (*Board, error) {
+	var c Config
+	if cfg != nil {
+		c = *cfg
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = 5 * time.Second
+	}
+	b := &Board{p: p, cfg: c, rd: xserial.NewFrameReader(p, NewDecoder()), ports: make(map[int]byte)}
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+	// The Firmware ignores Queries while it boots, so ask again each Second
+	for b.name == "" {
+		if err := b.write(Sysex(ReportFirmware, nil)); err != nil {
+			return nil, err
+		}
+		wait, stop := context.WithTimeout(ctx, time.Second)
+		_, err := b.reply(wait, ReportFirmware)
+		stop()
+		if err == ErrNoResponse && ctx.Err() == nil {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := b.write(Sysex(CapabilityQuery, nil)); err != nil {
+		return nil, err
+	}
+	if _, err := b.reply(ctx, CapabilityResponse); err != nil {
+		return nil, err
+	}
+	if err := b.write(Sysex(AnalogMappingQuery, nil)); err != nil {
+		return nil, err
+	}
+	if _, err := b.reply(ctx, AnalogMappingResponse); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func (b *Board) write(msg []byte) error {
+	_, err := b.p.Write(msg)
+	return err
+}
+
+// read returns the next message, applied to the Board's state
+func (b *Board) read(ctx context.Context) (Message, error) {
+	for {
+		f, err := b.rd.ReadFrameContext(ctx)
+		if err == xserial.ErrReadTimeout {
+			if err = ctx.Err(); err == nil {
+				continue
+			}
+		}
+		if err != nil {
+			return Message{}, err
+		}
+		m, err := b.apply(f)
+		if err == ErrFormat {
+			continue
+		}
+		return m, err
+	}
+}
+
+// reply waits for the sysex reply cmd, keeping other messages for Next
+func (b *Board) reply(ctx context.Context, cmd byte) (Message, error) {
+	for {
+		m, err := b.read(ctx)
+		if err == context.DeadlineExceeded {
+			return m, ErrNoResponse
+		}
+		if err != nil {
+			return m, err
+		}
+		if m.Type == StartSysex && m.Sysex == cmd {
+			return m, nil
+		}
+		b.pending = append(b.pending, m)
+	}
+}
+
+// Next returns the next message received, after updating the pin values
+// it reports
+func (b *Board) Next(ctx context.Context) (Message, error) {
+	if len(b.pending) > 0 {
+		m := b.pending[0]
+		b.pending = b.pending[1:]
+		return m, nil
+	}
+	return b.read(ctx)
+}
+
+// apply decodes frame f and updates the Board with it
+func (b *Board) apply(f []byte) (Message, error) {
+	m := Message{Type: f[0]}
+	switch f[0] & 0xF0 {
+	case DigitalMessage:
+		m.Type = DigitalMessage
+		m.Pin = int(f[0] & 0x0F)
+		m.Value = int(f[1]) | int(f[2])<<7
+		for i := 0; i < 8; i++ {
+			pin := m.Pin*8 + i
+			if pin < len(b.pins) && (b.pins[pin].Mode == ModeInput || b.pins[pin].Mode == ModePullup) {
+				b.pins[pin].Value = m.Value >> uint(i) & 1
+			}
+		}
+		return m, nil
+	case AnalogMessage:
+		m.Type = AnalogMessage
+		m.Pin = int(f[0] & 0x0F)
+		m.Value = int(f[1]) | int(f[2])<<7
+		if pin, ok := b.channels[m.Pin]; ok {
+			b.pins[pin].Value = m.Value
+		}
+		return m, nil
+	}
+	switch f[0] {
+	case ReportVersion:
+		b.major, b.minor = f[1], f[2]
+		return m, nil
+	case StartSysex:
+		if len(f) < 3 {
+			return m, ErrFormat
+		}
+		m.Sysex, m.Data = f[1], f[2:len(f)-1]
+		return m, b.sysex(&m)
+	}
+	return m, nil
+}
+
+// sysex updates the Board from the sysex replies it understands
+func (b *Board) sysex(m *Message) error {
+	d := m.Data
+	switch m.Sysex {
+	case ReportFirmware:
+		if len(d) < 2 {
+			return ErrFormat
+		}
+		b.major, b.minor = d[0], d[1]
+		b.name = string(Decode7(d[2:]))
+		if b.name == "" {
+			b.name = "Firmata"
+		}
+	case CapabilityResponse:
+		b.pins = b.pins[:0]
+		pin := Pin{Modes: make(map[byte]int), Channel: -1, Mode: ModeIgnore}
+		for i := 0; i < len(d); {
+			if d[i] == 0x7F {
+				b.pins = append(b.pins, pin)
+				pin = Pin{Modes: make(map[byte]int), Channel: -1, Mode: ModeIgnore}
+				i++
+				continue
+			}
+			if i+1 >= len(d) {
+				return ErrFormat
+			}
+			pin.Modes[d[i]] = int(d[i+1])
+			i += 2
+		}
+	case AnalogMappingResponse:
+		b.channels = make(map[int]int)
+		for pin, ch := range d {
+			if ch == 0x7F || pin >= len(b.pins) {
+				continue
+			}
+			b.pins[pin].Channel = int(ch)
+			b.channels[int(ch)] = pin
+		}
+	case ExtendedAnalog:
+		if len(d) < 2 {
+			return ErrFormat
+		}
+		m.Pin = int(d[0])
+		for i, v := range d[1:] {
+			m.Value |= int(v) << uint(7*i)
+		}
+		if m.Pin < len(b.pins) {
+			b.pins[m.Pin].Value = m.Value
+		}
+	case PinStateResponse:
+		if len(d) < 2 {
+			return ErrFormat
+		}
+		pin := int(d[0])
+		if pin < len(b.pins) {
+			b.pins[pin].Mode = d[1]
+			v := 0
+			for i, c := range d[2:] {
+				v |= int(c) << uint(7*i)
+			}
+			b.pins[pin].Value = v
+		}
+	}
+	return nil
+}
+
+// Firmware returns the name and version the firmware reported, such as
+// "StandardFirmata.ino" 2.5
+func (b *Board) Firmware() (name string, major, minor byte) {
+	return b.name, b.major, b.minor
+}
+
+// Pins returns what the board reported about its pins
+func (b *Board) Pins() []Pin {
+	return append([]Pin(nil), b.pins...)
+}
+
+// pin checks pin exists and supports mode
+func (b *Board) pin(pin int, mode byte) error {
+	if pin < 0 || pin >= len(b.pins) {
+		return ErrPin
+	}
+	if _, ok := b.pins[pin].Modes[mode]; !ok {
+		return ErrPin
+	}
+	return nil
+}
+
+// PinMode sets the mode of pin, such as ModeOutput or ModeAnalog
+func (b *Board) PinMode(pin int, mode byte) error {
+	if err := b.pin(pin, mode); err != nil {
+		return err
+	}
+	if err := b.write([]byte{SetPinMode, byte(pin), mode}); err != nil {
+		return err
+	}
+	b.pins[pin].Mode = mode
+	return nil
+}
+
+// DigitalWrite drives an output pin high or low. The whole port is sent,
+// as firmware before Firmata 2.5 only understands that.
+func (b *Board) DigitalWrite(pin int, high bool) error {
+	if pin < 0 || pin >= len(b.pins) {
+		return ErrPin
+	}
+	port, bit := pin/8, byte(1)<<uint(pin%8)
+	v := b.ports[port]
+	if high {
+		v |= bit
+		b.pins[pin].Value = 1
+	} else {
+		v &^= bit
+		b.pins[pin].Value = 0
+	}
+	b.ports[port] = v
+	return b.write([]byte{DigitalMessage | byte(port), v & 0x7F, v >> 7})
+}
+
+// AnalogWrite sets the PWM duty or servo position of pin, with
+// EXTENDED_ANALOG for pins above 15 or values above 14 bits
+func (b *Board) AnalogWrite(pin, value int) error {
+	if pin < 0 || pin >= len(b.pins) || value < 0 {
+		return ErrPin
+	}
+	b.pins[pin].Value = value
+	if pin <= 15 && value < 1<<14 {
+		return b.write([]byte{AnalogMessage | byte(pin), byte(value & 0x7F), byte(value >> 7 & 0x7F)})
+	}
+	data := []byte{byte(pin)}
+	for v := value; ; {
+		data = append(data, byte(v&0x7F))
+		if v >>= 7; v == 0 {
+			break
+		}
+	}
+	return b.write(Sysex(ExtendedAnalog, data))
+}
+
+// DigitalRead returns the last value reported for an input pin. Turn on
+// reports for its port with ReportDigitalPort and keep calling Next.
+func (b *Board) DigitalRead(pin int) (bool, error) {
+	if pin < 0 || pin >= len(b.pins) {
+		return false, ErrPin
+	}
+	return b.pins[pin].Value != 0, nil
+}
+
+// AnalogRead returns the last value reported on an analog channel. Turn
+// on reports for it with ReportAnalogChannel and keep calling Next.
+func (b *Board) AnalogRead(channel int) (int, error) {
+	pin, ok := b.channels[channel]
+	if !ok {
+		return 0, ErrPin
+	}
+	return b.pins[pin].Value, nil
+}
+
+// ReportDigitalPort turns reports of the eight pins of port on or off
+func (b *Board) ReportDigitalPort(port int, on bool) error {
+	return b.write([]byte{ReportDigital | byte(port&0x0F), flag(on)})
+}
+
+// ReportAnalogChannel turns reports of an analog channel on or off
+func (b *Board) ReportAnalogChannel(channel int, on bool) error {
+	return b.write([]byte{ReportAnalog | byte(channel&0x0F), flag(on)})
+}
+
+// SetSamplingInterval sets how often analog channels are reported,
+// 19ms by default
+func (b *Board) SetSamplingInterval(d time.Duration) error {
+	ms := int(d / time.Millisecond)
+	return b.write(Sysex(SamplingInterval, []byte{byte(ms & 0x7F), byte(ms >> 7 & 0x7F)}))
+}
+
+// SendSysex sends a sysex command with data, which must be 7-bit
+func (b *Board) SendSysex(cmd byte, data []byte) error {
+	return b.write(Sysex(cmd, data))
+}
+
+// SendString sends text as STRING_DATA
+func (b *Board) SendString(text string) error {
+	return b.write(Sysex(StringData, Encode7([]byte(text))))
+}
+
+// Reset asks the firmware to reset its pins and reports
+func (b *Board) Reset() error {
+	return b.write([]byte{SystemReset})
+}
+
+func flag(on bool) byte {
+	if on {
+		return 1
+	}
+	return 0
+}
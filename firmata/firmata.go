@@ -0,0 +1,209 @@
+// Package firmata implements the Firmata protocol that StandardFirmata
+// and similar sketches speak: MIDI-like messages for digital and analog
+// I/O, sysex commands for everything else, and a Board driving an
+// Arduino-class board on a Port.
+package firmata
+
+import (
+	"errors"
+)
+
+// Message Types; the low nibble of the first three carries the port or
+// the pin
+const (
+	DigitalMessage     = 0x90
+	ReportAnalog       = 0xC0
+	ReportDigital      = 0xD0
+	AnalogMessage      = 0xE0
+	StartSysex         = 0xF0
+	SetPinMode         = 0xF4
+	SetDigitalPinValue = 0xF5
+	EndSysex           = 0xF7
+	ReportVersion      = 0xF9
+	SystemReset        = 0xFF
+)
+
+// Sysex Commands
+const (
+	EncoderData           = 0x61
+	AnalogMappingQuery    = 0x69
+	AnalogMappingResponse = 0x6A
+	CapabilityQuery       = 0x6B
+	CapabilityResponse    = 0x6C
+	PinStateQuery         = 0x6D
+	PinStateResponse      = 0x6E
+	ExtendedAnalog        = 0x6F
+	ServoConfig           = 0x70
+	StringData            = 0x71
+	OneWireData           = 0x73
+	ShiftData             = 0x75
+	I2CRequest            = 0x76
+	I2CReply              = 0x77
+	I2CConfig             = 0x78
+	ReportFirmware        = 0x79
+	SamplingInterval      = 0x7A
+	SchedulerData         = 0x7B
+)
+
+// Pin Modes
+const (
+	ModeInput   = 0x00
+	ModeOutput  = 0x01
+	ModeAnalog  = 0x02
+	ModePWM     = 0x03
+	ModeServo   = 0x04
+	ModeShift   = 0x05
+	ModeI2C     = 0x06
+	ModeOneWire = 0x07
+	ModeStepper = 0x08
+	ModeEncoder = 0x09
+	ModeSerial  = 0x0A
+	ModePullup  = 0x0B
+	// Mode of pins not set yet
+	ModeIgnore = 0x7F
+)
+
+// MaxSysex is the longest sysex message, framing included, a Decoder
+// accepts
+const MaxSysex = 4096
+
+var (
+	// ErrFormat - the message is too short for its type
+	ErrFormat = errors.New("firmata: malformed message")
+	// ErrNoResponse - the board did not answer in time
+	ErrNoResponse = errors.New("firmata: no response")
+	// ErrPin - the pin does not exist or does not support the mode
+	ErrPin = errors.New("firmata: pin not available")
+)
+
+// messageLength returns the length of the message starting with status,
+// or 0 when status starts no message
+func messageLength(status byte) int {
+	switch status & 0xF0 {
+	case DigitalMessage, AnalogMessage:
+		return 3
+	case ReportAnalog, ReportDigital:
+		return 2
+	}
+	switch status {
+	case SetPinMode, SetDigitalPinValue, ReportVersion:
+		return 3
+	case SystemReset:
+		return 1
+	}
+	return 0
+}
+
+// Decoder is an xserial.FrameDecoder returning whole Firmata messages,
+// sysex messages from START_SYSEX to END_SYSEX. Data bytes outside a
+// message, unknown status bytes and sysex messages over MaxSysex count as
+// resyncs.
+type Decoder struct {
+	buf     []byte
+	want    int
+	sysex   bool
+	skip    bool
+	resyncs uint64
+}
+
+// NewDecoder returns a Decoder
+func NewDecoder() *Decoder {
+	return &Decoder{}
+}
+
+func (d *Decoder) Feed(p []byte) [][]byte {
+	var frames [][]byte
+	for _, c := range p {
+		if d.sysex {
+			if c == EndSysex {
+				if !d.skip {
+					frames = append(frames, append(append([]byte(nil), d.buf...), c))
+				}
+				d.Reset()
+				continue
+			}
+			if c&0x80 != 0 {
+				// A Status Byte cuts the Sysex short
+				d.resyncs++
+				d.Reset()
+			} else {
+				if !d.skip {
+					if len(d.buf)+1 >= MaxSysex {
+						d.skip = true
+						d.resyncs++
+					} else {
+						d.buf = append(d.buf, c)
+					}
+				}
+				continue
+			}
+		}
+		if c&0x80 != 0 {
+			if len(d.buf) > 0 {
+				// Message cut short by the next
+				d.resyncs++
+			}
+			d.Reset()
+			if c == StartSysex {
+				d.sysex = true
+				d.buf = append(d.buf, c)
+				continue
+			}
+			d.want = messageLength(c)
+			if d.want == 0 {
+				d.resyncs++
+				continue
+			}
+			d.buf = append(d.buf, c)
+		} else if len(d.buf) == 0 {
+			d.resyncs++
+			continue
+		} else {
+			d.buf = append(d.buf, c)
+		}
+		if len(d.buf) == d.want {
+			frames = append(frames, append([]byte(nil), d.buf...))
+			d.Reset()
+		}
+	}
+	return frames
+}
+
+func (d *Decoder) Resyncs() uint64 {
+	return d.resyncs
+}
+
+func (d *Decoder) Reset() {
+	d.buf = d.buf[:0]
+	d.want = 0
+	d.sysex = false
+	d.skip = false
+}
+
+// Sysex returns a sysex message for cmd with data, which must be 7-bit
+func Sysex(cmd byte, data []byte) []byte {
+	b := make([]byte, 0, len(data)+3)
+	b = append(b, StartSysex, cmd)
+	b = append(b, data...)
+	return append(b, EndSysex)
+}
+
+// Encode7 splits each byte of b into the LSB and MSB 7-bit pair sysex
+// data such as strings and I2C carries
+func Encode7(b []byte) []byte {
+	out := make([]byte, 0, len(b)*2)
+	for _, c := range b {
+		out = append(out, c&0x7F, c>>7)
+	}
+	return out
+}
+
+// Decode7 joins 7-bit LSB and MSB pairs back into bytes, dropping an odd
+// trailing byte
+func Decode7(b []byte) []byte {
+	out := make([]byte, 0, len(b)/2)
+	for i := 0; i+1 < len(b); i += 2 {
+		out = append(out, b[i]|b[i+1]<<7)
+	}
+	return out
+}
@@ -0,0 +1,117 @@
+package xserial
+
+import (
+	"context"
+	"io"
+)
+
+// FrameDecoder incrementally splits a received byte stream into frames.
+// Decoders keep partial frames between calls and must produce the same
+// frames and resync count however the stream is split, down to one byte per
+// Feed, so fuzzers can drive them a byte at a time.
+type FrameDecoder interface {
+	// Feed consumes p and returns the frames it completed, in order. The
+	// frames are owned by the caller.
+	Feed(p []byte) [][]byte
+	// Resyncs returns how often the decoder discarded input to regain
+	// framing, such as after a bad checksum or a stray byte
+	Resyncs() uint64
+	// Reset discards any partial frame
+	Reset()
+}
+
+// matchDecoder adapts a Matcher to a FrameDecoder
+type matchDecoder struct {
+	match Matcher
+	buf   []byte
+}
+
+// NewMatchDecoder returns a FrameDecoder cutting a frame wherever match
+// completes one at the start of the pending input. It never resyncs.
+func NewMatchDecoder(match Matcher) FrameDecoder {
+	return &matchDecoder{match: match}
+}
+
+func (d *matchDecoder) Feed(p []byte) [][]byte {
+	var frames [][]byte
+	for _, b := range p {
+		// One Byte at a time so Results don't depend on how p was split
+		d.buf = append(d.buf, b)
+		if n := d.match(d.buf); n > 0 {
+			frames = append(frames, append([]byte(nil), d.buf[:n]...))
+			d.buf = append(d.buf[:0], d.buf[n:]...)
+		}
+	}
+	return frames
+}
+
+func (d *matchDecoder) Resyncs() uint64 {
+	return 0
+}
+
+func (d *matchDecoder) Reset() {
+	d.buf = d.buf[:0]
+}
+
+// FrameReader reads frames from a Port through a FrameDecoder
+type FrameReader struct {
+	p       Port
+	dec     FrameDecoder
+	buf     []byte
+	pending [][]byte
+}
+
+// NewFrameReader returns a FrameReader decoding p with dec
+func NewFrameReader(p Port, dec FrameDecoder) *FrameReader {
+	return &FrameReader{p: p, dec: dec, buf: make([]byte, 4096)}
+}
+
+// ReadFrame returns the next frame. Read errors, including ErrReadTimeout,
+// are returned as they occur; partial frames are kept for the next call.
+func (r *FrameReader) ReadFrame() ([]byte, error) {
+	return r.ReadFrameContext(context.Background())
+}
+
+// ReadFrameContext is ReadFrame giving up with ctx.Err() once ctx is done
+func (r *FrameReader) ReadFrameContext(ctx context.Context) ([]byte, error) {
+	for len(r.pending) == 0 {
+		n, err := ReadContext(ctx, r.p, r.buf)
+		if n > 0 {
+			r.pending = r.dec.Feed(r.buf[:n])
+		}
+		if len(r.pending) > 0 {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+	}
+	f := r.pending[0]
+	r.pending = r.pending[1:]
+	return f, nil
+}
+
+// Decoder returns the FrameDecoder, for example to read its Resyncs
+func (r *FrameReader) Decoder() FrameDecoder {
+	return r.dec
+}
+
+// DecodeAll feeds everything from rd to dec and returns the frames, which
+// is convenient for decoding captures
+func DecodeAll(rd io.Reader, dec FrameDecoder) ([][]byte, error) {
+	var frames [][]byte
+	buf := make([]byte, 4096)
+	for {
+		n, err := rd.Read(buf)
+		frames = append(frames, dec.Feed(buf[:n])...)
+		if err == io.EOF {
+			return frames, nil
+		}
+		if err != nil {
+			return frames, err
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package framing
+
+import (
+	"sync"
+
+	"github.com/packing/xserial"
+)
+
+// AddressFunc returns the station a frame is addressed to, with ok false
+// when the header cannot be parsed
+type AddressFunc func(frame []byte) (addr uint32, ok bool)
+
+// ByteAddress returns an AddressFunc reading the address from the byte at
+// offset, as in Modbus RTU and most multidrop protocols
+func ByteAddress(offset int) AddressFunc {
+	return func(frame []byte) (uint32, bool) {
+		if len(frame) <= offset {
+			return 0, false
+		}
+		return uint32(frame[offset]), true
+	}
+}
+
+// AddressFilter wraps a FrameDecoder and passes on only the frames
+// addressed to its stations, so an application on a multidrop bus sees
+// its own traffic. Frames for other stations and frames whose address
+// cannot be parsed are dropped and counted, not resynced.
+type AddressFilter struct {
+	dec     xserial.FrameDecoder
+	addr    AddressFunc
+	mx      sync.Mutex
+	accept  map[uint32]bool
+	dropped uint64
+}
+
+// NewAddressFilter returns an AddressFilter delivering the frames of dec
+// that addr finds addressed to one of stations
+func NewAddressFilter(dec xserial.FrameDecoder, addr AddressFunc, stations ...uint32) *AddressFilter {
+	f := &AddressFilter{dec: dec, addr: addr}
+	f.SetStations(stations...)
+	return f
+}
+
+// SetStations replaces the stations delivered, taking effect from the next
+// Feed
+func (f *AddressFilter) SetStations(stations ...uint32) {
+	accept := make(map[uint32]bool, len(stations))
+	for _, s := range stations {
+		accept[s] = true
+	}
+	f.mx.Lock()
+	f.accept = accept
+	f.mx.Unlock()
+}
+
+func (f *AddressFilter) Feed(p []byte) [][]byte {
+	frames := f.dec.Feed(p)
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	n := 0
+	for _, fr := range frames {
+		if a, ok := f.addr(fr); ok && f.accept[a] {
+			frames[n] = fr
+			n++
+		} else {
+			f.dropped++
+		}
+	}
+	return frames[:n]
+}
+
+// Dropped returns how many complete frames were not delivered
+func (f *AddressFilter) Dropped() uint64 {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	return f.dropped
+}
+
+func (f *AddressFilter) Resyncs() uint64 {
+	return f.dec.Resyncs()
+}
+
+func (f *AddressFilter) Reset() {
+	f.dec.Reset()
+}
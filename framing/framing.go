@@ -0,0 +1,235 @@
+package framing
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// Delimited cuts frames at a delimiter, which is not included in them.
+// Create it with NewDelimited or NewLines.
+type Delimited struct {
+	delim   []byte
+	max     int
+	trimCR  bool
+	buf     []byte
+	scanned int
+	// Dropping an over long Frame up to the next Delimiter
+	discarding bool
+	resyncs    uint64
+}
+
+// NewDelimited returns a decoder for frames ending in delim. A frame longer
+// than max bytes is dropped up to the next delim and counted as a resync;
+// max of 0 allows any length. Empty frames are returned.
+func NewDelimited(delim []byte, max int) *Delimited {
+	return &Delimited{delim: append([]byte(nil), delim...), max: max}
+}
+
+// NewLines returns a decoder for lines ending in "\n" with any "\r" before
+// it removed, like bufio.ScanLines
+func NewLines(max int) *Delimited {
+	d := NewDelimited([]byte("\n"), max)
+	d.trimCR = true
+	return d
+}
+
+func (d *Delimited) Feed(p []byte) [][]byte {
+	var frames [][]byte
+	d.buf = append(d.buf, p...)
+	for {
+		i := bytes.Index(d.buf[d.scanned:], d.delim)
+		if i < 0 {
+			// Keep what could be the Start of a Delimiter
+			d.scanned = len(d.buf) - len(d.delim) + 1
+			if d.scanned < 0 {
+				d.scanned = 0
+			}
+			// A trimmed CR may still bring the Frame within max
+			limit := d.max
+			if d.trimCR {
+				limit++
+			}
+			if d.max > 0 && d.scanned > limit {
+				if !d.discarding {
+					d.discarding = true
+					d.resyncs++
+				}
+				d.buf = append(d.buf[:0], d.buf[d.scanned:]...)
+				d.scanned = 0
+			}
+			return frames
+		}
+		i += d.scanned
+		f := d.buf[:i]
+		if d.trimCR && len(f) > 0 && f[len(f)-1] == '\r' {
+			f = f[:len(f)-1]
+		}
+		switch {
+		case d.discarding:
+			d.discarding = false
+		case d.max > 0 && len(f) > d.max:
+			d.resyncs++
+		default:
+			frames = append(frames, append([]byte{}, f...))
+		}
+		d.buf = append(d.buf[:0], d.buf[i+len(d.delim):]...)
+		d.scanned = 0
+	}
+}
+
+func (d *Delimited) Resyncs() uint64 {
+	return d.resyncs
+}
+
+func (d *Delimited) Reset() {
+	d.buf = d.buf[:0]
+	d.scanned = 0
+	d.discarding = false
+}
+
+// Fixed cuts frames of a fixed length. It never resyncs; Reset realigns it.
+type Fixed struct {
+	n   int
+	buf []byte
+}
+
+// NewFixed returns a decoder for frames of n bytes
+func NewFixed(n int) *Fixed {
+	if n < 1 {
+		n = 1
+	}
+	return &Fixed{n: n}
+}
+
+func (d *Fixed) Feed(p []byte) [][]byte {
+	var frames [][]byte
+	d.buf = append(d.buf, p...)
+	i := 0
+	for ; len(d.buf)-i >= d.n; i += d.n {
+		frames = append(frames, append([]byte(nil), d.buf[i:i+d.n]...))
+	}
+	d.buf = append(d.buf[:0], d.buf[i:]...)
+	return frames
+}
+
+func (d *Fixed) Resyncs() uint64 {
+	return 0
+}
+
+func (d *Fixed) Reset() {
+	d.buf = d.buf[:0]
+}
+
+// LengthConfig describes a frame carrying its own length
+type LengthConfig struct {
+	// Optional - Bytes every frame starts with, such as 0xAA 0x55
+	Sync []byte
+	// Position of the Length Field from the Start of the Frame
+	Offset int
+	// Length Field Size - 1, 2 or 4 bytes, defaults to 1
+	Size int
+	// Length Field is Little Endian instead of Big Endian
+	LittleEndian bool
+	// Added to the Length Field to get the bytes following it, for example
+	// 2 for a trailing CRC the length does not cover, or -2 for a length
+	// that counts itself
+	Adjust int
+	// Longest whole frame, defaults to 65536
+	Max int
+}
+
+// LengthPrefixed cuts frames whose header gives their length. Frames are
+// returned whole, header included. Create it with NewLengthPrefixed.
+type LengthPrefixed struct {
+	cfg        LengthConfig
+	buf        []byte
+	discarding bool
+	resyncs    uint64
+}
+
+// NewLengthPrefixed returns a decoder for frames described by cfg. A bad
+// Sync or a length outside the frame limits drops one byte and tries again,
+// counting one resync per run of dropped bytes.
+func NewLengthPrefixed(cfg *LengthConfig) *LengthPrefixed {
+	c := *cfg
+	c.Sync = append([]byte(nil), c.Sync...)
+	if c.Size != 2 && c.Size != 4 {
+		c.Size = 1
+	}
+	if c.Max <= 0 {
+		c.Max = 65536
+	}
+	return &LengthPrefixed{cfg: c}
+}
+
+// length returns the whole frame length of the header at b, or -1 if it is
+// impossible
+func (d *LengthPrefixed) length(b []byte) int {
+	var order binary.ByteOrder = binary.BigEndian
+	if d.cfg.LittleEndian {
+		order = binary.LittleEndian
+	}
+	field := b[d.cfg.Offset : d.cfg.Offset+d.cfg.Size]
+	var v int64
+	switch d.cfg.Size {
+	case 1:
+		v = int64(field[0])
+	case 2:
+		v = int64(order.Uint16(field))
+	case 4:
+		v = int64(order.Uint32(field))
+	}
+	hdr := int64(d.cfg.Offset + d.cfg.Size)
+	n := hdr + v + int64(d.cfg.Adjust)
+	if n < hdr || n < 1 || n > int64(d.cfg.Max) {
+		return -1
+	}
+	return int(n)
+}
+
+func (d *LengthPrefixed) Feed(p []byte) [][]byte {
+	var frames [][]byte
+	d.buf = append(d.buf, p...)
+	i := 0
+	for {
+		b := d.buf[i:]
+		n := len(d.cfg.Sync)
+		if len(b) < n {
+			n = len(b)
+		}
+		ok := bytes.Equal(b[:n], d.cfg.Sync[:n])
+		hdr := d.cfg.Offset + d.cfg.Size
+		length := 0
+		if ok && len(b) >= hdr {
+			if length = d.length(b); length < 0 {
+				ok = false
+			}
+		}
+		if !ok {
+			// Drop a Byte and look for the next Frame
+			if !d.discarding {
+				d.discarding = true
+				d.resyncs++
+			}
+			i++
+			continue
+		}
+		if len(b) < hdr || len(b) < length {
+			break
+		}
+		frames = append(frames, append([]byte(nil), b[:length]...))
+		d.discarding = false
+		i += length
+	}
+	d.buf = append(d.buf[:0], d.buf[i:]...)
+	return frames
+}
+
+func (d *LengthPrefixed) Resyncs() uint64 {
+	return d.resyncs
+}
+
+func (d *LengthPrefixed) Reset() {
+	d.buf = d.buf[:0]
+	d.discarding = false
+}
@@ -0,0 +1,48 @@
+// Package framing provides the FrameDecoders most serial protocols need -
+// delimiter terminated, fixed length and length prefixed - filters any of
+// them by station address for multidrop buses, and turns any FrameDecoder
+// into a bufio.SplitFunc for use with a bufio.Scanner.
+package framing
+
+import (
+	"bufio"
+
+	"github.com/packing/xserial"
+)
+
+// Split returns a bufio.SplitFunc cutting tokens with dec, so a Scanner
+// gets the same frames and resync handling as a FrameReader. dec must not
+// be shared; a partial frame at EOF is dropped. Input is fed a byte at a
+// time and each frame returned as soon as it completes, so frames already
+// received never wait for another Read.
+func Split(dec xserial.FrameDecoder) bufio.SplitFunc {
+	return func(data []byte, atEOF bool) (int, []byte, error) {
+		for i := range data {
+			if frames := dec.Feed(data[i : i+1]); len(frames) > 0 {
+				return i + 1, frames[0], nil
+			}
+		}
+		return len(data), nil, nil
+	}
+}
+
+// ScanDelimited is a SplitFunc for frames ending in delim, see NewDelimited
+func ScanDelimited(delim []byte, max int) bufio.SplitFunc {
+	return Split(NewDelimited(delim, max))
+}
+
+// ScanLines is a SplitFunc for lines, see NewLines
+func ScanLines(max int) bufio.SplitFunc {
+	return Split(NewLines(max))
+}
+
+// ScanFixed is a SplitFunc for frames of n bytes
+func ScanFixed(n int) bufio.SplitFunc {
+	return Split(NewFixed(n))
+}
+
+// ScanLengthPrefixed is a SplitFunc for frames carrying their length, see
+// NewLengthPrefixed
+func ScanLengthPrefixed(cfg *LengthConfig) bufio.SplitFunc {
+	return Split(NewLengthPrefixed(cfg))
+}
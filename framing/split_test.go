@@ -0,0 +1,62 @@
+package framing
+
+import (
+	"bufio"
+	"io"
+	"testing"
+)
+
+// chunkReader returns its chunks one per Read, then io.EOF, counting Reads
+type chunkReader struct {
+	chunks [][]byte
+	reads  int
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	r.reads++
+	if len(r.chunks) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, r.chunks[0])
+	r.chunks = r.chunks[1:]
+	return n, nil
+}
+
+func TestSplitFramesOfOneRead(t *testing.T) {
+	r := &chunkReader{chunks: [][]byte{[]byte("one\ntwo\nthree\npart")}}
+	s := bufio.NewScanner(r)
+	s.Split(ScanLines(64))
+	for _, want := range []string{"one", "two", "three"} {
+		if !s.Scan() {
+			t.Fatalf("Scan ended before %q: %v", want, s.Err())
+		}
+		if got := s.Text(); got != want {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+		if r.reads != 1 {
+			t.Fatalf("%q needed %d Reads, want 1", want, r.reads)
+		}
+	}
+	if s.Scan() {
+		t.Fatalf("partial frame %q returned at EOF", s.Text())
+	}
+	if err := s.Err(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSplitManyFramesAtEOF(t *testing.T) {
+	r := &chunkReader{chunks: [][]byte{make([]byte, 1000)}}
+	s := bufio.NewScanner(r)
+	s.Split(ScanFixed(4))
+	n := 0
+	for s.Scan() {
+		n++
+	}
+	if err := s.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if n != 250 {
+		t.Fatalf("got %d frames, want 250", n)
+	}
+}
@@ -1,5 +1,14 @@
 module github.com/packing/xserial
 
-go 1.16
+go 1.18
 
-require golang.org/x/sys v0.0.0-20220114195835-da31bd327af9
+require (
+	github.com/tarm/serial v0.0.0-20180830185346-98f6abe2eb07
+	go.bug.st/serial v1.3.4
+	golang.org/x/crypto v0.0.0-20220112180741-5e0467b6c7ce
+	golang.org/x/sys v0.0.0-20220114195835-da31bd327af9
+	golang.org/x/term v0.0.0-20201126162022-7de9c90e9dd1
+	golang.org/x/text v0.3.7
+)
+
+require github.com/creack/goselect v0.1.2 // indirect
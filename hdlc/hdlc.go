@@ -0,0 +1,236 @@
+// Package hdlc implements HDLC-like asynchronous framing as used by PPP (RFC
+// 1662): frames between 0x7E flags, 0x7D escapes and a CRC-16/CCITT frame
+// check sequence.
+package hdlc
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"github.com/packing/xserial"
+	"github.com/packing/xserial/checksum"
+)
+
+// RFC 1662 Special Characters
+const (
+	Flag   = 0x7E
+	Escape = 0x7D
+	// Escaped bytes are sent XORed with this
+	escapeXOR = 0x20
+)
+
+// DefaultMax is the largest payload a Decoder accepts by default
+const DefaultMax = 1500
+
+// ErrTooLarge is returned by WriteFrame for a payload over the maximum
+var ErrTooLarge = errors.New("hdlc: frame too large")
+
+// FCS returns the frame check sequence of b, sent low byte first
+func FCS(b []byte) uint16 {
+	return checksum.X25.Checksum(b)
+}
+
+// Config configures framing
+type Config struct {
+	// Control characters 0x00 - 0x1F escaped on send, bit n for character
+	// n. Zero escapes only Flag and Escape; PPP links start with 0xFFFFFFFF.
+	ACCM uint32
+	// Largest payload, defaults to DefaultMax
+	Max int
+	// Time Exchange waits for a reply, defaults to 1 second
+	Timeout time.Duration
+	// Times Exchange sends again after a Timeout, defaults to 3; negative
+	// for none
+	Retries int
+}
+
+func (c *Config) defaults() Config {
+	var cfg Config
+	if c != nil {
+		cfg = *c
+	}
+	if cfg.Max <= 0 {
+		cfg.Max = DefaultMax
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = time.Second
+	}
+	if cfg.Retries < 0 {
+		cfg.Retries = 0
+	} else if cfg.Retries == 0 {
+		cfg.Retries = 3
+	}
+	return cfg
+}
+
+// Encode returns p with its FCS as one frame between flags. cfg may be nil.
+func Encode(p []byte, cfg *Config) []byte {
+	var accm uint32
+	if cfg != nil {
+		accm = cfg.ACCM
+	}
+	fcs := FCS(p)
+	b := make([]byte, 0, len(p)+len(p)/8+6)
+	b = append(b, Flag)
+	put := func(c byte) {
+		if c == Flag || c == Escape || c < 0x20 && accm&(1<<c) != 0 {
+			b = append(b, Escape, c^escapeXOR)
+		} else {
+			b = append(b, c)
+		}
+	}
+	for _, c := range p {
+		put(c)
+	}
+	put(byte(fcs))
+	put(byte(fcs >> 8))
+	return append(b, Flag)
+}
+
+// Decoder is an xserial.FrameDecoder returning the payload of intact
+// frames. Frames with a bad FCS, an abort sequence, or over the maximum are
+// dropped and counted as resyncs; empty frames between flags are skipped.
+type Decoder struct {
+	max     int
+	buf     []byte
+	esc     bool
+	bad     bool
+	resyncs uint64
+}
+
+// NewDecoder returns a Decoder for frames described by cfg, which may be nil
+func NewDecoder(cfg *Config) *Decoder {
+	c := cfg.defaults()
+	return &Decoder{max: c.Max}
+}
+
+func (d *Decoder) Feed(p []byte) [][]byte {
+	var frames [][]byte
+	for _, c := range p {
+		if c == Flag {
+			if f := d.end(); f != nil {
+				frames = append(frames, f)
+			}
+			continue
+		}
+		if d.bad {
+			continue
+		}
+		if d.esc {
+			d.esc = false
+			c ^= escapeXOR
+		} else if c == Escape {
+			d.esc = true
+			continue
+		}
+		// Room for the FCS
+		if len(d.buf) == d.max+2 {
+			d.bad = true
+			d.resyncs++
+			continue
+		}
+		d.buf = append(d.buf, c)
+	}
+	return frames
+}
+
+// end closes the frame at a flag, returning its payload if intact
+func (d *Decoder) end() []byte {
+	defer d.Reset()
+	switch {
+	case d.bad:
+		return nil
+	case d.esc:
+		// Escape Flag Aborts the Frame
+		d.resyncs++
+		return nil
+	case len(d.buf) == 0:
+		return nil
+	case len(d.buf) < 3:
+		d.resyncs++
+		return nil
+	}
+	n := len(d.buf) - 2
+	if FCS(d.buf[:n]) != binary.LittleEndian.Uint16(d.buf[n:]) {
+		d.resyncs++
+		return nil
+	}
+	return append([]byte(nil), d.buf[:n]...)
+}
+
+func (d *Decoder) Resyncs() uint64 {
+	return d.resyncs
+}
+
+func (d *Decoder) Reset() {
+	d.buf = d.buf[:0]
+	d.esc = false
+	d.bad = false
+}
+
+// Conn exchanges frames over a Port. Only frames passing the FCS check are
+// returned; the rest show in Resyncs.
+type Conn struct {
+	p   xserial.Port
+	cfg Config
+	rd  *xserial.FrameReader
+}
+
+// NewConn returns a Conn over p. cfg may be nil.
+func NewConn(p xserial.Port, cfg *Config) *Conn {
+	c := cfg.defaults()
+	return &Conn{p: p, cfg: c, rd: xserial.NewFrameReader(p, NewDecoder(&c))}
+}
+
+// WriteFrame sends payload as one frame
+func (c *Conn) WriteFrame(payload []byte) error {
+	if len(payload) > c.cfg.Max {
+		return ErrTooLarge
+	}
+	_, err := c.p.Write(Encode(payload, &c.cfg))
+	return err
+}
+
+// ReadFrame returns the payload of the next intact frame, with Read errors
+// such as ErrReadTimeout returned as they occur
+func (c *Conn) ReadFrame() ([]byte, error) {
+	return c.rd.ReadFrame()
+}
+
+// ReadFrameContext is ReadFrame giving up once ctx is done
+func (c *Conn) ReadFrameContext(ctx context.Context) ([]byte, error) {
+	return c.rd.ReadFrameContext(ctx)
+}
+
+// Exchange sends req and returns the first intact frame received after it,
+// sending req again whenever no reply arrives within Timeout. Damaged
+// replies are dropped like silence, so they are retried too.
+func (c *Conn) Exchange(ctx context.Context, req []byte) ([]byte, error) {
+	var err error
+	for attempt := 0; attempt <= c.cfg.Retries; attempt++ {
+		if err = c.WriteFrame(req); err != nil {
+			return nil, err
+		}
+		actx, cancel := context.WithTimeout(ctx, c.cfg.Timeout)
+		var f []byte
+		f, err = c.rd.ReadFrameContext(actx)
+		cancel()
+		if err == nil {
+			return f, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if err != xserial.ErrReadTimeout && err != context.DeadlineExceeded {
+			return nil, err
+		}
+	}
+	return nil, xserial.ErrReadTimeout
+}
+
+// Resyncs returns how many damaged frames were dropped
+func (c *Conn) Resyncs() uint64 {
+	return c.rd.Decoder().Resyncs()
+}
@@ -0,0 +1,75 @@
+package xserial
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// hexDumpPort logs all traffic of the wrapped Port as hexdump lines
+type hexDumpPort struct {
+	Port
+	mx sync.Mutex
+	w  io.Writer
+}
+
+// WithHexDump wraps p so every chunk read or written is logged to w as
+// timestamped hexdump lines, for example:
+//
+//	15:04:05.000000 RX 0000  41 54 0d 0a                                      |AT..|
+func WithHexDump(p Port, w io.Writer) Port {
+	return &hexDumpPort{Port: p, w: w}
+}
+
+// Unwrap returns the dumped Port
+func (h *hexDumpPort) Unwrap() Port {
+	return h.Port
+}
+
+func (h *hexDumpPort) Read(p []byte) (int, error) {
+	n, err := h.Port.Read(p)
+	if n > 0 {
+		h.dump("RX", p[:n])
+	}
+	return n, err
+}
+
+func (h *hexDumpPort) Write(p []byte) (int, error) {
+	n, err := h.Port.Write(p)
+	if n > 0 {
+		h.dump("TX", p[:n])
+	}
+	return n, err
+}
+
+func (h *hexDumpPort) dump(dir string, data []byte) {
+	stamp := time.Now().Format("15:04:05.000000")
+	var b strings.Builder
+	for off := 0; off < len(data); off += 16 {
+		end := off + 16
+		if end > len(data) {
+			end = len(data)
+		}
+		fmt.Fprintf(&b, "%s %s %04x  ", stamp, dir, off)
+		for i := off; i < off+16; i++ {
+			if i < end {
+				fmt.Fprintf(&b, "%02x ", data[i])
+			} else {
+				b.WriteString("   ")
+			}
+		}
+		b.WriteString(" |")
+		for _, c := range data[off:end] {
+			if c < 0x20 || c > 0x7e {
+				c = '.'
+			}
+			b.WriteByte(c)
+		}
+		b.WriteString("|\n")
+	}
+	h.mx.Lock()
+	io.WriteString(h.w, b.String())
+	h.mx.Unlock()
+}
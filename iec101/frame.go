@@ -0,0 +1,245 @@
+// Package iec101 implements the IEC 60870-5-101 link layer over a Port: FT1.2
+// single character, fixed and variable length frames with their checksums,
+// and an unbalanced Master keeping the frame count bit of each station.
+package iec101
+
+import (
+	"errors"
+
+	"github.com/packing/xserial/checksum"
+)
+
+// FT1.2 Framing Characters
+const (
+	startFixed    = 0x10
+	startVariable = 0x68
+	end           = 0x16
+	// SingleAck is the single character acknowledgement
+	SingleAck = 0xE5
+)
+
+// Control Field Bits
+const (
+	ctrlDIR = 0x80
+	ctrlPRM = 0x40
+	// FCB from a Primary, ACD from a Secondary
+	ctrlFCB = 0x20
+	// FCV from a Primary, DFC from a Secondary
+	ctrlFCV = 0x10
+)
+
+// Primary Function Codes
+const (
+	FuncResetLink       = 0
+	FuncResetProcess    = 1
+	FuncTestLink        = 2
+	FuncUserDataConfirm = 3
+	FuncUserDataNoReply = 4
+	FuncRequestAccess   = 8
+	FuncRequestStatus   = 9
+	FuncRequestClass1   = 10
+	FuncRequestClass2   = 11
+)
+
+// Secondary Function Codes
+const (
+	FuncAck            = 0
+	FuncNack           = 1
+	FuncUserData       = 8
+	FuncNackNoData     = 9
+	FuncStatus         = 11
+	FuncNotFunctional  = 14
+	FuncNotImplemented = 15
+)
+
+// maxLength is the most a variable length frame's L field may count
+const maxLength = 253
+
+var (
+	errBadFrame = errors.New("iec101: malformed frame")
+	// ErrTooLarge - user data beyond the 253 bytes a frame counts
+	ErrTooLarge = errors.New("iec101: user data too large")
+)
+
+// Frame is one FT1.2 frame
+type Frame struct {
+	// The single character acknowledgement, no other field is used
+	Single bool
+	// Direction - balanced transmission only
+	DIR bool
+	// Primary - set on frames from the initiating station
+	PRM bool
+	// Frame Count Bit and Frame Count Valid of a primary frame
+	FCB bool
+	FCV bool
+	// Access Demand and Data Flow Control of a secondary frame
+	ACD      bool
+	DFC      bool
+	Function byte
+	// Link Address, AddressSize bytes long
+	Address uint16
+	// User Data (ASDU); frames without are sent with fixed length
+	Data []byte
+}
+
+// control returns the Control Field of f
+func (f *Frame) control() byte {
+	c := f.Function & 0x0F
+	if f.DIR {
+		c |= ctrlDIR
+	}
+	if f.PRM {
+		c |= ctrlPRM
+		if f.FCB {
+			c |= ctrlFCB
+		}
+		if f.FCV {
+			c |= ctrlFCV
+		}
+	} else {
+		if f.ACD {
+			c |= ctrlFCB
+		}
+		if f.DFC {
+			c |= ctrlFCV
+		}
+	}
+	return c
+}
+
+// Marshal encodes f for a link with addresses of addrSize bytes, 0 to 2
+func (f *Frame) Marshal(addrSize int) ([]byte, error) {
+	if f.Single {
+		return []byte{SingleAck}, nil
+	}
+	body := []byte{f.control()}
+	for i := 0; i < addrSize; i++ {
+		body = append(body, byte(f.Address>>(8*uint(i))))
+	}
+	if len(f.Data) == 0 {
+		b := append([]byte{startFixed}, body...)
+		return append(b, checksum.Sum(body), end), nil
+	}
+	if 1+addrSize+len(f.Data) > maxLength {
+		return nil, ErrTooLarge
+	}
+	body = append(body, f.Data...)
+	b := []byte{startVariable, byte(len(body)), byte(len(body)), startVariable}
+	b = append(b, body...)
+	return append(b, checksum.Sum(body), end), nil
+}
+
+// parseBody decodes the Control Field, Address and Data
+func parseBody(body []byte, addrSize int) Frame {
+	c := body[0]
+	f := Frame{DIR: c&ctrlDIR != 0, PRM: c&ctrlPRM != 0, Function: c & 0x0F}
+	if f.PRM {
+		f.FCB = c&ctrlFCB != 0
+		f.FCV = c&ctrlFCV != 0
+	} else {
+		f.ACD = c&ctrlFCB != 0
+		f.DFC = c&ctrlFCV != 0
+	}
+	for i := 0; i < addrSize; i++ {
+		f.Address |= uint16(body[1+i]) << (8 * uint(i))
+	}
+	if data := body[1+addrSize:]; len(data) > 0 {
+		f.Data = append([]byte(nil), data...)
+	}
+	return f
+}
+
+// frameLength returns the length of the frame at the start of b, 0 if more
+// bytes are needed, or -1 if b does not start a frame
+func frameLength(b []byte, addrSize int) int {
+	switch b[0] {
+	case SingleAck:
+		return 1
+	case startFixed:
+		return 1 + 1 + addrSize + 2
+	case startVariable:
+		if len(b) < 4 {
+			return 0
+		}
+		if b[1] != b[2] || b[3] != startVariable || int(b[1]) < 1+addrSize || b[1] > maxLength {
+			return -1
+		}
+		return 4 + int(b[1]) + 2
+	}
+	return -1
+}
+
+// ParseFrame decodes a complete frame as returned by a Decoder
+func ParseFrame(b []byte, addrSize int) (Frame, error) {
+	if len(b) == 0 {
+		return Frame{}, errBadFrame
+	}
+	n := frameLength(b, addrSize)
+	if n <= 0 || len(b) != n {
+		return Frame{}, errBadFrame
+	}
+	if n == 1 {
+		return Frame{Single: true}, nil
+	}
+	body := b[1 : n-2]
+	if b[0] == startVariable {
+		body = b[4 : n-2]
+	}
+	if b[n-1] != end || checksum.Sum(body) != b[n-2] {
+		return Frame{}, errBadFrame
+	}
+	return parseBody(body, addrSize), nil
+}
+
+// Decoder is an xserial.FrameDecoder for FT1.2 frames. It returns each frame
+// whole, ready for ParseFrame; damaged frames are dropped and counted as
+// resyncs, and the search restarts after their first byte.
+type Decoder struct {
+	addrSize int
+	buf      []byte
+	resyncs  uint64
+	// Discarding Bytes outside a Frame
+	stray bool
+}
+
+// NewDecoder returns a Decoder for a link with addresses of addrSize bytes
+func NewDecoder(addrSize int) *Decoder {
+	return &Decoder{addrSize: addrSize}
+}
+
+func (d *Decoder) Feed(p []byte) [][]byte {
+	var frames [][]byte
+	d.buf = append(d.buf, p...)
+	i := 0
+	for i < len(d.buf) {
+		b := d.buf[i:]
+		n := frameLength(b, d.addrSize)
+		if n == 0 || n > len(b) {
+			break
+		}
+		if n > 0 {
+			if _, err := ParseFrame(b[:n], d.addrSize); err == nil {
+				frames = append(frames, append([]byte(nil), b[:n]...))
+				d.stray = false
+				i += n
+				continue
+			}
+		}
+		if !d.stray {
+			d.stray = true
+			d.resyncs++
+		}
+		i++
+	}
+	d.buf = append(d.buf[:0], d.buf[i:]...)
+	return frames
+}
+
+func (d *Decoder) Resyncs() uint64 {
+	return d.resyncs
+}
+
+func (d *Decoder) Reset() {
+	d.buf = d.buf[:0]
+	d.stray = false
+}
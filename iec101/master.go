@@ -0,0 +1,196 @@
+package iec101
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/packing/xserial"
+)
+
+var (
+	// ErrNack - the station refused the request
+	ErrNack = errors.New("iec101: request refused")
+	// ErrNoResponse - the station did not answer
+	ErrNoResponse = errors.New("iec101: no response")
+)
+
+// Config configures a Master
+type Config struct {
+	// Link Address Size in bytes, 1 or 2; defaults to 1, negative for point
+	// to point links without an address
+	AddressSize int
+	// How long to wait for each reply, defaults to 1 second
+	Timeout time.Duration
+	// Further attempts after a reply times out, defaults to 3; negative for
+	// none
+	Retries int
+}
+
+// Master is the primary station of an unbalanced link polling secondary
+// stations. It toggles the frame count bit of each station on every
+// successful exchange and repeats it unchanged when a reply is lost, so
+// stations can tell a repeat from a new request. Its methods must not be
+// called concurrently.
+type Master struct {
+	p   xserial.Port
+	cfg Config
+	rd  *xserial.FrameReader
+	// Next FCB per Station, present once its Link is Reset
+	fcb map[uint16]bool
+}
+
+// NewMaster returns a Master on p. cfg may be nil.
+func NewMaster(p xserial.Port, cfg *Config) *Master {
+	var c Config
+	if cfg != nil {
+		c = *cfg
+	}
+	switch {
+	case c.AddressSize < 0:
+		c.AddressSize = 0
+	case c.AddressSize == 0:
+		c.AddressSize = 1
+	case c.AddressSize > 2:
+		c.AddressSize = 2
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = time.Second
+	}
+	if c.Retries < 0 {
+		c.Retries = 0
+	} else if c.Retries == 0 {
+		c.Retries = 3
+	}
+	return &Master{
+		p:   p,
+		cfg: c,
+		rd:  xserial.NewFrameReader(p, NewDecoder(c.AddressSize)),
+		fcb: make(map[uint16]bool),
+	}
+}
+
+// Send writes f as is, without waiting for a reply
+func (m *Master) Send(f *Frame) error {
+	b, err := f.Marshal(m.cfg.AddressSize)
+	if err != nil {
+		return err
+	}
+	_, err = m.p.Write(b)
+	return err
+}
+
+// Request sends function fn with data to station addr and returns its
+// reply. Functions needing the frame count bit get it, resetting the link
+// first if it has not been. A lost reply is asked for again with the same
+// frame count bit.
+func (m *Master) Request(ctx context.Context, addr uint16, fn byte, data []byte) (Frame, error) {
+	f := &Frame{PRM: true, Function: fn, Address: addr, Data: data}
+	switch fn {
+	case FuncTestLink, FuncUserDataConfirm, FuncRequestClass1, FuncRequestClass2:
+		fcb, ok := m.fcb[addr]
+		if !ok {
+			if err := m.ResetLink(ctx, addr); err != nil {
+				return Frame{}, err
+			}
+			fcb = m.fcb[addr]
+		}
+		f.FCB, f.FCV = fcb, true
+	}
+	r, err := m.exchange(ctx, f)
+	if err != nil {
+		return r, err
+	}
+	if f.FCV {
+		m.fcb[addr] = !f.FCB
+	}
+	if !r.Single && r.Function == FuncNack {
+		return r, ErrNack
+	}
+	return r, nil
+}
+
+// ResetLink resets the link of station addr, which restarts its frame
+// count bit
+func (m *Master) ResetLink(ctx context.Context, addr uint16) error {
+	r, err := m.exchange(ctx, &Frame{PRM: true, Function: FuncResetLink, Address: addr})
+	if err != nil {
+		return err
+	}
+	if !r.Single && r.Function != FuncAck {
+		return ErrNack
+	}
+	// The First FCB after a Reset is Set
+	m.fcb[addr] = true
+	return nil
+}
+
+// RequestStatus asks station addr for its link status
+func (m *Master) RequestStatus(ctx context.Context, addr uint16) (Frame, error) {
+	return m.Request(ctx, addr, FuncRequestStatus, nil)
+}
+
+// RequestClass1 polls station addr for class 1 (priority) data
+func (m *Master) RequestClass1(ctx context.Context, addr uint16) (Frame, error) {
+	return m.Request(ctx, addr, FuncRequestClass1, nil)
+}
+
+// RequestClass2 polls station addr for class 2 (cyclic) data
+func (m *Master) RequestClass2(ctx context.Context, addr uint16) (Frame, error) {
+	return m.Request(ctx, addr, FuncRequestClass2, nil)
+}
+
+// SendConfirmed sends an ASDU to station addr and waits for its
+// acknowledgement
+func (m *Master) SendConfirmed(ctx context.Context, addr uint16, asdu []byte) error {
+	_, err := m.Request(ctx, addr, FuncUserDataConfirm, asdu)
+	return err
+}
+
+// SendNoReply sends an ASDU, typically to the broadcast address, that no
+// station answers
+func (m *Master) SendNoReply(addr uint16, asdu []byte) error {
+	return m.Send(&Frame{PRM: true, Function: FuncUserDataNoReply, Address: addr, Data: asdu})
+}
+
+// exchange sends f and returns the first reply from its station
+func (m *Master) exchange(ctx context.Context, f *Frame) (Frame, error) {
+	for attempt := 0; attempt <= m.cfg.Retries; attempt++ {
+		if err := m.p.Flush(); err != nil {
+			return Frame{}, err
+		}
+		if err := m.Send(f); err != nil {
+			return Frame{}, err
+		}
+		actx, cancel := context.WithTimeout(ctx, m.cfg.Timeout)
+		r, err := m.reply(actx, f.Address)
+		cancel()
+		if err == nil {
+			return r, nil
+		}
+		if ctx.Err() != nil {
+			return Frame{}, ctx.Err()
+		}
+		if err != context.DeadlineExceeded && err != xserial.ErrReadTimeout {
+			return Frame{}, err
+		}
+	}
+	return Frame{}, ErrNoResponse
+}
+
+// reply reads until a secondary frame from addr or a single character
+func (m *Master) reply(ctx context.Context, addr uint16) (Frame, error) {
+	for {
+		b, err := m.rd.ReadFrameContext(ctx)
+		if err != nil {
+			return Frame{}, err
+		}
+		r, err := ParseFrame(b, m.cfg.AddressSize)
+		if err != nil {
+			continue
+		}
+		if r.Single || !r.PRM && r.Address == addr {
+			return r, nil
+		}
+	}
+}
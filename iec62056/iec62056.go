@@ -0,0 +1,164 @@
+// Package iec62056 reads utility meters through their IEC 62056-21
+// optical or current loop port in protocol mode C: the request and
+// identification at 300 baud, the acknowledgement switching both ends to
+// the meter's faster rate, and the data readout streamed as data sets with
+// its block check character verified.
+//
+// Meters frame characters as 7E1. The package sends them as 8N1 frames
+// carrying the parity bit itself, which are identical on the wire, so any
+// Port will do.
+package iec62056
+
+import (
+	"errors"
+	"fmt"
+	"math/bits"
+	"strings"
+
+	"github.com/packing/xserial"
+)
+
+// InitialBaud is the rate every session starts at
+const InitialBaud = 300
+
+// Control Characters
+const (
+	SOH = 0x01
+	STX = 0x02
+	ETX = 0x03
+	EOT = 0x04
+	ACK = 0x06
+	NAK = 0x15
+)
+
+// Mode C Baud Rate Characters, from '0'
+var baudRates = []int{300, 600, 1200, 2400, 4800, 9600, 19200}
+
+var (
+	// ErrNoResponse - the meter did not answer in time
+	ErrNoResponse = errors.New("iec62056: no response")
+	// ErrFormat - a message from the meter is malformed
+	ErrFormat = errors.New("iec62056: malformed message")
+	// ErrBCC - the data readout failed its block check character
+	ErrBCC = errors.New("iec62056: block check mismatch")
+	// ErrParity - a character failed its even parity
+	ErrParity = errors.New("iec62056: parity error")
+	// ErrNoBaudSetter - the meter offers a faster rate but the Port cannot
+	// change its baud rate while open
+	ErrNoBaudSetter = errors.New("iec62056: port cannot change baud rate")
+)
+
+// PortConfig returns the Config to open name with, 300 baud 8N1 for the
+// 7E1 characters with their parity sent as the eighth bit
+func PortConfig(name string) *xserial.Config {
+	return &xserial.Config{Name: name, Baud: InitialBaud, Parity: "N", StopBits: 1}
+}
+
+// Identification is the meter's reply to the request message
+type Identification struct {
+	// Three letter manufacturer's identification
+	Manufacturer string
+	// Fastest baud rate the meter offers and its character
+	Baud     int
+	BaudChar byte
+	// The meter answers within 20ms, not 200ms, flagged by a lower case
+	// last letter of Manufacturer
+	FastReaction bool
+	// Enhanced capability character after a backslash, zero if none
+	Enhanced byte
+	// Free form identification following
+	Ident string
+}
+
+// ParseIdentification decodes an identification message, with or without
+// its CR LF
+func ParseIdentification(line string) (Identification, error) {
+	var id Identification
+	line = strings.TrimRight(line, "\r\n")
+	if len(line) < 5 || line[0] != '/' {
+		return id, ErrFormat
+	}
+	id.Manufacturer = line[1:4]
+	id.FastReaction = line[3] >= 'a' && line[3] <= 'z'
+	id.BaudChar = line[4]
+	n := int(id.BaudChar - '0')
+	if n < 0 || n >= len(baudRates) {
+		return id, fmt.Errorf("%w: baud rate character %q is not mode C", ErrFormat, id.BaudChar)
+	}
+	id.Baud = baudRates[n]
+	rest := line[5:]
+	if len(rest) >= 2 && rest[0] == '\\' {
+		id.Enhanced = rest[1]
+		rest = rest[2:]
+	}
+	id.Ident = rest
+	return id, nil
+}
+
+// DataSet is one value of the readout, as "1.8.0(001234.5*kWh)"
+type DataSet struct {
+	// OBIS or manufacturer specific address; empty continues the
+	// previous data set
+	Address string
+	Value   string
+	Unit    string
+}
+
+func (d DataSet) String() string {
+	if d.Unit == "" {
+		return d.Address + "(" + d.Value + ")"
+	}
+	return d.Address + "(" + d.Value + "*" + d.Unit + ")"
+}
+
+// ParseDataSets decodes the data sets of one readout line, with or without
+// its CR LF
+func ParseDataSets(line string) ([]DataSet, error) {
+	var sets []DataSet
+	line = strings.TrimRight(line, "\r\n")
+	for line != "" {
+		open := strings.IndexByte(line, '(')
+		if open < 0 {
+			return sets, ErrFormat
+		}
+		end := strings.IndexByte(line[open:], ')')
+		if end < 0 {
+			return sets, ErrFormat
+		}
+		end += open
+		d := DataSet{Address: line[:open], Value: line[open+1 : end]}
+		if star := strings.IndexByte(d.Value, '*'); star >= 0 {
+			d.Value, d.Unit = d.Value[:star], d.Value[star+1:]
+		}
+		sets = append(sets, d)
+		line = line[end+1:]
+	}
+	return sets, nil
+}
+
+// BCC returns the block check character of b, the XOR of its characters
+func BCC(b []byte) byte {
+	var bcc byte
+	for _, c := range b {
+		bcc ^= c & 0x7F
+	}
+	return bcc
+}
+
+// withParity returns c with even parity in its eighth bit
+func withParity(c byte) byte {
+	c &= 0x7F
+	if bits.OnesCount8(c)%2 != 0 {
+		c |= 0x80
+	}
+	return c
+}
+
+// encode returns s as 7E1 characters
+func encode(s string) []byte {
+	b := make([]byte, len(s))
+	for i := 0; i < len(s); i++ {
+		b[i] = withParity(s[i])
+	}
+	return b
+}
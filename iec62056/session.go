@@ -0,0 +1,231 @@
+package iec62056
+
+import (
+	"context"
+	"io"
+	"math/bits"
+	"strings"
+	"time"
+
+	"github.com/packing/xserial"
+)
+
+// Config configures a Session
+type Config struct {
+	// Device address sent in the request, empty for whichever meter is
+	// listening
+	Address string
+	// Fastest baud rate to switch to, zero for the one the meter offers
+	MaxBaud int
+	// How long to wait for each message and between characters of the
+	// readout, defaults to 1.5 seconds, the longest reaction time allowed
+	Timeout time.Duration
+}
+
+// Session is one data readout. Its methods must not be called
+// concurrently.
+type Session struct {
+	p   xserial.Port
+	bs  xserial.BaudSetter
+	cfg Config
+	id  Identification
+	buf []byte
+	raw []byte
+	// Readout State - inside the Block after STX, its BCC so far, and the
+	// Data Sets of the last Line not yet returned
+	block bool
+	bcc   byte
+	sets  []DataSet
+	done  bool
+}
+
+// Open sends the request message on p and the acknowledgement selecting
+// the data readout, switching p to the agreed baud rate. p must be a
+// BaudSetter, or wrap one, unless the session stays at InitialBaud. cfg
+// may be nil.
+func Open(ctx context.Context, p xserial.Port, cfg *Config) (*Session, error) {
+	var c Config
+	if cfg != nil {
+		c = *cfg
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = 1500 * time.Millisecond
+	}
+	s := &Session{p: p, cfg: c, buf: make([]byte, 256)}
+	if bs, ok := xserial.As[xserial.BaudSetter](p); ok {
+		s.bs = bs
+		// A previous Session may have left the Port fast
+		if err := bs.SetBaudRate(InitialBaud); err != nil {
+			return nil, err
+		}
+	}
+	p.Flush()
+	if _, err := p.Write(encode("/?" + c.Address + "!\r\n")); err != nil {
+		return nil, err
+	}
+	line, err := s.line(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// Echoes and Noise from the optical Head come before the '/'
+	start := strings.IndexByte(line, '/')
+	if start < 0 {
+		return nil, ErrFormat
+	}
+	if s.id, err = ParseIdentification(line[start:]); err != nil {
+		return nil, err
+	}
+	baud, char := s.id.Baud, s.id.BaudChar
+	for c.MaxBaud > 0 && baud > c.MaxBaud && char > '0' {
+		char--
+		baud = baudRates[char-'0']
+	}
+	if baud != InitialBaud && s.bs == nil {
+		return nil, ErrNoBaudSetter
+	}
+	// The Meter listens only after its Reaction Time
+	reaction := 200 * time.Millisecond
+	if s.id.FastReaction {
+		reaction = 20 * time.Millisecond
+	}
+	time.Sleep(reaction)
+	if _, err := p.Write(encode(string([]byte{ACK, '0', char, '0', '\r', '\n'}))); err != nil {
+		return nil, err
+	}
+	if baud != InitialBaud {
+		// The Acknowledgement goes out at the old Rate
+		if err := p.Drain(); err != nil {
+			return nil, err
+		}
+		if err := s.bs.SetBaudRate(baud); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Identification returns the meter's identification
+func (s *Session) Identification() Identification {
+	return s.id
+}
+
+// Next returns the next data set of the readout, and io.EOF after the last
+// once the block check character has been verified
+func (s *Session) Next(ctx context.Context) (DataSet, error) {
+	for len(s.sets) == 0 {
+		if s.done {
+			return DataSet{}, io.EOF
+		}
+		line, err := s.line(ctx)
+		if err != nil {
+			return DataSet{}, err
+		}
+		// Readout Lines end in CR LF; the "!" Line ends the Data
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" || line == "!" {
+			continue
+		}
+		if s.sets, err = ParseDataSets(line); err != nil {
+			return DataSet{}, err
+		}
+	}
+	d := s.sets[0]
+	s.sets = s.sets[1:]
+	return d, nil
+}
+
+// ReadAll returns the data sets remaining in the readout
+func (s *Session) ReadAll(ctx context.Context) ([]DataSet, error) {
+	var sets []DataSet
+	for {
+		d, err := s.Next(ctx)
+		if err == io.EOF {
+			return sets, nil
+		}
+		if err != nil {
+			return sets, err
+		}
+		sets = append(sets, d)
+	}
+}
+
+// Close returns the Port to InitialBaud, where the meter goes after the
+// readout, leaving it open
+func (s *Session) Close() error {
+	if s.bs == nil {
+		return nil
+	}
+	return s.bs.SetBaudRate(InitialBaud)
+}
+
+// line returns characters up to and including LF. Inside the readout the
+// BCC follows every character from STX on, and ETX ends the readout once
+// the BCC after it matches.
+func (s *Session) line(ctx context.Context) (string, error) {
+	var b []byte
+	for {
+		c, err := s.char(ctx)
+		if err != nil {
+			return "", err
+		}
+		if !s.block {
+			if c == STX {
+				s.block, s.bcc = true, 0
+				continue
+			}
+		} else {
+			s.bcc ^= c
+			if c == ETX {
+				bcc, err := s.char(ctx)
+				if err != nil {
+					return "", err
+				}
+				s.done, s.block = true, false
+				if bcc != s.bcc {
+					return "", ErrBCC
+				}
+				return string(b), nil
+			}
+		}
+		b = append(b, c)
+		if c == '\n' {
+			return string(b), nil
+		}
+	}
+}
+
+// char returns the next character without its parity bit, waiting up to
+// Timeout
+func (s *Session) char(ctx context.Context) (byte, error) {
+	if len(s.raw) == 0 {
+		if err := s.fill(ctx); err != nil {
+			return 0, err
+		}
+	}
+	c := s.raw[0]
+	s.raw = s.raw[1:]
+	if bits.OnesCount8(c)%2 != 0 {
+		return 0, ErrParity
+	}
+	return c & 0x7F, nil
+}
+
+// fill reads at least one byte into raw
+func (s *Session) fill(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, s.cfg.Timeout)
+	defer cancel()
+	for len(s.raw) == 0 {
+		if err := ctx.Err(); err != nil {
+			if err == context.DeadlineExceeded {
+				return ErrNoResponse
+			}
+			return err
+		}
+		n, err := xserial.ReadContext(ctx, s.p, s.buf)
+		s.raw = s.buf[:n]
+		if err != nil && err != xserial.ErrReadTimeout && ctx.Err() == nil {
+			return err
+		}
+	}
+	return nil
+}
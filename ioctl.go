@@ -0,0 +1,20 @@
+package xserial
+
+// IoctlArg is the argument of Ioctl: either a plain value or a buffer the
+// driver reads from and fills in, so callers never handle raw pointers
+type IoctlArg struct {
+	value uintptr
+	buf   []byte
+}
+
+// IoctlValue passes v itself, for requests taking an integer argument
+func IoctlValue(v uintptr) IoctlArg {
+	return IoctlArg{value: v}
+}
+
+// IoctlBuffer passes a pointer to b, for requests reading or writing a
+// structure. b must be at least as large as the structure the request
+// expects.
+func IoctlBuffer(b []byte) IoctlArg {
+	return IoctlArg{buf: b}
+}
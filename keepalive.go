@@ -0,0 +1,249 @@
+package xserial
+
+import (
+	"sync"
+	"time"
+)
+
+// KeepaliveConfig configures a Keepalive
+type KeepaliveConfig struct {
+	// Idle time before a probe is sent, defaults to 5 seconds
+	Interval time.Duration
+	// How long to wait for an answer, defaults to 1 second
+	Timeout time.Duration
+	// Unanswered probes in a row before the link is dead, defaults to 3
+	Misses int
+	// Bytes written as the probe
+	ProbeData []byte
+	// Optional - Sends the probe instead of ProbeData, for example PulseDTR
+	Probe func(p Port) error
+	// Optional - Reports an answer other than received data, such as a
+	// modem line changing
+	Answered func(p Port) bool
+	// Optional - Called when the link is found dead and when it answers again
+	OnDead  func()
+	OnAlive func()
+}
+
+// PulseDTR returns a probe that drops DTR for d and raises it again
+func PulseDTR(d time.Duration) func(p Port) error {
+	return func(p Port) error {
+		l, ok := As[LineController](p)
+		if !ok {
+			return ErrNotImplemented
+		}
+		if err := l.SetDTR(false); err != nil {
+			return err
+		}
+		time.Sleep(d)
+		return l.SetDTR(true)
+	}
+}
+
+// Keepalive is a Port wrapper that probes a quiet link and reports when the
+// peer stops answering. Any received data counts as an answer, whether the
+// application Reads it or it is still waiting in the driver, and probe
+// answers are delivered to the application like any other data.
+type Keepalive struct {
+	Port
+	cfg  KeepaliveConfig
+	stop chan struct{}
+	done chan struct{}
+
+	mx     sync.Mutex
+	lastRX time.Time
+	lastTX time.Time
+	misses int
+	dead   bool
+	closed bool
+}
+
+// NewKeepalive starts probing p. cfg may be nil.
+func NewKeepalive(p Port, cfg *KeepaliveConfig) *Keepalive {
+	var c KeepaliveConfig
+	if cfg != nil {
+		c = *cfg
+	}
+	if c.Interval <= 0 {
+		c.Interval = 5 * time.Second
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = time.Second
+	}
+	if c.Misses <= 0 {
+		c.Misses = 3
+	}
+	now := time.Now()
+	k := &Keepalive{Port: p, cfg: c, stop: make(chan struct{}), done: make(chan struct{}), lastRX: now, lastTX: now}
+	go k.run()
+	return k
+}
+
+// Unwrap returns the probed Port
+func (k *Keepalive) Unwrap() Port {
+	return k.Port
+}
+
+// Alive reports whether the peer answered recently enough
+func (k *Keepalive) Alive() bool {
+	k.mx.Lock()
+	defer k.mx.Unlock()
+	return !k.dead
+}
+
+func (k *Keepalive) Read(b []byte) (int, error) {
+	n, err := k.Port.Read(b)
+	if n > 0 {
+		k.answered(time.Now())
+	}
+	return n, err
+}
+
+func (k *Keepalive) Write(b []byte) (int, error) {
+	n, err := k.Port.Write(b)
+	if n > 0 {
+		k.mx.Lock()
+		k.lastTX = time.Now()
+		k.mx.Unlock()
+	}
+	return n, err
+}
+
+// answered records life on the link
+func (k *Keepalive) answered(at time.Time) {
+	k.mx.Lock()
+	k.lastRX = at
+	k.misses = 0
+	revived := k.dead
+	k.dead = false
+	k.mx.Unlock()
+	if revived && k.cfg.OnAlive != nil {
+		k.cfg.OnAlive()
+	}
+}
+
+// queuedIn returns the bytes waiting in the driver, or -1 if unknown
+func (k *Keepalive) queuedIn() int {
+	if q, ok := As[QueueReporter](k.Port); ok {
+		if in, _, err := q.Queued(); err == nil {
+			return in
+		}
+	}
+	return -1
+}
+
+// heard reports whether the peer has sent anything since t, when inBefore
+// bytes were waiting in the driver
+func (k *Keepalive) heard(t time.Time, inBefore int) bool {
+	k.mx.Lock()
+	rx := k.lastRX
+	k.mx.Unlock()
+	if rx.After(t) {
+		return true
+	}
+	if k.cfg.Answered != nil && k.cfg.Answered(k.Port) {
+		return true
+	}
+	return inBefore >= 0 && k.queuedIn() > inBefore
+}
+
+func (k *Keepalive) run() {
+	defer close(k.done)
+	t := time.NewTimer(k.cfg.Interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-k.stop:
+			return
+		case <-t.C:
+		}
+		// Only probe once both directions have been quiet for Interval
+		k.mx.Lock()
+		last := k.lastRX
+		if k.lastTX.After(last) {
+			last = k.lastTX
+		}
+		k.mx.Unlock()
+		if wait := time.Until(last.Add(k.cfg.Interval)); wait > 0 {
+			t.Reset(wait)
+			continue
+		}
+		if !k.probe() {
+			return
+		}
+		t.Reset(k.cfg.Interval)
+	}
+}
+
+// probe sends one probe and waits for an answer; false means stopped
+func (k *Keepalive) probe() bool {
+	sent, inBefore := time.Now(), k.queuedIn()
+	var err error
+	if k.cfg.Probe != nil {
+		err = k.cfg.Probe(k.Port)
+	} else if len(k.cfg.ProbeData) > 0 {
+		_, err = k.Port.Write(k.cfg.ProbeData)
+	}
+	k.mx.Lock()
+	k.lastTX = time.Now()
+	k.mx.Unlock()
+
+	if err == nil {
+		// Sample for an Answer until the Timeout
+		step := k.cfg.Timeout / 10
+		if step <= 0 {
+			step = k.cfg.Timeout
+		}
+		deadline := time.NewTimer(k.cfg.Timeout)
+		tick := time.NewTicker(step)
+		answered := false
+	wait:
+		for {
+			select {
+			case <-k.stop:
+				tick.Stop()
+				deadline.Stop()
+				return false
+			case <-tick.C:
+				if answered = k.heard(sent, inBefore); answered {
+					break wait
+				}
+			case <-deadline.C:
+				break wait
+			}
+		}
+		tick.Stop()
+		deadline.Stop()
+		if answered {
+			k.answered(time.Now())
+			return true
+		}
+	}
+
+	// Missed
+	k.mx.Lock()
+	k.misses++
+	died := !k.dead && k.misses >= k.cfg.Misses
+	if died {
+		k.dead = true
+	}
+	k.mx.Unlock()
+	if died && k.cfg.OnDead != nil {
+		k.cfg.OnDead()
+	}
+	return true
+}
+
+// Close stops probing and closes the underlying Port
+func (k *Keepalive) Close() error {
+	k.mx.Lock()
+	if k.closed {
+		k.mx.Unlock()
+		return ErrPortClosed
+	}
+	k.closed = true
+	k.mx.Unlock()
+	close(k.stop)
+	<-k.done
+	return k.Port.Close()
+}
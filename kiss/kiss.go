@@ -0,0 +1,194 @@
+// Package kiss implements the KISS TNC protocol for amateur radio packet
+// modems. Frames use SLIP escaping with a leading type byte carrying the
+// TNC port and the command, so an AX.25 stack can run over a Port.
+package kiss
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/packing/xserial"
+	"github.com/packing/xserial/slip"
+)
+
+// Framing Characters, shared with SLIP
+const (
+	FEND  = slip.END
+	FESC  = slip.ESC
+	TFEND = slip.EscEND
+	TFESC = slip.EscESC
+)
+
+// Commands, the low nibble of the type byte
+const (
+	CmdData        = 0x00
+	CmdTXDelay     = 0x01
+	CmdPersistence = 0x02
+	CmdSlotTime    = 0x03
+	CmdTXTail      = 0x04
+	CmdFullDuplex  = 0x05
+	CmdSetHardware = 0x06
+	// Leaves KISS mode; sent as the whole type byte
+	CmdReturn = 0xFF
+)
+
+// Timing parameters are sent in units of 10ms
+const timingUnit = 10 * time.Millisecond
+
+var (
+	// ErrEmpty - a frame without its type byte
+	ErrEmpty = errors.New("kiss: empty frame")
+	// ErrPort - TNC ports are numbered 0 to 15
+	ErrPort = errors.New("kiss: port out of range")
+)
+
+// Frame is one KISS frame
+type Frame struct {
+	// TNC Port, 0 to 15
+	Port    int
+	Command byte
+	Data    []byte
+}
+
+// Marshal returns the frame escaped and delimited by FEND
+func (f *Frame) Marshal() ([]byte, error) {
+	if f.Port < 0 || f.Port > 15 {
+		return nil, ErrPort
+	}
+	t := byte(f.Port)<<4 | f.Command&0x0F
+	if f.Command == CmdReturn {
+		t = CmdReturn
+	}
+	return slip.Encode(append([]byte{t}, f.Data...)), nil
+}
+
+// Parse splits an unescaped frame, as returned by a Decoder, into its parts
+func Parse(b []byte) (Frame, error) {
+	if len(b) == 0 {
+		return Frame{}, ErrEmpty
+	}
+	if b[0] == CmdReturn {
+		return Frame{Command: CmdReturn, Data: b[1:]}, nil
+	}
+	return Frame{Port: int(b[0] >> 4), Command: b[0] & 0x0F, Data: b[1:]}, nil
+}
+
+// NewDecoder returns an xserial.FrameDecoder for KISS frames, type byte
+// included, accepting up to max bytes or slip.DefaultMax if max is 0
+func NewDecoder(max int) xserial.FrameDecoder {
+	return slip.NewDecoder(max)
+}
+
+// TNC talks KISS to a modem on a Port
+type TNC struct {
+	p  xserial.Port
+	rd *xserial.FrameReader
+}
+
+// NewTNC returns a TNC on p, which must already be in KISS mode
+func NewTNC(p xserial.Port) *TNC {
+	return &TNC{p: p, rd: xserial.NewFrameReader(p, NewDecoder(0))}
+}
+
+// WriteFrame sends f
+func (t *TNC) WriteFrame(f *Frame) error {
+	b, err := f.Marshal()
+	if err != nil {
+		return err
+	}
+	_, err = t.p.Write(b)
+	return err
+}
+
+// ReadFrame returns the next frame from the TNC, with Read errors such as
+// ErrReadTimeout returned as they occur
+func (t *TNC) ReadFrame() (Frame, error) {
+	return t.ReadFrameContext(context.Background())
+}
+
+// ReadFrameContext is ReadFrame giving up once ctx is done
+func (t *TNC) ReadFrameContext(ctx context.Context) (Frame, error) {
+	b, err := t.rd.ReadFrameContext(ctx)
+	if err != nil {
+		return Frame{}, err
+	}
+	return Parse(b)
+}
+
+// Send transmits an AX.25 frame on a TNC port
+func (t *TNC) Send(port int, data []byte) error {
+	return t.WriteFrame(&Frame{Port: port, Command: CmdData, Data: data})
+}
+
+// Read returns the next AX.25 frame received on any port, skipping other
+// commands. It suits stacks wanting a packet io.Reader; frames longer than
+// b are truncated.
+func (t *TNC) Read(b []byte) (int, error) {
+	for {
+		f, err := t.ReadFrame()
+		if err != nil {
+			return 0, err
+		}
+		if f.Command == CmdData {
+			return copy(b, f.Data), nil
+		}
+	}
+}
+
+// Write sends b as one AX.25 frame on port 0
+func (t *TNC) Write(b []byte) (int, error) {
+	if err := t.Send(0, b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// timing converts d to 10ms units
+func timing(d time.Duration) byte {
+	n := d / timingUnit
+	if n > 255 {
+		n = 255
+	}
+	return byte(n)
+}
+
+// SetTXDelay sets the keyup delay before sending
+func (t *TNC) SetTXDelay(port int, d time.Duration) error {
+	return t.WriteFrame(&Frame{Port: port, Command: CmdTXDelay, Data: []byte{timing(d)}})
+}
+
+// SetPersistence sets the p-persistence value, transmitting with
+// probability (p+1)/256 when the channel is clear
+func (t *TNC) SetPersistence(port int, p byte) error {
+	return t.WriteFrame(&Frame{Port: port, Command: CmdPersistence, Data: []byte{p}})
+}
+
+// SetSlotTime sets the wait between channel samples
+func (t *TNC) SetSlotTime(port int, d time.Duration) error {
+	return t.WriteFrame(&Frame{Port: port, Command: CmdSlotTime, Data: []byte{timing(d)}})
+}
+
+// SetTXTail sets how long the transmitter stays keyed after a frame
+func (t *TNC) SetTXTail(port int, d time.Duration) error {
+	return t.WriteFrame(&Frame{Port: port, Command: CmdTXTail, Data: []byte{timing(d)}})
+}
+
+// SetFullDuplex switches between full and half duplex
+func (t *TNC) SetFullDuplex(port int, on bool) error {
+	var v byte
+	if on {
+		v = 1
+	}
+	return t.WriteFrame(&Frame{Port: port, Command: CmdFullDuplex, Data: []byte{v}})
+}
+
+// SetHardware sends a TNC specific configuration command
+func (t *TNC) SetHardware(port int, data []byte) error {
+	return t.WriteFrame(&Frame{Port: port, Command: CmdSetHardware, Data: data})
+}
+
+// Exit takes the TNC out of KISS mode
+func (t *TNC) Exit() error {
+	return t.WriteFrame(&Frame{Command: CmdReturn})
+}
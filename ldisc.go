@@ -0,0 +1,13 @@
+package xserial
+
+// Linux Line Disciplines for Config.LineDiscipline
+const (
+	LineDisciplineTTY     = 0  // N_TTY - the default terminal discipline
+	LineDisciplineSLIP    = 1  // N_SLIP
+	LineDisciplinePPP     = 3  // N_PPP
+	LineDisciplineHDLC    = 13 // N_HDLC
+	LineDisciplineHCI     = 15 // N_HCI - Bluetooth HCI UART
+	LineDisciplineSLCAN   = 17 // N_SLCAN - serial line CAN adapters
+	LineDisciplinePPS     = 18 // N_PPS - PPS on the DCD line
+	LineDisciplineGSM0710 = 21 // N_GSM0710 - kernel GSM 07.10 mux
+)
@@ -0,0 +1,270 @@
+// Package lin is a LIN bus master over a UART and a LIN transceiver: it
+// sends the break, sync and protected identifier of each frame header,
+// publishes or collects the response with its classic or enhanced
+// checksum and runs a schedule of frame slots.
+package lin
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/packing/xserial"
+)
+
+const (
+	// Sync is the byte after the break every slave measures its bit time on
+	Sync = 0x55
+	// MaxData is the longest frame response, checksum excluded
+	MaxData = 8
+	// Diagnostic Frames, which always carry the classic checksum
+	MasterRequest = 0x3C
+	SlaveResponse = 0x3D
+)
+
+var (
+	// ErrNoResponse - no slave answered the header in time
+	ErrNoResponse = errors.New("lin: no response")
+	// ErrChecksum - the response failed its checksum
+	ErrChecksum = errors.New("lin: checksum mismatch")
+	// ErrEcho - what the transceiver read back differs from what was sent,
+	// a bit error or a collision; set NoEcho for interfaces without echo
+	ErrEcho = errors.New("lin: echo mismatch")
+	// ErrNoBreak - the Port cannot send a break
+	ErrNoBreak = errors.New("lin: port cannot send a break")
+	// ErrLength - the frame is longer than MaxData or empty
+	ErrLength = errors.New("lin: bad frame length")
+)
+
+// PID returns the protected identifier of a 6-bit frame id: the id with
+// its two parity bits
+func PID(id byte) byte {
+	id &= 0x3F
+	bit := func(n uint) byte { return id >> n & 1 }
+	p0 := bit(0) ^ bit(1) ^ bit(2) ^ bit(4)
+	p1 := ^(bit(1) ^ bit(3) ^ bit(4) ^ bit(5)) & 1
+	return id | p0<<6 | p1<<7
+}
+
+// ClassicChecksum returns the LIN 1.x checksum over data
+func ClassicChecksum(data []byte) byte {
+	return checksum(0, data)
+}
+
+// EnhancedChecksum returns the LIN 2.x checksum over the protected
+// identifier and data
+func EnhancedChecksum(pid byte, data []byte) byte {
+	return checksum(uint(pid), data)
+}
+
+// checksum is the inverted sum with carry wrapped around
+func checksum(sum uint, data []byte) byte {
+	for _, c := range data {
+		sum += uint(c)
+		if sum > 0xFF {
+			sum -= 0xFF
+		}
+	}
+	return ^byte(sum)
+}
+
+// Config configures a Master
+type Config struct {
+	// Bus speed the Port runs at, defaults to 19200; used for timing
+	Baud int
+	// Length of the break, defaults to 13 bit times. Timer granularity
+	// lengthens it, which slaves accept.
+	Break time.Duration
+	// Carry the LIN 1.x classic checksum on every frame; otherwise the
+	// enhanced checksum is used except for the diagnostic frames
+	Classic bool
+	// The interface does not read back what it transmits. Most single
+	// wire transceivers do, and the echo is checked for bit errors.
+	NoEcho bool
+	// How long to wait for a response beyond the nominal frame time,
+	// defaults to 20ms to allow for USB serial latency
+	Timeout time.Duration
+}
+
+// Master drives the bus as its master node. Its methods must not be called
+// concurrently.
+type Master struct {
+	p     xserial.Port
+	lines xserial.LineController
+	cfg   Config
+}
+
+// NewMaster returns a Master on p, which must be open at the bus speed.
+// cfg may be nil.
+func NewMaster(p xserial.Port, cfg *Config) (*Master, error) {
+	var c Config
+	if cfg != nil {
+		c = *cfg
+	}
+	if c.Baud <= 0 {
+		c.Baud = 19200
+	}
+	if c.Break <= 0 {
+		c.Break = bits(c.Baud, 13)
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = 20 * time.Millisecond
+	}
+	lines, ok := xserial.As[xserial.LineController](p)
+	if !ok {
+		return nil, ErrNoBreak
+	}
+	return &Master{p: p, lines: lines, cfg: c}, nil
+}
+
+// bits returns how long n bit times take at baud
+func bits(baud, n int) time.Duration {
+	return time.Duration(n) * time.Second / time.Duration(baud)
+}
+
+// checksumFor returns the checksum the frame with pid carries
+func (m *Master) checksumFor(pid byte, data []byte) byte {
+	if m.cfg.Classic || pid&0x3F == MasterRequest || pid&0x3F == SlaveResponse {
+		return ClassicChecksum(data)
+	}
+	return EnhancedChecksum(pid, data)
+}
+
+// frameTimeout returns the longest a frame with n data bytes may take,
+// 1.4 times nominal as LIN allows, plus the configured Timeout
+func (m *Master) frameTimeout(n int) time.Duration {
+	nominal := bits(m.cfg.Baud, 34+10*(n+1))
+	return nominal*14/10 + m.cfg.Timeout
+}
+
+// header sends break, sync and the protected identifier of id
+func (m *Master) header(ctx context.Context, id byte) (byte, error) {
+	pid := PID(id)
+	// The Break must not cut the previous Frame short
+	if err := m.p.Drain(); err != nil {
+		return pid, err
+	}
+	m.p.Flush()
+	if err := m.lines.SendBreak(m.cfg.Break); err != nil {
+		return pid, err
+	}
+	if _, err := m.p.Write([]byte{Sync, pid}); err != nil {
+		return pid, err
+	}
+	if m.cfg.NoEcho {
+		return pid, nil
+	}
+	timeout := m.frameTimeout(0)
+	for {
+		b, err := m.read(ctx, 1, timeout)
+		if err == ErrNoResponse {
+			return pid, ErrEcho
+		}
+		if err != nil {
+			return pid, err
+		}
+		// Most UARTs read the Break back as a zero Byte
+		if b[0] == 0 {
+			continue
+		}
+		if b[0] != Sync {
+			return pid, ErrEcho
+		}
+		break
+	}
+	b, err := m.read(ctx, 1, timeout)
+	if err == ErrNoResponse || err == nil && b[0] != pid {
+		return pid, ErrEcho
+	}
+	return pid, err
+}
+
+// Publish sends a frame with id and data, the master acting as the
+// publishing node
+func (m *Master) Publish(ctx context.Context, id byte, data []byte) error {
+	if len(data) == 0 || len(data) > MaxData {
+		return ErrLength
+	}
+	pid, err := m.header(ctx, id)
+	if err != nil {
+		return err
+	}
+	out := append(append([]byte(nil), data...), m.checksumFor(pid, data))
+	if _, err := m.p.Write(out); err != nil {
+		return err
+	}
+	if m.cfg.NoEcho {
+		return nil
+	}
+	echo, err := m.read(ctx, len(out), m.frameTimeout(len(data)))
+	if err == ErrNoResponse {
+		return ErrEcho
+	}
+	if err != nil {
+		return err
+	}
+	for i := range out {
+		if echo[i] != out[i] {
+			return ErrEcho
+		}
+	}
+	return nil
+}
+
+// Request sends the header of id and returns the n data bytes a slave
+// responds with
+func (m *Master) Request(ctx context.Context, id byte, n int) ([]byte, error) {
+	if n <= 0 || n > MaxData {
+		return nil, ErrLength
+	}
+	pid, err := m.header(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	r, err := m.read(ctx, n+1, m.frameTimeout(n))
+	if err != nil {
+		return nil, err
+	}
+	if r[n] != m.checksumFor(pid, r[:n]) {
+		return nil, ErrChecksum
+	}
+	return r[:n], nil
+}
+
+// WakeUp sends the wake up signal, a dominant pulse of about 250µs to 5ms,
+// then waits the 100ms slaves have to start
+func (m *Master) WakeUp() error {
+	if _, err := m.p.Write([]byte{0x80}); err != nil {
+		return err
+	}
+	time.Sleep(100 * time.Millisecond)
+	m.p.Flush()
+	return nil
+}
+
+// GoToSleep sends the go to sleep command in a master request frame
+func (m *Master) GoToSleep(ctx context.Context) error {
+	return m.Publish(ctx, MasterRequest, []byte{0x00, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF})
+}
+
+// read returns exactly n bytes, or ErrNoResponse after timeout
+func (m *Master) read(ctx context.Context, n int, timeout time.Duration) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	buf := make([]byte, n)
+	got := 0
+	for got < n {
+		if err := ctx.Err(); err != nil {
+			if err == context.DeadlineExceeded {
+				return nil, ErrNoResponse
+			}
+			return nil, err
+		}
+		k, err := xserial.ReadContext(ctx, m.p, buf[got:])
+		got += k
+		if err != nil && err != xserial.ErrReadTimeout && ctx.Err() == nil {
+			return nil, err
+		}
+	}
+	return buf, nil
+}
@@ -0,0 +1,68 @@
+package lin
+
+import (
+	"context"
+	"time"
+)
+
+// Slot is one entry of a schedule table
+type Slot struct {
+	// Frame identifier, 0 to 63
+	ID byte
+	// Data the master publishes in the slot; nil makes the slot a request
+	// for Length bytes from a slave. It is read when the slot comes up, so
+	// onFrame may change it for the next round.
+	Data   []byte
+	Length int
+	// Time from the start of the slot to the start of the next
+	Delay time.Duration
+}
+
+// Run works through schedule over and over until ctx is done, returning
+// ctx.Err(). onFrame, which may be nil, is called after each slot with
+// the data published or received and the frame error. Frame errors such as
+// ErrNoResponse do not stop the schedule; Port errors do.
+func (m *Master) Run(ctx context.Context, schedule []Slot, onFrame func(s *Slot, data []byte, err error)) error {
+	if len(schedule) == 0 {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	<-timer.C
+	next := time.Now()
+	for i := 0; ; i = (i + 1) % len(schedule) {
+		s := &schedule[i]
+		var data []byte
+		var err error
+		if s.Data != nil {
+			data = s.Data
+			err = m.Publish(ctx, s.ID, s.Data)
+		} else {
+			data, err = m.Request(ctx, s.ID, s.Length)
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		switch err {
+		case nil, ErrNoResponse, ErrChecksum, ErrEcho, ErrLength:
+		default:
+			return err
+		}
+		if onFrame != nil {
+			onFrame(s, data, err)
+		}
+		// Slots start on a fixed Grid, not after the previous one ends
+		next = next.Add(s.Delay)
+		if d := time.Until(next); d > 0 {
+			timer.Reset(d)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-timer.C:
+			}
+		} else {
+			next = time.Now()
+		}
+	}
+}
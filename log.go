@@ -0,0 +1,52 @@
+package xserial
+
+import (
+	"sync/atomic"
+)
+
+// LogLevel is the severity of a log record, matching the log/slog levels
+type LogLevel int
+
+const (
+	LevelDebug LogLevel = -4
+	LevelInfo  LogLevel = 0
+	LevelWarn  LogLevel = 4
+	LevelError LogLevel = 8
+)
+
+// Logger receives diagnostic records for opens, configuration changes,
+// errors and, with Config.LogData, the data itself. keyvals alternate keys
+// and values as in log/slog.
+type Logger interface {
+	Log(level LogLevel, msg string, keyvals ...interface{})
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Log(LogLevel, string, ...interface{}) {}
+
+type loggerHolder struct{ Logger }
+
+var defaultLogger atomic.Value
+
+func init() {
+	defaultLogger.Store(loggerHolder{nopLogger{}})
+}
+
+// SetLogger replaces the Logger used by Ports whose Config has none; nil
+// silences logging. With Go 1.21 and later the default sends warnings to
+// log/slog; NewSlogLogger(nil) sends every level. Failures that are returned
+// to the caller, such as a failed open, read or write, are logged at Debug.
+func SetLogger(l Logger) {
+	if l == nil {
+		l = nopLogger{}
+	}
+	defaultLogger.Store(loggerHolder{l})
+}
+
+func loggerFor(cfg *Config) Logger {
+	if cfg.Logger != nil {
+		return cfg.Logger
+	}
+	return defaultLogger.Load().(loggerHolder).Logger
+}
@@ -0,0 +1,37 @@
+//go:build go1.21
+// +build go1.21
+
+package xserial
+
+import (
+	"context"
+	"log/slog"
+)
+
+// By default only warnings and errors go to slog, so programs that never
+// asked for logging do not get a record for every open, close or failed
+// attempt they already see as an error
+func init() {
+	SetLogger(slogLogger{min: LevelWarn})
+}
+
+type slogLogger struct {
+	l   *slog.Logger
+	min LogLevel
+}
+
+// NewSlogLogger adapts l to Logger; nil follows slog.Default at each record
+func NewSlogLogger(l *slog.Logger) Logger {
+	return slogLogger{l: l, min: LevelDebug}
+}
+
+func (s slogLogger) Log(level LogLevel, msg string, keyvals ...interface{}) {
+	if level < s.min {
+		return
+	}
+	l := s.l
+	if l == nil {
+		l = slog.Default()
+	}
+	l.Log(context.Background(), slog.Level(level), msg, keyvals...)
+}
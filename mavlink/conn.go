@@ -0,0 +1,97 @@
+package mavlink
+
+import (
+	"context"
+	"sync"
+
+	"github.com/packing/xserial"
+)
+
+// Config configures a Conn
+type Config struct {
+	// Messages known to the Conn, defaults to Common
+	Dialect Dialect
+	// Identity used by Send, defaults to 255 and 190, the ground station
+	// convention
+	SysID  byte
+	CompID byte
+	// Send v1 frames, for old radios and autopilots
+	V1 bool
+	// Also return messages missing from the Dialect, unchecked
+	AcceptUnknown bool
+}
+
+// Conn sends and receives MAVLink frames on a Port
+type Conn struct {
+	p   xserial.Port
+	cfg Config
+	dec *Decoder
+	rd  *xserial.FrameReader
+	mx  sync.Mutex
+	seq byte
+}
+
+// NewConn returns a Conn on p. cfg may be nil.
+func NewConn(p xserial.Port, cfg *Config) *Conn {
+	var c Config
+	if cfg != nil {
+		c = *cfg
+	}
+	if c.Dialect == nil {
+		c.Dialect = Common
+	}
+	if c.SysID == 0 {
+		c.SysID = 255
+	}
+	if c.CompID == 0 {
+		c.CompID = 190
+	}
+	dec := NewDecoder(c.Dialect)
+	dec.AcceptUnknown = c.AcceptUnknown
+	return &Conn{p: p, cfg: c, dec: dec, rd: xserial.NewFrameReader(p, dec)}
+}
+
+// ReadFrame returns the next valid frame, with Read errors such as
+// ErrReadTimeout returned as they occur
+func (c *Conn) ReadFrame(ctx context.Context) (*Frame, error) {
+	b, err := c.rd.ReadFrameContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// The Decoder has checked the CRC
+	f, _, err := parse(b)
+	return f, err
+}
+
+// Send writes message msgID from the Conn's identity with the next
+// sequence number
+func (c *Conn) Send(msgID uint32, payload []byte) error {
+	version := 2
+	if c.cfg.V1 {
+		version = 1
+	}
+	c.mx.Lock()
+	f := &Frame{Version: version, Seq: c.seq, SysID: c.cfg.SysID, CompID: c.cfg.CompID, MsgID: msgID, Payload: payload}
+	c.seq++
+	c.mx.Unlock()
+	return c.WriteFrame(f)
+}
+
+// WriteFrame writes f as it is, for forwarding; the message must be in the
+// Dialect
+func (c *Conn) WriteFrame(f *Frame) error {
+	info, ok := c.cfg.Dialect[f.MsgID]
+	if !ok {
+		return ErrUnknownMessage
+	}
+	if len(f.Payload) > 255 || f.Version == 1 && f.MsgID > 255 {
+		return ErrFormat
+	}
+	_, err := c.p.Write(f.Marshal(info.CRCExtra))
+	return err
+}
+
+// Decoder returns the Conn's Decoder, to read Resyncs
+func (c *Conn) Decoder() *Decoder {
+	return c.dec
+}
@@ -0,0 +1,290 @@
+// Package mavlink frames MAVLink v1 and v2 packets over serial links such
+// as telemetry radios: frame detection with CRC extra checking against a
+// dialect table, a Conn for sending and receiving on a Port and a Router
+// forwarding between several links. Payloads are left to the caller or a
+// generated dialect package; signatures are carried but not verified.
+package mavlink
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/packing/xserial/checksum"
+)
+
+// Start Markers
+const (
+	MagicV1 = 0xFE
+	MagicV2 = 0xFD
+)
+
+// IncompatSigned marks a v2 frame carrying a signature
+const IncompatSigned = 0x01
+
+// SignatureLength is the size of a v2 signature block
+const SignatureLength = 13
+
+const (
+	headerV1 = 6
+	headerV2 = 10
+)
+
+var (
+	// ErrChecksum - the frame was damaged, or sent with another CRC extra
+	ErrChecksum = errors.New("mavlink: checksum mismatch")
+	// ErrUnknownMessage - the message ID is not in the Dialect
+	ErrUnknownMessage = errors.New("mavlink: unknown message")
+	// ErrFormat - the bytes are not a MAVLink frame
+	ErrFormat = errors.New("mavlink: malformed frame")
+)
+
+// MessageInfo describes one message of a dialect, as listed in the tables
+// generated from the dialect XML
+type MessageInfo struct {
+	// Seed folded into the CRC, from the message definition
+	CRCExtra byte
+	// Payload offsets of the target_system and target_component fields, -1
+	// for messages without them
+	TargetSystem    int
+	TargetComponent int
+}
+
+// Dialect maps message IDs to their description
+type Dialect map[uint32]MessageInfo
+
+// Common lists the most used messages of common.xml. Merge the table of
+// the dialect in use for anything else.
+var Common = Dialect{
+	0:   {50, -1, -1},  // HEARTBEAT
+	1:   {124, -1, -1}, // SYS_STATUS
+	2:   {137, -1, -1}, // SYSTEM_TIME
+	4:   {237, 12, 13}, // PING
+	20:  {214, 2, 3},   // PARAM_REQUEST_READ
+	21:  {159, 0, 1},   // PARAM_REQUEST_LIST
+	22:  {220, -1, -1}, // PARAM_VALUE
+	23:  {168, 4, 5},   // PARAM_SET
+	24:  {24, -1, -1},  // GPS_RAW_INT
+	30:  {39, -1, -1},  // ATTITUDE
+	33:  {104, -1, -1}, // GLOBAL_POSITION_INT
+	43:  {132, 0, 1},   // MISSION_REQUEST_LIST
+	44:  {221, 2, 3},   // MISSION_COUNT
+	47:  {153, 0, 1},   // MISSION_ACK
+	65:  {118, -1, -1}, // RC_CHANNELS
+	74:  {20, -1, -1},  // VFR_HUD
+	75:  {158, 30, 31}, // COMMAND_INT
+	76:  {152, 30, 31}, // COMMAND_LONG
+	77:  {143, -1, -1}, // COMMAND_ACK
+	109: {185, -1, -1}, // RADIO_STATUS
+	111: {34, -1, -1},  // TIMESYNC
+	147: {154, -1, -1}, // BATTERY_STATUS
+	148: {178, -1, -1}, // AUTOPILOT_VERSION
+	242: {104, -1, -1}, // HOME_POSITION
+	253: {83, -1, -1},  // STATUSTEXT
+}
+
+// Frame is one MAVLink packet
+type Frame struct {
+	// 1 or 2
+	Version int
+	// Flags of v2 frames
+	Incompat byte
+	Compat   byte
+	Seq      byte
+	SysID    byte
+	CompID   byte
+	// Below 256 for v1
+	MsgID   uint32
+	Payload []byte
+	// SignatureLength bytes when Incompat has IncompatSigned
+	Signature []byte
+}
+
+// Marshal encodes f with the CRC extra of its message. v2 payloads are
+// sent with trailing zeros truncated as the protocol requires.
+func (f *Frame) Marshal(crcExtra byte) []byte {
+	var b []byte
+	if f.Version == 1 {
+		b = make([]byte, headerV1, headerV1+len(f.Payload)+2)
+		b[0], b[1], b[2], b[3], b[4], b[5] = MagicV1, byte(len(f.Payload)), f.Seq, f.SysID, f.CompID, byte(f.MsgID)
+		b = append(b, f.Payload...)
+	} else {
+		payload := f.Payload
+		for len(payload) > 1 && payload[len(payload)-1] == 0 {
+			payload = payload[:len(payload)-1]
+		}
+		b = make([]byte, headerV2, headerV2+len(payload)+2+SignatureLength)
+		b[0], b[1], b[2], b[3], b[4], b[5], b[6] = MagicV2, byte(len(payload)), f.Incompat, f.Compat, f.Seq, f.SysID, f.CompID
+		b[7], b[8], b[9] = byte(f.MsgID), byte(f.MsgID>>8), byte(f.MsgID>>16)
+		b = append(b, payload...)
+	}
+	sum := crc(b, crcExtra)
+	b = append(b, byte(sum), byte(sum>>8))
+	if f.Version != 1 && f.Incompat&IncompatSigned != 0 {
+		b = append(b, f.Signature...)
+	}
+	return b
+}
+
+// crc returns the checksum of a frame without its magic and CRC
+func crc(b []byte, crcExtra byte) uint16 {
+	c := checksum.MCRF4XX.Checksum(b[1:])
+	return checksum.MCRF4XX.Update(c, []byte{crcExtra})
+}
+
+// frameLength returns the full length of the frame starting b, from its
+// header, or 0 if more bytes are needed
+func frameLength(b []byte) int {
+	switch {
+	case len(b) < 3:
+		return 0
+	case b[0] == MagicV1:
+		return headerV1 + int(b[1]) + 2
+	case b[2]&IncompatSigned != 0:
+		return headerV2 + int(b[1]) + 2 + SignatureLength
+	}
+	return headerV2 + int(b[1]) + 2
+}
+
+// parse splits a complete frame, leaving the checksum to the caller
+func parse(b []byte) (*Frame, uint16, error) {
+	if len(b) < 1 || b[0] != MagicV1 && b[0] != MagicV2 || frameLength(b) != len(b) {
+		return nil, 0, ErrFormat
+	}
+	f := &Frame{}
+	var n int
+	if b[0] == MagicV1 {
+		f.Version, f.Seq, f.SysID, f.CompID, f.MsgID = 1, b[2], b[3], b[4], uint32(b[5])
+		n = headerV1 + int(b[1])
+		f.Payload = append([]byte(nil), b[headerV1:n]...)
+	} else {
+		f.Version, f.Incompat, f.Compat, f.Seq, f.SysID, f.CompID = 2, b[2], b[3], b[4], b[5], b[6]
+		f.MsgID = uint32(b[7]) | uint32(b[8])<<8 | uint32(b[9])<<16
+		n = headerV2 + int(b[1])
+		f.Payload = append([]byte(nil), b[headerV2:n]...)
+		if f.Incompat&IncompatSigned != 0 {
+			f.Signature = append([]byte(nil), b[n+2:]...)
+		}
+	}
+	return f, binary.LittleEndian.Uint16(b[n:]), nil
+}
+
+// valid checks the CRC of frame b against crcExtra
+func valid(b []byte, sum uint16, crcExtra byte) bool {
+	n := headerV1
+	if b[0] == MagicV2 {
+		n = headerV2
+	}
+	return crc(b[:n+int(b[1])], crcExtra) == sum
+}
+
+// ParseFrame decodes a complete frame, checking its CRC with the extra
+// from d
+func ParseFrame(b []byte, d Dialect) (*Frame, error) {
+	f, sum, err := parse(b)
+	if err != nil {
+		return nil, err
+	}
+	info, ok := d[f.MsgID]
+	if !ok {
+		return nil, ErrUnknownMessage
+	}
+	if !valid(b, sum, info.CRCExtra) {
+		return nil, ErrChecksum
+	}
+	return f, nil
+}
+
+// Padded returns the payload extended with zeros to n bytes, undoing the
+// truncation of v2 frames before decoding fields
+func (f *Frame) Padded(n int) []byte {
+	if len(f.Payload) >= n {
+		return f.Payload
+	}
+	p := make([]byte, n)
+	copy(p, f.Payload)
+	return p
+}
+
+// target returns the system and component a frame is addressed to, zero
+// for broadcast
+func (f *Frame) target(d Dialect) (sys, comp byte) {
+	info, ok := d[f.MsgID]
+	if !ok {
+		return 0, 0
+	}
+	if info.TargetSystem >= 0 && info.TargetSystem < len(f.Payload) {
+		sys = f.Payload[info.TargetSystem]
+	}
+	if info.TargetComponent >= 0 && info.TargetComponent < len(f.Payload) {
+		comp = f.Payload[info.TargetComponent]
+	}
+	return sys, comp
+}
+
+// Decoder is an xserial.FrameDecoder for v1 and v2 frames, returned whole
+// with any signature. Frames failing their CRC, and bytes between frames,
+// count as resyncs; scanning restarts after the start marker of a bad frame.
+type Decoder struct {
+	// Pass on messages missing from the Dialect without checking their
+	// CRC, as a router forwarding other dialects needs
+	AcceptUnknown bool
+	dialect       Dialect
+	buf           []byte
+	stray         bool
+	resyncs       uint64
+}
+
+// NewDecoder returns an empty Decoder checking frames against d
+func NewDecoder(d Dialect) *Decoder {
+	return &Decoder{dialect: d}
+}
+
+// check reports whether b is a frame this Decoder passes on
+func (d *Decoder) check(b []byte) bool {
+	f, sum, err := parse(b)
+	if err != nil {
+		return false
+	}
+	info, ok := d.dialect[f.MsgID]
+	if !ok {
+		return d.AcceptUnknown
+	}
+	return valid(b, sum, info.CRCExtra)
+}
+
+func (d *Decoder) Feed(p []byte) [][]byte {
+	var frames [][]byte
+	d.buf = append(d.buf, p...)
+	i := 0
+	for i < len(d.buf) {
+		b := d.buf[i:]
+		if b[0] == MagicV1 || b[0] == MagicV2 {
+			n := frameLength(b)
+			if n == 0 || n > len(b) {
+				break
+			}
+			if d.check(b[:n]) {
+				frames = append(frames, append([]byte(nil), b[:n]...))
+				d.stray = false
+				i += n
+				continue
+			}
+		}
+		if !d.stray {
+			d.stray = true
+			d.resyncs++
+		}
+		i++
+	}
+	d.buf = append(d.buf[:0], d.buf[i:]...)
+	return frames
+}
+
+func (d *Decoder) Resyncs() uint64 {
+	return d.resyncs
+}
+
+func (d *Decoder) Reset() {
+	d.buf = d.buf[:0]
+	d.stray = false
+}
@@ -0,0 +1,191 @@
+package mavlink
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/packing/xserial"
+)
+
+// ErrRouterClosed - the Router has been closed
+var ErrRouterClosed = errors.New("mavlink: router closed")
+
+// RouterConfig configures a Router
+type RouterConfig struct {
+	// Messages whose targets the Router knows, defaults to Common. Other
+	// messages are forwarded unchecked as broadcasts.
+	Dialect Dialect
+	// Optional - Called with every frame received, before it is forwarded;
+	// from is nil for frames given to Send
+	OnFrame func(from *Link, f *Frame)
+	// Optional - Called once for a link whose Port failed; the link is
+	// removed
+	OnError func(l *Link, err error)
+}
+
+// Router forwards frames between links following the MAVLink routing
+// rules: broadcasts go to every other link, addressed messages only to the
+// links their target system and component have been heard on. Frames are
+// forwarded as received, signatures included.
+type Router struct {
+	cfg RouterConfig
+
+	mx     sync.Mutex
+	links  []*Link
+	closed bool
+}
+
+// Link is one Port attached to a Router
+type Link struct {
+	Name   string
+	r      *Router
+	p      xserial.Port
+	dec    *Decoder
+	reader *xserial.AsyncReader
+
+	// Systems and Components heard on this Link, guarded by the Router
+	seen map[uint16]bool
+}
+
+// NewRouter returns a Router without links. cfg may be nil.
+func NewRouter(cfg *RouterConfig) *Router {
+	var c RouterConfig
+	if cfg != nil {
+		c = *cfg
+	}
+	if c.Dialect == nil {
+		c.Dialect = Common
+	}
+	return &Router{cfg: c}
+}
+
+// Add starts reading p as a link of the Router. p stays owned by the
+// caller; Remove and Close stop reading but leave it open.
+func (r *Router) Add(name string, p xserial.Port) (*Link, error) {
+	l := &Link{Name: name, r: r, p: p, seen: make(map[uint16]bool)}
+	l.dec = NewDecoder(r.cfg.Dialect)
+	l.dec.AcceptUnknown = true
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	if r.closed {
+		return nil, ErrRouterClosed
+	}
+	r.links = append(r.links, l)
+	l.reader = xserial.OnData(p, l.receive, &xserial.AsyncConfig{OnError: l.fail})
+	return l, nil
+}
+
+// Remove detaches l from the Router
+func (r *Router) Remove(l *Link) {
+	if r.forget(l) {
+		l.reader.Stop()
+	}
+}
+
+// forget takes l off the link table, reporting whether it was there
+func (r *Router) forget(l *Link) bool {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	for i, o := range r.links {
+		if o == l {
+			r.links = append(r.links[:i], r.links[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Send routes a frame originating locally; the message must be in the
+// Dialect
+func (r *Router) Send(f *Frame) error {
+	info, ok := r.cfg.Dialect[f.MsgID]
+	if !ok {
+		return ErrUnknownMessage
+	}
+	r.mx.Lock()
+	closed := r.closed
+	r.mx.Unlock()
+	if closed {
+		return ErrRouterClosed
+	}
+	if r.cfg.OnFrame != nil {
+		r.cfg.OnFrame(nil, f)
+	}
+	r.route(nil, f, f.Marshal(info.CRCExtra))
+	return nil
+}
+
+// Close detaches every link
+func (r *Router) Close() error {
+	r.mx.Lock()
+	if r.closed {
+		r.mx.Unlock()
+		return ErrRouterClosed
+	}
+	r.closed = true
+	links := r.links
+	r.links = nil
+	r.mx.Unlock()
+	for _, l := range links {
+		l.reader.Stop()
+	}
+	return nil
+}
+
+// route writes raw frame f to every link other than from that should see it
+func (r *Router) route(from *Link, f *Frame, raw []byte) {
+	sys, comp := f.target(r.cfg.Dialect)
+	r.mx.Lock()
+	if from != nil {
+		from.seen[uint16(f.SysID)<<8|uint16(f.CompID)] = true
+	}
+	var out []*Link
+	for _, l := range r.links {
+		if l != from && l.reaches(sys, comp) {
+			out = append(out, l)
+		}
+	}
+	r.mx.Unlock()
+	// Port Writes are atomic, so Frames from several Links never interleave
+	for _, l := range out {
+		l.p.Write(raw)
+	}
+}
+
+// reaches reports whether target sys and comp, zero for any, have been
+// heard on l
+func (l *Link) reaches(sys, comp byte) bool {
+	if sys == 0 {
+		return true
+	}
+	if comp != 0 {
+		return l.seen[uint16(sys)<<8|uint16(comp)]
+	}
+	for k := range l.seen {
+		if byte(k>>8) == sys {
+			return true
+		}
+	}
+	return false
+}
+
+// receive decodes and forwards data read from the Link
+func (l *Link) receive(data []byte) {
+	for _, raw := range l.dec.Feed(data) {
+		f, _, err := parse(raw)
+		if err != nil {
+			continue
+		}
+		if l.r.cfg.OnFrame != nil {
+			l.r.cfg.OnFrame(l, f)
+		}
+		l.r.route(l, f, raw)
+	}
+}
+
+// fail removes a Link whose Port stopped
+func (l *Link) fail(err error) {
+	if l.r.forget(l) && l.r.cfg.OnError != nil {
+		l.r.cfg.OnError(l, err)
+	}
+}
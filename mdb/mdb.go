@@ -0,0 +1,252 @@
+// Package mdb is a vending machine controller for the Multi-Drop Bus of
+// coin changers, bill validators and cashless readers: 9600 baud with a
+// ninth mode bit marking the address byte of each command and the last
+// byte of each response, carried by switching between mark and space
+// parity.
+package mdb
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/packing/xserial"
+)
+
+// Peripheral Addresses, the top five bits of the address byte
+const (
+	AddrChanger       = 0x08
+	AddrCashless1     = 0x10
+	AddrGateway       = 0x18
+	AddrDisplay       = 0x20
+	AddrEnergy        = 0x28
+	AddrBillValidator = 0x30
+	AddrUSD1          = 0x40
+	AddrUSD2          = 0x48
+	AddrUSD3          = 0x50
+	AddrCoinHopper1   = 0x58
+	AddrCashless2     = 0x60
+	AddrAgeVerify     = 0x68
+	AddrCoinHopper2   = 0x70
+)
+
+// Common Commands, added to the Address
+const (
+	CmdReset = 0x00
+	CmdSetup = 0x01
+	CmdPoll  = 0x03
+)
+
+// Response Codes
+const (
+	ACK = 0x00
+	RET = 0xAA
+	NAK = 0xFF
+)
+
+// MaxBlock is the longest block, checksum included
+const MaxBlock = 36
+
+var (
+	// ErrNoResponse - the peripheral did not answer in time
+	ErrNoResponse = errors.New("mdb: no response")
+	// ErrNAK - the peripheral refused the command
+	ErrNAK = errors.New("mdb: command refused (NAK)")
+	// ErrChecksum - the response failed its checksum after all retries
+	ErrChecksum = errors.New("mdb: checksum mismatch")
+	// ErrTooLong - the command or response exceeds MaxBlock
+	ErrTooLong = errors.New("mdb: block too long")
+	// ErrNoBreak - the Port cannot send a break
+	ErrNoBreak = errors.New("mdb: port cannot send a break")
+
+	errTimeout = errors.New("mdb: timeout")
+)
+
+// PortConfig returns the Config to open name with for MDB, 9600 baud with
+// space parity and parity errors marked, so bytes with the mode bit can be
+// told apart
+func PortConfig(name string) *xserial.Config {
+	return &xserial.Config{Name: name, Baud: 9600, Parity: "G", StopBits: 1}
+}
+
+// Config configures a Master
+type Config struct {
+	// How long to wait for a response, defaults to 20ms. MDB allows 5ms
+	// but USB adapters add their latency.
+	Timeout time.Duration
+	// Further attempts after no response or a bad checksum, defaults to 2;
+	// negative for none
+	Retries int
+}
+
+// Master is the vending machine controller on the bus. Its methods must
+// not be called concurrently.
+type Master struct {
+	p       *xserial.NineBitPort
+	cfg     Config
+	buf     []uint16
+	pending []uint16
+}
+
+// NewMaster returns a Master on p, opened with PortConfig. Ports that
+// cannot report the mode bit received, such as on Windows, end responses
+// after Timeout without a byte instead. cfg may be nil.
+func NewMaster(p xserial.Port, cfg *Config) (*Master, error) {
+	var c Config
+	if cfg != nil {
+		c = *cfg
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = 20 * time.Millisecond
+	}
+	if c.Retries < 0 {
+		c.Retries = 0
+	} else if c.Retries == 0 {
+		c.Retries = 2
+	}
+	nine, err := xserial.NewNineBitPort(p, 1)
+	if err != nil {
+		return nil, err
+	}
+	return &Master{p: nine, cfg: c, buf: make([]uint16, MaxBlock)}, nil
+}
+
+// Checksum returns the MDB checksum, the sum of the bytes
+func Checksum(b []byte) byte {
+	var sum byte
+	for _, c := range b {
+		sum += c
+	}
+	return sum
+}
+
+// Command sends cmd, an address plus command, with data and returns the
+// data the peripheral responds with, nil for a plain ACK. Responses with
+// data are acknowledged; a bad checksum asks for the response again, no
+// response repeats the command.
+func (m *Master) Command(ctx context.Context, cmd byte, data []byte) ([]byte, error) {
+	if len(data)+2 > MaxBlock {
+		return nil, ErrTooLong
+	}
+	block := append([]byte{cmd}, data...)
+	block = append(block, Checksum(block))
+	m.discard()
+	err := m.write(block)
+	for retries := m.cfg.Retries; err == nil; retries-- {
+		var resp []byte
+		resp, err = m.response(ctx)
+		if err == nil {
+			return resp, nil
+		}
+		if retries == 0 {
+			break
+		}
+		switch err {
+		case ErrChecksum:
+			m.discard()
+			_, err = m.p.Write([]byte{RET})
+		case ErrNoResponse:
+			m.discard()
+			err = m.write(block)
+		default:
+			return nil, err
+		}
+	}
+	return nil, err
+}
+
+// response reads a response up to the byte with the mode bit and
+// acknowledges it when it carries data
+func (m *Master) response(ctx context.Context) ([]byte, error) {
+	var resp []byte
+	for {
+		c, mode, err := m.read(ctx)
+		if err == errTimeout {
+			if len(resp) == 0 || m.p.MarksParity() {
+				return nil, ErrNoResponse
+			}
+			// Without the Mode Bit the Response ends when the Line goes quiet
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		resp = append(resp, c)
+		if len(resp) > MaxBlock {
+			return nil, ErrTooLong
+		}
+		if mode {
+			break
+		}
+	}
+	n := len(resp) - 1
+	if n == 0 {
+		switch resp[0] {
+		case ACK:
+			return nil, nil
+		case NAK:
+			return nil, ErrNAK
+		}
+		return nil, ErrChecksum
+	}
+	if Checksum(resp[:n]) != resp[n] {
+		return nil, ErrChecksum
+	}
+	if _, err := m.p.Write([]byte{ACK}); err != nil {
+		return nil, err
+	}
+	return resp[:n], nil
+}
+
+// write sends block with the mode bit on its first byte
+func (m *Master) write(block []byte) error {
+	if err := m.p.WriteAddress(block[0]); err != nil {
+		return err
+	}
+	_, err := m.p.Write(block[1:])
+	return err
+}
+
+// read returns the next byte and its mode bit, or errTimeout once Timeout
+// passes without one
+func (m *Master) read(ctx context.Context) (byte, bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.cfg.Timeout)
+	defer cancel()
+	for len(m.pending) == 0 {
+		if err := ctx.Err(); err != nil {
+			if err == context.DeadlineExceeded {
+				return 0, false, errTimeout
+			}
+			return 0, false, err
+		}
+		n, err := m.p.ReadNineContext(ctx, m.buf)
+		m.pending = m.buf[:n]
+		if err != nil && err != xserial.ErrReadTimeout && ctx.Err() == nil {
+			return 0, false, err
+		}
+	}
+	v := m.pending[0]
+	m.pending = m.pending[1:]
+	return byte(v), v&xserial.NineBit != 0, nil
+}
+
+// discard drops input
+func (m *Master) discard() {
+	m.p.Flush()
+	m.pending = nil
+}
+
+// Reset holds the bus in break for 100ms, resetting every peripheral;
+// they need 200ms more before the first command
+func (m *Master) Reset() error {
+	lines, ok := xserial.As[xserial.LineController](m.p)
+	if !ok {
+		return ErrNoBreak
+	}
+	if err := lines.SendBreak(100 * time.Millisecond); err != nil {
+		return err
+	}
+	time.Sleep(200 * time.Millisecond)
+	m.discard()
+	return nil
+}
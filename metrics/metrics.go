@@ -0,0 +1,116 @@
+// Package metrics exposes xserial Port counters in the Prometheus text
+// exposition format, so serial gateways can be scraped without pulling the
+// Prometheus client library into every program.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/packing/xserial"
+)
+
+// buffered is implemented by xserial.BufferedPort
+type buffered interface {
+	Buffered() int
+	Overruns() uint64
+}
+
+type metric struct {
+	name, help, kind string
+	value            func(s xserial.Stats) uint64
+}
+
+var metrics = []metric{
+	{"xserial_read_bytes_total", "Bytes read from the port.", "counter", func(s xserial.Stats) uint64 { return s.BytesRead }},
+	{"xserial_written_bytes_total", "Bytes written to the port.", "counter", func(s xserial.Stats) uint64 { return s.BytesWritten }},
+	{"xserial_read_timeouts_total", "Reads that timed out without data.", "counter", func(s xserial.Stats) uint64 { return s.ReadTimeouts }},
+	{"xserial_read_errors_total", "Reads that failed.", "counter", func(s xserial.Stats) uint64 { return s.ReadErrors }},
+	{"xserial_write_errors_total", "Writes that failed.", "counter", func(s xserial.Stats) uint64 { return s.WriteErrors }},
+	{"xserial_reopens_total", "Times the port was opened again.", "counter", func(s xserial.Stats) uint64 { return s.Reopens }},
+}
+
+// Registry holds named Ports and serves their metrics over HTTP
+type Registry struct {
+	mx    sync.Mutex
+	ports map[string]xserial.Port
+}
+
+// NewRegistry creates an empty Registry
+func NewRegistry() *Registry {
+	return &Registry{ports: make(map[string]xserial.Port)}
+}
+
+// Add registers p under name, replacing any Port already using it
+func (r *Registry) Add(name string, p xserial.Port) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	r.ports[name] = p
+}
+
+// Remove unregisters the Port called name
+func (r *Registry) Remove(name string) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	delete(r.ports, name)
+}
+
+// ServeHTTP writes all metrics in the Prometheus text format
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.WriteTo(w)
+}
+
+// WriteTo writes all metrics in the Prometheus text format to w
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mx.Lock()
+	names := make([]string, 0, len(r.ports))
+	for name := range r.ports {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	ports := make([]xserial.Port, len(names))
+	for i, name := range names {
+		ports[i] = r.ports[name]
+	}
+	r.mx.Unlock()
+
+	stats := make([]xserial.Stats, len(ports))
+	for i, p := range ports {
+		stats[i] = p.Stats()
+	}
+
+	var b strings.Builder
+	for _, m := range metrics {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+		for i, name := range names {
+			fmt.Fprintf(&b, "%s{port=\"%s\"} %d\n", m.name, escape(name), m.value(stats[i]))
+		}
+	}
+
+	// Buffer Occupancy for Buffered Ports
+	b.WriteString("# HELP xserial_buffered_bytes Bytes waiting in the user-space receive buffer.\n# TYPE xserial_buffered_bytes gauge\n")
+	for i, name := range names {
+		if bp, ok := ports[i].(buffered); ok {
+			fmt.Fprintf(&b, "xserial_buffered_bytes{port=\"%s\"} %d\n", escape(name), bp.Buffered())
+		}
+	}
+	b.WriteString("# HELP xserial_buffer_overrun_bytes_total Bytes dropped because the receive buffer was full.\n# TYPE xserial_buffer_overrun_bytes_total counter\n")
+	for i, name := range names {
+		if bp, ok := ports[i].(buffered); ok {
+			fmt.Fprintf(&b, "xserial_buffer_overrun_bytes_total{port=\"%s\"} %d\n", escape(name), bp.Overruns())
+		}
+	}
+
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+// escape quotes a label value per the text exposition format
+func escape(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
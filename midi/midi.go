@@ -0,0 +1,359 @@
+// Package midi frames MIDI 1.0 messages on a serial line at 31250 baud:
+// it expands running status, passes realtime bytes through wherever they
+// interrupt a message, collects system exclusive messages and writes
+// messages with running status for DIY and DIN interface hardware.
+package midi
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"github.com/packing/xserial"
+)
+
+// Baud is the MIDI line speed
+const Baud = 31250
+
+// Channel Message Types, the high nibble of the status byte
+const (
+	NoteOff         = 0x80
+	NoteOn          = 0x90
+	PolyPressure    = 0xA0
+	ControlChange   = 0xB0
+	ProgramChange   = 0xC0
+	ChannelPressure = 0xD0
+	PitchBend       = 0xE0
+)
+
+// System Messages
+const (
+	SysEx        = 0xF0
+	TimeCode     = 0xF1
+	SongPosition = 0xF2
+	SongSelect   = 0xF3
+	TuneRequest  = 0xF6
+	EndSysEx     = 0xF7
+	// Realtime, one byte each and allowed between any two bytes
+	TimingClock   = 0xF8
+	Start         = 0xFA
+	Continue      = 0xFB
+	Stop          = 0xFC
+	ActiveSensing = 0xFE
+	SystemReset   = 0xFF
+)
+
+// DefaultMaxSysEx is the longest system exclusive message, F0 and F7
+// included, a Decoder accepts by default
+const DefaultMaxSysEx = 4096
+
+// ErrFormat - the message is too short for its status
+var ErrFormat = errors.New("midi: malformed message")
+
+// PortConfig returns the Config to open name with for MIDI, 31250 baud
+// 8N1. Linux needs a driver taking arbitrary rates, as FTDI and CH340
+// drivers do.
+func PortConfig(name string) *xserial.Config {
+	return &xserial.Config{Name: name, Baud: Baud, Parity: "N", StopBits: 1}
+}
+
+// Message is one complete MIDI message starting with its status byte
+type Message []byte
+
+// Status returns the status byte, or 0 for an empty message
+func (m Message) Status() byte {
+	if len(m) == 0 {
+		return 0
+	}
+	return m[0]
+}
+
+// Type returns the message type: the high nibble for channel messages,
+// such as NoteOn, and the status byte for system messages
+func (m Message) Type() byte {
+	s := m.Status()
+	if s < 0xF0 {
+		return s & 0xF0
+	}
+	return s
+}
+
+// Channel returns the channel, 0 to 15, of a channel message, or -1
+func (m Message) Channel() int {
+	s := m.Status()
+	if s < 0x80 || s >= 0xF0 {
+		return -1
+	}
+	return int(s & 0x0F)
+}
+
+// Realtime reports whether m is a single byte realtime message
+func (m Message) Realtime() bool {
+	return m.Status() >= TimingClock
+}
+
+// IsNoteOff reports whether m ends a note, counting NoteOn with velocity
+// zero as running status senders use it
+func (m Message) IsNoteOff() bool {
+	switch m.Type() {
+	case NoteOff:
+		return len(m) == 3
+	case NoteOn:
+		return len(m) == 3 && m[2] == 0
+	}
+	return false
+}
+
+// Bend returns the value of a Pitch Bend, from -8192 to 8191
+func (m Message) Bend() int {
+	if m.Type() != PitchBend || len(m) < 3 {
+		return 0
+	}
+	return (int(m[1]) | int(m[2])<<7) - 8192
+}
+
+// messageLength returns the length of the message starting with status,
+// or 0 for sysex and stray bytes
+func messageLength(status byte) int {
+	switch {
+	case status < 0x80:
+		return 0
+	case status < 0xC0, status >= 0xE0 && status < 0xF0:
+		return 3
+	case status < 0xE0:
+		return 2
+	}
+	switch status {
+	case TimeCode, SongSelect:
+		return 2
+	case SongPosition:
+		return 3
+	case SysEx, EndSysEx:
+		return 0
+	}
+	// Tune Request, Realtime and the undefined Status Bytes
+	return 1
+}
+
+// Decoder is an xserial.FrameDecoder returning whole MIDI messages with
+// running status expanded. Realtime messages come out where they arrive,
+// before the message they interrupt. Data bytes without a status, stray
+// EndSysEx, system exclusive messages cut short by a status byte or over
+// the maximum count as resyncs.
+type Decoder struct {
+	max     int
+	buf     []byte
+	want    int
+	running byte
+	sysex   bool
+	skip    bool
+	resyncs uint64
+}
+
+// NewDecoder returns a Decoder accepting system exclusive messages up to
+// max bytes, or DefaultMaxSysEx if max is 0
+func NewDecoder(max int) *Decoder {
+	if max <= 0 {
+		max = DefaultMaxSysEx
+	}
+	return &Decoder{max: max}
+}
+
+func (d *Decoder) Feed(p []byte) [][]byte {
+	var frames [][]byte
+	for _, c := range p {
+		if c >= TimingClock {
+			frames = append(frames, []byte{c})
+			continue
+		}
+		if d.sysex {
+			switch {
+			case c == EndSysEx:
+				if !d.skip {
+					frames = append(frames, append(append([]byte(nil), d.buf...), c))
+				}
+				d.reset()
+				continue
+			case c < 0x80:
+				if !d.skip {
+					if len(d.buf)+1 >= d.max {
+						d.skip = true
+						d.resyncs++
+					} else {
+						d.buf = append(d.buf, c)
+					}
+				}
+				continue
+			}
+			// A Status Byte cuts the Message short
+			if !d.skip {
+				d.resyncs++
+			}
+			d.reset()
+		}
+		if c >= 0x80 {
+			if len(d.buf) > 0 {
+				d.resyncs++
+			}
+			d.reset()
+			switch {
+			case c == SysEx:
+				d.running = 0
+				d.sysex = true
+				d.buf = append(d.buf, c)
+				continue
+			case c == EndSysEx:
+				d.running = 0
+				d.resyncs++
+				continue
+			case c >= 0xF0:
+				// System Common cancels Running Status
+				d.running = 0
+			default:
+				d.running = c
+			}
+			d.want = messageLength(c)
+			d.buf = append(d.buf, c)
+		} else {
+			if len(d.buf) == 0 {
+				if d.running == 0 {
+					d.resyncs++
+					continue
+				}
+				d.want = messageLength(d.running)
+				d.buf = append(d.buf, d.running)
+			}
+			d.buf = append(d.buf, c)
+		}
+		if len(d.buf) == d.want {
+			frames = append(frames, append([]byte(nil), d.buf...))
+			d.buf = d.buf[:0]
+		}
+	}
+	return frames
+}
+
+// reset drops the partial message, keeping running status
+func (d *Decoder) reset() {
+	d.buf = d.buf[:0]
+	d.want = 0
+	d.sysex = false
+	d.skip = false
+}
+
+func (d *Decoder) Resyncs() uint64 {
+	return d.resyncs
+}
+
+func (d *Decoder) Reset() {
+	d.reset()
+	d.running = 0
+}
+
+// Writer writes messages, leaving out a channel message's status byte
+// when it repeats the last one if running status is on
+type Writer struct {
+	w       io.Writer
+	running bool
+	status  byte
+}
+
+// NewWriter returns a Writer on w, using running status if running is set
+func NewWriter(w io.Writer, running bool) *Writer {
+	return &Writer{w: w, running: running}
+}
+
+// WriteMessage writes one complete message
+func (w *Writer) WriteMessage(m Message) error {
+	if len(m) == 0 || m[0] < 0x80 {
+		return ErrFormat
+	}
+	if n := messageLength(m[0]); n != 0 && len(m) != n {
+		return ErrFormat
+	}
+	out := []byte(m)
+	s := m[0]
+	switch {
+	case s >= TimingClock:
+		// Realtime leaves Running Status alone
+	case s >= 0xF0:
+		w.status = 0
+	case w.running && s == w.status:
+		out = out[1:]
+	default:
+		w.status = s
+	}
+	_, err := w.w.Write(out)
+	return err
+}
+
+// Reset makes the next channel message carry its status byte, as a
+// receiver that joined late needs
+func (w *Writer) Reset() {
+	w.status = 0
+}
+
+// Conn sends and receives MIDI messages on a Port opened with PortConfig
+type Conn struct {
+	*Writer
+	rd *xserial.FrameReader
+}
+
+// NewConn returns a Conn on p, writing with running status if running is
+// set
+func NewConn(p xserial.Port, running bool) *Conn {
+	return &Conn{Writer: NewWriter(p, running), rd: xserial.NewFrameReader(p, NewDecoder(0))}
+}
+
+// ReadMessage returns the next message, with Read errors such as
+// ErrReadTimeout returned as they occur
+func (c *Conn) ReadMessage() (Message, error) {
+	return c.ReadMessageContext(context.Background())
+}
+
+// ReadMessageContext is ReadMessage giving up once ctx is done
+func (c *Conn) ReadMessageContext(ctx context.Context) (Message, error) {
+	f, err := c.rd.ReadFrameContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return Message(f), nil
+}
+
+// channel returns a channel message of typ on ch with data
+func channel(typ byte, ch int, data ...byte) Message {
+	return append(Message{typ | byte(ch&0x0F)}, data...)
+}
+
+// NoteOnMessage returns a Note On for note at velocity on channel ch
+func NoteOnMessage(ch int, note, velocity byte) Message {
+	return channel(NoteOn, ch, note&0x7F, velocity&0x7F)
+}
+
+// NoteOffMessage returns a Note Off for note at velocity on channel ch
+func NoteOffMessage(ch int, note, velocity byte) Message {
+	return channel(NoteOff, ch, note&0x7F, velocity&0x7F)
+}
+
+// ControlChangeMessage returns a Control Change setting controller to
+// value on channel ch
+func ControlChangeMessage(ch int, controller, value byte) Message {
+	return channel(ControlChange, ch, controller&0x7F, value&0x7F)
+}
+
+// ProgramChangeMessage returns a Program Change to program on channel ch
+func ProgramChangeMessage(ch int, program byte) Message {
+	return channel(ProgramChange, ch, program&0x7F)
+}
+
+// PitchBendMessage returns a Pitch Bend on channel ch, value from -8192
+// to 8191 with 0 as center
+func PitchBendMessage(ch, value int) Message {
+	v := value + 8192
+	if v < 0 {
+		v = 0
+	} else if v > 0x3FFF {
+		v = 0x3FFF
+	}
+	return channel(PitchBend, ch, byte(v&0x7F), byte(v>>7))
+}
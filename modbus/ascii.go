@@ -0,0 +1,52 @@
+package modbus
+
+import (
+	"bytes"
+	"encoding/hex"
+	"time"
+
+	"github.com/packing/xserial/checksum"
+)
+
+// ascii frames ADUs as Modbus ASCII: ':' then address, PDU and LRC in
+// upper case hex, ended by CR LF
+type ascii struct{}
+
+func (ascii) encode(slave byte, pdu []byte) []byte {
+	adu := append([]byte{slave}, pdu...)
+	adu = append(adu, checksum.LRC(adu))
+	b := make([]byte, 1, 1+2*len(adu)+2)
+	b[0] = ':'
+	b = append(b, bytes.ToUpper([]byte(hex.EncodeToString(adu)))...)
+	return append(b, '\r', '\n')
+}
+
+// match completes at the CR LF ending the frame
+func (ascii) match(b []byte) int {
+	if i := bytes.Index(b, []byte("\r\n")); i >= 0 {
+		return i + 2
+	}
+	return 0
+}
+
+func (ascii) decode(frame []byte) (byte, []byte, error) {
+	// Noise before the Start of the Frame is Ignored
+	start := bytes.LastIndexByte(frame, ':')
+	if start < 0 || len(frame) < start+3 {
+		return 0, nil, ErrInvalidResponse
+	}
+	adu := make([]byte, hex.DecodedLen(len(frame)-start-3))
+	if _, err := hex.Decode(adu, frame[start+1:len(frame)-2]); err != nil || len(adu) < 4 {
+		return 0, nil, ErrInvalidResponse
+	}
+	n := len(adu) - 1
+	if checksum.LRC(adu[:n]) != adu[n] {
+		return 0, nil, ErrChecksum
+	}
+	return adu[0], adu[1:n], nil
+}
+
+// ASCII has no Inter-Frame Gap
+func (ascii) silence() time.Duration {
+	return 0
+}
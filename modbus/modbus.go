@@ -0,0 +1,368 @@
+// Package modbus implements a Modbus serial line master (client) over a
+// Port: the bit and register access functions, exception decoding and
+// retries, speaking Modbus RTU with its inter-frame timing or Modbus ASCII.
+package modbus
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/packing/xserial"
+)
+
+// Function Codes
+const (
+	FuncReadCoils              = 0x01
+	FuncReadDiscreteInputs     = 0x02
+	FuncReadHoldingRegisters   = 0x03
+	FuncReadInputRegisters     = 0x04
+	FuncWriteSingleCoil        = 0x05
+	FuncWriteSingleRegister    = 0x06
+	FuncWriteMultipleCoils     = 0x0F
+	FuncWriteMultipleRegisters = 0x10
+)
+
+// Exception Codes
+const (
+	ExIllegalFunction        = 0x01
+	ExIllegalDataAddress     = 0x02
+	ExIllegalDataValue       = 0x03
+	ExServerDeviceFailure    = 0x04
+	ExAcknowledge            = 0x05
+	ExServerDeviceBusy       = 0x06
+	ExMemoryParityError      = 0x08
+	ExGatewayPathUnavailable = 0x0A
+	ExGatewayTargetFailed    = 0x0B
+)
+
+// Mode selects the serial transmission mode
+type Mode int
+
+const (
+	// ModeRTU - binary frames with a CRC, separated by silence
+	ModeRTU Mode = iota
+	// ModeASCII - hex encoded frames with an LRC, from ':' to CR LF
+	ModeASCII
+)
+
+// Broadcast reaches every slave; none of them reply
+const Broadcast = 0
+
+var (
+	// ErrInvalidResponse - the reply does not answer the request
+	ErrInvalidResponse = errors.New("modbus: invalid response")
+	// ErrChecksum - the reply was damaged
+	ErrChecksum = errors.New("modbus: checksum mismatch")
+	// ErrQuantity - too few or too many items for one request
+	ErrQuantity = errors.New("modbus: quantity out of range")
+)
+
+var exceptionText = map[byte]string{
+	ExIllegalFunction:        "illegal function",
+	ExIllegalDataAddress:     "illegal data address",
+	ExIllegalDataValue:       "illegal data value",
+	ExServerDeviceFailure:    "server device failure",
+	ExAcknowledge:            "acknowledge",
+	ExServerDeviceBusy:       "server device busy",
+	ExMemoryParityError:      "memory parity error",
+	ExGatewayPathUnavailable: "gateway path unavailable",
+	ExGatewayTargetFailed:    "gateway target device failed to respond",
+}
+
+// Exception is a slave's refusal of a request
+type Exception struct {
+	Function byte
+	Code     byte
+}
+
+func (e *Exception) Error() string {
+	text, ok := exceptionText[e.Code]
+	if !ok {
+		text = "unknown"
+	}
+	return fmt.Sprintf("modbus: exception %d (%s) for function %d", e.Code, text, e.Function)
+}
+
+// Config configures a Client
+type Config struct {
+	// Transmission Mode, defaults to ModeRTU
+	Mode Mode
+	// Line Speed, for the RTU silent interval between frames; defaults to
+	// 9600
+	Baud int
+	// Time allowed for each reply, defaults to 1 second
+	Timeout time.Duration
+	// Further attempts after a timeout or a damaged reply
+	Retries int
+	// Pause after a broadcast so slaves can act on it, defaults to 100ms
+	TurnaroundDelay time.Duration
+}
+
+// transport frames requests and replies for one serial transmission mode
+type transport interface {
+	encode(slave byte, pdu []byte) []byte
+	match(b []byte) int
+	decode(adu []byte) (slave byte, pdu []byte, err error)
+	// Idle Time required between Frames
+	silence() time.Duration
+}
+
+// Client is a Modbus master. Its methods may be called concurrently; the
+// requests are sent one at a time.
+type Client struct {
+	p   xserial.Port
+	cfg Config
+	t   transport
+
+	mx   sync.Mutex
+	last time.Time
+}
+
+// NewClient returns a Client on p. cfg may be nil.
+func NewClient(p xserial.Port, cfg *Config) *Client {
+	var c Config
+	if cfg != nil {
+		c = *cfg
+	}
+	if c.Baud <= 0 {
+		c.Baud = 9600
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = time.Second
+	}
+	if c.TurnaroundDelay <= 0 {
+		c.TurnaroundDelay = 100 * time.Millisecond
+	}
+	var t transport = newRTU(c.Baud)
+	if c.Mode == ModeASCII {
+		t = ascii{}
+	}
+	return &Client{p: p, cfg: c, t: t}
+}
+
+// pduLength returns the length of the response PDU at the start of b, or
+// -1 if more bytes are needed to tell
+func pduLength(b []byte) int {
+	if len(b) < 1 {
+		return -1
+	}
+	fc := b[0]
+	switch {
+	case fc&0x80 != 0:
+		return 2
+	case fc == 0x07:
+		return 2
+	case fc == 0x05, fc == 0x06, fc == 0x08, fc == 0x0B, fc == 0x0F, fc == 0x10:
+		return 5
+	case fc == 0x16:
+		return 7
+	case fc == 0x18:
+		if len(b) < 3 {
+			return -1
+		}
+		return 3 + int(binary.BigEndian.Uint16(b[1:]))
+	default:
+		// Byte Count follows the Function Code
+		if len(b) < 2 {
+			return -1
+		}
+		return 2 + int(b[1])
+	}
+}
+
+// Request sends function fc with data to slave and returns the data of the
+// reply. An exception reply is returned as an *Exception. A broadcast
+// returns no data.
+func (c *Client) Request(slave, fc byte, data []byte) ([]byte, error) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	adu := c.t.encode(slave, append([]byte{fc}, data...))
+	var err error
+	for attempt := 0; attempt <= c.cfg.Retries; attempt++ {
+		var pdu []byte
+		if pdu, err = c.exchange(slave, adu); err == nil {
+			if pdu == nil {
+				return nil, nil
+			}
+			if pdu[0] == fc|0x80 {
+				return nil, &Exception{Function: fc, Code: pdu[1]}
+			}
+			if pdu[0] != fc {
+				return nil, ErrInvalidResponse
+			}
+			return pdu[1:], nil
+		}
+		if err != xserial.ErrReadTimeout && err != ErrChecksum && err != ErrInvalidResponse {
+			return nil, err
+		}
+	}
+	return nil, err
+}
+
+// exchange sends one request and returns the reply PDU
+func (c *Client) exchange(slave byte, adu []byte) ([]byte, error) {
+	// Keep the Silent Interval since the last Frame
+	if wait := c.t.silence() - time.Since(c.last); wait > 0 {
+		time.Sleep(wait)
+	}
+	defer func() {
+		c.last = time.Now()
+	}()
+	if slave == Broadcast {
+		if err := c.p.Flush(); err != nil {
+			return nil, err
+		}
+		if _, err := c.p.Write(adu); err != nil {
+			return nil, err
+		}
+		time.Sleep(c.cfg.TurnaroundDelay)
+		return nil, nil
+	}
+
+	resp, err := xserial.Transact(c.p, adu, c.t.match, &xserial.TransactConfig{Timeout: c.cfg.Timeout})
+	if err != nil {
+		return nil, err
+	}
+	from, pdu, err := c.t.decode(resp)
+	if err != nil {
+		return nil, err
+	}
+	if from != slave || len(pdu) < 2 {
+		return nil, ErrInvalidResponse
+	}
+	return pdu, nil
+}
+
+// readBits reads qty coils or discrete inputs
+func (c *Client) readBits(slave, fc byte, addr, qty uint16) ([]bool, error) {
+	if qty < 1 || qty > 2000 {
+		return nil, ErrQuantity
+	}
+	data, err := c.Request(slave, fc, words(addr, qty))
+	if err != nil || slave == Broadcast {
+		return nil, err
+	}
+	if len(data) < 1 || int(data[0]) != (int(qty)+7)/8 || len(data) != 1+int(data[0]) {
+		return nil, ErrInvalidResponse
+	}
+	bits := make([]bool, qty)
+	for i := range bits {
+		bits[i] = data[1+i/8]&(1<<uint(i%8)) != 0
+	}
+	return bits, nil
+}
+
+// readRegisters reads qty holding or input registers
+func (c *Client) readRegisters(slave, fc byte, addr, qty uint16) ([]uint16, error) {
+	if qty < 1 || qty > 125 {
+		return nil, ErrQuantity
+	}
+	data, err := c.Request(slave, fc, words(addr, qty))
+	if err != nil || slave == Broadcast {
+		return nil, err
+	}
+	if len(data) < 1 || int(data[0]) != 2*int(qty) || len(data) != 1+int(data[0]) {
+		return nil, ErrInvalidResponse
+	}
+	regs := make([]uint16, qty)
+	for i := range regs {
+		regs[i] = binary.BigEndian.Uint16(data[1+2*i:])
+	}
+	return regs, nil
+}
+
+// ReadCoils reads qty coils from addr, function 1
+func (c *Client) ReadCoils(slave byte, addr, qty uint16) ([]bool, error) {
+	return c.readBits(slave, FuncReadCoils, addr, qty)
+}
+
+// ReadDiscreteInputs reads qty discrete inputs from addr, function 2
+func (c *Client) ReadDiscreteInputs(slave byte, addr, qty uint16) ([]bool, error) {
+	return c.readBits(slave, FuncReadDiscreteInputs, addr, qty)
+}
+
+// ReadHoldingRegisters reads qty holding registers from addr, function 3
+func (c *Client) ReadHoldingRegisters(slave byte, addr, qty uint16) ([]uint16, error) {
+	return c.readRegisters(slave, FuncReadHoldingRegisters, addr, qty)
+}
+
+// ReadInputRegisters reads qty input registers from addr, function 4
+func (c *Client) ReadInputRegisters(slave byte, addr, qty uint16) ([]uint16, error) {
+	return c.readRegisters(slave, FuncReadInputRegisters, addr, qty)
+}
+
+// WriteSingleCoil sets the coil at addr, function 5
+func (c *Client) WriteSingleCoil(slave byte, addr uint16, on bool) error {
+	var v uint16
+	if on {
+		v = 0xFF00
+	}
+	return c.writeEcho(slave, FuncWriteSingleCoil, words(addr, v))
+}
+
+// WriteSingleRegister sets the holding register at addr, function 6
+func (c *Client) WriteSingleRegister(slave byte, addr, value uint16) error {
+	return c.writeEcho(slave, FuncWriteSingleRegister, words(addr, value))
+}
+
+// WriteMultipleCoils sets len(values) coils from addr, function 15
+func (c *Client) WriteMultipleCoils(slave byte, addr uint16, values []bool) error {
+	if len(values) < 1 || len(values) > 1968 {
+		return ErrQuantity
+	}
+	data := append(words(addr, uint16(len(values))), byte((len(values)+7)/8))
+	packed := make([]byte, (len(values)+7)/8)
+	for i, v := range values {
+		if v {
+			packed[i/8] |= 1 << uint(i%8)
+		}
+	}
+	return c.writeMultiple(slave, FuncWriteMultipleCoils, append(data, packed...))
+}
+
+// WriteMultipleRegisters sets len(values) holding registers from addr,
+// function 16
+func (c *Client) WriteMultipleRegisters(slave byte, addr uint16, values []uint16) error {
+	if len(values) < 1 || len(values) > 123 {
+		return ErrQuantity
+	}
+	data := append(words(addr, uint16(len(values))), byte(2*len(values)))
+	return c.writeMultiple(slave, FuncWriteMultipleRegisters, append(data, words(values...)...))
+}
+
+// writeEcho sends a single write, which the slave answers with the request
+func (c *Client) writeEcho(slave, fc byte, data []byte) error {
+	resp, err := c.Request(slave, fc, data)
+	if err != nil || slave == Broadcast {
+		return err
+	}
+	if string(resp) != string(data) {
+		return ErrInvalidResponse
+	}
+	return nil
+}
+
+// writeMultiple sends a multiple write, answered with address and quantity
+func (c *Client) writeMultiple(slave, fc byte, data []byte) error {
+	resp, err := c.Request(slave, fc, data)
+	if err != nil || slave == Broadcast {
+		return err
+	}
+	if len(resp) != 4 || string(resp) != string(data[:4]) {
+		return ErrInvalidResponse
+	}
+	return nil
+}
+
+// words encodes v big endian
+func words(v ...uint16) []byte {
+	b := make([]byte, 2*len(v))
+	for i, w := range v {
+		binary.BigEndian.PutUint16(b[2*i:], w)
+	}
+	return b
+}
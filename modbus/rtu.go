@@ -0,0 +1,58 @@
+package modbus
+
+import (
+	"time"
+
+	"github.com/packing/xserial/checksum"
+)
+
+// rtu frames ADUs as Modbus RTU: address, PDU and a CRC-16/MODBUS sent low
+// byte first, with frames separated by 3.5 characters of silence
+type rtu struct {
+	// Silent Interval between Frames
+	gap time.Duration
+}
+
+func newRTU(baud int) *rtu {
+	if baud > 19200 {
+		// Fixed above 19200 Baud by the Serial Line Specification
+		return &rtu{gap: 1750 * time.Microsecond}
+	}
+	// 11 Bits per Character
+	char := 11 * time.Second / time.Duration(baud)
+	return &rtu{gap: char * 7 / 2}
+}
+
+func (t *rtu) encode(slave byte, pdu []byte) []byte {
+	adu := append([]byte{slave}, pdu...)
+	crc := checksum.Modbus.Checksum(adu)
+	return append(adu, byte(crc), byte(crc>>8))
+}
+
+// match returns the length of the response at the start of b, from the
+// function code and byte count since RTU has no end marker
+func (t *rtu) match(b []byte) int {
+	if len(b) < 2 {
+		return 0
+	}
+	n := pduLength(b[1:])
+	if n < 0 || len(b) < 1+n+2 {
+		return 0
+	}
+	return 1 + n + 2
+}
+
+func (t *rtu) decode(adu []byte) (byte, []byte, error) {
+	if len(adu) < 4 {
+		return 0, nil, ErrInvalidResponse
+	}
+	n := len(adu) - 2
+	if checksum.Modbus.Checksum(adu[:n]) != uint16(adu[n])|uint16(adu[n+1])<<8 {
+		return 0, nil, ErrChecksum
+	}
+	return adu[0], adu[1:n], nil
+}
+
+func (t *rtu) silence() time.Duration {
+	return t.gap
+}
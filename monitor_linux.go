@@ -0,0 +1,258 @@
+// Copyright 2021 Abhijit Bose. All rights reserved.
+
+//go:build linux
+// +build linux
+
+package xserial
+
+import (
+	"bytes"
+	"encoding/binary"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Netlink Groups of NETLINK_KOBJECT_UEVENT
+const (
+	ueventGroupKernel = 1
+	ueventGroupUdev   = 2
+)
+
+// udev Messages start with this Prefix and a big-endian Magic Number
+const (
+	udevPrefix = "libudev\x00"
+	udevMagic  = 0xfeedcafe
+)
+
+// Monitor watches netlink for serial devices being plugged in and removed
+type Monitor struct {
+	fd int
+	ch chan PortEvent
+	// Self Pipe to Stop the Monitor
+	stopR, stopW int
+	done         chan struct{}
+	kernel       bool
+
+	mx     sync.Mutex
+	err    error
+	closed bool
+}
+
+// NewMonitor starts watching for serial device hotplug events. cfg may be
+// nil.
+func NewMonitor(cfg *MonitorConfig) (*Monitor, error) {
+	var c MonitorConfig
+	if cfg != nil {
+		c = *cfg
+	}
+	if c.Depth <= 0 {
+		c.Depth = 16
+	}
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.NETLINK_KOBJECT_UEVENT)
+	if err != nil {
+		return nil, portError("socket", "", err)
+	}
+	group := uint32(ueventGroupUdev)
+	if c.Kernel {
+		group = ueventGroupKernel
+	}
+	if err = unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK, Groups: group}); err != nil {
+		unix.Close(fd)
+		return nil, portError("bind", "", err)
+	}
+	var pipe [2]int
+	if err = unix.Pipe2(pipe[:], unix.O_CLOEXEC); err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+	m := &Monitor{
+		fd:     fd,
+		ch:     make(chan PortEvent, c.Depth),
+		stopR:  pipe[0],
+		stopW:  pipe[1],
+		done:   make(chan struct{}),
+		kernel: c.Kernel,
+	}
+	go m.run()
+	return m, nil
+}
+
+// Events returns the hotplug events; the channel is closed by Close or
+// when receiving fails, see Err
+func (m *Monitor) Events() <-chan PortEvent {
+	return m.ch
+}
+
+// Err returns the error that stopped the Monitor, if any
+func (m *Monitor) Err() error {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	return m.err
+}
+
+// Close stops the Monitor and closes the Events channel
+func (m *Monitor) Close() error {
+	m.mx.Lock()
+	if m.closed {
+		m.mx.Unlock()
+		return ErrPortClosed
+	}
+	m.closed = true
+	m.mx.Unlock()
+	unix.Write(m.stopW, []byte{0})
+	<-m.done
+	unix.Close(m.stopW)
+	return nil
+}
+
+func (m *Monitor) fail(err error) {
+	m.mx.Lock()
+	m.err = err
+	m.mx.Unlock()
+}
+
+func (m *Monitor) run() {
+	defer close(m.done)
+	defer close(m.ch)
+	defer unix.Close(m.stopR)
+	defer unix.Close(m.fd)
+
+	buf := make([]byte, 16*1024)
+	fds := []unix.PollFd{{Fd: int32(m.stopR), Events: unix.POLLIN}, {Fd: int32(m.fd), Events: unix.POLLIN}}
+	for {
+		if _, err := unix.Poll(fds, -1); err != nil {
+			if err == unix.EINTR {
+				continue
+			}
+			m.fail(err)
+			return
+		}
+		if fds[0].Revents != 0 {
+			return
+		}
+		if fds[1].Revents == 0 {
+			continue
+		}
+		n, from, err := unix.Recvfrom(m.fd, buf, 0)
+		if err == unix.EINTR || err == unix.EAGAIN {
+			continue
+		}
+		if err == unix.ENOBUFS {
+			// Events were Dropped by the Kernel - keep going
+			continue
+		}
+		if err != nil {
+			m.fail(err)
+			return
+		}
+		// Only trust the Kernel (Pid 0) or udevd on the udev Group
+		if nl, ok := from.(*unix.SockaddrNetlink); !ok || (m.kernel && nl.Pid != 0) {
+			continue
+		}
+		props, ok := parseUevent(buf[:n], m.kernel)
+		if !ok {
+			continue
+		}
+		e, ok := portEventFor(props)
+		if !ok {
+			continue
+		}
+		if !m.send(e, fds[:1]) {
+			return
+		}
+	}
+}
+
+// send delivers e, blocking only until the Monitor is Stopped
+func (m *Monitor) send(e PortEvent, stop []unix.PollFd) bool {
+	for {
+		select {
+		case m.ch <- e:
+			return true
+		default:
+		}
+		if n, _ := unix.Poll(stop, int(eventLinePollInterval/time.Millisecond)); n > 0 {
+			return false
+		}
+	}
+}
+
+// parseUevent extracts the KEY=VALUE properties of a kernel or udev message
+func parseUevent(msg []byte, kernel bool) (map[string]string, bool) {
+	if kernel {
+		// action@devpath\0KEY=VALUE\0...
+		i := bytes.IndexByte(msg, 0)
+		if i < 0 || bytes.IndexByte(msg[:i], '@') < 0 {
+			return nil, false
+		}
+		msg = msg[i+1:]
+	} else {
+		if len(msg) < 40 || string(msg[:8]) != udevPrefix || binary.BigEndian.Uint32(msg[8:]) != udevMagic {
+			return nil, false
+		}
+		// Offsets are in Host Byte Order
+		off := *(*uint32)(unsafe.Pointer(&msg[16]))
+		length := *(*uint32)(unsafe.Pointer(&msg[20]))
+		if uint64(off)+uint64(length) > uint64(len(msg)) {
+			return nil, false
+		}
+		msg = msg[off : off+length]
+	}
+	props := make(map[string]string)
+	for _, kv := range bytes.Split(msg, []byte{0}) {
+		if i := bytes.IndexByte(kv, '='); i > 0 {
+			props[string(kv[:i])] = string(kv[i+1:])
+		}
+	}
+	return props, true
+}
+
+// portEventFor turns tty add and remove uevents into PortEvents
+func portEventFor(props map[string]string) (PortEvent, bool) {
+	if props["SUBSYSTEM"] != "tty" || props["DEVNAME"] == "" {
+		return PortEvent{}, false
+	}
+	name := props["DEVNAME"]
+	if !strings.HasPrefix(name, "/") {
+		name = "/dev/" + name
+	}
+	e := PortEvent{Time: time.Now()}
+	switch props["ACTION"] {
+	case "add":
+		info, ok := sysPortInfo(filepath.Base(name))
+		if !ok {
+			return PortEvent{}, false
+		}
+		e.Action, e.Port = PortAdded, info
+	case "remove":
+		// sysfs is already gone, so use what udev recorded
+		e.Action = PortRemoved
+		e.Port = PortInfo{
+			Name:         name,
+			Driver:       props["ID_USB_DRIVER"],
+			USB:          props["ID_BUS"] == "usb",
+			VID:          props["ID_VENDOR_ID"],
+			PID:          props["ID_MODEL_ID"],
+			SerialNumber: props["ID_SERIAL_SHORT"],
+			Manufacturer: props["ID_VENDOR"],
+			Product:      props["ID_MODEL"],
+			Interface:    props["ID_USB_INTERFACE_NUM"],
+		}
+		for _, link := range strings.Fields(props["DEVLINKS"]) {
+			switch filepath.Dir(link) {
+			case serialByID:
+				e.Port.ByID = link
+			case serialByPath:
+				e.Port.ByPath = link
+			}
+		}
+	default:
+		return PortEvent{}, false
+	}
+	return e, true
+}
@@ -0,0 +1,30 @@
+//go:build !linux
+// +build !linux
+
+package xserial
+
+// Monitor watches for serial devices being plugged in and removed. It is
+// only implemented on Linux.
+type Monitor struct {
+	ch chan PortEvent
+}
+
+// NewMonitor returns ErrNotImplemented outside Linux
+func NewMonitor(cfg *MonitorConfig) (*Monitor, error) {
+	return nil, ErrNotImplemented
+}
+
+// Events returns the hotplug events
+func (m *Monitor) Events() <-chan PortEvent {
+	return m.ch
+}
+
+// Err returns the error that stopped the Monitor, if any
+func (m *Monitor) Err() error {
+	return nil
+}
+
+// Close stops the Monitor
+func (m *Monitor) Close() error {
+	return nil
+}
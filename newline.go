@@ -0,0 +1,118 @@
+package xserial
+
+import (
+	"sync"
+)
+
+// Newline names the line ending used on the wire. The application always
+// sees "\n".
+type Newline int
+
+const (
+	// NewlineRaw - bytes pass unchanged
+	NewlineRaw Newline = iota
+	// NewlineCRLF - "\n" is sent as "\r\n"; "\r\n", "\r" and "\n" read as "\n"
+	NewlineCRLF
+	// NewlineCR - "\n" is sent as "\r"; "\r" reads as "\n"
+	NewlineCR
+)
+
+// NewlineConfig configures a NewlinePort, each direction on its own
+type NewlineConfig struct {
+	Read  Newline
+	Write Newline
+}
+
+// NewlinePort translates line endings like the ICRNL and ONLCR terminal
+// modes, on any platform
+type NewlinePort struct {
+	Port
+	cfg NewlineConfig
+	wmx sync.Mutex
+	buf []byte
+	// Last Byte written was "\r"
+	wcr bool
+	rmx sync.Mutex
+	// Last Byte read was "\r", so a "\n" after it is part of the same Ending
+	rcr bool
+}
+
+// NewNewlinePort wraps p so its line endings follow cfg
+func NewNewlinePort(p Port, cfg NewlineConfig) *NewlinePort {
+	return &NewlinePort{Port: p, cfg: cfg}
+}
+
+// Unwrap returns the translated Port
+func (p *NewlinePort) Unwrap() Port {
+	return p.Port
+}
+
+// Write translates b and writes it, returning len(b) once all of it is
+// sent. A "\r\n" written is sent as one ending, not doubled.
+func (p *NewlinePort) Write(b []byte) (int, error) {
+	if p.cfg.Write == NewlineRaw {
+		return p.Port.Write(b)
+	}
+	p.wmx.Lock()
+	defer p.wmx.Unlock()
+	out := p.buf[:0]
+	for _, c := range b {
+		switch {
+		case c != '\n':
+			out = append(out, c)
+		case p.wcr:
+			// Already Ended by the "\r"
+			if p.cfg.Write == NewlineCRLF {
+				out = append(out, c)
+			}
+		case p.cfg.Write == NewlineCRLF:
+			out = append(out, '\r', '\n')
+		default:
+			out = append(out, '\r')
+		}
+		p.wcr = c == '\r'
+	}
+	p.buf = out
+	if _, err := p.Port.Write(out); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Read reads into b and translates it, reading again while all that
+// arrived was the "\n" of a "\r\n". Translating never grows the data, so
+// nothing is held back from the next Read.
+func (p *NewlinePort) Read(b []byte) (int, error) {
+	if p.cfg.Read == NewlineRaw {
+		return p.Port.Read(b)
+	}
+	p.rmx.Lock()
+	defer p.rmx.Unlock()
+	for {
+		n, err := p.Port.Read(b)
+		out := b[:0]
+		for _, c := range b[:n] {
+			cr := c == '\r'
+			switch {
+			case cr:
+				c = '\n'
+			case c == '\n' && p.rcr && p.cfg.Read == NewlineCRLF:
+				p.rcr = false
+				continue
+			}
+			p.rcr = cr
+			out = append(out, c)
+		}
+		if len(out) > 0 || n == 0 || err != nil {
+			return len(out), err
+		}
+	}
+}
+
+// Flush discards buffered data and forgets a "\r" just read
+func (p *NewlinePort) Flush() error {
+	p.rmx.Lock()
+	p.rcr = false
+	p.rmx.Unlock()
+	return p.Port.Flush()
+}
@@ -0,0 +1,204 @@
+package xserial
+
+import (
+	"context"
+	"sync"
+)
+
+// NineBit is the ninth bit of a value read or written by a NineBitPort,
+// marking address bytes on multidrop buses such as MDB
+const NineBit = 0x100
+
+// NineBitPort carries 9-bit protocols over a UART with mark and space
+// parity. The ninth bit goes out as mark parity, switched to for those
+// bytes, and is read back from the parity errors a ParityMarker reports
+// under space parity. Ports that are not ParityMarkers read every byte
+// without it.
+type NineBitPort struct {
+	Port
+	stopBits int
+	marks    bool
+	quiet    markSetter
+	wmx      sync.Mutex
+	rmx      sync.Mutex
+	buf      []byte
+	// Bytes of a Mark Sequence read so far
+	esc int
+}
+
+// NewNineBitPort wraps p, setting it to space parity with parity errors
+// marked (parity "G") and stopbits
+func NewNineBitPort(p Port, stopbits int) (*NineBitPort, error) {
+	if err := p.SetParity("G", stopbits); err != nil {
+		return nil, err
+	}
+	n := &NineBitPort{Port: p, stopBits: stopbits}
+	if m, ok := As[ParityMarker](p); ok {
+		n.marks = m.MarksParity()
+	}
+	if m, ok := As[markSetter](p); ok {
+		n.quiet = m
+	}
+	return n, nil
+}
+
+// markSetter is implemented by the OS Ports, switching parity for each
+// address byte without logging a config change
+type markSetter interface {
+	setMarkParity(mark bool) error
+}
+
+// setMark switches to mark parity, or back to "G"
+func (n *NineBitPort) setMark(mark bool) error {
+	if n.quiet != nil {
+		return n.quiet.setMarkParity(mark)
+	}
+	if mark {
+		return n.Port.SetParity("M", n.stopBits)
+	}
+	return n.Port.SetParity("G", n.stopBits)
+}
+
+// Unwrap returns the wrapped Port
+func (n *NineBitPort) Unwrap() Port {
+	return n.Port
+}
+
+// MarksParity reports whether reads carry the ninth bit
+func (n *NineBitPort) MarksParity() bool {
+	return n.marks
+}
+
+// Write sends b with the ninth bit clear
+func (n *NineBitPort) Write(b []byte) (int, error) {
+	n.wmx.Lock()
+	defer n.wmx.Unlock()
+	return n.Port.Write(b)
+}
+
+// WriteAddress sends b with the ninth bit set
+func (n *NineBitPort) WriteAddress(b byte) error {
+	_, err := n.WriteNine([]uint16{NineBit | uint16(b)})
+	return err
+}
+
+// WriteNine sends the low nine bits of each value of p. Output is drained
+// around bytes with the ninth bit, as parity changes at once.
+func (n *NineBitPort) WriteNine(p []uint16) (int, error) {
+	n.wmx.Lock()
+	defer n.wmx.Unlock()
+	b := make([]byte, 0, len(p))
+	for i := 0; i < len(p); {
+		mark := p[i]&NineBit != 0
+		b = b[:0]
+		for ; i < len(p) && (p[i]&NineBit != 0) == mark; i++ {
+			b = append(b, byte(p[i]))
+		}
+		if !mark {
+			if _, err := n.Port.Write(b); err != nil {
+				return i - len(b), err
+			}
+			continue
+		}
+		if err := n.writeMarked(b); err != nil {
+			return i - len(b), err
+		}
+	}
+	return len(p), nil
+}
+
+// writeMarked sends b under mark parity, returning to "G"
+func (n *NineBitPort) writeMarked(b []byte) error {
+	if err := n.Port.Drain(); err != nil {
+		return err
+	}
+	if err := n.setMark(true); err != nil {
+		return err
+	}
+	_, err := n.Port.Write(b)
+	if err == nil {
+		err = n.Port.Drain()
+	}
+	if perr := n.setMark(false); err == nil {
+		err = perr
+	}
+	return err
+}
+
+// Read reads data bytes, dropping the ninth bit
+func (n *NineBitPort) Read(b []byte) (int, error) {
+	if !n.marks {
+		return n.Port.Read(b)
+	}
+	v := make([]uint16, len(b))
+	k, err := n.ReadNine(v)
+	for i := 0; i < k; i++ {
+		b[i] = byte(v[i])
+	}
+	return k, err
+}
+
+// ReadNine reads values with NineBit set for bytes received with the
+// ninth bit. Like Read it returns whatever has arrived, waiting up to the
+// ReadTimeout.
+func (n *NineBitPort) ReadNine(p []uint16) (int, error) {
+	n.rmx.Lock()
+	defer n.rmx.Unlock()
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if len(n.buf) < len(p) {
+		n.buf = make([]byte, len(p))
+	}
+	for {
+		// Never more raw Bytes than fit decoded, so nothing is left over
+		c, err := n.Port.Read(n.buf[:len(p)])
+		k := 0
+		for _, x := range n.buf[:c] {
+			if !n.marks {
+				p[k] = uint16(x)
+				k++
+				continue
+			}
+			switch n.esc {
+			case 0:
+				if x == 0xFF {
+					n.esc = 1
+					continue
+				}
+				p[k] = uint16(x)
+				k++
+			case 1:
+				if x == 0xFF {
+					n.esc = 0
+					p[k] = 0xFF
+					k++
+					continue
+				}
+				// 0xFF 0x00 marks the next Byte
+				n.esc = 2
+			default:
+				n.esc = 0
+				p[k] = NineBit | uint16(x)
+				k++
+			}
+		}
+		// Half a Mark Sequence waits for the rest
+		if k > 0 || c == 0 || err != nil {
+			return k, err
+		}
+	}
+}
+
+// ReadNineContext is ReadNine waiting for input as ReadContext does
+func (n *NineBitPort) ReadNineContext(ctx context.Context, p []uint16) (int, error) {
+	return readWaiting(ctx, n, func() (int, error) { return n.ReadNine(p) })
+}
+
+// Flush discards input and output, including half a mark sequence read
+func (n *NineBitPort) Flush() error {
+	n.rmx.Lock()
+	n.esc = 0
+	n.rmx.Unlock()
+	return n.Port.Flush()
+}
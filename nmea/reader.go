@@ -0,0 +1,58 @@
+package nmea
+
+import (
+	"context"
+	"io"
+
+	"github.com/packing/xserial"
+)
+
+// Reader reads sentences from a Port
+type Reader struct {
+	dec *Decoder
+	rd  *xserial.FrameReader
+}
+
+// NewReader returns a Reader on p
+func NewReader(p xserial.Port) *Reader {
+	dec := NewDecoder()
+	return &Reader{dec: dec, rd: xserial.NewFrameReader(p, dec)}
+}
+
+// ReadSentence returns the next valid sentence, with Read errors such as
+// ErrReadTimeout returned as they occur
+func (r *Reader) ReadSentence(ctx context.Context) (*Sentence, error) {
+	for {
+		b, err := r.rd.ReadFrameContext(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if s, err := Parse(string(b)); err == nil {
+			return s, nil
+		}
+	}
+}
+
+// Decoder returns the Reader's Decoder, to set Strict or read Resyncs
+func (r *Reader) Decoder() *Decoder {
+	return r.dec
+}
+
+// Talker sends sentences under one talker ID
+type Talker struct {
+	w  io.Writer
+	id string
+}
+
+// NewTalker returns a Talker writing to w, typically a Port, as id such as
+// "GP" or "II"
+func NewTalker(w io.Writer, id string) *Talker {
+	return &Talker{w: w, id: id}
+}
+
+// Send writes one sentence of type typ with its checksum and CR LF
+func (t *Talker) Send(typ string, fields ...string) error {
+	s := Sentence{Talker: t.id, Type: typ, Fields: fields}
+	_, err := io.WriteString(t.w, s.String()+"\r\n")
+	return err
+}
@@ -0,0 +1,159 @@
+// Package nmea reads and writes NMEA 0183 sentences, as sent by GPS and
+// other navigation receivers: framing with checksum validation, typed
+// parsing of the common GGA, RMC and GSV sentences and a talker for sending.
+package nmea
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/packing/xserial/checksum"
+)
+
+// MaxLength is the longest sentence a Decoder accepts. The standard allows
+// 82 characters but many receivers send longer proprietary sentences.
+const MaxLength = 256
+
+var (
+	// ErrChecksum - the sentence was damaged
+	ErrChecksum = errors.New("nmea: checksum mismatch")
+	// ErrFormat - the text is not an NMEA sentence
+	ErrFormat = errors.New("nmea: malformed sentence")
+)
+
+// Sentence is one sentence split into its fields
+type Sentence struct {
+	// '$' for parametric sentences, '!' for encapsulated ones such as AIS
+	Start byte
+	// Talker such as "GP" or "GN"; "P" for proprietary sentences
+	Talker string
+	// Sentence Type such as "GGA"; for proprietary sentences the
+	// manufacturer code and type
+	Type   string
+	Fields []string
+}
+
+// String formats s with its checksum, without CR LF
+func (s *Sentence) String() string {
+	start := s.Start
+	if start == 0 {
+		start = '$'
+	}
+	body := s.Talker + s.Type
+	if len(s.Fields) > 0 {
+		body += "," + strings.Join(s.Fields, ",")
+	}
+	return fmt.Sprintf("%c%s*%02X", start, body, checksum.XOR([]byte(body)))
+}
+
+// Parse splits a sentence, with or without CR LF, checking its checksum
+// when it has one
+func Parse(line string) (*Sentence, error) {
+	line = strings.TrimRight(line, "\r\n")
+	if len(line) < 2 || line[0] != '$' && line[0] != '!' {
+		return nil, ErrFormat
+	}
+	body := line[1:]
+	if i := strings.LastIndexByte(body, '*'); i >= 0 {
+		sum, err := strconv.ParseUint(body[i+1:], 16, 8)
+		if err != nil || len(body)-i-1 != 2 {
+			return nil, ErrFormat
+		}
+		body = body[:i]
+		if byte(sum) != checksum.XOR([]byte(body)) {
+			return nil, ErrChecksum
+		}
+	}
+	fields := strings.Split(body, ",")
+	addr := fields[0]
+	s := &Sentence{Start: line[0], Fields: fields[1:]}
+	switch {
+	case strings.HasPrefix(addr, "P"):
+		s.Talker, s.Type = "P", addr[1:]
+	case len(addr) >= 5:
+		s.Talker, s.Type = addr[:2], addr[2:]
+	default:
+		return nil, ErrFormat
+	}
+	return s, nil
+}
+
+// Decoder is an xserial.FrameDecoder cutting sentences from '$' or '!' to
+// the line end. It returns each sentence without CR LF; sentences with a bad
+// checksum and runs of noise are dropped and counted as resyncs. Sentences
+// without a checksum are passed on unless Strict is set.
+type Decoder struct {
+	// Require every Sentence to carry a Checksum
+	Strict  bool
+	buf     []byte
+	in      bool
+	stray   bool
+	resyncs uint64
+}
+
+// NewDecoder returns an empty Decoder
+func NewDecoder() *Decoder {
+	return &Decoder{}
+}
+
+func (d *Decoder) Feed(p []byte) [][]byte {
+	var frames [][]byte
+	for _, c := range p {
+		switch {
+		case c == '$' || c == '!':
+			// A Start inside a Sentence abandons it
+			if d.in {
+				d.resync()
+			}
+			d.in, d.stray = true, false
+			d.buf = append(d.buf[:0], c)
+		case !d.in:
+			if c != '\r' && c != '\n' {
+				d.resync()
+			}
+		case c == '\r' || c == '\n':
+			d.in = false
+			if d.valid() {
+				frames = append(frames, append([]byte(nil), d.buf...))
+			} else {
+				d.resync()
+			}
+		case len(d.buf) == MaxLength:
+			d.in = false
+			d.resync()
+		default:
+			d.buf = append(d.buf, c)
+		}
+	}
+	return frames
+}
+
+// valid checks the collected sentence
+func (d *Decoder) valid() bool {
+	i := strings.LastIndexByte(string(d.buf), '*')
+	if i < 0 {
+		return !d.Strict && len(d.buf) > 1
+	}
+	_, err := Parse(string(d.buf))
+	return err == nil
+}
+
+// resync counts the start of a run of discarded input
+func (d *Decoder) resync() {
+	if !d.stray {
+		d.stray = true
+		d.resyncs++
+	}
+}
+
+func (d *Decoder) Resyncs() uint64 {
+	return d.resyncs
+}
+
+func (d *Decoder) Reset() {
+	d.buf = d.buf[:0]
+	d.in = false
+	d.stray = false
+}
@@ -0,0 +1,233 @@
+package nmea
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GGA - Global Positioning System Fix Data
+type GGA struct {
+	Talker string
+	// UTC Time of Day of the Fix
+	Time time.Duration
+	// Degrees, negative South and West
+	Latitude  float64
+	Longitude float64
+	// Fix Quality - 0 invalid, 1 GPS, 2 DGPS, 4 RTK fixed, 5 RTK float
+	Quality    int
+	Satellites int
+	HDOP       float64
+	// Metres above Mean Sea Level
+	Altitude float64
+	// Metres from the WGS84 Ellipsoid to Mean Sea Level
+	GeoidSeparation float64
+	// Seconds since the last DGPS Update, and the Station used
+	DGPSAge     float64
+	DGPSStation string
+}
+
+// RMC - Recommended Minimum Navigation Information
+type RMC struct {
+	Talker string
+	// UTC Date and Time of the Fix
+	Time time.Time
+	// Status A - the Fix is usable
+	Valid     bool
+	Latitude  float64
+	Longitude float64
+	// Speed over Ground in Knots
+	Speed float64
+	// Course over Ground, Degrees True
+	Course float64
+	// Magnetic Variation, Degrees, negative West
+	Variation float64
+	// FAA Mode Indicator from NMEA 2.3 on, such as "A" autonomous
+	Mode string
+}
+
+// GSV - Satellites in View, spread over several sentences
+type GSV struct {
+	Talker string
+	// Sentences in the Group and the Number of this one, from 1
+	Total  int
+	Number int
+	InView int
+	// Up to four per Sentence
+	Satellites []SatelliteInView
+}
+
+// SatelliteInView is one satellite of a GSV sentence
+type SatelliteInView struct {
+	PRN int
+	// Degrees
+	Elevation int
+	Azimuth   int
+	// dB-Hz, -1 while not tracked
+	SNR int
+}
+
+// fields parses the fields of a sentence, keeping the first error
+type fields struct {
+	s   *Sentence
+	err error
+}
+
+// str returns field i, empty if the sentence has fewer
+func (f *fields) str(i int) string {
+	if i < len(f.s.Fields) {
+		return f.s.Fields[i]
+	}
+	return ""
+}
+
+func (f *fields) fail(err error) {
+	if err != nil && f.err == nil {
+		f.err = ErrFormat
+	}
+}
+
+// number parses an optional decimal field
+func (f *fields) number(i int) float64 {
+	v := f.str(i)
+	if v == "" {
+		return 0
+	}
+	n, err := strconv.ParseFloat(v, 64)
+	f.fail(err)
+	return n
+}
+
+// integer parses an optional integer field, def when empty
+func (f *fields) integer(i, def int) int {
+	v := f.str(i)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	f.fail(err)
+	return n
+}
+
+// clock parses hhmmss.sss into a time of day
+func (f *fields) clock(i int) time.Duration {
+	v := f.str(i)
+	if v == "" {
+		return 0
+	}
+	if len(v) < 6 {
+		f.fail(ErrFormat)
+		return 0
+	}
+	h, err := strconv.Atoi(v[0:2])
+	f.fail(err)
+	m, err := strconv.Atoi(v[2:4])
+	f.fail(err)
+	sec, err := strconv.ParseFloat(v[4:], 64)
+	f.fail(err)
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute + time.Duration(sec*float64(time.Second)+0.5)
+}
+
+// coordinate parses ddmm.mmmm or dddmm.mmmm at i and its hemisphere at i+1
+// into degrees
+func (f *fields) coordinate(i int) float64 {
+	v, hemi := f.str(i), f.str(i+1)
+	if v == "" {
+		return 0
+	}
+	dot := strings.IndexByte(v, '.')
+	if dot < 0 {
+		dot = len(v)
+	}
+	if dot < 3 {
+		f.fail(ErrFormat)
+		return 0
+	}
+	deg, err := strconv.Atoi(v[:dot-2])
+	f.fail(err)
+	min, err := strconv.ParseFloat(v[dot-2:], 64)
+	f.fail(err)
+	c := float64(deg) + min/60
+	switch hemi {
+	case "S", "W":
+		c = -c
+	case "N", "E":
+	default:
+		f.fail(ErrFormat)
+	}
+	return c
+}
+
+// GGA returns s as GGA data
+func (s *Sentence) GGA() (*GGA, error) {
+	if s.Type != "GGA" {
+		return nil, ErrFormat
+	}
+	f := &fields{s: s}
+	g := &GGA{
+		Talker:          s.Talker,
+		Time:            f.clock(0),
+		Latitude:        f.coordinate(1),
+		Longitude:       f.coordinate(3),
+		Quality:         f.integer(5, 0),
+		Satellites:      f.integer(6, 0),
+		HDOP:            f.number(7),
+		Altitude:        f.number(8),
+		GeoidSeparation: f.number(10),
+		DGPSAge:         f.number(12),
+		DGPSStation:     f.str(13),
+	}
+	return g, f.err
+}
+
+// RMC returns s as RMC data
+func (s *Sentence) RMC() (*RMC, error) {
+	if s.Type != "RMC" {
+		return nil, ErrFormat
+	}
+	f := &fields{s: s}
+	r := &RMC{
+		Talker:    s.Talker,
+		Valid:     f.str(1) == "A",
+		Latitude:  f.coordinate(2),
+		Longitude: f.coordinate(4),
+		Speed:     f.number(6),
+		Course:    f.number(7),
+		Variation: f.number(9),
+		Mode:      f.str(11),
+	}
+	if f.str(10) == "W" {
+		r.Variation = -r.Variation
+	}
+	tod := f.clock(0)
+	if d := f.str(8); d != "" {
+		date, err := time.Parse("020106", d)
+		f.fail(err)
+		r.Time = date.Add(tod)
+	}
+	return r, f.err
+}
+
+// GSV returns s as GSV data
+func (s *Sentence) GSV() (*GSV, error) {
+	if s.Type != "GSV" {
+		return nil, ErrFormat
+	}
+	f := &fields{s: s}
+	g := &GSV{
+		Talker: s.Talker,
+		Total:  f.integer(0, 0),
+		Number: f.integer(1, 0),
+		InView: f.integer(2, 0),
+	}
+	// Blocks of Four Fields; NMEA 4.1 may append a Signal ID
+	for i := 3; i+4 <= len(s.Fields); i += 4 {
+		g.Satellites = append(g.Satellites, SatelliteInView{
+			PRN:       f.integer(i, 0),
+			Elevation: f.integer(i+1, 0),
+			Azimuth:   f.integer(i+2, 0),
+			SNR:       f.integer(i+3, -1),
+		})
+	}
+	return g, f.err
+}
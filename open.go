@@ -0,0 +1,92 @@
+package xserial
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// How often OpenContext retries while the device is missing
+const openRetryInterval = 100 * time.Millisecond
+
+// isAbsent reports whether an Open failed because the device is not there
+// (yet), as when a USB adapter is still enumerating
+func isAbsent(err error) bool {
+	return errors.Is(err, os.ErrNotExist) || errors.Is(err, syscall.ENODEV) ||
+		errors.Is(err, syscall.ENXIO) || err == ErrDeviceRemoved
+}
+
+// OpenContext is OpenPort waiting for the device to appear. While cfg.Name
+// does not exist it retries, giving up with ctx.Err() once ctx is done;
+// any other error is returned at once.
+func OpenContext(ctx context.Context, cfg *Config) (Port, error) {
+	t := time.NewTicker(openRetryInterval)
+	defer t.Stop()
+	waiting := false
+	for {
+		p, err := open(cfg)
+		if err == nil {
+			logOpened(cfg)
+			return p, nil
+		}
+		if !isAbsent(err) {
+			loggerFor(cfg).Log(LevelDebug, "serial port open failed", "port", cfg.Name, "err", err)
+			return nil, err
+		}
+		if !waiting {
+			waiting = true
+			loggerFor(cfg).Log(LevelDebug, "waiting for serial port", "port", cfg.Name)
+		}
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// OpenFirstMatching lists the ports and opens the first one match accepts,
+// using cfg with Name replaced. Ports another process already holds are
+// skipped, so two services sharing a predicate each get a different device.
+// cfg may be nil. It returns ErrNoPort if nothing matches.
+func OpenFirstMatching(match func(PortInfo) bool, cfg *Config) (Port, PortInfo, error) {
+	infos, err := ListPorts()
+	if err != nil {
+		return nil, PortInfo{}, err
+	}
+	var c Config
+	if cfg != nil {
+		c = *cfg
+	}
+	err = ErrNoPort
+	for _, info := range infos {
+		if !match(info) {
+			continue
+		}
+		c.Name = info.Name
+		var p Port
+		if p, err = OpenPort(&c); err == nil {
+			return p, info, nil
+		}
+		// Held Elsewhere or Gone since Listing - Try the Next
+		if err == ErrAlreadyOpen || errors.Is(err, syscall.EBUSY) || isAbsent(err) {
+			continue
+		}
+		return nil, PortInfo{}, err
+	}
+	return nil, PortInfo{}, err
+}
+
+// MatchUSB returns an OpenFirstMatching predicate for USB adapters with the
+// given IDs; empty arguments match anything. IDs compare case-insensitively.
+func MatchUSB(vid, pid, serial string) func(PortInfo) bool {
+	return func(info PortInfo) bool {
+		return info.USB &&
+			(vid == "" || strings.EqualFold(info.VID, vid)) &&
+			(pid == "" || strings.EqualFold(info.PID, pid)) &&
+			(serial == "" || info.SerialNumber == serial)
+	}
+}
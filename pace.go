@@ -0,0 +1,71 @@
+package xserial
+
+import (
+	"sync"
+	"time"
+)
+
+// PaceConfig configures a PacedPort
+type PaceConfig struct {
+	// Idle Time inserted between Bytes of a Write
+	ByteDelay time.Duration
+	// Minimum Idle Time between the end of one Write and the start of the next
+	FrameGap time.Duration
+}
+
+// PacedPort throttles writes for devices that drop bytes sent back to back.
+// Each Write is treated as one frame.
+type PacedPort struct {
+	Port
+	cfg  PaceConfig
+	mx   sync.Mutex
+	last time.Time
+}
+
+// NewPacedPort wraps p so its writes follow cfg
+func NewPacedPort(p Port, cfg PaceConfig) *PacedPort {
+	return &PacedPort{Port: p, cfg: cfg}
+}
+
+// Unwrap returns the paced Port
+func (p *PacedPort) Unwrap() Port {
+	return p.Port
+}
+
+// Write sends p honouring the frame gap and inter-byte delay; output is
+// drained before each pause so the gaps appear on the wire
+func (p *PacedPort) Write(b []byte) (n int, err error) {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+
+	if p.cfg.FrameGap > 0 && !p.last.IsZero() {
+		if wait := p.cfg.FrameGap - time.Since(p.last); wait > 0 {
+			time.Sleep(wait)
+		}
+	}
+	defer func() {
+		p.last = time.Now()
+	}()
+
+	if p.cfg.ByteDelay <= 0 {
+		n, err = p.Port.Write(b)
+		if err == nil {
+			err = p.Port.Drain()
+		}
+		return n, err
+	}
+	for i := range b {
+		c, err := p.Port.Write(b[i : i+1])
+		n += c
+		if err != nil {
+			return n, err
+		}
+		if err = p.Port.Drain(); err != nil {
+			return n, err
+		}
+		if i < len(b)-1 {
+			time.Sleep(p.cfg.ByteDelay)
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,58 @@
+// Copyright 2021 Abhijit Bose. All rights reserved.
+
+//go:build darwin
+// +build darwin
+
+package xserial
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// kqueue based Poller Backend
+type pollBackend struct {
+	kq  int
+	evs []unix.Kevent_t
+}
+
+func newPollBackend() (pollBackend, error) {
+	fd, err := unix.Kqueue()
+	if err != nil {
+		return pollBackend{}, err
+	}
+	return pollBackend{kq: fd, evs: make([]unix.Kevent_t, 64)}, nil
+}
+
+func (b pollBackend) change(fd int, flags int) error {
+	var ev unix.Kevent_t
+	unix.SetKevent(&ev, fd, unix.EVFILT_READ, flags)
+	_, err := unix.Kevent(b.kq, []unix.Kevent_t{ev}, nil, nil)
+	return err
+}
+
+func (b pollBackend) add(fd int) error {
+	return b.change(fd, unix.EV_ADD)
+}
+
+func (b pollBackend) del(fd int) error {
+	return b.change(fd, unix.EV_DELETE)
+}
+
+// wait blocks until descriptors are ready and stores them in ready
+func (b pollBackend) wait(ready []int) (int, error) {
+	if len(ready) < len(b.evs) {
+		b.evs = b.evs[:len(ready)]
+	}
+	n, err := unix.Kevent(b.kq, nil, b.evs, nil)
+	if err != nil {
+		return 0, err
+	}
+	for i := 0; i < n; i++ {
+		ready[i] = int(b.evs[i].Ident)
+	}
+	return n, nil
+}
+
+func (b pollBackend) close() error {
+	return unix.Close(b.kq)
+}
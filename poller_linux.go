@@ -0,0 +1,52 @@
+// Copyright 2021 Abhijit Bose. All rights reserved.
+
+//go:build linux
+// +build linux
+
+package xserial
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// epoll based Poller Backend
+type pollBackend struct {
+	epfd int
+	evs  []unix.EpollEvent
+}
+
+func newPollBackend() (pollBackend, error) {
+	fd, err := unix.EpollCreate1(unix.EPOLL_CLOEXEC)
+	if err != nil {
+		return pollBackend{}, err
+	}
+	return pollBackend{epfd: fd, evs: make([]unix.EpollEvent, 64)}, nil
+}
+
+func (b pollBackend) add(fd int) error {
+	ev := unix.EpollEvent{Events: unix.EPOLLIN, Fd: int32(fd)}
+	return unix.EpollCtl(b.epfd, unix.EPOLL_CTL_ADD, fd, &ev)
+}
+
+func (b pollBackend) del(fd int) error {
+	return unix.EpollCtl(b.epfd, unix.EPOLL_CTL_DEL, fd, nil)
+}
+
+// wait blocks until descriptors are ready and stores them in ready
+func (b pollBackend) wait(ready []int) (int, error) {
+	if len(ready) < len(b.evs) {
+		b.evs = b.evs[:len(ready)]
+	}
+	n, err := unix.EpollWait(b.epfd, b.evs, -1)
+	if err != nil {
+		return 0, err
+	}
+	for i := 0; i < n; i++ {
+		ready[i] = int(b.evs[i].Fd)
+	}
+	return n, nil
+}
+
+func (b pollBackend) close() error {
+	return unix.Close(b.epfd)
+}
@@ -0,0 +1,208 @@
+// Copyright 2021 Abhijit Bose. All rights reserved.
+
+//go:build linux || darwin
+// +build linux darwin
+
+package xserial
+
+import (
+	"context"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// Poller services many open Ports from a single goroutine. Handlers are
+// invoked from Run whenever their Port has data waiting; readiness is level
+// triggered so a handler that does not drain its Port is called again.
+type Poller struct {
+	mx       sync.Mutex
+	backend  pollBackend
+	handlers map[int]pollEntry
+	// Self Pipe used to Interrupt Run
+	wakeR, wakeW int
+	closed       bool
+	// Runs in Progress; the last to return after Close releases the Poller
+	running int
+}
+
+type pollEntry struct {
+	port    Port
+	handler func(Port)
+}
+
+// NewPoller creates an empty Poller
+func NewPoller() (*Poller, error) {
+	b, err := newPollBackend()
+	if err != nil {
+		return nil, err
+	}
+	var fds [2]int
+	if err = unix.Pipe(fds[:]); err != nil {
+		b.close()
+		return nil, err
+	}
+	unix.SetNonblock(fds[0], true)
+	unix.SetNonblock(fds[1], true)
+	if err = b.add(fds[0]); err != nil {
+		b.close()
+		unix.Close(fds[0])
+		unix.Close(fds[1])
+		return nil, err
+	}
+	return &Poller{
+		backend:  b,
+		handlers: make(map[int]pollEntry),
+		wakeR:    fds[0],
+		wakeW:    fds[1],
+	}, nil
+}
+
+// Add registers an open Port and the handler to call when it is readable
+func (p *Poller) Add(port Port, handler func(Port)) error {
+	fd, ok := portFD(port)
+	if !ok {
+		return ErrNotPollable
+	}
+
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	if p.closed {
+		return ErrPortClosed
+	}
+	if fd <= 0 {
+		return ErrNotOpen
+	}
+	if _, ok := p.handlers[fd]; ok {
+		return ErrAlreadyOpen
+	}
+	if err := p.backend.add(fd); err != nil {
+		return err
+	}
+	p.handlers[fd] = pollEntry{port: port, handler: handler}
+	return nil
+}
+
+// Remove unregisters a Port; it must be called before the Port is closed
+func (p *Poller) Remove(port Port) error {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	for fd, e := range p.handlers {
+		if e.port == port {
+			delete(p.handlers, fd)
+			return p.backend.del(fd)
+		}
+	}
+	return ErrPortNotInitialized
+}
+
+// Run dispatches readable events until ctx is done or the Poller is closed
+func (p *Poller) Run(ctx context.Context) error {
+	p.mx.Lock()
+	if p.closed {
+		p.mx.Unlock()
+		return nil
+	}
+	p.running++
+	p.mx.Unlock()
+	defer p.exit()
+
+	// Joined before Run returns, so wake never writes to a released fd
+	stop := make(chan struct{})
+	exited := make(chan struct{})
+	defer func() {
+		close(stop)
+		<-exited
+	}()
+	go func() {
+		defer close(exited)
+		select {
+		case <-ctx.Done():
+			p.wake()
+		case <-stop:
+		}
+	}()
+
+	ready := make([]int, 64)
+	for {
+		n, err := p.backend.wait(ready)
+		if err == unix.EINTR {
+			continue
+		}
+		if err != nil {
+			if p.isClosed() {
+				return nil
+			}
+			return err
+		}
+		for _, fd := range ready[:n] {
+			if fd == p.wakeR {
+				var b [64]byte
+				for {
+					if _, err := unix.Read(p.wakeR, b[:]); err != nil {
+						break
+					}
+				}
+				continue
+			}
+			p.mx.Lock()
+			e, ok := p.handlers[fd]
+			p.mx.Unlock()
+			if ok && e.handler != nil {
+				e.handler(e.port)
+			}
+		}
+
+		if p.isClosed() {
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+}
+
+func (p *Poller) isClosed() bool {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	return p.closed
+}
+
+// exit ends a Run, releasing the Poller if it was closed meanwhile
+func (p *Poller) exit() {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	p.running--
+	if p.closed && p.running == 0 {
+		p.release()
+	}
+}
+
+func (p *Poller) wake() {
+	unix.Write(p.wakeW, []byte{0})
+}
+
+// Close stops Run and releases the Poller; registered Ports stay open. While
+// Run is still returning the release is left to it, so Close may be called
+// from a handler.
+func (p *Poller) Close() error {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	if p.closed {
+		return ErrPortClosed
+	}
+	p.closed = true
+	p.handlers = nil
+	if p.running > 0 {
+		p.wake()
+		return nil
+	}
+	return p.release()
+}
+
+// release closes the wake Pipe and the backend once no Run is polling them
+func (p *Poller) release() error {
+	unix.Close(p.wakeW)
+	unix.Close(p.wakeR)
+	return p.backend.close()
+}
@@ -0,0 +1,61 @@
+package xserial
+
+import (
+	"time"
+)
+
+// PortInfo describes a serial device found by ListPorts or reported by a
+// Monitor. USB fields are empty for other devices and on platforms that
+// cannot discover them.
+type PortInfo struct {
+	// Device to pass as Config.Name, such as /dev/ttyUSB0 or COM3
+	Name string
+	// Kernel Driver, such as ftdi_sio or cp210x
+	Driver string
+	// Set for USB Adapters
+	USB bool
+	// USB Vendor and Product ID as four Hex Digits, such as 0403 and 6001
+	VID, PID     string
+	SerialNumber string
+	Manufacturer string
+	Product      string
+	// USB Interface Number on Multi-Port Adapters
+	Interface string
+	// Persistent Linux Names under /dev/serial/by-id and by-path, which
+	// survive re-enumeration unlike /dev/ttyUSBx
+	ByID   string
+	ByPath string
+}
+
+// PortAction is the kind of a PortEvent
+type PortAction int
+
+const (
+	// PortAdded is sent once a device is ready to be opened
+	PortAdded PortAction = iota
+	// PortRemoved is sent when a device has gone away
+	PortRemoved
+)
+
+func (a PortAction) String() string {
+	if a == PortAdded {
+		return "add"
+	}
+	return "remove"
+}
+
+// PortEvent reports a serial device appearing or going away
+type PortEvent struct {
+	Action PortAction
+	Port   PortInfo
+	Time   time.Time
+}
+
+// MonitorConfig configures a Monitor
+type MonitorConfig struct {
+	// Listen to raw kernel uevents instead of udev. They arrive before udev
+	// has created symlinks and set permissions, but work without udevd.
+	Kernel bool
+	// Channel Capacity, defaults to 16
+	Depth int
+}
@@ -0,0 +1,36 @@
+// Copyright 2021 Abhijit Bose. All rights reserved.
+
+//go:build darwin
+// +build darwin
+
+package xserial
+
+import (
+	"path/filepath"
+	"sort"
+)
+
+// ListPorts returns the callout devices present, sorted by Name. USB
+// details are not discovered on macOS.
+func ListPorts() ([]PortInfo, error) {
+	names, err := filepath.Glob("/dev/cu.*")
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	ports := make([]PortInfo, 0, len(names))
+	for _, n := range names {
+		ports = append(ports, PortInfo{Name: n})
+	}
+	return ports, nil
+}
+
+// StablePaths returns empty strings as macOS has no persistent aliases
+func StablePaths(dev string) (byID, byPath string) {
+	return "", ""
+}
+
+// ResolvePort returns name unchanged
+func ResolvePort(name string) (string, error) {
+	return name, nil
+}
@@ -0,0 +1,117 @@
+// Copyright 2021 Abhijit Bose. All rights reserved.
+
+//go:build linux
+// +build linux
+
+package xserial
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const sysClassTTY = "/sys/class/tty"
+
+// ListPorts returns the serial devices present, sorted by Name. Virtual
+// consoles and unused legacy UARTs are skipped.
+func ListPorts() ([]PortInfo, error) {
+	entries, err := ioutil.ReadDir(sysClassTTY)
+	if err != nil {
+		return nil, err
+	}
+	var ports []PortInfo
+	for _, e := range entries {
+		if info, ok := sysPortInfo(e.Name()); ok {
+			ports = append(ports, info)
+		}
+	}
+	sort.Slice(ports, func(i, j int) bool { return ports[i].Name < ports[j].Name })
+	return ports, nil
+}
+
+// readSys returns the trimmed contents of a sysfs attribute
+func readSys(path string) string {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// sysPortInfo describes the tty called name from sysfs
+func sysPortInfo(name string) (PortInfo, bool) {
+	dir := filepath.Join(sysClassTTY, name)
+	// Only ttys backed by Hardware have a device Link
+	dev, err := filepath.EvalSymlinks(filepath.Join(dir, "device"))
+	if err != nil {
+		return PortInfo{}, false
+	}
+	info := PortInfo{Name: "/dev/" + name}
+	if drv, err := os.Readlink(filepath.Join(dev, "driver")); err == nil {
+		info.Driver = filepath.Base(drv)
+	}
+	// Legacy 8250 Ports exist whether or not a UART is fitted
+	if info.Driver == "serial8250" && readSys(filepath.Join(dir, "type")) == "0" {
+		return PortInfo{}, false
+	}
+	// Walk up from the Interface to the USB Device
+	for d := dev; d != "/" && d != "."; d = filepath.Dir(d) {
+		if info.Interface == "" {
+			info.Interface = readSys(filepath.Join(d, "bInterfaceNumber"))
+		}
+		if vid := readSys(filepath.Join(d, "idVendor")); vid != "" {
+			info.USB = true
+			info.VID = vid
+			info.PID = readSys(filepath.Join(d, "idProduct"))
+			info.SerialNumber = readSys(filepath.Join(d, "serial"))
+			info.Manufacturer = readSys(filepath.Join(d, "manufacturer"))
+			info.Product = readSys(filepath.Join(d, "product"))
+			break
+		}
+	}
+	if !info.USB {
+		info.Interface = ""
+	}
+	info.ByID, info.ByPath = StablePaths(info.Name)
+	return info, true
+}
+
+// Directories of the udev maintained persistent Symlinks
+const (
+	serialByID   = "/dev/serial/by-id"
+	serialByPath = "/dev/serial/by-path"
+)
+
+// StablePaths returns the /dev/serial/by-id and by-path symlinks pointing
+// at dev, such as /dev/ttyUSB0, or empty strings where udev made none
+func StablePaths(dev string) (byID, byPath string) {
+	real, err := filepath.EvalSymlinks(dev)
+	if err != nil {
+		return "", ""
+	}
+	return findLink(serialByID, real), findLink(serialByPath, real)
+}
+
+// findLink returns the first symlink in dir resolving to target
+func findLink(dir, target string) string {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return ""
+	}
+	for _, e := range entries {
+		link := filepath.Join(dir, e.Name())
+		if r, err := filepath.EvalSymlinks(link); err == nil && r == target {
+			return link
+		}
+	}
+	return ""
+}
+
+// ResolvePort returns the device node behind a persistent name such as
+// /dev/serial/by-id/usb-FTDI_..., or name itself if it is no symlink
+func ResolvePort(name string) (string, error) {
+	return filepath.EvalSymlinks(name)
+}
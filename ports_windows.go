@@ -0,0 +1,47 @@
+// Copyright 2021 Abhijit Bose. All rights reserved.
+
+package xserial
+
+import (
+	"sort"
+
+	"golang.org/x/sys/windows/registry"
+)
+
+// ListPorts returns the COM ports registered with Windows, sorted by Name.
+// USB details are not discovered on Windows.
+func ListPorts() ([]PortInfo, error) {
+	k, err := registry.OpenKey(registry.LOCAL_MACHINE, `HARDWARE\DEVICEMAP\SERIALCOMM`, registry.QUERY_VALUE)
+	if err == registry.ErrNotExist {
+		// No Ports at all
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer k.Close()
+	values, err := k.ReadValueNames(-1)
+	if err != nil {
+		return nil, err
+	}
+	var ports []PortInfo
+	for _, v := range values {
+		name, _, err := k.GetStringValue(v)
+		if err != nil {
+			continue
+		}
+		ports = append(ports, PortInfo{Name: name})
+	}
+	sort.Slice(ports, func(i, j int) bool { return ports[i].Name < ports[j].Name })
+	return ports, nil
+}
+
+// StablePaths returns empty strings as Windows has no persistent aliases
+func StablePaths(dev string) (byID, byPath string) {
+	return "", ""
+}
+
+// ResolvePort returns name unchanged
+func ResolvePort(name string) (string, error) {
+	return name, nil
+}
@@ -0,0 +1,280 @@
+package xserial
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Capture Format - one line per chunk:
+//
+//	<nanoseconds since start> <R|W> <hex data>
+//
+// R is data the device sent (read by the application), W is data the
+// application wrote.
+
+// ErrReplayMismatch is returned by a strict Replayer when the application
+// writes something other than what was recorded
+var ErrReplayMismatch = newError("replay: written data does not match capture", nil, false)
+
+// recorderPort writes all traffic of the wrapped Port to a capture
+type recorderPort struct {
+	Port
+	mx    sync.Mutex
+	w     io.Writer
+	start time.Time
+}
+
+// NewRecorder wraps p so every chunk read or written is appended to w in
+// the capture format understood by NewReplayer
+func NewRecorder(p Port, w io.Writer) Port {
+	return &recorderPort{Port: p, w: w, start: time.Now()}
+}
+
+// Unwrap returns the recorded Port
+func (r *recorderPort) Unwrap() Port {
+	return r.Port
+}
+
+func (r *recorderPort) record(dir byte, data []byte) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	fmt.Fprintf(r.w, "%d %c %s\n", time.Since(r.start).Nanoseconds(), dir, hex.EncodeToString(data))
+}
+
+func (r *recorderPort) Read(p []byte) (int, error) {
+	n, err := r.Port.Read(p)
+	if n > 0 {
+		r.record('R', p[:n])
+	}
+	return n, err
+}
+
+func (r *recorderPort) Write(p []byte) (int, error) {
+	n, err := r.Port.Write(p)
+	if n > 0 {
+		r.record('W', p[:n])
+	}
+	return n, err
+}
+
+// ReplayConfig configures a Replayer
+type ReplayConfig struct {
+	// How long Read waits for recorded device data, zero waits forever
+	ReadTimeout time.Duration
+	// Fail Writes that differ from the capture with ErrReplayMismatch
+	Strict bool
+}
+
+type replayRecord struct {
+	at   time.Duration
+	dir  byte
+	data []byte
+}
+
+// Replayer is a Port that plays back the device side of a capture. Device
+// data becomes readable with its recorded timing relative to the preceding
+// chunk, and recorded application writes must happen before the device
+// data that followed them is released.
+type Replayer struct {
+	cfg    ReplayConfig
+	mx     sync.Mutex
+	recs   []replayRecord
+	next   int           // Current Record
+	off    int           // Bytes of the Current Record already consumed
+	last   time.Time     // When the previous Record was consumed
+	lastAt time.Duration // Recorded Offset of the previous Record
+	stats  Stats
+	notify chan struct{}
+	done   chan struct{}
+	events chan Event
+	closed bool
+}
+
+// NewReplayer loads a capture written by NewRecorder. cfg may be nil.
+func NewReplayer(r io.Reader, cfg *ReplayConfig) (*Replayer, error) {
+	rp := &Replayer{
+		notify: make(chan struct{}, 1),
+		done:   make(chan struct{}),
+		events: make(chan Event),
+		last:   time.Now(),
+	}
+	if cfg != nil {
+		rp.cfg = *cfg
+	}
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 1024*1024)
+	for line := 1; sc.Scan(); line++ {
+		f := strings.Fields(sc.Text())
+		if len(f) == 0 {
+			continue
+		}
+		if len(f) != 3 || (f[1] != "R" && f[1] != "W") {
+			return nil, fmt.Errorf("replay: line %d: malformed record", line)
+		}
+		ns, err := strconv.ParseInt(f[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("replay: line %d: %v", line, err)
+		}
+		data, err := hex.DecodeString(f[2])
+		if err != nil {
+			return nil, fmt.Errorf("replay: line %d: %v", line, err)
+		}
+		rp.recs = append(rp.recs, replayRecord{at: time.Duration(ns), dir: f[1][0], data: data})
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return rp, nil
+}
+
+// advance consumes n bytes of the current record with mx held
+func (r *Replayer) advance(n int) {
+	r.off += n
+	if r.off < len(r.recs[r.next].data) {
+		return
+	}
+	r.last = time.Now()
+	r.lastAt = r.recs[r.next].at
+	r.next++
+	r.off = 0
+	select {
+	case r.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Read returns recorded device data once it is due. It returns io.EOF at
+// the end of the capture.
+func (r *Replayer) Read(p []byte) (int, error) {
+	var deadline <-chan time.Time
+	if r.cfg.ReadTimeout > 0 {
+		t := time.NewTimer(r.cfg.ReadTimeout)
+		defer t.Stop()
+		deadline = t.C
+	}
+
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	for {
+		if r.closed {
+			return 0, ErrPortClosed
+		}
+		if r.next >= len(r.recs) {
+			return 0, io.EOF
+		}
+		rec := r.recs[r.next]
+		var wait *time.Timer
+		if rec.dir == 'R' {
+			due := r.last.Add(rec.at - r.lastAt)
+			if r.off > 0 || !time.Now().Before(due) {
+				n := copy(p, rec.data[r.off:])
+				r.advance(n)
+				r.stats.BytesRead += uint64(n)
+				return n, nil
+			}
+			wait = time.NewTimer(time.Until(due))
+		} else {
+			// Never fires - the Application has to Write first
+			wait = time.NewTimer(time.Hour)
+		}
+		// Wait for the Data to be due or for the Application to Write
+		r.mx.Unlock()
+		timedOut := false
+		select {
+		case <-wait.C:
+		case <-r.notify:
+		case <-r.done:
+		case <-deadline:
+			timedOut = true
+		}
+		wait.Stop()
+		r.mx.Lock()
+		if timedOut {
+			r.stats.ReadTimeouts++
+			return 0, ErrReadTimeout
+		}
+	}
+}
+
+// Write consumes recorded application writes
+func (r *Replayer) Write(p []byte) (int, error) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	if r.closed {
+		return 0, ErrPortClosed
+	}
+	rest := p
+	for len(rest) > 0 && r.next < len(r.recs) && r.recs[r.next].dir == 'W' {
+		want := r.recs[r.next].data[r.off:]
+		n := len(want)
+		if n > len(rest) {
+			n = len(rest)
+		}
+		if r.cfg.Strict && !bytes.Equal(want[:n], rest[:n]) {
+			r.stats.WriteErrors++
+			return len(p) - len(rest), ErrReplayMismatch
+		}
+		r.advance(n)
+		rest = rest[n:]
+	}
+	if len(rest) > 0 && r.cfg.Strict {
+		r.stats.WriteErrors++
+		return len(p) - len(rest), ErrReplayMismatch
+	}
+	r.stats.BytesWritten += uint64(len(p))
+	return len(p), nil
+}
+
+// Close releases blocked readers; further calls fail with ErrPortClosed
+func (r *Replayer) Close() error {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	if r.closed {
+		return ErrPortClosed
+	}
+	r.closed = true
+	close(r.done)
+	close(r.events)
+	return nil
+}
+
+// SetParity is accepted and ignored
+func (r *Replayer) SetParity(parity string, stopbits int) error {
+	return nil
+}
+
+// Flush is accepted and ignored; recorded data is never discarded
+func (r *Replayer) Flush() error {
+	return nil
+}
+
+// Drain returns immediately
+func (r *Replayer) Drain() error {
+	return nil
+}
+
+// Events never delivers; the channel is closed by Close
+func (r *Replayer) Events() <-chan Event {
+	return r.events
+}
+
+// Stats returns the replayed traffic counters
+func (r *Replayer) Stats() Stats {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	return r.stats
+}
+
+// Done reports whether the whole capture has been replayed
+func (r *Replayer) Done() bool {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	return r.next >= len(r.recs)
+}
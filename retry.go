@@ -0,0 +1,123 @@
+package xserial
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"time"
+)
+
+// IsTransient reports whether err is worth retrying: EAGAIN, EINTR or a
+// timeout
+func IsTransient(err error) bool {
+	return errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.EINTR) || os.IsTimeout(err) ||
+		errors.Is(err, os.ErrDeadlineExceeded)
+}
+
+// RetryConfig configures a RetryPort
+type RetryConfig struct {
+	// Total Attempts per Operation, defaults to 3
+	Attempts int
+	// Delay before the first Retry, doubled for each further one; defaults
+	// to 10ms
+	Backoff time.Duration
+	// Upper bound of the Delay, defaults to 1 second
+	MaxBackoff time.Duration
+	// Optional - Decides which errors are retried, defaults to IsTransient
+	Retryable func(err error) bool
+	// Optional - Called before each Retry with the failed attempt number
+	// (starting at 1) and its error
+	OnRetry func(op string, attempt int, err error)
+	// Receives a Debug record for each Retry, defaults to the package Logger
+	Logger Logger
+}
+
+// RetryPort is a Port wrapper retrying Reads, Writes and Transacts that
+// fail with a transient error, backing off between attempts
+type RetryPort struct {
+	Port
+	cfg RetryConfig
+}
+
+// NewRetryPort wraps p. cfg may be nil.
+func NewRetryPort(p Port, cfg *RetryConfig) *RetryPort {
+	var c RetryConfig
+	if cfg != nil {
+		c = *cfg
+	}
+	if c.Attempts <= 0 {
+		c.Attempts = 3
+	}
+	if c.Backoff <= 0 {
+		c.Backoff = 10 * time.Millisecond
+	}
+	if c.MaxBackoff <= 0 {
+		c.MaxBackoff = time.Second
+	}
+	if c.Retryable == nil {
+		c.Retryable = IsTransient
+	}
+	return &RetryPort{Port: p, cfg: c}
+}
+
+// Unwrap returns the wrapped Port
+func (r *RetryPort) Unwrap() Port {
+	return r.Port
+}
+
+// retry runs fn until it succeeds, fails permanently or runs out of attempts
+func (r *RetryPort) retry(op string, fn func() error) error {
+	delay := r.cfg.Backoff
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= r.cfg.Attempts || !r.cfg.Retryable(err) {
+			return err
+		}
+		loggerFor(&Config{Logger: r.cfg.Logger}).Log(LevelDebug, "retrying", "op", op, "attempt", attempt, "delay", delay, "err", err)
+		if r.cfg.OnRetry != nil {
+			r.cfg.OnRetry(op, attempt, err)
+		}
+		time.Sleep(delay)
+		if delay *= 2; delay > r.cfg.MaxBackoff {
+			delay = r.cfg.MaxBackoff
+		}
+	}
+}
+
+// Read retries until some data arrives or a permanent error occurs
+func (r *RetryPort) Read(b []byte) (n int, err error) {
+	err = r.retry("read", func() error {
+		n, err = r.Port.Read(b)
+		if n > 0 {
+			// Data beats any Error
+			return nil
+		}
+		return err
+	})
+	return n, err
+}
+
+// Write retries the unsent remainder of b
+func (r *RetryPort) Write(b []byte) (n int, err error) {
+	err = r.retry("write", func() error {
+		c, err := r.Port.Write(b[n:])
+		n += c
+		return err
+	})
+	return n, err
+}
+
+// Transact is Transact on the wrapped Port with the retry policy applied
+// instead of TransactConfig.Retries
+func (r *RetryPort) Transact(req []byte, match Matcher, cfg *TransactConfig) (resp []byte, err error) {
+	var c TransactConfig
+	if cfg != nil {
+		c = *cfg
+	}
+	c.Retries = 0
+	err = r.retry("transact", func() error {
+		resp, err = Transact(r.Port, req, match, &c)
+		return err
+	})
+	return resp, err
+}
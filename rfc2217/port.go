@@ -0,0 +1,620 @@
+// Package rfc2217 is a client for RFC 2217, the Telnet COM Port Control
+// Option, as served by ser2net and most terminal servers. A remote Port
+// behaves like a local one: framing changes, flushes, modem lines and
+// breaks are forwarded to the server.
+package rfc2217
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/packing/xserial"
+)
+
+// How long the server has to acknowledge a setting
+const ackTimeout = 3 * time.Second
+
+var (
+	// ErrNotSupported - the server refused the COM Port Control option
+	ErrNotSupported = errors.New("rfc2217: server does not support com port control")
+	// ErrNoAck - the server did not acknowledge a setting in time
+	ErrNoAck = errors.New("rfc2217: no acknowledgement from server")
+)
+
+// Port is an xserial.Port on a remote terminal server. It also implements
+// xserial.LineController and xserial.ReadWaiter.
+type Port struct {
+	conn        net.Conn
+	conf        xserial.Config
+	readTimeout time.Duration
+	parser      parser
+	done        chan struct{}
+	// One Setting in flight at a time
+	cmdMx sync.Mutex
+	// Serialises Writes to conn
+	txMx sync.Mutex
+
+	mx       sync.Mutex
+	rx       []byte
+	notify   chan struct{} // Closed whenever Data, Flow or State change
+	weWill   map[byte]bool
+	weDo     map[byte]bool
+	comPort  chan bool // Server's Answer to WILL COM-PORT-OPTION
+	waits    map[byte]chan []byte
+	lines    int
+	suspend  bool // Server asked us to stop sending
+	eof      bool
+	err      error
+	closed   bool
+	stats    xserial.Stats
+	events   chan xserial.Event
+	rxQueued bool
+}
+
+var (
+	_ xserial.Port           = (*Port)(nil)
+	_ xserial.LineController = (*Port)(nil)
+	_ xserial.ReadWaiter     = (*Port)(nil)
+)
+
+// Open dials the server named by cfg.Name, "rfc2217://host:port" or just
+// "host:port", and applies the framing of cfg
+func Open(cfg *xserial.Config) (*Port, error) {
+	return OpenContext(context.Background(), cfg)
+}
+
+// OpenContext is Open with ctx bounding the dial and the negotiation
+func OpenContext(ctx context.Context, cfg *xserial.Config) (*Port, error) {
+	addr := strings.TrimPrefix(cfg.Name, "rfc2217://")
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, &xserial.PortError{Op: "open", Port: cfg.Name, Err: err}
+	}
+	return NewPort(ctx, conn, cfg)
+}
+
+// NewPort runs RFC 2217 over an established connection, such as a TLS
+// tunnel to the server. The Port owns conn from here on.
+func NewPort(ctx context.Context, conn net.Conn, cfg *xserial.Config) (*Port, error) {
+	p := &Port{
+		conn:        conn,
+		conf:        *cfg,
+		readTimeout: cfg.ReadTimeout * time.Millisecond,
+		done:        make(chan struct{}),
+		notify:      make(chan struct{}),
+		weWill:      map[byte]bool{optBinary: true, optSGA: true, optComPort: true},
+		weDo:        map[byte]bool{optBinary: true, optSGA: true},
+		comPort:     make(chan bool, 1),
+		waits:       make(map[byte]chan []byte),
+		lines:       xserial.LineDTR | xserial.LineRTS,
+	}
+	if p.conf.Baud == 0 {
+		p.conf.Baud = 9600
+	}
+	go p.receive()
+
+	// Offer Binary Transmission and COM Port Control
+	offer := []byte{
+		iac, will, optBinary, iac, do, optBinary,
+		iac, will, optSGA, iac, do, optSGA,
+		iac, will, optComPort,
+	}
+	if err := p.send(offer); err != nil {
+		p.Close()
+		return nil, err
+	}
+	select {
+	case ok := <-p.comPort:
+		if !ok {
+			p.Close()
+			return nil, ErrNotSupported
+		}
+	case <-p.done:
+		p.Close()
+		return nil, p.failure()
+	case <-ctx.Done():
+		p.Close()
+		return nil, ctx.Err()
+	case <-time.After(ackTimeout):
+		p.Close()
+		return nil, ErrNotSupported
+	}
+
+	if err := p.setup(); err != nil {
+		p.Close()
+		return nil, err
+	}
+	return p, nil
+}
+
+// setup applies the opening Configuration on the server
+func (p *Port) setup() error {
+	if err := p.SetBaudRate(p.conf.Baud); err != nil {
+		return err
+	}
+	if _, err := p.command(cmdSetDataSize, []byte{8}); err != nil {
+		return err
+	}
+	if err := p.SetParity(p.conf.Parity, p.conf.StopBits); err != nil {
+		return err
+	}
+	flow := byte(controlNoFlow)
+	switch p.conf.Flow {
+	case xserial.FlowNone:
+	case xserial.FlowHardware:
+		flow = controlHardware
+	case xserial.FlowSoft:
+		flow = controlXONXOFF
+	default:
+		return &xserial.ConfigError{Setting: "flow control", Value: p.conf.Flow}
+	}
+	if _, err := p.command(cmdSetControl, []byte{flow}); err != nil {
+		return err
+	}
+	// Raise DTR and RTS like a local Open and report Line Changes
+	if _, err := p.command(cmdSetControl, []byte{controlDTROn}); err != nil {
+		return err
+	}
+	if p.conf.Flow != xserial.FlowHardware {
+		if _, err := p.command(cmdSetControl, []byte{controlRTSOn}); err != nil {
+			return err
+		}
+	}
+	if _, err := p.command(cmdSetLineStateMask, []byte{0}); err != nil {
+		return err
+	}
+	_, err := p.command(cmdSetModemStateMask, []byte{0xFF})
+	return err
+}
+
+// send writes raw Telnet traffic
+func (p *Port) send(b []byte) error {
+	p.txMx.Lock()
+	defer p.txMx.Unlock()
+	_, err := p.conn.Write(b)
+	return err
+}
+
+// command sends a COM-PORT-OPTION command and waits for the server's answer
+func (p *Port) command(cmd byte, value []byte) ([]byte, error) {
+	p.cmdMx.Lock()
+	defer p.cmdMx.Unlock()
+	ch := make(chan []byte, 1)
+	p.mx.Lock()
+	if p.closed || p.eof {
+		p.mx.Unlock()
+		return nil, xserial.ErrNotOpen
+	}
+	p.waits[cmd+serverOffset] = ch
+	p.mx.Unlock()
+	defer func() {
+		p.mx.Lock()
+		delete(p.waits, cmd+serverOffset)
+		p.mx.Unlock()
+	}()
+
+	msg := append([]byte{iac, sb, optComPort, cmd}, escape(value)...)
+	if err := p.send(append(msg, iac, se)); err != nil {
+		return nil, err
+	}
+	t := time.NewTimer(ackTimeout)
+	defer t.Stop()
+	select {
+	case r := <-ch:
+		return r, nil
+	case <-p.done:
+		return nil, p.failure()
+	case <-t.C:
+		return nil, ErrNoAck
+	}
+}
+
+// failure returns why the connection ended
+func (p *Port) failure() error {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	if p.err != nil {
+		return p.err
+	}
+	return xserial.ErrPortClosed
+}
+
+// receive reads the connection until it fails
+func (p *Port) receive() {
+	buf := make([]byte, 4096)
+	var data []byte
+	for {
+		n, err := p.conn.Read(buf)
+		if n > 0 {
+			data = p.parser.feed(buf[:n], data[:0], p.negotiate, p.subnegotiation)
+			if len(data) > 0 {
+				p.mx.Lock()
+				p.rx = append(p.rx, data...)
+				p.wake()
+				p.mx.Unlock()
+				p.event(xserial.Event{Type: xserial.EventRXAvailable})
+			}
+		}
+		if err != nil {
+			p.mx.Lock()
+			closed := p.closed
+			p.eof = true
+			if err != io.EOF && !closed {
+				p.err = err
+			}
+			p.wake()
+			p.mx.Unlock()
+			close(p.done)
+			if !closed {
+				p.event(xserial.Event{Type: xserial.EventDisconnect, Err: err})
+			}
+			return
+		}
+	}
+}
+
+// negotiate answers the server's option requests, on the receive goroutine
+func (p *Port) negotiate(verb, opt byte) {
+	var reply []byte
+	p.mx.Lock()
+	switch verb {
+	case do:
+		if opt == optComPort {
+			select {
+			case p.comPort <- true:
+			default:
+			}
+		}
+		if _, offered := p.weWill[opt]; !offered {
+			p.weWill[opt] = false
+			reply = []byte{iac, wont, opt}
+		}
+	case dont:
+		if opt == optComPort {
+			select {
+			case p.comPort <- false:
+			default:
+			}
+		}
+		if p.weWill[opt] {
+			p.weWill[opt] = false
+			reply = []byte{iac, wont, opt}
+		}
+	case will:
+		// Refuse what we did not ask for; a Server Echo would corrupt the Data
+		if _, asked := p.weDo[opt]; !asked {
+			p.weDo[opt] = false
+			reply = []byte{iac, dont, opt}
+		}
+	case wont:
+		if p.weDo[opt] {
+			p.weDo[opt] = false
+			reply = []byte{iac, dont, opt}
+		}
+	}
+	p.mx.Unlock()
+	if reply != nil {
+		p.send(reply)
+	}
+}
+
+// subnegotiation handles COM-PORT-OPTION traffic from the server
+func (p *Port) subnegotiation(sub []byte) {
+	if len(sub) < 2 || sub[0] != optComPort {
+		return
+	}
+	cmd, value := sub[1], sub[2:]
+	switch cmd {
+	case cmdNotifyModemState + serverOffset:
+		if len(value) > 0 {
+			p.modemState(value[0])
+		}
+	case cmdFlowControlSuspend + serverOffset, cmdFlowControlResume + serverOffset:
+		p.mx.Lock()
+		p.suspend = cmd == cmdFlowControlSuspend+serverOffset
+		p.wake()
+		p.mx.Unlock()
+	default:
+		p.mx.Lock()
+		ch := p.waits[cmd]
+		p.mx.Unlock()
+		if ch != nil {
+			select {
+			case ch <- append([]byte(nil), value...):
+			default:
+			}
+		}
+	}
+}
+
+// modemState applies a NOTIFY-MODEMSTATE from the server
+func (p *Port) modemState(state byte) {
+	lines := 0
+	if state&modemCTS != 0 {
+		lines |= xserial.LineCTS
+	}
+	if state&modemDSR != 0 {
+		lines |= xserial.LineDSR
+	}
+	if state&modemRI != 0 {
+		lines |= xserial.LineRI
+	}
+	if state&modemCD != 0 {
+		lines |= xserial.LineDCD
+	}
+	p.mx.Lock()
+	lines |= p.lines & (xserial.LineDTR | xserial.LineRTS)
+	changed := lines != p.lines
+	p.lines = lines
+	p.mx.Unlock()
+	if changed {
+		p.event(xserial.Event{Type: xserial.EventLineStatus, Lines: lines})
+	}
+}
+
+// wake releases all waiters with mx held
+func (p *Port) wake() {
+	close(p.notify)
+	p.notify = make(chan struct{})
+}
+
+// event sends e if Events has been called; RX Events are not repeated
+// until the next Read
+func (p *Port) event(e xserial.Event) {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	if p.events == nil || p.closed {
+		return
+	}
+	if e.Type == xserial.EventRXAvailable {
+		if p.rxQueued {
+			return
+		}
+		p.rxQueued = true
+	}
+	e.Time = time.Now()
+	select {
+	case p.events <- e:
+	default:
+	}
+}
+
+// Read returns received bytes, waiting up to ReadTimeout for some to
+// arrive. Once the server hangs up and the data is consumed it returns
+// io.EOF.
+func (p *Port) Read(b []byte) (int, error) {
+	var deadline <-chan time.Time
+	if p.readTimeout > 0 {
+		t := time.NewTimer(p.readTimeout)
+		defer t.Stop()
+		deadline = t.C
+	}
+	for {
+		p.mx.Lock()
+		p.rxQueued = false
+		if p.closed {
+			p.mx.Unlock()
+			return 0, xserial.ErrNotOpen
+		}
+		if len(p.rx) > 0 {
+			n := copy(b, p.rx)
+			p.rx = p.rx[n:]
+			p.stats.BytesRead += uint64(n)
+			p.mx.Unlock()
+			return n, nil
+		}
+		if p.eof {
+			err := p.err
+			if err == nil {
+				err = io.EOF
+			} else {
+				p.stats.ReadErrors++
+			}
+			p.mx.Unlock()
+			return 0, err
+		}
+		notify := p.notify
+		if deadline == nil {
+			p.mx.Unlock()
+			return 0, nil
+		}
+		p.mx.Unlock()
+		select {
+		case <-notify:
+		case <-deadline:
+			p.mx.Lock()
+			p.stats.ReadTimeouts++
+			p.mx.Unlock()
+			return 0, xserial.ErrReadTimeout
+		}
+	}
+}
+
+// WaitReadable blocks until Read would return data or EOF
+func (p *Port) WaitReadable(ctx context.Context) error {
+	for {
+		p.mx.Lock()
+		if p.closed {
+			p.mx.Unlock()
+			return xserial.ErrNotOpen
+		}
+		if len(p.rx) > 0 || p.eof {
+			p.mx.Unlock()
+			return nil
+		}
+		notify := p.notify
+		p.mx.Unlock()
+		select {
+		case <-notify:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Write sends b, waiting while the server has suspended the flow
+func (p *Port) Write(b []byte) (int, error) {
+	for {
+		p.mx.Lock()
+		if p.closed || p.eof {
+			p.mx.Unlock()
+			return 0, xserial.ErrNotOpen
+		}
+		notify := p.notify
+		suspended := p.suspend
+		p.mx.Unlock()
+		if !suspended {
+			break
+		}
+		<-notify
+	}
+	err := p.send(escape(b))
+	p.mx.Lock()
+	if err != nil {
+		p.stats.WriteErrors++
+	} else {
+		p.stats.BytesWritten += uint64(len(b))
+	}
+	p.mx.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Close hangs up on the server
+func (p *Port) Close() error {
+	p.mx.Lock()
+	if p.closed {
+		p.mx.Unlock()
+		return xserial.ErrPortNotInitialized
+	}
+	p.closed = true
+	p.wake()
+	if p.events != nil {
+		close(p.events)
+	}
+	p.mx.Unlock()
+	err := p.conn.Close()
+	<-p.done
+	return err
+}
+
+// SetBaudRate changes the line speed on the server
+func (p *Port) SetBaudRate(baud int) error {
+	v := make([]byte, 4)
+	binary.BigEndian.PutUint32(v, uint32(baud))
+	if _, err := p.command(cmdSetBaudRate, v); err != nil {
+		return err
+	}
+	p.mx.Lock()
+	p.conf.Baud = baud
+	p.mx.Unlock()
+	return nil
+}
+
+// SetParity changes parity and stop bits on the server
+func (p *Port) SetParity(parity string, stopbits int) error {
+	par, ok := parities[parity]
+	if !ok {
+		return &xserial.ConfigError{Setting: "parity", Value: parity}
+	}
+	if stopbits != 1 && stopbits != 2 {
+		return &xserial.ConfigError{Setting: "stop bits", Value: stopbits}
+	}
+	if _, err := p.command(cmdSetParity, []byte{par}); err != nil {
+		return err
+	}
+	_, err := p.command(cmdSetStopSize, []byte{byte(stopbits)})
+	return err
+}
+
+// Flush discards unread input here and both buffers on the server
+func (p *Port) Flush() error {
+	p.mx.Lock()
+	p.rx = nil
+	p.mx.Unlock()
+	_, err := p.command(cmdPurgeData, []byte{purgeBoth})
+	return err
+}
+
+// Drain returns once everything written has reached the server. RFC 2217
+// cannot tell when the server's UART has sent it.
+func (p *Port) Drain() error {
+	p.txMx.Lock()
+	defer p.txMx.Unlock()
+	return nil
+}
+
+// Events returns RX, modem line and disconnect Events; the channel is
+// closed by Close
+func (p *Port) Events() <-chan xserial.Event {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	if p.events == nil {
+		p.events = make(chan xserial.Event, 16)
+		if p.closed {
+			close(p.events)
+		}
+	}
+	return p.events
+}
+
+// Stats returns the traffic counters
+func (p *Port) Stats() xserial.Stats {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	return p.stats
+}
+
+// setLine switches DTR or RTS on the server
+func (p *Port) setLine(line int, on bool, onValue, offValue byte) error {
+	v := offValue
+	if on {
+		v = onValue
+	}
+	if _, err := p.command(cmdSetControl, []byte{v}); err != nil {
+		return err
+	}
+	p.mx.Lock()
+	if on {
+		p.lines |= line
+	} else {
+		p.lines &^= line
+	}
+	p.mx.Unlock()
+	return nil
+}
+
+func (p *Port) SetDTR(on bool) error {
+	return p.setLine(xserial.LineDTR, on, controlDTROn, controlDTROff)
+}
+
+func (p *Port) SetRTS(on bool) error {
+	return p.setLine(xserial.LineRTS, on, controlRTSOn, controlRTSOff)
+}
+
+// ModemLines returns our DTR and RTS and the lines last reported by the
+// server
+func (p *Port) ModemLines() (int, error) {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	return p.lines, nil
+}
+
+// SendBreak holds the remote line in the break condition for d
+func (p *Port) SendBreak(d time.Duration) error {
+	if _, err := p.command(cmdSetControl, []byte{controlBreakOn}); err != nil {
+		return err
+	}
+	time.Sleep(d)
+	_, err := p.command(cmdSetControl, []byte{controlBreakOff})
+	return err
+}
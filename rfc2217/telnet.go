@@ -0,0 +1,155 @@
+package rfc2217
+
+// Telnet Commands
+const (
+	iac  = 255
+	dont = 254
+	do   = 253
+	wont = 252
+	will = 251
+	sb   = 250
+	se   = 240
+)
+
+// Telnet Options
+const (
+	optBinary  = 0
+	optEcho    = 1
+	optSGA     = 3 // Suppress Go Ahead
+	optComPort = 44
+)
+
+// COM-PORT-OPTION Commands from the Client; the Server answers with the
+// Command plus serverOffset
+const (
+	cmdSetBaudRate        = 1
+	cmdSetDataSize        = 2
+	cmdSetParity          = 3
+	cmdSetStopSize        = 4
+	cmdSetControl         = 5
+	cmdNotifyLineState    = 6
+	cmdNotifyModemState   = 7
+	cmdFlowControlSuspend = 8
+	cmdFlowControlResume  = 9
+	cmdSetLineStateMask   = 10
+	cmdSetModemStateMask  = 11
+	cmdPurgeData          = 12
+
+	serverOffset = 100
+)
+
+// SET-CONTROL Values
+const (
+	controlNoFlow   = 1
+	controlXONXOFF  = 2
+	controlHardware = 3
+	controlBreakOn  = 5
+	controlBreakOff = 6
+	controlDTROn    = 8
+	controlDTROff   = 9
+	controlRTSOn    = 11
+	controlRTSOff   = 12
+)
+
+// PURGE-DATA of both Server Buffers
+const purgeBoth = 3
+
+// Modem State Bits of NOTIFY-MODEMSTATE
+const (
+	modemCD  = 0x80
+	modemRI  = 0x40
+	modemDSR = 0x20
+	modemCTS = 0x10
+	// The Lines without their Delta Bits
+	modemLines = 0xF0
+)
+
+var parities = map[string]byte{"N": 1, "O": 2, "E": 3, "M": 4, "S": 5}
+
+// parser splits the Telnet stream from the server into data and option
+// traffic
+type parser struct {
+	state int
+	verb  byte   // Pending WILL / WONT / DO / DONT
+	sub   []byte // Subnegotiation collected so far
+}
+
+// Parser States
+const (
+	stData = iota
+	stIAC
+	stVerb
+	stSub
+	stSubIAC
+)
+
+// feed consumes b, appending data bytes to data and calling negotiate and
+// subnegotiation for option traffic
+func (p *parser) feed(b, data []byte, negotiate func(verb, opt byte), subneg func(sub []byte)) []byte {
+	for _, c := range b {
+		switch p.state {
+		case stData:
+			if c == iac {
+				p.state = stIAC
+			} else {
+				data = append(data, c)
+			}
+		case stIAC:
+			switch c {
+			case iac:
+				data = append(data, iac)
+				p.state = stData
+			case will, wont, do, dont:
+				p.verb, p.state = c, stVerb
+			case sb:
+				p.sub, p.state = p.sub[:0], stSub
+			default:
+				// NOP, Go Ahead and the like carry nothing for a Port
+				p.state = stData
+			}
+		case stVerb:
+			negotiate(p.verb, c)
+			p.state = stData
+		case stSub:
+			if c == iac {
+				p.state = stSubIAC
+			} else {
+				p.sub = append(p.sub, c)
+			}
+		case stSubIAC:
+			switch c {
+			case se:
+				subneg(p.sub)
+				p.state = stData
+			case iac:
+				p.sub = append(p.sub, iac)
+				p.state = stSub
+			default:
+				// Malformed - Drop the Subnegotiation
+				p.state = stData
+			}
+		}
+	}
+	return data
+}
+
+// escape doubles IAC bytes in b
+func escape(b []byte) []byte {
+	n := 0
+	for _, c := range b {
+		if c == iac {
+			n++
+		}
+	}
+	if n == 0 {
+		return b
+	}
+	out := make([]byte, 0, len(b)+n)
+	for _, c := range b {
+		out = append(out, c)
+		if c == iac {
+			out = append(out, iac)
+		}
+	}
+	return out
+}
@@ -0,0 +1,77 @@
+package rs485
+
+import (
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// GPIO Character Device Requests of linux/gpio.h
+const (
+	gpioGetLineHandle      = 0xC16CB403
+	gpioHandleSetLineValue = 0xC040B409
+	gpioHandleRequestOut   = 1 << 1
+	gpioHandleActiveLow    = 1 << 2
+	gpioHandlesMax         = 64
+)
+
+// gpioHandleRequest is struct gpiohandle_request of linux/gpio.h
+type gpioHandleRequest struct {
+	LineOffsets   [gpioHandlesMax]uint32
+	Flags         uint32
+	DefaultValues [gpioHandlesMax]uint8
+	ConsumerLabel [32]byte
+	Lines         uint32
+	FD            int32
+}
+
+// GPIOLine is a Direction driving a GPIO line, for boards wiring the
+// transceiver's DE and /RE pins to a GPIO rather than RTS. Bus then waits
+// the delays and drains around it as for RTS.
+type GPIOLine struct {
+	fd int
+}
+
+// GPIO requests line of chip, such as "gpiochip0" or /dev/gpiochip0, as an
+// output through the GPIO character device, set to receive. The line is
+// high while sending unless activeLow.
+func GPIO(chip string, line int, activeLow bool) (*GPIOLine, error) {
+	if !strings.HasPrefix(chip, "/") {
+		chip = "/dev/" + chip
+	}
+	if line < 0 {
+		return nil, unix.EINVAL
+	}
+	cfd, err := unix.Open(chip, unix.O_RDWR|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer unix.Close(cfd)
+	req := gpioHandleRequest{Flags: gpioHandleRequestOut, Lines: 1}
+	req.LineOffsets[0] = uint32(line)
+	if activeLow {
+		req.Flags |= gpioHandleActiveLow
+	}
+	copy(req.ConsumerLabel[:], "xserial-rs485")
+	if _, _, e1 := unix.Syscall(unix.SYS_IOCTL, uintptr(cfd), gpioGetLineHandle, uintptr(unsafe.Pointer(&req))); e1 != 0 {
+		return nil, e1
+	}
+	return &GPIOLine{fd: int(req.FD)}, nil
+}
+
+func (g *GPIOLine) Transmit(on bool) error {
+	var values [gpioHandlesMax]uint8
+	if on {
+		values[0] = 1
+	}
+	if _, _, e1 := unix.Syscall(unix.SYS_IOCTL, uintptr(g.fd), gpioHandleSetLineValue, uintptr(unsafe.Pointer(&values))); e1 != 0 {
+		return e1
+	}
+	return nil
+}
+
+// Close releases the line, after the Bus using it is done
+func (g *GPIOLine) Close() error {
+	return unix.Close(g.fd)
+}
@@ -0,0 +1,21 @@
+//go:build !linux
+// +build !linux
+
+package rs485
+
+// GPIOLine is a Direction driving a GPIO line, only on Linux
+type GPIOLine struct{}
+
+// GPIO fails, only Linux having the GPIO character device
+func GPIO(chip string, line int, activeLow bool) (*GPIOLine, error) {
+	return nil, ErrNoGPIO
+}
+
+func (g *GPIOLine) Transmit(on bool) error {
+	return ErrNoGPIO
+}
+
+// Close releases the line
+func (g *GPIOLine) Close() error {
+	return ErrNoGPIO
+}
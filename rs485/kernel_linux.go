@@ -0,0 +1,52 @@
+package rs485
+
+import (
+	"time"
+	"unsafe"
+
+	"github.com/packing/xserial"
+	"golang.org/x/sys/unix"
+)
+
+// serial_rs485 Flags
+const (
+	serRS485Enabled      = 1 << 0
+	serRS485RTSOnSend    = 1 << 1
+	serRS485RTSAfterSend = 1 << 2
+	serRS485RXDuringTX   = 1 << 4
+)
+
+// serialRS485 is struct serial_rs485 of linux/serial.h
+type serialRS485 struct {
+	Flags              uint32
+	DelayRTSBeforeSend uint32
+	DelayRTSAfterSend  uint32
+	padding            [5]uint32
+}
+
+// setKernel enables the driver's RS-485 mode on p as cfg describes
+func setKernel(p xserial.Port, cfg *Config) error {
+	io, ok := xserial.As[xserial.Ioctler](p)
+	if !ok {
+		return ErrNoKernel
+	}
+	r := serialRS485{
+		Flags:              serRS485Enabled | serRS485RTSOnSend,
+		DelayRTSBeforeSend: uint32(cfg.DelayBeforeSend / time.Millisecond),
+		DelayRTSAfterSend:  uint32(cfg.DelayAfterSend / time.Millisecond),
+	}
+	if cfg.RTSActiveLow {
+		r.Flags = serRS485Enabled | serRS485RTSAfterSend
+	}
+	if cfg.Echo {
+		r.Flags |= serRS485RXDuringTX
+	}
+	buf := (*[unsafe.Sizeof(r)]byte)(unsafe.Pointer(&r))[:]
+	if _, err := io.Ioctl(unix.TIOCSRS485, xserial.IoctlBuffer(buf)); err != nil {
+		if err == unix.ENOTTY || err == unix.EINVAL {
+			return ErrNoKernel
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,11 @@
+//go:build !linux && !windows
+// +build !linux,!windows
+
+package rs485
+
+import "github.com/packing/xserial"
+
+// setKernel fails, only Linux and Windows drivers switching RTS themselves
+func setKernel(p xserial.Port, cfg *Config) error {
+	return ErrNoKernel
+}
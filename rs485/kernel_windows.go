@@ -0,0 +1,15 @@
+package rs485
+
+import (
+	"github.com/packing/xserial"
+)
+
+// setKernel has the driver raise RTS while sending with RTS_CONTROL_TOGGLE.
+// Windows does so only active high and without turnaround delays.
+func setKernel(p xserial.Port, cfg *Config) error {
+	t, ok := xserial.As[xserial.RTSToggler](p)
+	if !ok || cfg.RTSActiveLow || cfg.DelayBeforeSend > 0 || cfg.DelayAfterSend > 0 {
+		return ErrNoKernel
+	}
+	return t.SetRTSToggle(true)
+}
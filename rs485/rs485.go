@@ -0,0 +1,187 @@
+// Package rs485 coordinates transmit and receive on half-duplex RS-485
+// buses: it enables the line driver around each Write, either by toggling
+// a Direction such as RTS or a GPIO line or by leaving it to the serial
+// driver, waits the turnaround delays, and checks the echo of what was
+// sent to detect collisions with other stations.
+package rs485
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/packing/xserial"
+)
+
+var (
+	// ErrCollision - the echo differed from what was sent, as when another
+	// station drove the bus at the same time
+	ErrCollision = errors.New("rs485: collision detected")
+	// ErrNoEcho - nothing came back, as when the receiver is disabled
+	// while sending
+	ErrNoEcho = errors.New("rs485: no echo received")
+	// ErrNoLines - the Port cannot drive RTS
+	ErrNoLines = errors.New("rs485: port cannot drive RTS")
+	// ErrNoKernel - the Port has no driver RS-485 mode, or not with the
+	// delays and polarity asked for
+	ErrNoKernel = errors.New("rs485: driver RS-485 mode not supported")
+	// ErrNoGPIO - there is no GPIO character device
+	ErrNoGPIO = errors.New("rs485: GPIO lines not supported")
+)
+
+// Direction switches a transceiver between driving the bus and listening
+type Direction interface {
+	Transmit(on bool) error
+}
+
+// rtsDirection drives the transceiver's enable from RTS
+type rtsDirection struct {
+	lines     xserial.LineController
+	activeLow bool
+}
+
+// RTS returns the Direction of transceivers enabled by RTS, which is high
+// while sending unless activeLow
+func RTS(p xserial.Port, activeLow bool) (Direction, error) {
+	lines, ok := xserial.As[xserial.LineController](p)
+	if !ok {
+		return nil, ErrNoLines
+	}
+	return &rtsDirection{lines: lines, activeLow: activeLow}, nil
+}
+
+func (d *rtsDirection) Transmit(on bool) error {
+	return d.lines.SetRTS(on != d.activeLow)
+}
+
+// Config configures a Bus
+type Config struct {
+	// Switches the transceiver around each Write, such as RTS or GPIO; nil
+	// for transceivers switching themselves or the driver mode of Kernel
+	Direction Direction
+	// Have the serial driver switch RTS itself: the Linux RS-485 mode, with
+	// the delays rounded to milliseconds, or RTS_CONTROL_TOGGLE on Windows,
+	// which has neither delays nor RTSActiveLow. RTSActiveLow sets its
+	// polarity.
+	Kernel       bool
+	RTSActiveLow bool
+	// Time from enabling the driver to the first byte, and from the last
+	// byte to releasing the bus
+	DelayBeforeSend time.Duration
+	DelayAfterSend  time.Duration
+	// The receiver hears the bus while sending: each Write reads back its
+	// echo and compares it. Read must not run during Write then.
+	Echo bool
+	// How long to wait for the echo after the last byte, defaults to 50ms
+	EchoTimeout time.Duration
+}
+
+// Bus is a Port on a half-duplex bus. Writes are serialised.
+type Bus struct {
+	xserial.Port
+	cfg Config
+	mx  sync.Mutex
+	buf []byte
+}
+
+// NewBus wraps p for the bus described by cfg, setting up the driver
+// mode if Kernel is set. cfg may be nil.
+func NewBus(p xserial.Port, cfg *Config) (*Bus, error) {
+	var c Config
+	if cfg != nil {
+		c = *cfg
+	}
+	if c.EchoTimeout <= 0 {
+		c.EchoTimeout = 50 * time.Millisecond
+	}
+	b := &Bus{Port: p, cfg: c}
+	if c.Kernel {
+		if err := setKernel(p, &c); err != nil {
+			return nil, err
+		}
+	}
+	if c.Direction != nil {
+		if err := c.Direction.Transmit(false); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// Unwrap returns the wrapped Port
+func (b *Bus) Unwrap() xserial.Port {
+	return b.Port
+}
+
+// Write sends p, enabling the driver for it, and returns once the bus has
+// been released. With Echo it returns ErrCollision or ErrNoEcho when the
+// echo does not match, along with len(p) as the bytes went out.
+func (b *Bus) Write(p []byte) (int, error) {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	if b.cfg.Echo {
+		// Nothing heard before the Write is part of its Echo
+		b.Port.Flush()
+	}
+	n, err := b.send(p)
+	if err != nil || !b.cfg.Echo {
+		return n, err
+	}
+	return n, b.echo(p)
+}
+
+// send writes p between the direction changes and delays
+func (b *Bus) send(p []byte) (int, error) {
+	d := b.cfg.Direction
+	if d != nil {
+		if err := d.Transmit(true); err != nil {
+			return 0, err
+		}
+	}
+	if !b.cfg.Kernel && b.cfg.DelayBeforeSend > 0 {
+		time.Sleep(b.cfg.DelayBeforeSend)
+	}
+	n, err := b.Port.Write(p)
+	// The Driver stays on until the last Stop Bit is out
+	if err == nil {
+		err = b.Port.Drain()
+	}
+	if !b.cfg.Kernel && b.cfg.DelayAfterSend > 0 {
+		time.Sleep(b.cfg.DelayAfterSend)
+	}
+	if d != nil {
+		if derr := d.Transmit(false); err == nil {
+			err = derr
+		}
+	}
+	return n, err
+}
+
+// echo reads back len(p) bytes and compares them with p
+func (b *Bus) echo(p []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), b.cfg.EchoTimeout)
+	defer cancel()
+	if cap(b.buf) < len(p) {
+		b.buf = make([]byte, len(p))
+	}
+	got := b.buf[:0]
+	for len(got) < len(p) {
+		if ctx.Err() != nil {
+			if len(got) == 0 {
+				return ErrNoEcho
+			}
+			return ErrCollision
+		}
+		n, err := xserial.ReadContext(ctx, b.Port, b.buf[len(got):len(p)])
+		got = b.buf[:len(got)+n]
+		if err != nil && err != xserial.ErrReadTimeout && ctx.Err() == nil {
+			return err
+		}
+		if !bytes.HasPrefix(p, got) {
+			return ErrCollision
+		}
+	}
+	return nil
+}
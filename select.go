@@ -0,0 +1,56 @@
+package xserial
+
+import (
+	"context"
+)
+
+// ReadWaiter is implemented by Ports without an OS descriptor, such as
+// in-memory test Ports, that can still block until data is readable
+type ReadWaiter interface {
+	WaitReadable(ctx context.Context) error
+}
+
+// Select blocks until one of the given Ports has data to read (or has hung
+// up) and returns it. When several descriptor backed Ports are ready the
+// first in argument order is returned. It returns ctx.Err() if ctx is done
+// first and ErrNotPollable if a Port can be neither polled nor waited on.
+func Select(ctx context.Context, ports ...Port) (Port, error) {
+	allFD := true
+	for _, p := range ports {
+		if _, ok := portFD(p); ok {
+			continue
+		}
+		allFD = false
+		if _, ok := As[ReadWaiter](p); !ok {
+			return nil, ErrNotPollable
+		}
+	}
+	if allFD {
+		return selectFDs(ctx, ports)
+	}
+
+	// Mixed Ports - Wait on each in its own goroutine
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	type result struct {
+		port Port
+		err  error
+	}
+	results := make(chan result, len(ports))
+	for _, p := range ports {
+		go func(p Port) {
+			var err error
+			if w, ok := As[ReadWaiter](p); ok {
+				err = w.WaitReadable(ctx)
+			} else {
+				_, err = selectFDs(ctx, []Port{p})
+			}
+			results <- result{p, err}
+		}(p)
+	}
+	r := <-results
+	if r.err != nil {
+		return nil, r.err
+	}
+	return r.port, nil
+}
@@ -0,0 +1,13 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package xserial
+
+import (
+	"context"
+)
+
+// selectFDs is unavailable without poll, Select falls back to ReadWaiter
+func selectFDs(ctx context.Context, ports []Port) (Port, error) {
+	return nil, ErrNotPollable
+}
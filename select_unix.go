@@ -0,0 +1,71 @@
+// Copyright 2021 Abhijit Bose. All rights reserved.
+
+//go:build linux || darwin
+// +build linux darwin
+
+package xserial
+
+import (
+	"context"
+
+	"golang.org/x/sys/unix"
+)
+
+// selectFDs implements Select for descriptor backed Ports with one poll
+func selectFDs(ctx context.Context, ports []Port) (Port, error) {
+	fds := make([]unix.PollFd, len(ports), len(ports)+1)
+	for i, p := range ports {
+		fd, ok := portFD(p)
+		if !ok {
+			return nil, ErrNotPollable
+		}
+		if fd <= 0 {
+			return nil, ErrNotOpen
+		}
+		fds[i] = unix.PollFd{Fd: int32(fd), Events: unix.POLLIN}
+	}
+
+	// Wake up Poll through a Pipe when ctx can be Cancelled
+	if done := ctx.Done(); done != nil {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		var pipe [2]int
+		if err := unix.Pipe(pipe[:]); err != nil {
+			return nil, err
+		}
+		defer unix.Close(pipe[0])
+		defer unix.Close(pipe[1])
+		fds = append(fds, unix.PollFd{Fd: int32(pipe[0]), Events: unix.POLLIN})
+
+		stop := make(chan struct{})
+		defer close(stop)
+		go func() {
+			select {
+			case <-done:
+				unix.Write(pipe[1], []byte{0})
+			case <-stop:
+			}
+		}()
+	}
+
+	for {
+		_, err := unix.Poll(fds, -1)
+		if err == unix.EINTR {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		// A Port Closed while Polled reports POLLNVAL at once, so it is
+		// Ready too and its Read returns the Error
+		for i, p := range ports {
+			if fds[i].Revents&(unix.POLLIN|unix.POLLHUP|unix.POLLERR|unix.POLLNVAL) != 0 {
+				return p, nil
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+	}
+}
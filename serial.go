@@ -3,8 +3,8 @@ package xserial
 //go:generate go run golang.org/x/sys/windows/mkwinsyscall -output zsyscall_windows.go syscall_windows.go
 
 import (
-	"fmt"
 	"io"
+	"os"
 	"time"
 )
 
@@ -17,45 +17,271 @@ const (
 	FlowSoft byte = iota // XON / XOFF based - Not Supported
 )
 
+// Modem Control and Status Lines
+const (
+	// LineCTS - Clear To Send
+	LineCTS = 1 << iota
+	// LineDSR - Data Set Ready
+	LineDSR
+	// LineRI - Ring Indicator
+	LineRI
+	// LineDCD - Data Carrier Detect
+	LineDCD
+	// LineDTR - Data Terminal Ready
+	LineDTR
+	// LineRTS - Request To Send
+	LineRTS
+)
+
+// EventType identifies the kind of an Event
+type EventType int
+
+const (
+	// EventRXAvailable - Data is waiting to be Read
+	EventRXAvailable EventType = iota
+	// EventLineStatus - One or more Modem Lines changed
+	EventLineStatus
+	// EventError - The Port reported an Error
+	EventError
+	// EventDisconnect - The Device went away, no more Events follow
+	EventDisconnect
+	// EventTXEmpty - Everything written has been sent, down to the Shift
+	// Register where the Driver reports it. Once per idle after Writes.
+	EventTXEmpty
+)
+
+// Event is delivered on the channel returned by Port.Events
+type Event struct {
+	Type EventType
+	// Modem Line State (Line* bits) for EventLineStatus
+	Lines int
+	// Cause for EventError and EventDisconnect
+	Err  error
+	Time time.Time
+}
+
+// Stats holds the running counters of a Port
+type Stats struct {
+	BytesRead    uint64
+	BytesWritten uint64
+	ReadTimeouts uint64
+	ReadErrors   uint64
+	WriteErrors  uint64
+	// Times the Port was Opened again after its first Open
+	Reopens uint64
+}
+
 // Config stores the complete configuration of a Serial Port
 type Config struct {
-	Name        string
+	Name        string // Device, or on Windows a \\.\pipe\ Named Pipe
 	Baud        int
 	ReadTimeout time.Duration // Blocks the Read operation for a specified time
 	Parity      string
 	StopBits    int
 	Flow        byte
+	IOUring     bool // Linux only - Use io_uring for Read / Write instead of select + read
+	// Unix only - Put back the termios found at Open when Closing, so a
+	// console tty is not left in raw mode for the next program
+	RestoreTermios bool
+	// Linux only - Kernel Line Discipline attached after Open, such as
+	// LineDisciplinePPS; zero keeps the terminal discipline
+	LineDiscipline int
+	// Skips Write Locking when the Application guarantees a Single Writer
+	SingleWriter bool
+	// Optional - Records a Span for Open, Read, Write and Close
+	Tracer Tracer
+	// Optional - Overrides the Package Logger set by SetLogger
+	Logger Logger
+	// Log every Read and Write at Debug Level
+	LogData bool
 }
 
 // Default Errors
 
 var (
 	// ErrNotImplemented -
-	ErrNotImplemented = fmt.Errorf("not implemented yet")
+	ErrNotImplemented = newError("not implemented yet", nil, false)
 	// ErrPortNotInitialized -
-	ErrPortNotInitialized = fmt.Errorf("port not initialized or closed")
+	ErrPortNotInitialized = newError("port not initialized or closed", os.ErrClosed, false)
 	// ErrNotOpen -
-	ErrNotOpen = fmt.Errorf("port not open")
+	ErrNotOpen = newError("port not open", os.ErrClosed, false)
 	// ErrAlreadyOpen -
-	ErrAlreadyOpen = fmt.Errorf("port is already open")
-	// ErrAccessDenied -
-	ErrAccessDenied = fmt.Errorf("access denied")
-	// ErrPortClosed
-	ErrPortClosed = fmt.Errorf("port closed")
-	//超时
-	ErrReadTimeout = fmt.Errorf("read port time out")
+	ErrAlreadyOpen = newError("port is already open", nil, false)
+	// ErrAccessDenied - matches os.ErrPermission
+	ErrAccessDenied = newError("access denied", os.ErrPermission, false)
+	// ErrPortClosed - matches os.ErrClosed
+	ErrPortClosed = newError("port closed", os.ErrClosed, false)
+	//超时 - satisfies os.IsTimeout and net.Error and matches os.ErrDeadlineExceeded
+	ErrReadTimeout = newError("read port time out", os.ErrDeadlineExceeded, true)
+	// ErrTimeout is ErrReadTimeout
+	ErrTimeout = ErrReadTimeout
+	// ErrDeviceRemoved is returned by Read and Write once the device has been
+	// unplugged; the Port should be closed and opened again
+	ErrDeviceRemoved = newError("device removed", nil, false)
+	// ErrDrainTimeout - CloseWithTimeout discarded output it could not send
+	ErrDrainTimeout = newError("drain timed out, pending output discarded", os.ErrDeadlineExceeded, true)
+	// ErrNoPort - no listed port matched; matches os.ErrNotExist
+	ErrNoPort = newError("no matching port found", os.ErrNotExist, false)
+	// ErrNotUSB - the device is not behind a USB adapter
+	ErrNotUSB = newError("device is not a usb adapter", nil, false)
+	// ErrNotPollable -
+	ErrNotPollable = newError("port is not backed by a pollable descriptor", nil, false)
+	// ErrInvalidConfig is matched by every ConfigError
+	ErrInvalidConfig = newError("invalid or not supported configuration", nil, false)
 )
 
-// Port Type for Multi platform implementation of Serial port functionality
+// Port Type for Multi platform implementation of Serial port functionality.
+//
+// Write is atomic per call: it returns only after all bytes are queued or an
+// error occurs, and concurrent calls are serialised so frames never interleave.
+// Read and Write may run concurrently with each other.
 type Port interface {
 	io.ReadWriteCloser
 	//设置校验位和停止位
 	SetParity(parity string, stopbits int) (err error)
 	//清理串口的缓存
 	Flush() (err error)
+	// Drain blocks until all written data has been transmitted
+	Drain() (err error)
+	// Events returns a channel of RX, line status, TX empty, error and
+	// disconnect Events. The monitor starts on first call and the channel
+	// is closed when the Port is closed. An RX Event is not repeated until
+	// Read is called.
+	Events() <-chan Event
+	// Stats returns a snapshot of the traffic and error counters
+	Stats() Stats
+}
+
+// LineController is implemented by Ports that can drive and sample the
+// modem control lines directly
+type LineController interface {
+	SetDTR(on bool) error
+	SetRTS(on bool) error
+	// ModemLines returns the current Line* bits
+	ModemLines() (int, error)
+	// SendBreak holds the line in the break condition for d
+	SendBreak(d time.Duration) error
+}
+
+// QueueReporter is implemented by Ports that can report how many bytes
+// wait in the driver: received and not yet Read, and written and not yet
+// transmitted
+type QueueReporter interface {
+	Queued() (in, out int, err error)
+}
+
+// InputFlusher is implemented by Ports that can discard received input
+// without touching output still waiting to be sent
+type InputFlusher interface {
+	FlushInput() error
+}
+
+// LineCounters holds the line errors a driver has counted
+type LineCounters struct {
+	// Bytes received with a bad Stop Bit
+	Frame uint64
+	// Bytes received with a bad Parity Bit
+	Parity uint64
+	// Bytes lost as the UART's receive FIFO was full
+	Overrun uint64
+	// Bytes lost as the driver's input buffer was full
+	BufferOverrun uint64
+	// Break Conditions received
+	Break uint64
+}
+
+// LineCounter is implemented by Ports whose driver counts line errors, for
+// diagnosing noise, wrong framing and lost data. Linux counts each event,
+// Windows only samples whether any occurred since it last looked.
+type LineCounter interface {
+	Counters() (LineCounters, error)
+}
+
+// BaudSetter is implemented by Ports that can change the line speed while
+// open, as bootloaders that switch to a faster rate need
+type BaudSetter interface {
+	SetBaudRate(baud int) error
+}
+
+// RTSToggler is implemented by Ports whose driver can raise RTS itself
+// while sending, as Windows does with RTS_CONTROL_TOGGLE, for RS-485
+// transceivers enabled by RTS
+type RTSToggler interface {
+	SetRTSToggle(on bool) error
+}
+
+// ParityMarker is implemented by Ports that can report the bytes received
+// with a parity error in line, as Linux does for parity "G": 0xFF 0x00
+// ahead of each such byte, and a data byte 0xFF doubled
+type ParityMarker interface {
+	MarksParity() bool
+}
+
+// Ioctler is implemented by Ports backed by a Unix device, for device
+// specific requests such as vendor extensions. The request runs under the
+// Port's lock, so it never races with Open and Close.
+type Ioctler interface {
+	// Ioctl issues req and returns the driver's result
+	Ioctl(req uintptr, arg IoctlArg) (int, error)
+}
+
+// fdPort is implemented by Ports backed by an OS file descriptor
+type fdPort interface {
+	fileDescriptor() int
+}
+
+// Wrapper is implemented by Port wrappers that pass Reads straight through
+// to the Port they wrap, so helpers such as Select can poll the real device
+type Wrapper interface {
+	Unwrap() Port
+}
+
+// As finds the first Port in the chain of Wrappers starting at p that
+// implements T, so optional interfaces such as LineController or BaudSetter
+// reach the device through any wrapper that does not offer them itself
+func As[T any](p Port) (T, bool) {
+	for {
+		if t, ok := p.(T); ok {
+			return t, true
+		}
+		w, ok := p.(Wrapper)
+		if !ok {
+			var zero T
+			return zero, false
+		}
+		p = w.Unwrap()
+	}
+}
+
+// portFD finds the descriptor behind p, looking through Wrappers
+func portFD(p Port) (fd int, ok bool) {
+	f, ok := As[fdPort](p)
+	if !ok {
+		return 0, false
+	}
+	return f.fileDescriptor(), true
 }
 
 // OpenPort is a Function to Create the Serial Port and return an Interface type enclosing the configuration
-func OpenPort(cfg *Config) (Port, error) {
+func OpenPort(cfg *Config) (p Port, err error) {
+	p, err = open(cfg)
+	if err != nil {
+		loggerFor(cfg).Log(LevelDebug, "serial port open failed", "port", cfg.Name, "err", err)
+		return nil, err
+	}
+	logOpened(cfg)
+	return p, nil
+}
+
+// open opens the Port without logging
+func open(cfg *Config) (Port, error) {
+	if cfg.Tracer != nil {
+		return openTraced(cfg)
+	}
 	return openPort(cfg)
 }
+
+func logOpened(cfg *Config) {
+	loggerFor(cfg).Log(LevelInfo, "serial port opened", "port", cfg.Name, "baud", cfg.Baud,
+		"parity", cfg.Parity, "stopbits", cfg.StopBits, "flow", cfg.Flow)
+}
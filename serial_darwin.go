@@ -6,14 +6,28 @@
 package xserial
 
 import (
-	"fmt"
+	"os"
 	"os/exec"
 	"sync"
+	"sync/atomic"
 	"time"
+	"unsafe"
 
 	"golang.org/x/sys/unix"
 )
 
+// Bytes waiting to be Read - FIONREAD
+const ioctlInQueue = 0x4004667f
+
+// Line Speed of any Rate - IOSSIOSPEED from IOKit/serial/ioss.h
+const ioctlSetSpeed = 0x80085402
+
+// Termios Get and Set after Output Drains
+const (
+	ioctlGetTermios = unix.TIOCGETA
+	ioctlSetTermios = unix.TIOCSETAW
+)
+
 var baudRates = map[int]uint32{
 	50:     unix.B50,
 	75:     unix.B75,
@@ -37,14 +51,32 @@ var baudRates = map[int]uint32{
 
 // Linux Compatible Serial Port Structure
 type serialPort struct {
+	// Counters - First so 64-bit Atomics stay Aligned on 32-bit Platforms
+	stats Stats
+	// Ever Opened - Further Opens count as Reopens
+	used bool
 	// Handle
 	fd int
 	// Lock for Handle - Make it Thread Safe by Default
-	mx sync.Mutex
+	// mx guards fd / opened and is only held exclusively by Open and Close;
+	// rxMx and txMx serialise each direction so Read never blocks Write
+	mx   sync.RWMutex
+	rxMx sync.Mutex
+	txMx sync.Mutex
 	// If Port is Open
 	opened bool
 	// Configuration
 	conf Config
+	log  Logger
+	// ReadTimeout Cached at Open - Duration and Milliseconds for poll
+	readTimeout   time.Duration
+	readTimeoutMs int
+	pfd           [1]unix.PollFd
+	// Event Monitor - Guarded by evMx as Read holds mx while blocked
+	evMx   sync.Mutex
+	events *eventMonitor
+	// Termios found at Open, put back by Close with RestoreTermios
+	saved *unix.Termios
 }
 
 // Platform Specific Open Port Function
@@ -56,6 +88,9 @@ func openPort(cfg *Config) (Port, error) {
 	if err != nil {
 		return nil, err
 	}
+	if cfg.LineDiscipline != LineDisciplineTTY {
+		return nil, &ConfigError{Setting: "line discipline", Value: cfg.LineDiscipline}
+	}
 
 	// Open Port
 	err = s.Open(cfg.Name)
@@ -64,13 +99,20 @@ func openPort(cfg *Config) (Port, error) {
 	}
 
 	// Auto Close on Errors
-	defer func(fd int, err error) {
+	defer func(fd int) {
 		if fd != 0 && err != nil {
 			unix.Close(fd)
 			s.fd = 0 // Not Initialized state
 			s.opened = false
 		}
-	}(s.fd, err)
+	}(s.fd)
+
+	// Snapshot the Original Settings
+	if cfg.RestoreTermios {
+		if err = s.saveTermios(); err != nil {
+			return nil, err
+		}
+	}
 
 	// Set Terminos
 	err = s.SetTermios(t)
@@ -80,6 +122,12 @@ func openPort(cfg *Config) (Port, error) {
 
 	// Set the Configuration
 	s.conf = *cfg
+	s.log = loggerFor(cfg)
+	s.readTimeout = cfg.ReadTimeout * time.Millisecond
+	s.readTimeoutMs = int(s.readTimeout / time.Millisecond)
+	if s.readTimeoutMs == 0 && s.readTimeout > 0 {
+		s.readTimeoutMs = 1
+	}
 
 	// Set Non-Blocking for Timeout and Blocking Purposes
 	err = unix.SetNonblock(s.fd, false)
@@ -118,11 +166,15 @@ func (s *serialPort) Open(name string) error {
 	// Try to Open
 	fd, err := unix.Open(name, unix.O_RDWR|unix.O_NOCTTY|unix.O_NONBLOCK|unix.O_EXCL, 0)
 	if err != nil {
-		return err
+		return portError("open", name, err)
 	}
 	// Assign fd
 	s.fd = fd
 	s.opened = true
+	if s.used {
+		atomic.AddUint64(&s.stats.Reopens, 1)
+	}
+	s.used = true
 
 	// Auto Close on Errors
 	defer func(fd int, err error) {
@@ -135,101 +187,77 @@ func (s *serialPort) Open(name string) error {
 
 	//独占权限
 	if _, _, e1 := unix.Syscall(unix.SYS_IOCTL, uintptr(fd), uintptr(unix.TIOCEXCL), 0); e1 != 0 {
-		return fmt.Errorf("failed to get exclusive access - %v", e1)
+		return &PortError{Op: "lock", Port: name, Err: e1}
 	}
 
 	return err
 }
 
-// unixSelect is a wapper for unix.Select that only returns error.
-func unixSelect(n int, r *unix.FdSet, w *unix.FdSet, e *unix.FdSet, tv *unix.Timeval) error {
-	_, err := unix.Select(n, r, w, e, tv)
-	return err
+func (s *serialPort) fileDescriptor() int {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	return s.fd
 }
 
-// fdget returns index and offset of fd in fds.
-func fdget(fd int, fds *unix.FdSet) (index, offset int) {
-	index = fd / (unix.FD_SETSIZE / len(fds.Bits)) % len(fds.Bits)
-	offset = fd % (unix.FD_SETSIZE / len(fds.Bits))
-	return
+func (s *serialPort) Read(p []byte) (n int, err error) {
+	n, err = s.read(p, nil)
+	err = portError("read", s.conf.Name, err)
+	s.countRead(p[:n], err)
+	return n, err
 }
 
-// fdset implements FD_SET macro.
-func fdset(fd int, fds *unix.FdSet) {
-	idx, pos := fdget(fd, fds)
-	fds.Bits[idx] = 1 << uint(pos)
+// ReadTimestamped is Read that also reports when the data became readable
+func (s *serialPort) ReadTimestamped(p []byte) (n int, at time.Time, err error) {
+	n, err = s.read(p, &at)
+	err = portError("read", s.conf.Name, err)
+	s.countRead(p[:n], err)
+	return n, at, err
 }
 
-// fdisset implements FD_ISSET macro.
-func fdisset(fd int, fds *unix.FdSet) bool {
-	idx, pos := fdget(fd, fds)
-	return fds.Bits[idx]&(1<<uint(pos)) != 0
-}
-
-func (s *serialPort) Read(p []byte) (n int, err error) {
-	var rfds unix.FdSet
-	fd := s.fd
-	fdset(fd, &rfds)
-	var tv *unix.Timeval
-	//如果设置了超时
-	if s.conf.ReadTimeout > 0 {
-		//time.Millisecond代替 1000*1000
-		tempTime := s.conf.ReadTimeout * time.Millisecond
-		timeout := unix.NsecToTimeval(tempTime.Nanoseconds())
-		tv = &timeout
-	}
-
-	// Establish Lock
-	s.mx.Lock()
-	defer s.mx.Unlock()
+// read performs Read, storing the time data was seen in at when non-nil
+func (s *serialPort) read(p []byte, at *time.Time) (n int, err error) {
+	// Establish Lock - Readers are Serialised, Writers are not Blocked
+	s.rxMx.Lock()
+	defer s.rxMx.Unlock()
+	s.mx.RLock()
+	defer s.mx.RUnlock()
 
 	// Check If its Open
 	if !s.opened {
 		return 0, ErrNotOpen
 	}
-	if s.conf.ReadTimeout > 0 {
+	// Re-arm RX Events
+	s.rearmEvents()
+	//如果设置了超时
+	if s.readTimeout > 0 {
+		// pollfd lives in the Port so the Hot Path does not Allocate
+		s.pfd[0] = unix.PollFd{Fd: int32(s.fd), Events: unix.POLLIN}
 		for {
-			// If unix.Select() returns EINTR (Interrupted system call), retry it
-			if err = unixSelect(fd+1, &rfds, nil, nil, tv); err == nil {
+			// If unix.Poll() returns EINTR (Interrupted system call), retry it
+			if _, err = unix.Poll(s.pfd[:], s.readTimeoutMs); err == nil {
 				break
 			}
 			if err != unix.EINTR {
-				err = fmt.Errorf("serial: could not select: %v", err)
-				return
+				return 0, os.NewSyscallError("poll", err)
 			}
 		}
-		if !fdisset(fd, &rfds) {
+		if s.pfd[0].Revents == 0 {
 			// Timeout
-			err = ErrReadTimeout
-			return
+			return 0, ErrReadTimeout
 		}
-		n, err = unix.Read(fd, p)
-		return
-	} else {
-		for {
-			// Perform the Actual Read
-			n, err = unix.Read(s.fd, p)
-			// In case the Read was interrupted by a Signal
-			if err == unix.EINTR {
-				continue
-			}
-			// Linux: when the port is disconnected during a read operation
-			// the port is left in a "readable with zero-length-data" state.
-			// https://stackoverflow.com/a/34945814/1655275
-
-			//if n == 0 && err == nil {
-			//	return 0, ErrPortClosed
-			//}
-
-			// In Case of Negative values of n due to other errors
-			if n < 0 {
-				n = 0 // Don't let -1 pass on
-			}
-			return n, err
+		if at != nil {
+			*at = time.Now()
 		}
-	}
-	/*
-		// Loop to Access the Port Data
+		n, err = unix.Read(s.fd, p)
+		if n < 0 {
+			n = 0 // Don't let -1 pass on
+		}
+		// Readable with zero-length data means the device has gone away
+		if n == 0 && err == nil && len(p) > 0 {
+			err = s.emptyRead(s.pfd[0].Revents)
+		}
+		return n, removedError(err)
+	} else {
 		for {
 			// Perform the Actual Read
 			n, err = unix.Read(s.fd, p)
@@ -240,36 +268,49 @@ func (s *serialPort) Read(p []byte) (n int, err error) {
 			// Linux: when the port is disconnected during a read operation
 			// the port is left in a "readable with zero-length-data" state.
 			// https://stackoverflow.com/a/34945814/1655275
-
-			if n == 0 && err == nil {
-				return 0, ErrPortClosed
+			if n == 0 && err == nil && len(p) > 0 {
+				err = s.emptyRead(-1)
 			}
 
 			// In Case of Negative values of n due to other errors
 			if n < 0 {
 				n = 0 // Don't let -1 pass on
 			}
-			return n, err
+			if at != nil && n > 0 {
+				*at = time.Now()
+			}
+			return n, removedError(err)
 		}
-	*/
+	}
 }
 
+// Write sends all of p before returning unless an error occurs. Calls are
+// serialised so concurrent writers never interleave within one call, unless
+// Config.SingleWriter is set.
 func (s *serialPort) Write(p []byte) (n int, err error) {
-	// Establish Lock
-	//s.mx.Lock()
-	//defer s.mx.Unlock()
+	defer func() {
+		err = portError("write", s.conf.Name, removedError(err))
+		s.countWrite(p[:n], int64(n), err)
+		if n > 0 {
+			s.armTXEmpty()
+		}
+	}()
+	// Establish Lock - Writers are Serialised, Readers are not Blocked
+	if !s.conf.SingleWriter {
+		s.txMx.Lock()
+		defer s.txMx.Unlock()
+	}
+	s.mx.RLock()
+	defer s.mx.RUnlock()
 
 	// Check If its Open
 	if !s.opened {
 		return 0, ErrNotOpen
 	}
 
-	n, err = unix.Write(s.fd, p)
-	// In case -1 returned - don't pass it on
-	if n < 0 {
-		n = 0
-	}
-	return n, err
+	return writeFull(p, func(b []byte) (int, error) {
+		return unix.Write(s.fd, b)
+	})
 }
 
 func (s *serialPort) Close() error {
@@ -289,21 +330,59 @@ func (s *serialPort) Close() error {
 		s.opened = false
 	}()
 
-	// Release Exclusive Access
-	if _, _, e1 := unix.Syscall(unix.SYS_IOCTL, uintptr(s.fd), uintptr(unix.TIOCNXCL), 0); e1 != 0 {
-		return fmt.Errorf("failed to release exclusive access - %v", e1)
-	}
+	// Stop the Event Monitor before the fd goes away
+	s.stopEvents()
+	s.restoreTermios()
+	s.log.Log(LevelInfo, "serial port closed", "port", s.conf.Name)
+
+	// Release Exclusive Access - fails once the Device has hung up, which
+	// must not keep the fd from being Closed
+	_, _, e1 := unix.Syscall(unix.SYS_IOCTL, uintptr(s.fd), uintptr(unix.TIOCNXCL), 0)
 
 	// Perform the Actual Close
-	return unix.Close(s.fd)
+	if err := unix.Close(s.fd); err != nil {
+		return err
+	}
+	if e1 != 0 && removedError(e1) != ErrDeviceRemoved {
+		return &PortError{Op: "unlock", Port: s.conf.Name, Err: e1}
+	}
+	return nil
 }
 
 func (s *serialPort) SetParity(parity string, stopbits int) (err error) {
 	return nil
 }
 
+// SetBaudRate changes the line speed with IOSSIOSPEED, which also takes
+// rates without a B constant
+func (s *serialPort) SetBaudRate(baud int) error {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	// Check If its Open
+	if !s.opened {
+		return ErrNotOpen
+	}
+	if baud <= 0 {
+		return &ConfigError{Setting: "baud", Value: baud}
+	}
+	// speed_t is an unsigned long
+	speed := uint(baud)
+	if _, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(s.fd), uintptr(ioctlSetSpeed), uintptr(unsafe.Pointer(&speed))); errno != 0 {
+		return &PortError{Op: "set baud rate", Port: s.conf.Name, Err: errno}
+	}
+	s.conf.Baud = baud
+	s.log.Log(LevelInfo, "serial config changed", "port", s.conf.Name, "baud", baud)
+	return nil
+}
+
 //清除缓存
 func (s *serialPort) Flush() error {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	// Check If its Open
+	if !s.opened {
+		return ErrNotOpen
+	}
 	const TCFLSH = 0x540B
 	_, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(s.fd), uintptr(TCFLSH), uintptr(unix.TCIOFLUSH))
 	if errno == 0 {
@@ -312,6 +391,38 @@ func (s *serialPort) Flush() error {
 	return errno
 }
 
+// FlushInput discards received data not yet Read, leaving queued output
+func (s *serialPort) FlushInput() error {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	// Check If its Open
+	if !s.opened {
+		return ErrNotOpen
+	}
+	// FREAD from sys/fcntl.h selects the Input Queue
+	const fread = 0x1
+	return unix.IoctlSetPointerInt(s.fd, unix.TIOCFLUSH, fread)
+}
+
+// Drain waits until all queued output has been transmitted
+func (s *serialPort) Drain() error {
+	// Wait for In-flight Writes
+	s.txMx.Lock()
+	defer s.txMx.Unlock()
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	// Check If its Open
+	if !s.opened {
+		return ErrNotOpen
+	}
+	for {
+		err := unix.IoctlSetInt(s.fd, unix.TIOCDRAIN, 0)
+		if err != unix.EINTR {
+			return err
+		}
+	}
+}
+
 func (s *serialPort) SetTermios(t unix.Termios) error {
 	return nil
 }
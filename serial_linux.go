@@ -6,14 +6,24 @@
 package xserial
 
 import (
-	"fmt"
 	"golang.org/x/sys/unix"
+	"os"
 	"os/exec"
 	"sync"
+	"sync/atomic"
 	"time"
 	"unsafe"
 )
 
+// Bytes waiting to be Read
+const ioctlInQueue = unix.TIOCINQ
+
+// Termios Get and Set after Output Drains
+const (
+	ioctlGetTermios = unix.TCGETS
+	ioctlSetTermios = unix.TCSETSW
+)
+
 var baudRates = map[int]uint32{
 	50:      unix.B50,
 	75:      unix.B75,
@@ -49,14 +59,34 @@ var baudRates = map[int]uint32{
 
 // Linux Compatible Serial Port Structure
 type serialPort struct {
+	// Counters - First so 64-bit Atomics stay Aligned on 32-bit Platforms
+	stats Stats
+	// Ever Opened - Further Opens count as Reopens
+	used bool
 	// Handle
 	fd int
 	// Lock for Handle - Make it Thread Safe by Default
-	mx sync.Mutex
+	// mx guards fd / opened and is only held exclusively by Open and Close;
+	// rxMx and txMx serialise each direction so Read never blocks Write
+	mx   sync.RWMutex
+	rxMx sync.Mutex
+	txMx sync.Mutex
 	// If Port is Open
 	opened bool
 	// Configuration
 	conf Config
+	log  Logger
+	// ReadTimeout Cached at Open - Duration and Milliseconds for poll
+	readTimeout   time.Duration
+	readTimeoutMs int
+	pfd           [1]unix.PollFd
+	// Event Monitor - Guarded by evMx as Read holds mx while blocked
+	evMx   sync.Mutex
+	events *eventMonitor
+	// Termios found at Open, put back by Close with RestoreTermios
+	saved *unix.Termios
+	// Optional io_uring Rings - One per Direction for Full Duplex
+	rxRing, txRing *ioURing
 }
 
 // Platform Specific Open Port Function
@@ -76,22 +106,46 @@ func openPort(cfg *Config) (Port, error) {
 	}
 
 	// Auto Close on Errors
-	defer func(fd int, err error) {
+	defer func(fd int) {
 		if fd != 0 && err != nil {
 			unix.Close(fd)
 			s.fd = 0 // Not Initialized state
 			s.opened = false
 		}
-	}(s.fd, err)
+	}(s.fd)
+
+	// Snapshot the Original Settings
+	if cfg.RestoreTermios {
+		if err = s.saveTermios(); err != nil {
+			return nil, err
+		}
+	}
 
 	// Set Terminos
-	err = s.SetTermios(t)
+	baud := cfg.Baud
+	if baud == 0 {
+		baud = 19200
+	}
+	err = s.setTermiosBaud(t, baud)
 	if err != nil {
 		return nil, err
 	}
 
 	// Set the Configuration
 	s.conf = *cfg
+	s.log = loggerFor(cfg)
+	s.readTimeout = cfg.ReadTimeout * time.Millisecond
+	s.readTimeoutMs = int(s.readTimeout / time.Millisecond)
+	if s.readTimeoutMs == 0 && s.readTimeout > 0 {
+		s.readTimeoutMs = 1
+	}
+
+	// Attach the Line Discipline once the Line is Set Up
+	if cfg.LineDiscipline != LineDisciplineTTY {
+		if err = s.SetLineDiscipline(cfg.LineDiscipline); err != nil {
+			return nil, err
+		}
+	}
 
 	// Set Non-Blocking for Timeout and Blocking Purposes
 	err = unix.SetNonblock(s.fd, false)
@@ -99,6 +153,18 @@ func openPort(cfg *Config) (Port, error) {
 		return nil, err
 	}
 
+	// Setup io_uring if Requested
+	if cfg.IOUring {
+		if s.rxRing, err = newIOURing(); err != nil {
+			return nil, err
+		}
+		if s.txRing, err = newIOURing(); err != nil {
+			s.rxRing.close()
+			s.rxRing = nil
+			return nil, err
+		}
+	}
+
 	// Finally Success
 	return s, err
 }
@@ -130,11 +196,15 @@ func (s *serialPort) Open(name string) error {
 	// Try to Open
 	fd, err := unix.Open(name, unix.O_RDWR|unix.O_NOCTTY|unix.O_NONBLOCK|unix.O_EXCL, 0)
 	if err != nil {
-		return err
+		return portError("open", name, err)
 	}
 	// Assign fd
 	s.fd = fd
 	s.opened = true
+	if s.used {
+		atomic.AddUint64(&s.stats.Reopens, 1)
+	}
+	s.used = true
 
 	// Auto Close on Errors
 	defer func(fd int, err error) {
@@ -147,101 +217,89 @@ func (s *serialPort) Open(name string) error {
 
 	//独占权限
 	if _, _, e1 := unix.Syscall(unix.SYS_IOCTL, uintptr(fd), uintptr(unix.TIOCEXCL), 0); e1 != 0 {
-		return fmt.Errorf("failed to get exclusive access - %v", e1)
+		return &PortError{Op: "lock", Port: name, Err: e1}
 	}
 
 	return err
 }
 
-// unixSelect is a wapper for unix.Select that only returns error.
-func unixSelect(n int, r *unix.FdSet, w *unix.FdSet, e *unix.FdSet, tv *unix.Timeval) error {
-	_, err := unix.Select(n, r, w, e, tv)
-	return err
-}
-
-// fdget returns index and offset of fd in fds.
-func fdget(fd int, fds *unix.FdSet) (index, offset int) {
-	index = fd / (unix.FD_SETSIZE / len(fds.Bits)) % len(fds.Bits)
-	offset = fd % (unix.FD_SETSIZE / len(fds.Bits))
-	return
+func (s *serialPort) fileDescriptor() int {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	return s.fd
 }
 
-// fdset implements FD_SET macro.
-func fdset(fd int, fds *unix.FdSet) {
-	idx, pos := fdget(fd, fds)
-	fds.Bits[idx] = 1 << uint(pos)
+func (s *serialPort) Read(p []byte) (n int, err error) {
+	n, err = s.read(p, nil)
+	err = portError("read", s.conf.Name, err)
+	s.countRead(p[:n], err)
+	return n, err
 }
 
-// fdisset implements FD_ISSET macro.
-func fdisset(fd int, fds *unix.FdSet) bool {
-	idx, pos := fdget(fd, fds)
-	return fds.Bits[idx]&(1<<uint(pos)) != 0
+// ReadTimestamped is Read that also reports when the data became readable
+func (s *serialPort) ReadTimestamped(p []byte) (n int, at time.Time, err error) {
+	n, err = s.read(p, &at)
+	err = portError("read", s.conf.Name, err)
+	s.countRead(p[:n], err)
+	return n, at, err
 }
 
-func (s *serialPort) Read(p []byte) (n int, err error) {
-	var rfds unix.FdSet
-	fd := s.fd
-	fdset(fd, &rfds)
-	var tv *unix.Timeval
-	//如果设置了超时
-	if s.conf.ReadTimeout > 0 {
-		//time.Millisecond代替 1000*1000
-		tempTime := s.conf.ReadTimeout * time.Millisecond
-		timeout := unix.NsecToTimeval(tempTime.Nanoseconds())
-		tv = &timeout
-	}
-
-	// Establish Lock
-	s.mx.Lock()
-	defer s.mx.Unlock()
+// read performs Read, storing the time data was seen in at when non-nil
+func (s *serialPort) read(p []byte, at *time.Time) (n int, err error) {
+	// Establish Lock - Readers are Serialised, Writers are not Blocked
+	s.rxMx.Lock()
+	defer s.rxMx.Unlock()
+	s.mx.RLock()
+	defer s.mx.RUnlock()
 
 	// Check If its Open
 	if !s.opened {
 		return 0, ErrNotOpen
 	}
-	if s.conf.ReadTimeout > 0 {
+	// Re-arm RX Events
+	s.rearmEvents()
+	// io_uring waits for data, so without a Timeout use the plain non-blocking Read
+	if s.rxRing != nil && s.readTimeout > 0 {
+		var revents int16
+		n, revents, err = s.rxRing.do(uringOpRead, s.fd, p, s.readTimeout)
+		if n == 0 && err == nil && len(p) > 0 {
+			err = s.emptyRead(revents)
+		}
+		if at != nil {
+			*at = time.Now()
+		}
+		return n, removedError(err)
+	}
+	//如果设置了超时
+	if s.readTimeout > 0 {
+		// pollfd lives in the Port so the Hot Path does not Allocate
+		s.pfd[0] = unix.PollFd{Fd: int32(s.fd), Events: unix.POLLIN}
 		for {
-			// If unix.Select() returns EINTR (Interrupted system call), retry it
-			if err = unixSelect(fd+1, &rfds, nil, nil, tv); err == nil {
+			// If unix.Poll() returns EINTR (Interrupted system call), retry it
+			if _, err = unix.Poll(s.pfd[:], s.readTimeoutMs); err == nil {
 				break
 			}
 			if err != unix.EINTR {
-				err = fmt.Errorf("serial: could not select: %v", err)
-				return
+				return 0, os.NewSyscallError("poll", err)
 			}
 		}
-		if !fdisset(fd, &rfds) {
+		if s.pfd[0].Revents == 0 {
 			// Timeout
-			err = ErrReadTimeout
-			return
+			return 0, ErrReadTimeout
 		}
-		n, err = unix.Read(fd, p)
-		return
-	} else {
-		for {
-			// Perform the Actual Read
-			n, err = unix.Read(s.fd, p)
-			// In case the Read was interrupted by a Signal
-			if err == unix.EINTR {
-				continue
-			}
-			// Linux: when the port is disconnected during a read operation
-			// the port is left in a "readable with zero-length-data" state.
-			// https://stackoverflow.com/a/34945814/1655275
-
-			//if n == 0 && err == nil {
-			//	return 0, ErrPortClosed
-			//}
-
-			// In Case of Negative values of n due to other errors
-			if n < 0 {
-				n = 0 // Don't let -1 pass on
-			}
-			return n, err
+		if at != nil {
+			*at = time.Now()
 		}
-	}
-	/*
-		// Loop to Access the Port Data
+		n, err = unix.Read(s.fd, p)
+		if n < 0 {
+			n = 0 // Don't let -1 pass on
+		}
+		// Readable with zero-length data means the device has gone away
+		if n == 0 && err == nil && len(p) > 0 {
+			err = s.emptyRead(s.pfd[0].Revents)
+		}
+		return n, removedError(err)
+	} else {
 		for {
 			// Perform the Actual Read
 			n, err = unix.Read(s.fd, p)
@@ -252,36 +310,55 @@ func (s *serialPort) Read(p []byte) (n int, err error) {
 			// Linux: when the port is disconnected during a read operation
 			// the port is left in a "readable with zero-length-data" state.
 			// https://stackoverflow.com/a/34945814/1655275
-
-			if n == 0 && err == nil {
-				return 0, ErrPortClosed
+			if n == 0 && err == nil && len(p) > 0 {
+				err = s.emptyRead(-1)
 			}
 
 			// In Case of Negative values of n due to other errors
 			if n < 0 {
 				n = 0 // Don't let -1 pass on
 			}
-			return n, err
+			if at != nil && n > 0 {
+				*at = time.Now()
+			}
+			return n, removedError(err)
 		}
-	*/
+	}
 }
 
+// Write sends all of p before returning unless an error occurs. Calls are
+// serialised so concurrent writers never interleave within one call, unless
+// Config.SingleWriter is set.
 func (s *serialPort) Write(p []byte) (n int, err error) {
-	// Establish Lock
-	//s.mx.Lock()
-	//defer s.mx.Unlock()
+	defer func() {
+		err = portError("write", s.conf.Name, removedError(err))
+		s.countWrite(p[:n], int64(n), err)
+		if n > 0 {
+			s.armTXEmpty()
+		}
+	}()
+	// Establish Lock - Writers are Serialised, Readers are not Blocked
+	if !s.conf.SingleWriter {
+		s.txMx.Lock()
+		defer s.txMx.Unlock()
+	}
+	s.mx.RLock()
+	defer s.mx.RUnlock()
 
 	// Check If its Open
 	if !s.opened {
 		return 0, ErrNotOpen
 	}
 
-	n, err = unix.Write(s.fd, p)
-	// In case -1 returned - don't pass it on
-	if n < 0 {
-		n = 0
+	if s.txRing != nil {
+		return writeFull(p, func(b []byte) (int, error) {
+			n, _, err := s.txRing.do(uringOpWrite, s.fd, b, 0)
+			return n, err
+		})
 	}
-	return n, err
+	return writeFull(p, func(b []byte) (int, error) {
+		return unix.Write(s.fd, b)
+	})
 }
 
 func (s *serialPort) Close() error {
@@ -301,13 +378,31 @@ func (s *serialPort) Close() error {
 		s.opened = false
 	}()
 
-	// Release Exclusive Access
-	if _, _, e1 := unix.Syscall(unix.SYS_IOCTL, uintptr(s.fd), uintptr(unix.TIOCNXCL), 0); e1 != 0 {
-		return fmt.Errorf("failed to release exclusive access - %v", e1)
+	// Stop the Event Monitor before the fd goes away
+	s.stopEvents()
+	s.restoreTermios()
+	s.log.Log(LevelInfo, "serial port closed", "port", s.conf.Name)
+
+	// Release io_uring Rings
+	for _, r := range []*ioURing{s.rxRing, s.txRing} {
+		if r != nil {
+			r.close()
+		}
 	}
+	s.rxRing, s.txRing = nil, nil
+
+	// Release Exclusive Access - fails once the Device has hung up, which
+	// must not keep the fd from being Closed
+	_, _, e1 := unix.Syscall(unix.SYS_IOCTL, uintptr(s.fd), uintptr(unix.TIOCNXCL), 0)
 
 	// Perform the Actual Close
-	return unix.Close(s.fd)
+	if err := unix.Close(s.fd); err != nil {
+		return err
+	}
+	if e1 != 0 && removedError(e1) != ErrDeviceRemoved {
+		return &PortError{Op: "unlock", Port: s.conf.Name, Err: e1}
+	}
+	return nil
 }
 
 func (s *serialPort) SetParity(parity string, stopbits int) (err error) {
@@ -319,6 +414,13 @@ func (s *serialPort) SetParity(parity string, stopbits int) (err error) {
 	}
 	//设置波特率
 	t.Cflag &^= unix.PARENB | unix.PARODD | unix.CMSPAR
+	// Mark and Space keep the Input Marking of "G", so 9-bit Senders can
+	// switch Parity per Byte
+	switch parity {
+	case "N", "E", "O":
+		t.Iflag &^= unix.INPCK | unix.PARMRK
+		t.Iflag |= unix.IGNPAR
+	}
 	switch parity {
 	case "N":
 	case "E":
@@ -329,8 +431,12 @@ func (s *serialPort) SetParity(parity string, stopbits int) (err error) {
 		t.Cflag |= unix.PARENB | unix.CMSPAR
 	case "M":
 		t.Cflag |= unix.PARENB | unix.PARODD | unix.CMSPAR
+	case "G":
+		t.Cflag |= unix.PARENB | unix.CMSPAR
+		t.Iflag |= unix.INPCK | unix.PARMRK
+		t.Iflag &^= unix.IGNPAR
 	default:
-		return fmt.Errorf("invalid or not supported parity")
+		return &ConfigError{Setting: "parity", Value: parity}
 	}
 
 	//设置停止位
@@ -340,7 +446,7 @@ func (s *serialPort) SetParity(parity string, stopbits int) (err error) {
 	case 2:
 		t.Cflag |= unix.CSTOPB
 	default:
-		return fmt.Errorf("invalid or not supported stop bits")
+		return &ConfigError{Setting: "stop bits", Value: stopbits}
 	}
 
 	err = s.SetTermios(t)
@@ -350,11 +456,60 @@ func (s *serialPort) SetParity(parity string, stopbits int) (err error) {
 	// Store the Parity
 	s.conf.Parity = parity
 	s.conf.StopBits = stopbits
+	s.log.Log(LevelInfo, "serial config changed", "port", s.conf.Name, "parity", parity, "stopbits", stopbits)
+	return nil
+}
+
+// MarksParity reports whether the Port is set to parity "G", reading each
+// byte received with the mark bit as 0xFF 0x00 and the byte
+func (s *serialPort) MarksParity() bool {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	return s.conf.Parity == "G"
+}
+
+// setMarkParity switches between mark parity and space under "G" for
+// NineBitPort, keeping the input marking and without logging each byte
+func (s *serialPort) setMarkParity(mark bool) error {
+	t, err := s.GetTermios()
+	if err != nil {
+		return err
+	}
+	t.Cflag |= unix.PARENB | unix.CMSPAR
+	if mark {
+		t.Cflag |= unix.PARODD
+	} else {
+		t.Cflag &^= unix.PARODD
+	}
+	return s.SetTermios(t)
+}
+
+// SetBaudRate changes the line speed, keeping the framing
+func (s *serialPort) SetBaudRate(baud int) error {
+	if baud <= 0 {
+		return &ConfigError{Setting: "baud", Value: baud}
+	}
+	t, err := s.GetTermios()
+	if err != nil {
+		return err
+	}
+	setSpeed(&t, baud)
+	if err := s.setTermiosBaud(t, baud); err != nil {
+		return err
+	}
+	s.conf.Baud = baud
+	s.log.Log(LevelInfo, "serial config changed", "port", s.conf.Name, "baud", baud)
 	return nil
 }
 
 //清除缓存
 func (s *serialPort) Flush() error {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	// Check If its Open
+	if !s.opened {
+		return ErrNotOpen
+	}
 	const TCFLSH = 0x540B
 	_, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(s.fd), uintptr(TCFLSH), uintptr(unix.TCIOFLUSH))
 	if errno == 0 {
@@ -363,16 +518,46 @@ func (s *serialPort) Flush() error {
 	return errno
 }
 
+// FlushInput discards received data not yet Read, leaving queued output
+func (s *serialPort) FlushInput() error {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	// Check If its Open
+	if !s.opened {
+		return ErrNotOpen
+	}
+	return unix.IoctlSetInt(s.fd, unix.TCFLSH, unix.TCIFLUSH)
+}
+
+// Drain waits until all queued output has been transmitted
+func (s *serialPort) Drain() error {
+	// Wait for In-flight Writes
+	s.txMx.Lock()
+	defer s.txMx.Unlock()
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	// Check If its Open
+	if !s.opened {
+		return ErrNotOpen
+	}
+	for {
+		err := unix.IoctlSetInt(s.fd, unix.TCSBRK, 1)
+		if err != unix.EINTR {
+			return err
+		}
+	}
+}
+
 func (s *serialPort) SetTermios(t unix.Termios) error {
 	// Establish Lock
-	s.mx.Lock()
-	defer s.mx.Unlock()
+	s.mx.RLock()
+	defer s.mx.RUnlock()
 	// Check If its Open
 	if !s.opened {
 		return ErrNotOpen
 	}
 	// Set Value
-	if _, _, e1 := unix.Syscall6(unix.SYS_IOCTL, uintptr(s.fd), uintptr(unix.TCSETS), uintptr(unsafe.Pointer(&t)), 0, 0, 0); e1 != 0 {
+	if _, _, e1 := unix.Syscall6(unix.SYS_IOCTL, uintptr(s.fd), uintptr(ioctlSetTermios2), uintptr(unsafe.Pointer(&t)), 0, 0, 0); e1 != 0 {
 		return error(e1)
 	}
 	return nil
@@ -380,8 +565,8 @@ func (s *serialPort) SetTermios(t unix.Termios) error {
 
 func (s *serialPort) GetTermios() (t unix.Termios, err error) {
 	// Establish Lock
-	s.mx.Lock()
-	defer s.mx.Unlock()
+	s.mx.RLock()
+	defer s.mx.RUnlock()
 
 	// Check If its Open
 	if !s.opened {
@@ -389,12 +574,27 @@ func (s *serialPort) GetTermios() (t unix.Termios, err error) {
 	}
 
 	//效果应该和unix.IoctlGetTermios 一样的，返回都是指针，不会存在内存泄露
-	if _, _, e1 := unix.Syscall6(unix.SYS_IOCTL, uintptr(s.fd), uintptr(unix.TCGETS), uintptr(unsafe.Pointer(&t)), 0, 0, 0); e1 != 0 {
+	if _, _, e1 := unix.Syscall6(unix.SYS_IOCTL, uintptr(s.fd), uintptr(ioctlGetTermios2), uintptr(unsafe.Pointer(&t)), 0, 0, 0); e1 != 0 {
 		return unix.Termios{}, error(e1)
 	}
 	return t, nil
 }
 
+// setSpeed sets both line speeds of t to baud, with BOTHER and the rate
+// itself when no B constant matches so drivers pick the nearest divisor
+func setSpeed(t *unix.Termios, baud int) {
+	t.Cflag &^= unix.CBAUD | unix.CBAUD<<unix.IBSHIFT
+	if rate, ok := baudRates[baud]; ok {
+		t.Cflag |= rate
+		t.Ispeed = rate
+		t.Ospeed = rate
+		return
+	}
+	t.Cflag |= unix.BOTHER
+	t.Ispeed = uint32(baud)
+	t.Ospeed = uint32(baud)
+}
+
 func getTermiosFor(cfg *Config) (unix.Termios, error) {
 	var t unix.Termios
 	// Set the Base RAW Mode - default 8 Bits
@@ -403,18 +603,14 @@ func getTermiosFor(cfg *Config) (unix.Termios, error) {
 	t.Cc[unix.VMIN] = 0
 	t.Cc[unix.VTIME] = 0
 	//设置波特率
-	var baud uint32
-	if cfg.Baud == 0 {
-		baud = unix.B19200
-	} else {
-		value, ok := baudRates[cfg.Baud]
-		if ok {
-			baud = value
-		}
+	baud := cfg.Baud
+	if baud == 0 {
+		baud = 19200
 	}
-	t.Cflag |= uint32(baud)
-	t.Ispeed = uint32(baud)
-	t.Ospeed = uint32(baud)
+	if baud < 0 {
+		return unix.Termios{}, &ConfigError{Setting: "baud", Value: cfg.Baud}
+	}
+	setSpeed(&t, baud)
 	//设备校验和
 	t.Cflag &^= unix.PARENB | unix.PARODD | unix.CMSPAR
 	switch cfg.Parity {
@@ -436,7 +632,7 @@ func getTermiosFor(cfg *Config) (unix.Termios, error) {
 		t.Iflag |= unix.PARMRK  //开启标记
 		t.Iflag &^= unix.IGNPAR //不可以忽略校验错误的
 	default:
-		return unix.Termios{}, fmt.Errorf("invalid or not supported parity")
+		return unix.Termios{}, &ConfigError{Setting: "parity", Value: cfg.Parity}
 	}
 	//设置停止位
 	t.Cflag &^= unix.CSTOPB
@@ -445,7 +641,7 @@ func getTermiosFor(cfg *Config) (unix.Termios, error) {
 	case 2:
 		t.Cflag |= unix.CSTOPB
 	default:
-		return unix.Termios{}, fmt.Errorf("invalid or not supported stop bits")
+		return unix.Termios{}, &ConfigError{Setting: "stop bits", Value: cfg.StopBits}
 	}
 	// Set Flow Control
 	t.Cflag &^= unix.CRTSCTS
@@ -457,7 +653,7 @@ func getTermiosFor(cfg *Config) (unix.Termios, error) {
 	case FlowHardware:
 		t.Cflag |= unix.CRTSCTS
 	default:
-		return unix.Termios{}, fmt.Errorf("invalid or not supported flow control")
+		return unix.Termios{}, &ConfigError{Setting: "flow control", Value: cfg.Flow}
 	}
 	// Timeout Settings
 	// Convert Time Out to Deci Seconds (1/10 of a Seconds)
@@ -488,3 +684,28 @@ func getTermiosFor(cfg *Config) (unix.Termios, error) {
 	// We are done
 	return t, nil
 }
+
+// SetLineDiscipline attaches the kernel Line Discipline ld to the tty
+func (s *serialPort) SetLineDiscipline(ld int) error {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	// Check If its Open
+	if !s.opened {
+		return ErrNotOpen
+	}
+	if err := unix.IoctlSetPointerInt(s.fd, unix.TIOCSETD, ld); err != nil {
+		return &PortError{Op: "set line discipline", Port: s.conf.Name, Err: err}
+	}
+	return nil
+}
+
+// LineDiscipline returns the kernel Line Discipline attached to the tty
+func (s *serialPort) LineDiscipline() (int, error) {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	// Check If its Open
+	if !s.opened {
+		return 0, ErrNotOpen
+	}
+	return unix.IoctlGetInt(s.fd, unix.TIOCGETD)
+}
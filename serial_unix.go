@@ -0,0 +1,256 @@
+// Copyright 2021 Abhijit Bose. All rights reserved.
+
+//go:build linux || darwin
+// +build linux darwin
+
+package xserial
+
+import (
+	"io"
+	"net"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// writeFull keeps calling write until all of p is sent, retrying on signals
+func writeFull(p []byte, write func([]byte) (int, error)) (n int, err error) {
+	for n < len(p) {
+		c, err := write(p[n:])
+		// In case -1 returned - don't pass it on
+		if c > 0 {
+			n += c
+		}
+		if err == unix.EINTR || err == unix.EAGAIN {
+			continue
+		}
+		if err != nil {
+			return n, err
+		}
+		if c == 0 {
+			return n, io.ErrShortWrite
+		}
+	}
+	return n, nil
+}
+
+// removedError maps the errors a vanished device produces to ErrDeviceRemoved
+func removedError(err error) error {
+	if err == unix.EIO || err == unix.ENXIO || err == unix.ENODEV {
+		return ErrDeviceRemoved
+	}
+	return err
+}
+
+// emptyRead tells an idle line from a removed device after a Read returned
+// no data. revents is the poll result the Read followed, or -1 to poll now.
+func (s *serialPort) emptyRead(revents int16) error {
+	if revents < 0 {
+		fds := [1]unix.PollFd{{Fd: int32(s.fd), Events: unix.POLLIN}}
+		if n, _ := unix.Poll(fds[:], 0); n <= 0 {
+			// Nothing to Read - the Line is Idle
+			return nil
+		}
+		revents = fds[0].Revents
+	}
+	if revents&(unix.POLLHUP|unix.POLLERR|unix.POLLNVAL) != 0 {
+		return ErrDeviceRemoved
+	}
+	// Readable yet Empty - a hung up tty fails ioctls with EIO
+	if _, err := unix.IoctlGetInt(s.fd, unix.TIOCMGET); removedError(err) == ErrDeviceRemoved {
+		return ErrDeviceRemoved
+	}
+	return nil
+}
+
+// WriteVec sends all bufs as one atomic write using writev where available,
+// so header, payload and checksum need not be copied into one buffer
+func (s *serialPort) WriteVec(bufs net.Buffers) (n int64, err error) {
+	defer func() {
+		err = portError("write", s.conf.Name, removedError(err))
+		s.countWrite(nil, n, err)
+		if n > 0 {
+			s.armTXEmpty()
+		}
+	}()
+	// Establish Lock - Writers are Serialised, Readers are not Blocked
+	if !s.conf.SingleWriter {
+		s.txMx.Lock()
+		defer s.txMx.Unlock()
+	}
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+
+	// Check If its Open
+	if !s.opened {
+		return 0, ErrNotOpen
+	}
+
+	// Copy the Slice Headers so the Caller's Buffers are left untouched
+	iov := make([][]byte, 0, len(bufs))
+	for _, b := range bufs {
+		if len(b) > 0 {
+			iov = append(iov, b)
+		}
+	}
+	for len(iov) > 0 {
+		batch := iov
+		if len(batch) > maxIOV {
+			batch = batch[:maxIOV]
+		}
+		c, err := writev(s.fd, batch)
+		if c > 0 {
+			n += int64(c)
+		}
+		if err == unix.EINTR || err == unix.EAGAIN {
+			continue
+		}
+		if err != nil {
+			return n, err
+		}
+		if c == 0 {
+			return n, io.ErrShortWrite
+		}
+		// Drop what was Written
+		for c > 0 {
+			if c >= len(iov[0]) {
+				c -= len(iov[0])
+				iov = iov[1:]
+			} else {
+				iov[0] = iov[0][c:]
+				c = 0
+			}
+		}
+	}
+	return n, nil
+}
+
+// ReadFrom implements io.ReaderFrom; *net.Buffers are sent with WriteVec
+func (s *serialPort) ReadFrom(r io.Reader) (n int64, err error) {
+	if b, ok := r.(*net.Buffers); ok {
+		n, err = s.WriteVec(*b)
+		// Consume like net.Buffers.WriteTo
+		for rem := n; rem > 0 && len(*b) > 0; {
+			if rem >= int64(len((*b)[0])) {
+				rem -= int64(len((*b)[0]))
+				*b = (*b)[1:]
+			} else {
+				(*b)[0] = (*b)[0][rem:]
+				rem = 0
+			}
+		}
+		return n, err
+	}
+	// Hide ReadFrom from io.Copy to avoid Recursion
+	return io.Copy(struct{ io.Writer }{s}, r)
+}
+
+// setLine raises or lowers one TIOCM_* line
+func (s *serialPort) setLine(bit int, on bool) error {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	// Check If its Open
+	if !s.opened {
+		return ErrNotOpen
+	}
+	req := uint(unix.TIOCMBIC)
+	if on {
+		req = unix.TIOCMBIS
+	}
+	return unix.IoctlSetPointerInt(s.fd, req, bit)
+}
+
+// SetDTR drives the Data Terminal Ready line
+func (s *serialPort) SetDTR(on bool) error {
+	return s.setLine(unix.TIOCM_DTR, on)
+}
+
+// SetRTS drives the Request To Send line
+func (s *serialPort) SetRTS(on bool) error {
+	return s.setLine(unix.TIOCM_RTS, on)
+}
+
+// ModemLines returns the current modem control lines as Line* bits
+func (s *serialPort) ModemLines() (int, error) {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	// Check If its Open
+	if !s.opened {
+		return 0, ErrNotOpen
+	}
+	return modemLines(s.fd)
+}
+
+// SendBreak holds TX in the break condition for d
+func (s *serialPort) SendBreak(d time.Duration) error {
+	// Keep Writers out while the Line is held
+	s.txMx.Lock()
+	defer s.txMx.Unlock()
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	// Check If its Open
+	if !s.opened {
+		return ErrNotOpen
+	}
+	if err := unix.IoctlSetInt(s.fd, unix.TIOCSBRK, 0); err != nil {
+		return err
+	}
+	time.Sleep(d)
+	return unix.IoctlSetInt(s.fd, unix.TIOCCBRK, 0)
+}
+
+// Queued returns the bytes waiting in the driver's input and output queues
+func (s *serialPort) Queued() (in, out int, err error) {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	// Check If its Open
+	if !s.opened {
+		return 0, 0, ErrNotOpen
+	}
+	if in, err = unix.IoctlGetInt(s.fd, ioctlInQueue); err != nil {
+		return 0, 0, err
+	}
+	out, err = unix.IoctlGetInt(s.fd, unix.TIOCOUTQ)
+	return in, out, err
+}
+
+// saveTermios snapshots the device's termios for RestoreTermios
+func (s *serialPort) saveTermios() (err error) {
+	s.saved, err = unix.IoctlGetTermios(s.fd, ioctlGetTermios)
+	return err
+}
+
+// restoreTermios puts the snapshot back once pending output has drained,
+// with mx held
+func (s *serialPort) restoreTermios() {
+	if s.saved == nil {
+		return
+	}
+	if err := unix.IoctlSetTermios(s.fd, ioctlSetTermios, s.saved); err != nil {
+		s.log.Log(LevelWarn, "restoring termios failed", "port", s.conf.Name, "err", err)
+	}
+	s.saved = nil
+}
+
+// Ioctl issues req on the device; see Ioctler
+func (s *serialPort) Ioctl(req uintptr, arg IoctlArg) (int, error) {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	// Check If its Open
+	if !s.opened {
+		return 0, ErrNotOpen
+	}
+	var r uintptr
+	var e1 unix.Errno
+	if len(arg.buf) > 0 {
+		// Pointer Conversion inside the Call keeps the Buffer Alive
+		r, _, e1 = unix.Syscall(unix.SYS_IOCTL, uintptr(s.fd), req, uintptr(unsafe.Pointer(&arg.buf[0])))
+	} else {
+		r, _, e1 = unix.Syscall(unix.SYS_IOCTL, uintptr(s.fd), req, arg.value)
+	}
+	if e1 != 0 {
+		return 0, e1
+	}
+	return int(r), nil
+}
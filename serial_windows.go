@@ -0,0 +1,798 @@
+// Copyright 2021 Abhijit Bose. All rights reserved.
+
+//go:build windows
+// +build windows
+
+package xserial
+
+import (
+	"context"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// DCB Flag Bits
+const (
+	dcbBinary           = 1 << 0
+	dcbParity           = 1 << 1
+	dcbOutxCtsFlow      = 1 << 2
+	dcbDtrControlEnable = 1 << 4
+	dcbOutX             = 1 << 8
+	dcbInX              = 1 << 9
+	dcbRtsControlMask   = 3 << 12
+	dcbRtsControlEnable = 1 << 12
+	dcbRtsHandshake     = 2 << 12
+	dcbRtsToggle        = 3 << 12
+)
+
+// PurgeComm Flags
+const (
+	purgeTxAbort = 0x0001
+	purgeTxClear = 0x0004
+	purgeRxClear = 0x0008
+)
+
+// EscapeCommFunction Codes
+const (
+	setRTS   = 3
+	clrRTS   = 4
+	setDTR   = 5
+	clrDTR   = 6
+	setBreak = 8
+	clrBreak = 9
+)
+
+// ClearCommError Error Bits
+const (
+	ceRXOver   = 0x0001
+	ceOverrun  = 0x0002
+	ceRXParity = 0x0004
+	ceFrame    = 0x0008
+	ceBreak    = 0x0010
+)
+
+// GetCommModemStatus Bits
+const (
+	msCTSOn  = 0x0010
+	msDSROn  = 0x0020
+	msRingOn = 0x0040
+	msRLSDOn = 0x0080
+)
+
+// Windows Compatible Serial Port Structure
+type serialPort struct {
+	// Counters - First so 64-bit Atomics stay Aligned on 32-bit Platforms
+	stats    Stats
+	lineErrs LineCounters
+	// Ever Opened - Further Opens count as Reopens
+	used bool
+	// Handle - Opened for Overlapped I/O so Read and Write run in parallel
+	h windows.Handle
+	// mx guards h / opened and is only held exclusively by Open and Close;
+	// rxMx and txMx serialise each direction so Read never blocks Write
+	mx   sync.RWMutex
+	rxMx sync.Mutex
+	txMx sync.Mutex
+	// If Port is Open
+	opened bool
+	// Named Pipe instead of a COM Port - No Line Settings
+	pipe bool
+	// Configuration
+	conf        Config
+	log         Logger
+	readTimeout time.Duration
+	// Overlapped State per Direction
+	rxOv, txOv windows.Overlapped
+	// Event Monitor
+	evMx   sync.Mutex
+	events *eventMonitor
+}
+
+// deviceName adds the \\.\ prefix needed for COM10 and above and for
+// virtual ports such as com0com's CNCA0
+func deviceName(name string) string {
+	if strings.HasPrefix(name, `\\`) {
+		return name
+	}
+	return `\\.\` + name
+}
+
+// Longest Wait in Milliseconds for a Busy Named Pipe to take another Client
+const pipeBusyWait = 2000
+
+// isPipeName reports whether name refers to a Named Pipe
+func isPipeName(name string) bool {
+	return strings.HasPrefix(strings.ToLower(deviceName(name)), `\\.\pipe\`)
+}
+
+// Platform Specific Open Port Function
+func openPort(cfg *Config) (Port, error) {
+	s := &serialPort{}
+
+	// Interpret the Config for Potential Errors
+	d, err := getDCBFor(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.LineDiscipline != LineDisciplineTTY {
+		return nil, &ConfigError{Setting: "line discipline", Value: cfg.LineDiscipline}
+	}
+
+	// Open Port
+	err = s.Open(cfg.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	// Set the Configuration
+	s.conf = *cfg
+	s.log = loggerFor(cfg)
+	s.readTimeout = cfg.ReadTimeout * time.Millisecond
+
+	if !s.pipe {
+		if err = s.setup(&d); err != nil {
+			s.Close()
+			return nil, err
+		}
+	}
+
+	// Finally Success
+	return s, nil
+}
+
+// setup applies the Line Settings and Timeouts to a COM Port
+func (s *serialPort) setup(d *dcb) error {
+	if err := setCommState(s.h, d); err != nil {
+		return &PortError{Op: "SetCommState", Port: s.conf.Name, Err: err}
+	}
+	// Return as soon as any Byte arrives, or after the Timeout with none
+	t := windows.CommTimeouts{ReadIntervalTimeout: 0xFFFFFFFF}
+	if s.readTimeout > 0 {
+		t.ReadTotalTimeoutMultiplier = 0xFFFFFFFF
+		t.ReadTotalTimeoutConstant = uint32(s.readTimeout / time.Millisecond)
+		if t.ReadTotalTimeoutConstant == 0 {
+			t.ReadTotalTimeoutConstant = 1
+		}
+	}
+	if err := windows.SetCommTimeouts(s.h, &t); err != nil {
+		return &PortError{Op: "SetCommTimeouts", Port: s.conf.Name, Err: err}
+	}
+	return purgeComm(s.h, purgeRxClear|purgeTxClear)
+}
+
+func (s *serialPort) Open(name string) error {
+	// Establish Lock
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	// Check If its Open
+	if s.opened {
+		// Release Log temporarily
+		s.mx.Unlock()
+		// Ignore Errors for Forced Close
+		s.Close()
+		// Re-Engage Lock
+		s.mx.Lock()
+	}
+
+	path, err := windows.UTF16PtrFromString(deviceName(name))
+	if err != nil {
+		return err
+	}
+	// No Sharing gives Exclusive Access
+	create := func() (windows.Handle, error) {
+		return windows.CreateFile(path, windows.GENERIC_READ|windows.GENERIC_WRITE, 0, nil,
+			windows.OPEN_EXISTING, windows.FILE_FLAG_OVERLAPPED, 0)
+	}
+	h, err := create()
+	if err == windows.ERROR_PIPE_BUSY {
+		// Single Instance Servers such as QEMU's take a moment to Accept again
+		if waitNamedPipe(path, pipeBusyWait) == nil {
+			h, err = create()
+		}
+	}
+	switch err {
+	case nil:
+	case windows.ERROR_ACCESS_DENIED, windows.ERROR_SHARING_VIOLATION, windows.ERROR_PIPE_BUSY:
+		return ErrAccessDenied
+	default:
+		return portError("open", name, err)
+	}
+
+	// One Manual Reset Event per Direction
+	for _, ov := range []*windows.Overlapped{&s.rxOv, &s.txOv} {
+		ev, err := windows.CreateEvent(nil, 1, 0, nil)
+		if err != nil {
+			windows.CloseHandle(h)
+			return err
+		}
+		*ov = windows.Overlapped{HEvent: ev}
+	}
+
+	// Assign Handle
+	s.h = h
+	s.pipe = isPipeName(name)
+	s.opened = true
+	if s.used {
+		atomic.AddUint64(&s.stats.Reopens, 1)
+	}
+	s.used = true
+	return nil
+}
+
+// overlapped runs one ReadFile or WriteFile and waits for it to finish. A
+// non-negative wait bounds the time spent, cancelling the I/O on expiry.
+func (s *serialPort) overlapped(ov *windows.Overlapped, wait time.Duration,
+	op func(done *uint32, ov *windows.Overlapped) error) (int, error) {
+	windows.ResetEvent(ov.HEvent)
+	var done uint32
+	err := op(&done, ov)
+	if err == nil {
+		return int(done), nil
+	}
+	if err != windows.ERROR_IO_PENDING {
+		return 0, err
+	}
+	if wait >= 0 {
+		ms := uint32(wait / time.Millisecond)
+		if ev, _ := windows.WaitForSingleObject(ov.HEvent, ms); ev == uint32(windows.WAIT_TIMEOUT) {
+			windows.CancelIoEx(s.h, ov)
+		}
+	}
+	// Always collect the Result so the Buffer is no longer in use
+	err = windows.GetOverlappedResult(s.h, ov, &done, true)
+	if err == windows.ERROR_OPERATION_ABORTED {
+		err = nil
+	}
+	return int(done), err
+}
+
+// available returns the number of bytes waiting to be Read
+func (s *serialPort) available() (int, error) {
+	if s.pipe {
+		var avail uint32
+		if err := peekNamedPipe(s.h, nil, 0, nil, &avail, nil); err != nil {
+			return 0, err
+		}
+		return int(avail), nil
+	}
+	st, err := s.commStatus()
+	if err != nil {
+		return 0, err
+	}
+	return int(st.CbInQue), nil
+}
+
+// outputEmpty reports whether the output queue is empty; Windows does not
+// report the Shift Register without WaitCommEvent
+func (s *serialPort) outputEmpty() (bool, error) {
+	if s.pipe {
+		return true, nil
+	}
+	st, err := s.commStatus()
+	if err != nil {
+		return false, err
+	}
+	return st.CbOutQue == 0, nil
+}
+
+// commStatus returns the queue sizes, adding the line errors that
+// ClearCommError reports and clears to the Counters
+func (s *serialPort) commStatus() (comStat, error) {
+	var errs uint32
+	var st comStat
+	if err := clearCommError(s.h, &errs, &st); err != nil {
+		return st, err
+	}
+	c := &s.lineErrs
+	if errs&ceFrame != 0 {
+		atomic.AddUint64(&c.Frame, 1)
+	}
+	if errs&ceRXParity != 0 {
+		atomic.AddUint64(&c.Parity, 1)
+	}
+	if errs&ceOverrun != 0 {
+		atomic.AddUint64(&c.Overrun, 1)
+	}
+	if errs&ceRXOver != 0 {
+		atomic.AddUint64(&c.BufferOverrun, 1)
+	}
+	if errs&ceBreak != 0 {
+		atomic.AddUint64(&c.Break, 1)
+	}
+	return st, nil
+}
+
+// Counters returns the line errors seen, each counted once per sample of
+// ClearCommError that reported it
+func (s *serialPort) Counters() (LineCounters, error) {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	// Check If its Open
+	if !s.opened {
+		return LineCounters{}, ErrNotOpen
+	}
+	if !s.pipe {
+		if _, err := s.commStatus(); err != nil {
+			return LineCounters{}, err
+		}
+	}
+	return LineCounters{
+		Frame:         atomic.LoadUint64(&s.lineErrs.Frame),
+		Parity:        atomic.LoadUint64(&s.lineErrs.Parity),
+		Overrun:       atomic.LoadUint64(&s.lineErrs.Overrun),
+		BufferOverrun: atomic.LoadUint64(&s.lineErrs.BufferOverrun),
+		Break:         atomic.LoadUint64(&s.lineErrs.Break),
+	}, nil
+}
+
+func (s *serialPort) Read(p []byte) (n int, err error) {
+	n, err = s.read(p)
+	err = portError("read", s.conf.Name, removedError(err))
+	s.countRead(p[:n], err)
+	return n, err
+}
+
+func (s *serialPort) read(p []byte) (n int, err error) {
+	// Establish Lock - Readers are Serialised, Writers are not Blocked
+	s.rxMx.Lock()
+	defer s.rxMx.Unlock()
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+
+	// Check If its Open
+	if !s.opened {
+		return 0, ErrNotOpen
+	}
+	// Re-arm RX Events
+	s.rearmEvents()
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	// COM Ports are bounded by COMMTIMEOUTS, Pipes by waiting
+	wait := time.Duration(-1)
+	if s.pipe {
+		if s.readTimeout == 0 {
+			if avail, err := s.available(); err != nil || avail == 0 {
+				return 0, s.pipeError(err)
+			}
+		} else {
+			wait = s.readTimeout
+		}
+	}
+	n, err = s.overlapped(&s.rxOv, wait, func(done *uint32, ov *windows.Overlapped) error {
+		return windows.ReadFile(s.h, p, done, ov)
+	})
+	if err != nil {
+		return n, s.pipeError(err)
+	}
+	if n == 0 && s.readTimeout > 0 {
+		return 0, ErrReadTimeout
+	}
+	return n, nil
+}
+
+// removedError maps the errors an unplugged USB adapter produces to
+// ErrDeviceRemoved
+func removedError(err error) error {
+	switch err {
+	case windows.ERROR_DEVICE_NOT_CONNECTED, windows.ERROR_BAD_COMMAND, windows.ERROR_GEN_FAILURE:
+		return ErrDeviceRemoved
+	}
+	return err
+}
+
+// pipeError maps a broken Pipe to io.EOF
+func (s *serialPort) pipeError(err error) error {
+	if err == windows.ERROR_BROKEN_PIPE || err == windows.ERROR_PIPE_NOT_CONNECTED {
+		return io.EOF
+	}
+	return err
+}
+
+// Write sends all of p before returning unless an error occurs. Calls are
+// serialised so concurrent writers never interleave within one call, unless
+// Config.SingleWriter is set.
+func (s *serialPort) Write(p []byte) (n int, err error) {
+	defer func() {
+		err = portError("write", s.conf.Name, removedError(err))
+		s.countWrite(p[:n], int64(n), err)
+		if n > 0 {
+			s.armTXEmpty()
+		}
+	}()
+	// Establish Lock - Writers are Serialised, Readers are not Blocked
+	if !s.conf.SingleWriter {
+		s.txMx.Lock()
+		defer s.txMx.Unlock()
+	}
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+
+	// Check If its Open
+	if !s.opened {
+		return 0, ErrNotOpen
+	}
+
+	for n < len(p) {
+		c, err := s.overlapped(&s.txOv, -1, func(done *uint32, ov *windows.Overlapped) error {
+			return windows.WriteFile(s.h, p[n:], done, ov)
+		})
+		n += c
+		if err != nil {
+			return n, s.pipeError(err)
+		}
+		if c == 0 {
+			return n, io.ErrShortWrite
+		}
+	}
+	return n, nil
+}
+
+func (s *serialPort) Close() error {
+	// Establish Lock
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	// Check If its Open
+	if !s.opened {
+		return ErrPortNotInitialized
+	}
+
+	// Auto Run at the End of the function
+	defer func() {
+		s.h = 0
+		s.opened = false
+	}()
+
+	// Stop the Event Monitor before the Handle goes away
+	s.stopEvents()
+	s.log.Log(LevelInfo, "serial port closed", "port", s.conf.Name)
+
+	windows.CloseHandle(s.rxOv.HEvent)
+	windows.CloseHandle(s.txOv.HEvent)
+	// Perform the Actual Close
+	return windows.CloseHandle(s.h)
+}
+
+func (s *serialPort) SetParity(parity string, stopbits int) (err error) {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	// Check If its Open
+	if !s.opened {
+		return ErrNotOpen
+	}
+	// Pipes have no Line Settings
+	if s.pipe {
+		return nil
+	}
+	if err = s.updateDCB(func(d *dcb) error {
+		return setDCBFraming(d, parity, stopbits)
+	}); err != nil {
+		return err
+	}
+	// Store the Parity
+	s.conf.Parity = parity
+	s.conf.StopBits = stopbits
+	s.log.Log(LevelInfo, "serial config changed", "port", s.conf.Name, "parity", parity, "stopbits", stopbits)
+	return nil
+}
+
+// setMarkParity switches between mark and space parity for NineBitPort
+// without logging each byte
+func (s *serialPort) setMarkParity(mark bool) error {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	// Check If its Open
+	if !s.opened {
+		return ErrNotOpen
+	}
+	if s.pipe {
+		return nil
+	}
+	return s.updateDCB(func(d *dcb) error {
+		d.Flags |= dcbParity
+		d.Parity = 4
+		if mark {
+			d.Parity = 3
+		}
+		return nil
+	})
+}
+
+// SetRTSToggle has the driver raise RTS while sending, RTS_CONTROL_TOGGLE,
+// or go back to holding it on
+func (s *serialPort) SetRTSToggle(on bool) error {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	// Check If its Open
+	if !s.opened {
+		return ErrNotOpen
+	}
+	if s.pipe {
+		return nil
+	}
+	if err := s.updateDCB(func(d *dcb) error {
+		d.Flags &^= dcbRtsControlMask | dcbOutxCtsFlow
+		if on {
+			d.Flags |= dcbRtsToggle
+		} else {
+			d.Flags |= dcbRtsControlEnable
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	s.log.Log(LevelInfo, "serial config changed", "port", s.conf.Name, "rts toggle", on)
+	return nil
+}
+
+// SetBaudRate changes the line speed, keeping the framing
+func (s *serialPort) SetBaudRate(baud int) error {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	// Check If its Open
+	if !s.opened {
+		return ErrNotOpen
+	}
+	if baud <= 0 {
+		return &ConfigError{Setting: "baud", Value: baud}
+	}
+	if s.pipe {
+		return nil
+	}
+	if err := s.updateDCB(func(d *dcb) error {
+		d.BaudRate = uint32(baud)
+		return nil
+	}); err != nil {
+		return err
+	}
+	s.conf.Baud = baud
+	s.log.Log(LevelInfo, "serial config changed", "port", s.conf.Name, "baud", baud)
+	return nil
+}
+
+// 清除缓存
+func (s *serialPort) Flush() error {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	// Check If its Open
+	if !s.opened {
+		return ErrNotOpen
+	}
+	if s.pipe {
+		return nil
+	}
+	// Abort Pending Writes too so a blocked Drain returns
+	return purgeComm(s.h, purgeRxClear|purgeTxClear|purgeTxAbort)
+}
+
+// FlushInput discards received data not yet Read, leaving queued output
+func (s *serialPort) FlushInput() error {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	// Check If its Open
+	if !s.opened {
+		return ErrNotOpen
+	}
+	if s.pipe {
+		return nil
+	}
+	return purgeComm(s.h, purgeRxClear)
+}
+
+// Drain waits until all queued output has been transmitted
+func (s *serialPort) Drain() error {
+	// Wait for In-flight Writes
+	s.txMx.Lock()
+	defer s.txMx.Unlock()
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	// Check If its Open
+	if !s.opened {
+		return ErrNotOpen
+	}
+	return windows.FlushFileBuffers(s.h)
+}
+
+// Queued returns the bytes waiting in the driver's input and output queues
+func (s *serialPort) Queued() (in, out int, err error) {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	// Check If its Open
+	if !s.opened {
+		return 0, 0, ErrNotOpen
+	}
+	if s.pipe {
+		in, err = s.available()
+		return in, 0, err
+	}
+	st, err := s.commStatus()
+	if err != nil {
+		return 0, 0, err
+	}
+	return int(st.CbInQue), int(st.CbOutQue), nil
+}
+
+// WaitReadable blocks until data is waiting. Windows offers no readiness
+// wait that coexists with overlapped reads, so the input queue is sampled.
+func (s *serialPort) WaitReadable(ctx context.Context) error {
+	t := time.NewTicker(5 * time.Millisecond)
+	defer t.Stop()
+	for {
+		s.mx.RLock()
+		if !s.opened {
+			s.mx.RUnlock()
+			return ErrNotOpen
+		}
+		n, err := s.available()
+		s.mx.RUnlock()
+		if err != nil {
+			return s.pipeError(err)
+		}
+		if n > 0 {
+			return nil
+		}
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// modemLines reads the Modem Status Lines as Line* bits
+func (s *serialPort) modemLines() (int, error) {
+	var st uint32
+	if err := getCommModemStatus(s.h, &st); err != nil {
+		return 0, err
+	}
+	lines := 0
+	for _, b := range []struct{ ms, line int }{
+		{msCTSOn, LineCTS}, {msDSROn, LineDSR}, {msRingOn, LineRI}, {msRLSDOn, LineDCD},
+	} {
+		if int(st)&b.ms != 0 {
+			lines |= b.line
+		}
+	}
+	return lines, nil
+}
+
+// escape runs one EscapeCommFunction on an open COM Port
+func (s *serialPort) escape(fn uint32) error {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	// Check If its Open
+	if !s.opened {
+		return ErrNotOpen
+	}
+	if s.pipe {
+		return nil
+	}
+	return escapeCommFunction(s.h, fn)
+}
+
+// SetDTR drives the Data Terminal Ready line
+func (s *serialPort) SetDTR(on bool) error {
+	if on {
+		return s.escape(setDTR)
+	}
+	return s.escape(clrDTR)
+}
+
+// SetRTS drives the Request To Send line
+func (s *serialPort) SetRTS(on bool) error {
+	if on {
+		return s.escape(setRTS)
+	}
+	return s.escape(clrRTS)
+}
+
+// ModemLines returns the current modem status lines as Line* bits
+func (s *serialPort) ModemLines() (int, error) {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	// Check If its Open
+	if !s.opened {
+		return 0, ErrNotOpen
+	}
+	if s.pipe {
+		return 0, nil
+	}
+	return s.modemLines()
+}
+
+// SendBreak holds TX in the break condition for d
+func (s *serialPort) SendBreak(d time.Duration) error {
+	// Keep Writers out while the Line is held
+	s.txMx.Lock()
+	defer s.txMx.Unlock()
+	if err := s.escape(setBreak); err != nil {
+		return err
+	}
+	time.Sleep(d)
+	return s.escape(clrBreak)
+}
+
+func setDCBFraming(d *dcb, parity string, stopbits int) error {
+	d.Flags &^= dcbParity
+	switch parity {
+	case "N", "":
+		d.Parity = 0
+	case "O":
+		d.Parity = 1
+	case "E":
+		d.Parity = 2
+	case "M":
+		d.Parity = 3
+	case "S", "G":
+		d.Parity = 4
+	default:
+		return &ConfigError{Setting: "parity", Value: parity}
+	}
+	if d.Parity != 0 {
+		d.Flags |= dcbParity
+	}
+	switch stopbits {
+	case 0, 1:
+		d.StopBits = 0
+	case 2:
+		d.StopBits = 2
+	default:
+		return &ConfigError{Setting: "stop bits", Value: stopbits}
+	}
+	return nil
+}
+
+// newDCB returns an empty DCB with its length set, as GetCommState and
+// SetCommState require
+func newDCB() dcb {
+	var d dcb
+	d.DCBlength = uint32(unsafe.Sizeof(d))
+	return d
+}
+
+// updateDCB reads the COM Port's DCB, applies change and writes it back
+func (s *serialPort) updateDCB(change func(d *dcb) error) error {
+	d := newDCB()
+	if err := getCommState(s.h, &d); err != nil {
+		return err
+	}
+	if err := change(&d); err != nil {
+		return err
+	}
+	return setCommState(s.h, &d)
+}
+
+func getDCBFor(cfg *Config) (dcb, error) {
+	d := newDCB()
+	d.BaudRate = uint32(cfg.Baud)
+	if cfg.Baud == 0 {
+		d.BaudRate = 19200
+	}
+	d.ByteSize = 8
+	d.Flags = dcbBinary | dcbDtrControlEnable | dcbRtsControlEnable
+	if err := setDCBFraming(&d, cfg.Parity, cfg.StopBits); err != nil {
+		return dcb{}, err
+	}
+	// Set Flow Control
+	switch cfg.Flow {
+	case FlowNone:
+	case FlowSoft:
+		d.Flags |= dcbOutX | dcbInX
+		d.XonChar, d.XoffChar = 0x11, 0x13
+		d.XonLim, d.XoffLim = 512, 512
+	case FlowHardware:
+		d.Flags = d.Flags&^dcbRtsControlMask | dcbOutxCtsFlow | dcbRtsHandshake
+	default:
+		return dcb{}, &ConfigError{Setting: "flow control", Value: cfg.Flow}
+	}
+	return d, nil
+}
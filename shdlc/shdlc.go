@@ -0,0 +1,313 @@
+// Package shdlc implements SHDLC, the framing Sensirion sensors such as the
+// SPS30 speak over UART: frames between 0x7E flags with 0x7D byte stuffing
+// and an inverted sum checksum, MOSI frames carrying a command from the
+// master and MISO frames carrying the device's state and reply.
+package shdlc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/packing/xserial"
+)
+
+// Special Characters
+const (
+	Flag   = 0x7E
+	Escape = 0x7D
+	// Escaped bytes are sent XORed with this
+	escapeXOR = 0x20
+)
+
+// MaxData is the most data a frame carries
+const MaxData = 255
+
+// Common Commands
+const (
+	CmdDeviceInfo   = 0xD0
+	CmdVersion      = 0xD1
+	CmdDeviceStatus = 0xD2
+	CmdReset        = 0xD3
+)
+
+// StateDeviceError is the bit of a MISO State flagging an error logged by
+// the device, readable with CmdDeviceStatus; the rest is the command's
+// error code
+const StateDeviceError = 0x80
+
+var (
+	// ErrFormat - the frame is too short or its length does not match
+	ErrFormat = errors.New("shdlc: malformed frame")
+	// ErrTooLarge - the data is over MaxData
+	ErrTooLarge = errors.New("shdlc: frame too large")
+	// ErrNoResponse - the device did not answer in time
+	ErrNoResponse = errors.New("shdlc: no response")
+	// ErrMismatch - the reply is for another address or command
+	ErrMismatch = errors.New("shdlc: reply does not match the command")
+)
+
+// StateError is returned for a MISO frame with an error code in its State
+type StateError struct {
+	Cmd   byte
+	State byte
+}
+
+func (e *StateError) Error() string {
+	return fmt.Sprintf("shdlc: command 0x%02X failed, state 0x%02X", e.Cmd, e.State)
+}
+
+// Checksum returns the SHDLC checksum of b, the inverted low byte of its
+// sum
+func Checksum(b []byte) byte {
+	var sum byte
+	for _, c := range b {
+		sum += c
+	}
+	return ^sum
+}
+
+// MOSI is a frame from master to device
+type MOSI struct {
+	Addr byte
+	Cmd  byte
+	Data []byte
+}
+
+// MISO is a frame from device to master
+type MISO struct {
+	Addr  byte
+	Cmd   byte
+	State byte
+	Data  []byte
+}
+
+// Marshal returns f stuffed between flags
+func (f *MOSI) Marshal() ([]byte, error) {
+	if len(f.Data) > MaxData {
+		return nil, ErrTooLarge
+	}
+	return Encode(append([]byte{f.Addr, f.Cmd, byte(len(f.Data))}, f.Data...)), nil
+}
+
+// Marshal returns f stuffed between flags
+func (f *MISO) Marshal() ([]byte, error) {
+	if len(f.Data) > MaxData {
+		return nil, ErrTooLarge
+	}
+	return Encode(append([]byte{f.Addr, f.Cmd, f.State, byte(len(f.Data))}, f.Data...)), nil
+}
+
+// ParseMOSI decodes the contents of a frame as returned by a Decoder
+func ParseMOSI(b []byte) (MOSI, error) {
+	if len(b) < 3 || int(b[2]) != len(b)-3 {
+		return MOSI{}, ErrFormat
+	}
+	return MOSI{Addr: b[0], Cmd: b[1], Data: b[3:]}, nil
+}
+
+// ParseMISO decodes the contents of a frame as returned by a Decoder
+func ParseMISO(b []byte) (MISO, error) {
+	if len(b) < 4 || int(b[3]) != len(b)-4 {
+		return MISO{}, ErrFormat
+	}
+	return MISO{Addr: b[0], Cmd: b[1], State: b[2], Data: b[4:]}, nil
+}
+
+// Encode returns b with its checksum, stuffed between flags
+func Encode(b []byte) []byte {
+	out := make([]byte, 0, len(b)+len(b)/8+4)
+	out = append(out, Flag)
+	put := func(c byte) {
+		switch c {
+		case Flag, Escape, 0x11, 0x13:
+			out = append(out, Escape, c^escapeXOR)
+		default:
+			out = append(out, c)
+		}
+	}
+	for _, c := range b {
+		put(c)
+	}
+	put(Checksum(b))
+	return append(out, Flag)
+}
+
+// Decoder is an xserial.FrameDecoder returning the contents of intact
+// frames, unstuffed and without the checksum. Frames with a bad checksum
+// or over the largest MISO frame are dropped and counted as resyncs; empty
+// frames between flags are skipped.
+type Decoder struct {
+	buf     []byte
+	esc     bool
+	bad     bool
+	resyncs uint64
+}
+
+// NewDecoder returns a Decoder
+func NewDecoder() *Decoder {
+	return &Decoder{}
+}
+
+func (d *Decoder) Feed(p []byte) [][]byte {
+	var frames [][]byte
+	for _, c := range p {
+		if c == Flag {
+			if f := d.end(); f != nil {
+				frames = append(frames, f)
+			}
+			continue
+		}
+		if d.bad {
+			continue
+		}
+		if d.esc {
+			d.esc = false
+			c ^= escapeXOR
+		} else if c == Escape {
+			d.esc = true
+			continue
+		}
+		// Address, Command, State, Length and Checksum
+		if len(d.buf) == MaxData+5 {
+			d.bad = true
+			d.resyncs++
+			continue
+		}
+		d.buf = append(d.buf, c)
+	}
+	return frames
+}
+
+// end closes the frame at a flag, returning its contents if intact
+func (d *Decoder) end() []byte {
+	defer d.Reset()
+	switch {
+	case d.bad:
+		return nil
+	case len(d.buf) == 0 && !d.esc:
+		return nil
+	case d.esc, len(d.buf) < 4:
+		d.resyncs++
+		return nil
+	}
+	n := len(d.buf) - 1
+	if Checksum(d.buf[:n]) != d.buf[n] {
+		d.resyncs++
+		return nil
+	}
+	return append([]byte(nil), d.buf[:n]...)
+}
+
+func (d *Decoder) Resyncs() uint64 {
+	return d.resyncs
+}
+
+func (d *Decoder) Reset() {
+	d.buf = d.buf[:0]
+	d.esc = false
+	d.bad = false
+}
+
+// Config configures a Conn
+type Config struct {
+	// Time to wait for a reply, defaults to 1 second
+	Timeout time.Duration
+	// Times Exchange sends again after no reply, defaults to 2; negative
+	// for none
+	Retries int
+}
+
+// Conn is the master side of an SHDLC link. Its methods must not be called
+// concurrently.
+type Conn struct {
+	p   xserial.Port
+	cfg Config
+	rd  *xserial.FrameReader
+}
+
+// NewConn returns a Conn over p. cfg may be nil.
+func NewConn(p xserial.Port, cfg *Config) *Conn {
+	var c Config
+	if cfg != nil {
+		c = *cfg
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = time.Second
+	}
+	if c.Retries < 0 {
+		c.Retries = 0
+	} else if c.Retries == 0 {
+		c.Retries = 2
+	}
+	return &Conn{p: p, cfg: c, rd: xserial.NewFrameReader(p, NewDecoder())}
+}
+
+// Exchange sends data as command cmd to the device at addr and returns the
+// data of its reply. A reply whose State holds an error code is returned
+// along with a StateError.
+func (c *Conn) Exchange(ctx context.Context, addr, cmd byte, data []byte) ([]byte, error) {
+	f, err := c.Transceive(ctx, &MOSI{Addr: addr, Cmd: cmd, Data: data})
+	if err != nil {
+		return nil, err
+	}
+	if f.State&^StateDeviceError != 0 {
+		return f.Data, &StateError{Cmd: cmd, State: f.State}
+	}
+	return f.Data, nil
+}
+
+// Transceive sends req and returns the MISO frame replying to it, sending
+// req again whenever no reply arrives within Timeout. Damaged replies are
+// dropped like silence.
+func (c *Conn) Transceive(ctx context.Context, req *MOSI) (MISO, error) {
+	b, err := req.Marshal()
+	if err != nil {
+		return MISO{}, err
+	}
+	for attempt := 0; attempt <= c.cfg.Retries; attempt++ {
+		if _, err := c.p.Write(b); err != nil {
+			return MISO{}, err
+		}
+		f, err := c.reply(ctx, req)
+		if err != ErrNoResponse {
+			return f, err
+		}
+	}
+	return MISO{}, ErrNoResponse
+}
+
+// reply waits up to Timeout for the MISO frame answering req, dropping
+// frames that do not parse
+func (c *Conn) reply(ctx context.Context, req *MOSI) (MISO, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.cfg.Timeout)
+	defer cancel()
+	for {
+		b, err := c.rd.ReadFrameContext(ctx)
+		if err == xserial.ErrReadTimeout {
+			if err = ctx.Err(); err == nil {
+				continue
+			}
+		}
+		if err == context.DeadlineExceeded {
+			return MISO{}, ErrNoResponse
+		}
+		if err != nil {
+			return MISO{}, err
+		}
+		f, err := ParseMISO(b)
+		if err != nil {
+			continue
+		}
+		if f.Addr != req.Addr || f.Cmd != req.Cmd {
+			return f, ErrMismatch
+		}
+		return f, nil
+	}
+}
+
+// Resyncs returns how many damaged frames were dropped
+func (c *Conn) Resyncs() uint64 {
+	return c.rd.Decoder().Resyncs()
+}
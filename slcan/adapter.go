@@ -0,0 +1,224 @@
+package slcan
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/packing/xserial"
+)
+
+// Status Flags read with Status
+const (
+	StatusRxFull          = 0x01
+	StatusTxFull          = 0x02
+	StatusErrorWarning    = 0x04
+	StatusDataOverrun     = 0x08
+	StatusErrorPassive    = 0x20
+	StatusArbitrationLost = 0x40
+	StatusBusError        = 0x80
+)
+
+// Bit Rates and their S Commands
+var bitrates = map[int]byte{
+	10000:   '0',
+	20000:   '1',
+	50000:   '2',
+	100000:  '3',
+	125000:  '4',
+	250000:  '5',
+	500000:  '6',
+	800000:  '7',
+	1000000: '8',
+}
+
+// Config configures an Adapter
+type Config struct {
+	// CAN bit rate, one of the LAWICEL rates from 10000 to 1000000,
+	// defaults to 500000
+	Bitrate int
+	// Open the channel listening only, neither sending nor acknowledging
+	ListenOnly bool
+	// Have the adapter stamp received frames with milliseconds
+	Timestamps bool
+	// How long to wait for each reply, defaults to 1 second
+	Timeout time.Duration
+}
+
+// Adapter drives a LAWICEL adapter on a Port. Frames arriving while a
+// command waits for its reply are kept for ReadFrame. Its methods must not
+// be called concurrently.
+type Adapter struct {
+	p       xserial.Port
+	cfg     Config
+	rd      *xserial.FrameReader
+	pending []Frame
+}
+
+// Open sets up the adapter on p and opens its CAN channel. cfg may be
+// nil.
+func Open(ctx context.Context, p xserial.Port, cfg *Config) (*Adapter, error) {
+	var c Config
+	if cfg != nil {
+		c = *cfg
+	}
+	if c.Bitrate == 0 {
+		c.Bitrate = 500000
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = time.Second
+	}
+	rate, ok := bitrates[c.Bitrate]
+	if !ok {
+		return nil, &xserial.ConfigError{Setting: "can bitrate", Value: c.Bitrate}
+	}
+	a := &Adapter{p: p, cfg: c, rd: xserial.NewFrameReader(p, NewDecoder())}
+	// Empty Lines end any half sent Command; the Replies are dropped
+	if _, err := p.Write([]byte{CR, CR, CR}); err != nil {
+		return nil, err
+	}
+	a.drain(ctx, 100*time.Millisecond)
+	// Close fails when the Channel is closed already
+	a.command(ctx, "C")
+	if _, err := a.command(ctx, "S"+string(rate)); err != nil {
+		return nil, err
+	}
+	if c.Timestamps {
+		if _, err := a.command(ctx, "Z1"); err != nil {
+			return nil, err
+		}
+	}
+	open := "O"
+	if c.ListenOnly {
+		open = "L"
+	}
+	if _, err := a.command(ctx, open); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// drain drops whatever arrives for d
+func (a *Adapter) drain(ctx context.Context, d time.Duration) {
+	ctx, cancel := context.WithTimeout(ctx, d)
+	defer cancel()
+	for ctx.Err() == nil {
+		a.rd.ReadFrameContext(ctx)
+	}
+	a.pending = nil
+}
+
+// line returns the next line, waiting until ctx is done
+func (a *Adapter) line(ctx context.Context) ([]byte, error) {
+	for {
+		b, err := a.rd.ReadFrameContext(ctx)
+		if err == xserial.ErrReadTimeout {
+			if err = ctx.Err(); err == nil {
+				continue
+			}
+		}
+		return b, err
+	}
+}
+
+// command sends cmd and returns its reply without the CR
+func (a *Adapter) command(ctx context.Context, cmd string) ([]byte, error) {
+	if _, err := a.p.Write(append([]byte(cmd), CR)); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, a.cfg.Timeout)
+	defer cancel()
+	for {
+		b, err := a.line(ctx)
+		if err == context.DeadlineExceeded {
+			return nil, ErrNoResponse
+		}
+		if err != nil {
+			return nil, err
+		}
+		if b[0] == BEL {
+			return nil, ErrCommand
+		}
+		if isFrame(b) {
+			if f, err := Parse(b); err == nil {
+				a.pending = append(a.pending, f)
+			}
+			continue
+		}
+		return b[:len(b)-1], nil
+	}
+}
+
+// Close closes the CAN channel, leaving the Port open
+func (a *Adapter) Close(ctx context.Context) error {
+	_, err := a.command(ctx, "C")
+	return err
+}
+
+// WriteFrame transmits f
+func (a *Adapter) WriteFrame(ctx context.Context, f *Frame) error {
+	b, err := f.Marshal()
+	if err != nil {
+		return err
+	}
+	// Replies are "z" or "Z" with Auto Poll, otherwise empty
+	_, err = a.command(ctx, string(b[:len(b)-1]))
+	return err
+}
+
+// ReadFrame returns the next frame received, dropping malformed lines
+func (a *Adapter) ReadFrame(ctx context.Context) (Frame, error) {
+	if len(a.pending) > 0 {
+		f := a.pending[0]
+		a.pending = a.pending[1:]
+		return f, nil
+	}
+	for {
+		b, err := a.line(ctx)
+		if err != nil {
+			return Frame{}, err
+		}
+		if !isFrame(b) {
+			continue
+		}
+		if f, err := Parse(b); err == nil {
+			return f, nil
+		}
+	}
+}
+
+// Version returns the hardware and software version, as "1013"
+func (a *Adapter) Version(ctx context.Context) (string, error) {
+	return a.query(ctx, "V")
+}
+
+// Serial returns the adapter's serial number
+func (a *Adapter) Serial(ctx context.Context) (string, error) {
+	return a.query(ctx, "N")
+}
+
+// Status returns the Status flags of the CAN controller, which also
+// clears them
+func (a *Adapter) Status(ctx context.Context) (byte, error) {
+	s, err := a.query(ctx, "F")
+	if err != nil {
+		return 0, err
+	}
+	v, err := strconv.ParseUint(s, 16, 8)
+	if err != nil {
+		return 0, ErrFormat
+	}
+	return byte(v), nil
+}
+
+// query runs cmd and returns its reply after the echoed command letter
+func (a *Adapter) query(ctx context.Context, cmd string) (string, error) {
+	b, err := a.command(ctx, cmd)
+	if err != nil {
+		return "", err
+	}
+	if len(b) == 0 || b[0] != cmd[0] {
+		return "", ErrFormat
+	}
+	return string(b[1:]), nil
+}
@@ -0,0 +1,215 @@
+// Package slcan speaks the LAWICEL Serial Line CAN protocol of USB CAN
+// adapters such as CANable and USBtin: ASCII commands ending in CR that
+// set the bit rate and open the channel, and frames sent and received as
+// hex lines, so the adapter reads and writes CAN frames for a Go CAN stack.
+package slcan
+
+import (
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"time"
+)
+
+// Reply Characters
+const (
+	CR  = '\r'
+	BEL = 0x07
+)
+
+// Adapters expect upper case Hex
+const hexDigits = "0123456789ABCDEF"
+
+// MaxLine is the longest line a Decoder accepts, an extended frame with
+// eight bytes and a timestamp
+const MaxLine = 32
+
+var (
+	// ErrFormat - the line is not a valid frame
+	ErrFormat = errors.New("slcan: malformed frame")
+	// ErrCommand - the adapter answered a command with BEL
+	ErrCommand = errors.New("slcan: command refused")
+	// ErrNoResponse - the adapter did not answer in time
+	ErrNoResponse = errors.New("slcan: no response")
+)
+
+// Frame is one classic CAN frame
+type Frame struct {
+	// 11-bit identifier, or 29-bit when Extended
+	ID       uint32
+	Extended bool
+	// Remote transmission request, carrying a Length but no data
+	Remote bool
+	Length uint8
+	Data   [8]byte
+	// Time received within the minute, when timestamps are on
+	Timestamp time.Duration
+}
+
+// Bytes returns the data bytes of f
+func (f *Frame) Bytes() []byte {
+	if f.Remote || f.Length > 8 {
+		return nil
+	}
+	return f.Data[:f.Length]
+}
+
+// Marshal returns f as a transmit command, such as "t1232AABB\r"
+func (f *Frame) Marshal() ([]byte, error) {
+	if f.Length > 8 || f.Extended && f.ID > 0x1FFFFFFF || !f.Extended && f.ID > 0x7FF {
+		return nil, ErrFormat
+	}
+	cmd, digits := byte('t'), 3
+	switch {
+	case f.Extended && f.Remote:
+		cmd, digits = 'R', 8
+	case f.Extended:
+		cmd, digits = 'T', 8
+	case f.Remote:
+		cmd = 'r'
+	}
+	b := make([]byte, 0, MaxLine)
+	b = append(b, cmd)
+	for i := digits - 1; i >= 0; i-- {
+		b = append(b, hexDigits[f.ID>>uint(4*i)&0x0F])
+	}
+	b = append(b, '0'+f.Length)
+	if !f.Remote {
+		for _, c := range f.Data[:f.Length] {
+			b = append(b, hexDigits[c>>4], hexDigits[c&0x0F])
+		}
+	}
+	return append(b, CR), nil
+}
+
+// Parse decodes a received frame line, with or without its CR. A four
+// digit timestamp after the data is read when present.
+func Parse(line []byte) (Frame, error) {
+	var f Frame
+	if n := len(line); n > 0 && line[n-1] == CR {
+		line = line[:n-1]
+	}
+	if len(line) == 0 {
+		return f, ErrFormat
+	}
+	digits := 3
+	switch line[0] {
+	case 't':
+	case 'r':
+		f.Remote = true
+	case 'T':
+		f.Extended, digits = true, 8
+	case 'R':
+		f.Extended, f.Remote, digits = true, true, 8
+	default:
+		return f, ErrFormat
+	}
+	if len(line) < 2+digits {
+		return f, ErrFormat
+	}
+	id, err := strconv.ParseUint(string(line[1:1+digits]), 16, 32)
+	if err != nil {
+		return f, ErrFormat
+	}
+	f.ID = uint32(id)
+	if !f.Extended && f.ID > 0x7FF || f.ID > 0x1FFFFFFF {
+		return f, ErrFormat
+	}
+	l := line[1+digits]
+	if l < '0' || l > '8' {
+		return f, ErrFormat
+	}
+	f.Length = l - '0'
+	rest := line[2+digits:]
+	if !f.Remote {
+		n := 2 * int(f.Length)
+		if len(rest) < n {
+			return f, ErrFormat
+		}
+		if _, err := hex.Decode(f.Data[:], rest[:n]); err != nil {
+			return f, ErrFormat
+		}
+		rest = rest[n:]
+	}
+	switch len(rest) {
+	case 0:
+	case 4:
+		ms, err := strconv.ParseUint(string(rest), 16, 16)
+		if err != nil {
+			return f, ErrFormat
+		}
+		f.Timestamp = time.Duration(ms) * time.Millisecond
+	default:
+		return f, ErrFormat
+	}
+	return f, nil
+}
+
+// isFrame reports whether line is a received frame rather than a reply
+func isFrame(line []byte) bool {
+	if len(line) == 0 {
+		return false
+	}
+	switch line[0] {
+	case 't', 'T', 'r', 'R':
+		return true
+	}
+	return false
+}
+
+// Decoder is an xserial.FrameDecoder splitting the adapter's output into
+// lines, each with its CR, and lone BELs. Lines over MaxLine count as
+// resyncs.
+type Decoder struct {
+	buf     []byte
+	skip    bool
+	resyncs uint64
+}
+
+// NewDecoder returns a Decoder
+func NewDecoder() *Decoder {
+	return &Decoder{}
+}
+
+func (d *Decoder) Feed(p []byte) [][]byte {
+	var frames [][]byte
+	for _, c := range p {
+		switch c {
+		case BEL:
+			if len(d.buf) > 0 && !d.skip {
+				d.resyncs++
+			}
+			d.Reset()
+			frames = append(frames, []byte{BEL})
+			continue
+		case CR:
+			if !d.skip {
+				frames = append(frames, append(append([]byte(nil), d.buf...), CR))
+			}
+			d.Reset()
+			continue
+		case '\n':
+			// Some Firmware ends Lines in CR LF
+			continue
+		}
+		if d.skip {
+			continue
+		}
+		if len(d.buf) >= MaxLine {
+			d.skip = true
+			d.resyncs++
+			continue
+		}
+		d.buf = append(d.buf, c)
+	}
+	return frames
+}
+
+func (d *Decoder) Resyncs() uint64 {
+	return d.resyncs
+}
+
+func (d *Decoder) Reset() {
+	d.buf = d.buf[:0]
+	d.skip = false
+}
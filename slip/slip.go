@@ -0,0 +1,179 @@
+// Package slip implements RFC 1055 Serial Line IP framing: packets end in
+// END and END or ESC inside them are escaped.
+package slip
+
+import (
+	"errors"
+	"io"
+)
+
+// RFC 1055 Special Characters
+const (
+	END    = 0xC0
+	ESC    = 0xDB
+	EscEND = 0xDC
+	EscESC = 0xDD
+)
+
+// DefaultMax is the largest packet a Decoder accepts by default, the RFC's
+// 1006 bytes with room for the common 1500 byte MTU
+const DefaultMax = 1500
+
+// ErrTooLarge is returned by Conn.Read when a packet does not fit the buffer
+var ErrTooLarge = errors.New("slip: packet larger than buffer")
+
+// Encode returns p as one SLIP packet. It starts with END as well, which
+// flushes any line noise the receiver has collected.
+func Encode(p []byte) []byte {
+	b := make([]byte, 0, len(p)+len(p)/8+2)
+	b = append(b, END)
+	for _, c := range p {
+		switch c {
+		case END:
+			b = append(b, ESC, EscEND)
+		case ESC:
+			b = append(b, ESC, EscESC)
+		default:
+			b = append(b, c)
+		}
+	}
+	return append(b, END)
+}
+
+// Decoder is an xserial.FrameDecoder for SLIP packets. Empty packets and
+// the bytes between repeated ENDs are skipped. A bad escape or a packet
+// over the maximum counts as a resync and the packet is dropped.
+type Decoder struct {
+	max     int
+	buf     []byte
+	esc     bool
+	bad     bool
+	resyncs uint64
+}
+
+// NewDecoder returns a Decoder accepting packets up to max bytes, or
+// DefaultMax if max is 0
+func NewDecoder(max int) *Decoder {
+	if max <= 0 {
+		max = DefaultMax
+	}
+	return &Decoder{max: max}
+}
+
+func (d *Decoder) Feed(p []byte) [][]byte {
+	var frames [][]byte
+	for _, c := range p {
+		if c == END {
+			if !d.bad && !d.esc && len(d.buf) > 0 {
+				frames = append(frames, append([]byte(nil), d.buf...))
+			} else if d.esc && !d.bad {
+				// ESC END is not a valid escape
+				d.resyncs++
+			}
+			d.Reset()
+			continue
+		}
+		if d.bad {
+			continue
+		}
+		if d.esc {
+			d.esc = false
+			switch c {
+			case EscEND:
+				c = END
+			case EscESC:
+				c = ESC
+			default:
+				d.fail()
+				continue
+			}
+		} else if c == ESC {
+			d.esc = true
+			continue
+		}
+		if len(d.buf) == d.max {
+			d.fail()
+			continue
+		}
+		d.buf = append(d.buf, c)
+	}
+	return frames
+}
+
+// fail drops the packet up to the next END
+func (d *Decoder) fail() {
+	d.bad = true
+	d.resyncs++
+}
+
+func (d *Decoder) Resyncs() uint64 {
+	return d.resyncs
+}
+
+func (d *Decoder) Reset() {
+	d.buf = d.buf[:0]
+	d.esc = false
+	d.bad = false
+}
+
+// Conn sends and receives SLIP packets over a byte stream such as an
+// xserial.Port. Each Write sends one packet and each Read returns one.
+type Conn struct {
+	rw      io.ReadWriter
+	dec     *Decoder
+	buf     []byte
+	pending [][]byte
+}
+
+// NewConn returns a Conn over rw accepting packets up to max bytes, or
+// DefaultMax if max is 0
+func NewConn(rw io.ReadWriter, max int) *Conn {
+	return &Conn{rw: rw, dec: NewDecoder(max), buf: make([]byte, 4096)}
+}
+
+// Write sends p as one packet
+func (c *Conn) Write(p []byte) (int, error) {
+	if _, err := c.rw.Write(Encode(p)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Read copies the next packet into p, returning ErrTooLarge with the
+// packet truncated if it does not fit. When the underlying Read returns
+// nothing, as a Port with a zero ReadTimeout does, Read returns 0 and its
+// error.
+func (c *Conn) Read(p []byte) (int, error) {
+	f, err := c.ReadPacket()
+	if f == nil {
+		return 0, err
+	}
+	n := copy(p, f)
+	if n < len(f) {
+		return n, ErrTooLarge
+	}
+	return n, nil
+}
+
+// ReadPacket returns the next packet
+func (c *Conn) ReadPacket() ([]byte, error) {
+	for len(c.pending) == 0 {
+		n, err := c.rw.Read(c.buf)
+		if n > 0 {
+			c.pending = c.dec.Feed(c.buf[:n])
+			continue
+		}
+		if err == nil {
+			return nil, nil
+		}
+		return nil, err
+	}
+	f := c.pending[0]
+	c.pending = c.pending[1:]
+	return f, nil
+}
+
+// Decoder returns the Conn's Decoder, for example to read its Resyncs
+func (c *Conn) Decoder() *Decoder {
+	return c.dec
+}
@@ -0,0 +1,105 @@
+package sms
+
+// GSM 03.38 Default Alphabet; 0x1B escapes to the Extension Table
+const gsm7Basic = "@£$¥èéùìòÇ\nØø\rÅåΔ_ΦΓΛΩΠΨΣΘΞ\x1bÆæßÉ !\"#¤%&'()*+,-./0123456789:;<=>?" +
+	"¡ABCDEFGHIJKLMNOPQRSTUVWXYZÄÖÑÜ§¿abcdefghijklmnopqrstuvwxyzäöñüà"
+
+const gsm7Escape = 0x1B
+
+var gsm7Extension = map[byte]rune{
+	0x0A: '\f',
+	0x14: '^',
+	0x28: '{',
+	0x29: '}',
+	0x2F: '\\',
+	0x3C: '[',
+	0x3D: '~',
+	0x3E: ']',
+	0x40: '|',
+	0x65: '€',
+}
+
+var (
+	gsm7Decode [128]rune
+	gsm7Encode = make(map[rune][]byte)
+)
+
+func init() {
+	i := 0
+	for _, r := range gsm7Basic {
+		gsm7Decode[i] = r
+		if i != gsm7Escape {
+			gsm7Encode[r] = []byte{byte(i)}
+		}
+		i++
+	}
+	for c, r := range gsm7Extension {
+		gsm7Encode[r] = []byte{gsm7Escape, c}
+	}
+}
+
+// toGSM7 returns the septets of s, false if s has a character outside the
+// alphabet
+func toGSM7(s string) ([]byte, bool) {
+	var out []byte
+	for _, r := range s {
+		b, ok := gsm7Encode[r]
+		if !ok {
+			return nil, false
+		}
+		out = append(out, b...)
+	}
+	return out, true
+}
+
+// fromGSM7 returns the text of septets
+func fromGSM7(septets []byte) string {
+	var out []rune
+	for i := 0; i < len(septets); i++ {
+		c := septets[i] & 0x7F
+		if c == gsm7Escape && i+1 < len(septets) {
+			i++
+			if r, ok := gsm7Extension[septets[i]&0x7F]; ok {
+				out = append(out, r)
+			} else {
+				// Unknown Extensions show as the Basic Character
+				out = append(out, gsm7Decode[septets[i]&0x7F])
+			}
+			continue
+		}
+		out = append(out, gsm7Decode[c])
+	}
+	return string(out)
+}
+
+// pack packs septets after fill bits, as they follow a user data header
+func pack(septets []byte, fill int) []byte {
+	bits := fill + 7*len(septets)
+	out := make([]byte, (bits+7)/8)
+	for i, s := range septets {
+		o := fill + 7*i
+		v := uint16(s&0x7F) << uint(o%8)
+		out[o/8] |= byte(v)
+		if v>>8 != 0 {
+			out[o/8+1] |= byte(v >> 8)
+		}
+	}
+	return out
+}
+
+// unpack returns n septets starting skip septets into b
+func unpack(b []byte, skip, n int) []byte {
+	out := make([]byte, 0, n)
+	for i := skip; i < n; i++ {
+		o := 7 * i
+		if o/8 >= len(b) {
+			break
+		}
+		v := uint16(b[o/8])
+		if o/8+1 < len(b) {
+			v |= uint16(b[o/8+1]) << 8
+		}
+		out = append(out, byte(v>>uint(o%8))&0x7F)
+	}
+	return out
+}
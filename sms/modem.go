@@ -0,0 +1,211 @@
+package sms
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/packing/xserial/atcmd"
+)
+
+// Message is a received message, reassembled from its parts
+type Message struct {
+	From string
+	// Service Centre Time Stamp of the first Part
+	Time time.Time
+	Text string
+	// User Data of 8 bit messages
+	Data []byte
+}
+
+// Config configures a Modem
+type Config struct {
+	// Request a delivery report for every message sent
+	StatusReport bool
+	// Optional - Called with each message received
+	OnMessage func(m *Message)
+	// Optional - Called with each delivery report
+	OnReport func(r *StatusReport)
+	// Drop parts of a concatenated message still incomplete after this
+	// long, defaults to 24 hours
+	PartTimeout time.Duration
+}
+
+// partKey identifies a concatenated message
+type partKey struct {
+	from  string
+	ref   uint16
+	total int
+}
+
+type partial struct {
+	parts   []*Deliver
+	have    int
+	started time.Time
+}
+
+// Modem sends and receives messages over an atcmd session
+type Modem struct {
+	c   *atcmd.Conn
+	cfg Config
+
+	mx    sync.Mutex
+	ref   byte
+	parts map[partKey]*partial
+}
+
+// New switches the modem to PDU mode, with new messages stored and
+// announced and delivery reports sent at once, and returns a Modem using
+// c. cfg may be nil.
+func New(ctx context.Context, c *atcmd.Conn, cfg *Config) (*Modem, error) {
+	var cf Config
+	if cfg != nil {
+		cf = *cfg
+	}
+	if cf.PartTimeout <= 0 {
+		cf.PartTimeout = 24 * time.Hour
+	}
+	m := &Modem{c: c, cfg: cf, parts: make(map[partKey]*partial)}
+	if _, err := c.Command(ctx, "AT+CMGF=0"); err != nil {
+		return nil, err
+	}
+	c.Handle("+CMTI:", 0, m.stored)
+	c.Handle("+CDS:", 1, m.report)
+	if _, err := c.Command(ctx, "AT+CNMI=2,1,0,1,0"); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Send sends text to number, split into parts when it is long, and
+// returns the message reference of each part for matching delivery
+// reports
+func (m *Modem) Send(ctx context.Context, number, text string) ([]int, error) {
+	m.mx.Lock()
+	m.ref++
+	ref := m.ref
+	m.mx.Unlock()
+	pdus, err := EncodeSubmit(number, text, m.cfg.StatusReport, ref)
+	if err != nil {
+		return nil, err
+	}
+	var refs []int
+	for _, pdu := range pdus {
+		// The Length excludes the Service Centre Field
+		cmd := fmt.Sprintf("AT+CMGS=%d", len(pdu)-1)
+		r, err := m.c.CommandPrompt(ctx, cmd, []byte(strings.ToUpper(hex.EncodeToString(pdu))))
+		if err != nil {
+			return refs, err
+		}
+		v, _ := r.Value("+CMGS")
+		mr, _ := strconv.Atoi(strings.SplitN(v, ",", 2)[0])
+		refs = append(refs, mr)
+	}
+	return refs, nil
+}
+
+// Read returns the message stored at index, deleting it when del is set
+func (m *Modem) Read(ctx context.Context, index int, del bool) (*Deliver, error) {
+	r, err := m.c.Command(ctx, fmt.Sprintf("AT+CMGR=%d", index))
+	if err != nil {
+		return nil, err
+	}
+	d, err := parseListed(r.Lines)
+	if err != nil {
+		return nil, err
+	}
+	if del {
+		if _, err := m.c.Command(ctx, fmt.Sprintf("AT+CMGD=%d", index)); err != nil {
+			return d, err
+		}
+	}
+	return d, nil
+}
+
+// parseListed decodes the PDU line following a +CMGR header
+func parseListed(lines []string) (*Deliver, error) {
+	for i, l := range lines {
+		if strings.HasPrefix(l, "+CMGR:") && i+1 < len(lines) {
+			b, err := hex.DecodeString(lines[i+1])
+			if err != nil {
+				return nil, ErrFormat
+			}
+			return ParseDeliver(b)
+		}
+	}
+	return nil, ErrFormat
+}
+
+// stored handles +CMTI: "SM",3 by reading and deleting the message
+func (m *Modem) stored(lines []string) {
+	v := lines[0][len("+CMTI:"):]
+	i := strings.LastIndexByte(v, ',')
+	index, err := strconv.Atoi(strings.TrimSpace(v[i+1:]))
+	if err != nil {
+		return
+	}
+	d, err := m.Read(context.Background(), index, true)
+	// A failed Delete still delivers the Message
+	if d == nil {
+		return
+	}
+	if msg := m.assemble(d); msg != nil && m.cfg.OnMessage != nil {
+		m.cfg.OnMessage(msg)
+	}
+}
+
+// report handles +CDS: with its PDU line
+func (m *Modem) report(lines []string) {
+	if len(lines) < 2 {
+		return
+	}
+	b, err := hex.DecodeString(lines[1])
+	if err != nil {
+		return
+	}
+	r, err := ParseStatusReport(b)
+	if err == nil && m.cfg.OnReport != nil {
+		m.cfg.OnReport(r)
+	}
+}
+
+// assemble returns the message d completes, nil while parts are missing
+func (m *Modem) assemble(d *Deliver) *Message {
+	if d.Total < 2 || d.Seq < 1 || d.Seq > d.Total {
+		return &Message{From: d.From, Time: d.Time, Text: d.Text, Data: d.Data}
+	}
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	now := time.Now()
+	for k, p := range m.parts {
+		if now.Sub(p.started) > m.cfg.PartTimeout {
+			delete(m.parts, k)
+		}
+	}
+	k := partKey{from: d.From, ref: d.Ref, total: d.Total}
+	p := m.parts[k]
+	if p == nil {
+		p = &partial{parts: make([]*Deliver, d.Total), started: now}
+		m.parts[k] = p
+	}
+	if p.parts[d.Seq-1] == nil {
+		p.have++
+	}
+	p.parts[d.Seq-1] = d
+	if p.have < d.Total {
+		return nil
+	}
+	delete(m.parts, k)
+	msg := &Message{From: d.From, Time: p.parts[0].Time}
+	var text strings.Builder
+	for _, part := range p.parts {
+		text.WriteString(part.Text)
+		msg.Data = append(msg.Data, part.Data...)
+	}
+	msg.Text = text.String()
+	return msg
+}
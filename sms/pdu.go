@@ -0,0 +1,392 @@
+// Package sms sends and receives text messages through GSM modems in PDU
+// mode: TPDU encoding with the GSM 7 bit alphabet or UCS-2, concatenation
+// of long messages, decoding of received messages and delivery reports, and
+// a Modem running it over an atcmd session.
+package sms
+
+import (
+	"encoding/binary"
+	"errors"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// Data Coding
+const (
+	dcsGSM7   = 0x00
+	dcsBinary = 0x04
+	dcsUCS2   = 0x08
+)
+
+// First Octet Bits
+const (
+	mtiMask      = 0x03
+	mtiDeliver   = 0x00
+	mtiSubmit    = 0x01
+	mtiStatus    = 0x02
+	foSRR        = 0x20
+	foUDHI       = 0x40
+	tonIntl      = 0x91
+	tonUnknown   = 0x81
+	tonAlpha     = 0x50
+	ieiConcat8   = 0x00
+	ieiConcat16  = 0x08
+	maxParts     = 255
+	maxUserData  = 140
+	concatHeader = 6
+)
+
+var (
+	// ErrFormat - the PDU is malformed or of another type
+	ErrFormat = errors.New("sms: malformed PDU")
+	// ErrTooLong - the text needs more than 255 parts
+	ErrTooLong = errors.New("sms: message too long")
+	// ErrAddress - the number has characters other than digits and a
+	// leading +
+	ErrAddress = errors.New("sms: invalid number")
+)
+
+// encodeAddress returns a number as length, type and swapped semi-octets
+func encodeAddress(number string) ([]byte, error) {
+	ton := byte(tonUnknown)
+	if strings.HasPrefix(number, "+") {
+		ton, number = tonIntl, number[1:]
+	}
+	if number == "" {
+		return nil, ErrAddress
+	}
+	b := []byte{byte(len(number)), ton}
+	for i := 0; i < len(number); i += 2 {
+		lo := number[i]
+		hi := byte('F')
+		if i+1 < len(number) {
+			hi = number[i+1]
+		}
+		l, ok1 := semiOctet(lo)
+		h, ok2 := semiOctet(hi)
+		if !ok1 || !ok2 {
+			return nil, ErrAddress
+		}
+		b = append(b, h<<4|l)
+	}
+	return b, nil
+}
+
+func semiOctet(c byte) (byte, bool) {
+	switch {
+	case c >= '0' && c <= '9':
+		return c - '0', true
+	case c == '*':
+		return 0xA, true
+	case c == '#':
+		return 0xB, true
+	case c == 'F':
+		return 0xF, true
+	}
+	return 0, false
+}
+
+// decodeAddress parses an address field, returning it and its size
+func decodeAddress(b []byte) (string, int, error) {
+	if len(b) < 2 {
+		return "", 0, ErrFormat
+	}
+	digits := int(b[0])
+	n := 2 + (digits+1)/2
+	if len(b) < n {
+		return "", 0, ErrFormat
+	}
+	ton, v := b[1], b[2:n]
+	if ton&0x70 == tonAlpha {
+		return fromGSM7(unpack(v, 0, digits*4/7)), n, nil
+	}
+	var s strings.Builder
+	if ton&0x70 == tonIntl&0x70 {
+		s.WriteByte('+')
+	}
+	for i := 0; i < digits; i++ {
+		d := v[i/2] >> (4 * uint(i%2)) & 0x0F
+		s.WriteByte("0123456789*#abcF"[d])
+	}
+	return s.String(), n, nil
+}
+
+// decodeSMSC parses the leading service centre field
+func decodeSMSC(b []byte) (string, int, error) {
+	if len(b) < 1 || len(b) < 1+int(b[0]) {
+		return "", 0, ErrFormat
+	}
+	n := 1 + int(b[0])
+	if b[0] == 0 {
+		return "", n, nil
+	}
+	// Length in Octets rather than Digits
+	digits := 2 * (int(b[0]) - 1)
+	if b[n-1]>>4 == 0x0F {
+		digits--
+	}
+	a := append([]byte{byte(digits)}, b[1:n]...)
+	s, _, err := decodeAddress(a)
+	return s, n, err
+}
+
+func bcd(b byte) int {
+	return int(b&0x0F)*10 + int(b>>4)
+}
+
+// decodeTime parses a 7 octet service centre time stamp
+func decodeTime(b []byte) time.Time {
+	quarters := int(b[6]&0x07)*10 + int(b[6]>>4)
+	if b[6]&0x08 != 0 {
+		quarters = -quarters
+	}
+	zone := time.FixedZone("", quarters*15*60)
+	year := 2000 + bcd(b[0])
+	if year >= 2090 {
+		year -= 100
+	}
+	return time.Date(year, time.Month(bcd(b[1])), bcd(b[2]), bcd(b[3]), bcd(b[4]), bcd(b[5]), 0, zone)
+}
+
+// EncodeSubmit returns the SMS-SUBMIT PDUs sending text to number, more
+// than one for a long text with ref as their concatenation reference. Text
+// is sent in the GSM 7 bit alphabet when it fits, UCS-2 otherwise. Each PDU
+// starts with an empty service centre field so the modem's default is
+// used; AT+CMGS takes the length after it.
+func EncodeSubmit(number, text string, report bool, ref byte) ([][]byte, error) {
+	addr, err := encodeAddress(number)
+	if err != nil {
+		return nil, err
+	}
+	var parts [][]byte
+	dcs := byte(dcsGSM7)
+	if septets, ok := toGSM7(text); ok {
+		parts = splitGSM7(septets)
+	} else {
+		dcs = dcsUCS2
+		parts = splitUCS2(utf16.Encode([]rune(text)))
+	}
+	if len(parts) > maxParts {
+		return nil, ErrTooLong
+	}
+	pdus := make([][]byte, len(parts))
+	for i, p := range parts {
+		fo := byte(mtiSubmit)
+		if report {
+			fo |= foSRR
+		}
+		var udh []byte
+		if len(parts) > 1 {
+			fo |= foUDHI
+			udh = []byte{concatHeader - 1, ieiConcat8, 3, ref, byte(len(parts)), byte(i + 1)}
+		}
+		b := []byte{0x00, fo, 0x00}
+		b = append(b, addr...)
+		b = append(b, 0x00, dcs)
+		if dcs == dcsGSM7 {
+			fill := (7 - len(udh)*8%7) % 7
+			udl := (len(udh)*8+fill)/7 + len(p)
+			b = append(b, byte(udl))
+			b = append(b, udh...)
+			b = append(b, pack(p, fill)...)
+		} else {
+			b = append(b, byte(len(udh)+len(p)))
+			b = append(b, udh...)
+			b = append(b, p...)
+		}
+		pdus[i] = b
+	}
+	return pdus, nil
+}
+
+// splitGSM7 cuts septets into parts, keeping escapes with their character
+func splitGSM7(septets []byte) [][]byte {
+	if len(septets) <= 160 {
+		return [][]byte{septets}
+	}
+	// A 6 Octet Header takes 7 Septets
+	const room = 153
+	var parts [][]byte
+	for len(septets) > 0 {
+		n := room
+		if n >= len(septets) {
+			n = len(septets)
+		} else if septets[n-1] == gsm7Escape {
+			n--
+		}
+		parts = append(parts, septets[:n])
+		septets = septets[n:]
+	}
+	return parts
+}
+
+// splitUCS2 cuts UTF-16 into big endian parts, keeping surrogate pairs
+// together
+func splitUCS2(units []uint16) [][]byte {
+	room := maxUserData / 2
+	if len(units) > room {
+		room = (maxUserData - concatHeader) / 2
+	}
+	var parts [][]byte
+	for len(units) > 0 || parts == nil {
+		n := room
+		if n >= len(units) {
+			n = len(units)
+		} else if units[n-1] >= 0xD800 && units[n-1] < 0xDC00 {
+			n--
+		}
+		p := make([]byte, 2*n)
+		for i, u := range units[:n] {
+			binary.BigEndian.PutUint16(p[2*i:], u)
+		}
+		parts = append(parts, p)
+		units = units[n:]
+	}
+	return parts
+}
+
+// Deliver is a received SMS-DELIVER PDU, one part of a concatenated
+// message
+type Deliver struct {
+	SMSC string
+	From string
+	// Service Centre Time Stamp
+	Time time.Time
+	PID  byte
+	DCS  byte
+	// Text of 7 bit and UCS-2 messages
+	Text string
+	// User Data of 8 bit messages
+	Data []byte
+	// Concatenation Reference, Parts and Number of this Part from 1;
+	// Total is 0 for a single message
+	Ref   uint16
+	Total int
+	Seq   int
+}
+
+// alphabet returns the character set selected by a data coding scheme
+func alphabet(dcs byte) byte {
+	switch {
+	case dcs&0xC0 == 0x00, dcs&0xC0 == 0x40:
+		// General Data Coding, Automatic Deletion Group
+		return dcs & 0x0C
+	case dcs&0xF0 == 0xE0:
+		return dcsUCS2
+	case dcs&0xF0 == 0xF0:
+		return dcs & 0x04
+	}
+	return dcsGSM7
+}
+
+// ParseDeliver decodes an SMS-DELIVER PDU, with its service centre field,
+// as listed by AT+CMGR
+func ParseDeliver(b []byte) (*Deliver, error) {
+	smsc, n, err := decodeSMSC(b)
+	if err != nil {
+		return nil, err
+	}
+	b = b[n:]
+	if len(b) < 1 || b[0]&mtiMask != mtiDeliver {
+		return nil, ErrFormat
+	}
+	fo := b[0]
+	from, n, err := decodeAddress(b[1:])
+	if err != nil {
+		return nil, err
+	}
+	b = b[1+n:]
+	if len(b) < 10 {
+		return nil, ErrFormat
+	}
+	d := &Deliver{SMSC: smsc, From: from, PID: b[0], DCS: b[1], Time: decodeTime(b[2:9])}
+	udl, ud := int(b[9]), b[10:]
+	var hdr int
+	if fo&foUDHI != 0 {
+		if len(ud) < 1 || len(ud) < 1+int(ud[0]) {
+			return nil, ErrFormat
+		}
+		hdr = 1 + int(ud[0])
+		d.concat(ud[1:hdr])
+	}
+	switch alphabet(d.DCS) {
+	case dcsGSM7:
+		d.Text = fromGSM7(unpack(ud, (hdr*8+6)/7, udl))
+	case dcsUCS2:
+		units := make([]uint16, 0, (len(ud)-hdr)/2)
+		for i := hdr; i+1 < len(ud) && i+1 < udl; i += 2 {
+			units = append(units, binary.BigEndian.Uint16(ud[i:]))
+		}
+		d.Text = string(utf16.Decode(units))
+	default:
+		if udl > len(ud) {
+			udl = len(ud)
+		}
+		d.Data = append([]byte(nil), ud[hdr:udl]...)
+	}
+	return d, nil
+}
+
+// concat takes the concatenation element from a user data header
+func (d *Deliver) concat(h []byte) {
+	for len(h) >= 2 && len(h) >= 2+int(h[1]) {
+		iei, v := h[0], h[2:2+int(h[1])]
+		switch {
+		case iei == ieiConcat8 && len(v) == 3:
+			d.Ref, d.Total, d.Seq = uint16(v[0]), int(v[1]), int(v[2])
+		case iei == ieiConcat16 && len(v) == 4:
+			d.Ref, d.Total, d.Seq = binary.BigEndian.Uint16(v), int(v[2]), int(v[3])
+		}
+		h = h[2+len(v):]
+	}
+}
+
+// StatusReport is a received SMS-STATUS-REPORT PDU
+type StatusReport struct {
+	SMSC string
+	// Message Reference returned by AT+CMGS for the message reported on
+	Reference int
+	Recipient string
+	// When the service centre took the message, and when it was delivered
+	// or the attempt ended
+	Time      time.Time
+	Discharge time.Time
+	// TP-Status: below 0x20 delivered, 0x20 - 0x3F still trying, above
+	// failed
+	Status byte
+}
+
+// Delivered reports whether the message reached the recipient
+func (r *StatusReport) Delivered() bool {
+	return r.Status < 0x20
+}
+
+// Pending reports whether the service centre is still trying
+func (r *StatusReport) Pending() bool {
+	return r.Status >= 0x20 && r.Status < 0x40
+}
+
+// ParseStatusReport decodes an SMS-STATUS-REPORT PDU with its service
+// centre field, as sent with +CDS
+func ParseStatusReport(b []byte) (*StatusReport, error) {
+	smsc, n, err := decodeSMSC(b)
+	if err != nil {
+		return nil, err
+	}
+	b = b[n:]
+	if len(b) < 2 || b[0]&mtiMask != mtiStatus {
+		return nil, ErrFormat
+	}
+	r := &StatusReport{SMSC: smsc, Reference: int(b[1])}
+	r.Recipient, n, err = decodeAddress(b[2:])
+	if err != nil {
+		return nil, err
+	}
+	b = b[2+n:]
+	if len(b) < 15 {
+		return nil, ErrFormat
+	}
+	r.Time, r.Discharge, r.Status = decodeTime(b[0:7]), decodeTime(b[7:14]), b[14]
+	return r, nil
+}
@@ -0,0 +1,49 @@
+package xserial
+
+import (
+	"encoding/hex"
+	"sync/atomic"
+)
+
+func (s *serialPort) Stats() Stats {
+	return Stats{
+		BytesRead:    atomic.LoadUint64(&s.stats.BytesRead),
+		BytesWritten: atomic.LoadUint64(&s.stats.BytesWritten),
+		ReadTimeouts: atomic.LoadUint64(&s.stats.ReadTimeouts),
+		ReadErrors:   atomic.LoadUint64(&s.stats.ReadErrors),
+		WriteErrors:  atomic.LoadUint64(&s.stats.WriteErrors),
+		Reopens:      atomic.LoadUint64(&s.stats.Reopens),
+	}
+}
+
+// countRead updates Stats and the Log for a Read that returned data
+func (s *serialPort) countRead(data []byte, err error) {
+	if len(data) > 0 {
+		atomic.AddUint64(&s.stats.BytesRead, uint64(len(data)))
+		if s.conf.LogData {
+			s.log.Log(LevelDebug, "serial read", "port", s.conf.Name, "data", hex.EncodeToString(data))
+		}
+	}
+	switch {
+	case err == ErrReadTimeout:
+		atomic.AddUint64(&s.stats.ReadTimeouts, 1)
+	case err != nil:
+		atomic.AddUint64(&s.stats.ReadErrors, 1)
+		s.log.Log(LevelDebug, "serial read failed", "port", s.conf.Name, "err", err)
+	}
+}
+
+// countWrite updates Stats and the Log for a Write of n bytes; data may be
+// nil when the bytes are not contiguous
+func (s *serialPort) countWrite(data []byte, n int64, err error) {
+	if n > 0 {
+		atomic.AddUint64(&s.stats.BytesWritten, uint64(n))
+		if s.conf.LogData && data != nil {
+			s.log.Log(LevelDebug, "serial write", "port", s.conf.Name, "data", hex.EncodeToString(data))
+		}
+	}
+	if err != nil {
+		atomic.AddUint64(&s.stats.WriteErrors, 1)
+		s.log.Log(LevelDebug, "serial write failed", "port", s.conf.Name, "err", err)
+	}
+}
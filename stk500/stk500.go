@@ -0,0 +1,243 @@
+// Package stk500 flashes AVR microcontrollers through the STK500 protocol
+// the Arduino bootloaders speak: version 1 for optiboot boards such as the
+// Uno and Nano, and version 2 for the Mega 2560.
+package stk500
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/packing/xserial"
+)
+
+var (
+	// ErrNoResponse - the bootloader did not answer in time, often because
+	// the board was not reset into it
+	ErrNoResponse = errors.New("stk500: no response")
+	// ErrNoSync - the bootloader answered out of sync (STK_NOSYNC)
+	ErrNoSync = errors.New("stk500: not in sync")
+	// ErrFailed - the bootloader reported a command failed (STK_FAILED)
+	ErrFailed = errors.New("stk500: command failed")
+	// ErrProtocol - the bootloader sent a malformed response
+	ErrProtocol = errors.New("stk500: unexpected response")
+)
+
+// StatusError is the status of a failed STK500v2 command
+type StatusError struct {
+	Cmd    byte
+	Status byte
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("stk500: command 0x%02X failed, status 0x%02X", e.Cmd, e.Status)
+}
+
+// Config configures a Programmer
+type Config struct {
+	// Protocol version, 1 for optiboot or 2 for the Mega 2560 bootloader,
+	// defaults to 1
+	Version int
+	// Leave DTR and RTS alone instead of pulsing them to reset the board
+	// into its bootloader
+	NoReset bool
+	// How long to wait for each response, defaults to 1 second
+	Timeout time.Duration
+	// Further sync attempts while the bootloader does not answer, defaults
+	// to 10; negative for none
+	Retries int
+	// Flash page size in bytes, defaults to 128 as on the ATmega328P; the
+	// ATmega2560 has 256
+	PageSize int
+	// Optional - Called after each page written with the bytes done so far
+	// and the total
+	OnProgress func(done, total int)
+}
+
+// protocol is one version of the STK500 command set
+type protocol interface {
+	sync(ctx context.Context) error
+	version(ctx context.Context) (major, minor byte, err error)
+	enter(ctx context.Context) error
+	leave(ctx context.Context) error
+	signature(ctx context.Context) ([3]byte, error)
+	loadAddress(ctx context.Context, words uint32) error
+	programPage(ctx context.Context, data []byte) error
+	readPage(ctx context.Context, n int) ([]byte, error)
+}
+
+// Programmer is a session with the bootloader in programming mode
+type Programmer struct {
+	c     *conn
+	cfg   Config
+	proto protocol
+}
+
+// Connect resets the board on p into its bootloader, synchronises and
+// enters programming mode. The bootloader only waits a moment after reset
+// before starting the sketch. cfg may be nil.
+func Connect(ctx context.Context, p xserial.Port, cfg *Config) (*Programmer, error) {
+	var c Config
+	if cfg != nil {
+		c = *cfg
+	}
+	if c.Version == 0 {
+		c.Version = 1
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = time.Second
+	}
+	if c.Retries < 0 {
+		c.Retries = 0
+	} else if c.Retries == 0 {
+		c.Retries = 10
+	}
+	if c.PageSize <= 0 {
+		c.PageSize = 128
+	}
+	pr := &Programmer{c: &conn{p: p}, cfg: c}
+	switch c.Version {
+	case 1:
+		pr.proto = &v1{c: pr.c, cfg: &pr.cfg}
+	case 2:
+		pr.proto = &v2{c: pr.c, cfg: &pr.cfg}
+	default:
+		return nil, &xserial.ConfigError{Setting: "stk500 version", Value: c.Version}
+	}
+	if !c.NoReset {
+		if err := Reset(p); err != nil {
+			return nil, err
+		}
+	}
+	if err := pr.proto.sync(ctx); err != nil {
+		return nil, err
+	}
+	if err := pr.proto.enter(ctx); err != nil {
+		return nil, err
+	}
+	return pr, nil
+}
+
+// Reset pulses DTR and RTS as the Arduino auto-reset circuit expects,
+// restarting the board into its bootloader
+func Reset(p xserial.Port) error {
+	lines, ok := xserial.As[xserial.LineController](p)
+	if !ok {
+		return errors.New("stk500: port has no DTR/RTS control")
+	}
+	if err := lines.SetDTR(false); err != nil {
+		return err
+	}
+	if err := lines.SetRTS(false); err != nil {
+		return err
+	}
+	time.Sleep(250 * time.Millisecond)
+	// Asserting the Lines pulls RESET low through the Capacitor
+	if err := lines.SetDTR(true); err != nil {
+		return err
+	}
+	if err := lines.SetRTS(true); err != nil {
+		return err
+	}
+	time.Sleep(50 * time.Millisecond)
+	return nil
+}
+
+// Version returns the bootloader's software version
+func (pr *Programmer) Version(ctx context.Context) (major, minor byte, err error) {
+	return pr.proto.version(ctx)
+}
+
+// Signature returns the three signature bytes that identify the part,
+// 1E 95 0F for the ATmega328P
+func (pr *Programmer) Signature(ctx context.Context) ([3]byte, error) {
+	return pr.proto.signature(ctx)
+}
+
+// ProgramFlash writes data to flash from addr, a byte address on a page
+// boundary. The last page is padded with 0xFF; the bootloader erases each
+// page as it writes it.
+func (pr *Programmer) ProgramFlash(ctx context.Context, addr uint32, data []byte) error {
+	size := pr.cfg.PageSize
+	if addr%uint32(size) != 0 {
+		return errors.New("stk500: address not on a page boundary")
+	}
+	for done := 0; done < len(data); done += size {
+		page := make([]byte, size)
+		n := copy(page, data[done:])
+		for i := n; i < size; i++ {
+			page[i] = 0xFF
+		}
+		if err := pr.proto.loadAddress(ctx, (addr+uint32(done))/2); err != nil {
+			return err
+		}
+		if err := pr.proto.programPage(ctx, page); err != nil {
+			return err
+		}
+		if pr.cfg.OnProgress != nil {
+			pr.cfg.OnProgress(done+n, len(data))
+		}
+	}
+	return nil
+}
+
+// ReadFlash returns n bytes of flash from addr, an even byte address
+func (pr *Programmer) ReadFlash(ctx context.Context, addr uint32, n int) ([]byte, error) {
+	if addr%2 != 0 {
+		return nil, errors.New("stk500: odd flash address")
+	}
+	out := make([]byte, 0, n)
+	for len(out) < n {
+		size := n - len(out)
+		if size > pr.cfg.PageSize {
+			size = pr.cfg.PageSize
+		}
+		if err := pr.proto.loadAddress(ctx, (addr+uint32(len(out)))/2); err != nil {
+			return out, err
+		}
+		r, err := pr.proto.readPage(ctx, size)
+		if err != nil {
+			return out, err
+		}
+		out = append(out, r...)
+	}
+	return out, nil
+}
+
+// Exit leaves programming mode, which starts the sketch
+func (pr *Programmer) Exit(ctx context.Context) error {
+	return pr.proto.leave(ctx)
+}
+
+// conn reads the bootloader's responses
+type conn struct {
+	p xserial.Port
+}
+
+// read returns exactly n bytes, or ErrNoResponse after timeout
+func (c *conn) read(ctx context.Context, n int, timeout time.Duration) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	buf := make([]byte, n)
+	got := 0
+	for got < n {
+		if err := ctx.Err(); err != nil {
+			if err == context.DeadlineExceeded {
+				return nil, ErrNoResponse
+			}
+			return nil, err
+		}
+		m, err := xserial.ReadContext(ctx, c.p, buf[got:])
+		got += m
+		if err != nil && err != xserial.ErrReadTimeout && ctx.Err() == nil {
+			return nil, err
+		}
+	}
+	return buf, nil
+}
+
+func (c *conn) write(b []byte) error {
+	_, err := c.p.Write(b)
+	return err
+}
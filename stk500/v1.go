@@ -0,0 +1,140 @@
+package stk500
+
+import (
+	"context"
+	"time"
+)
+
+// STK500v1 Commands and Responses
+const (
+	respOK      = 0x10
+	respFailed  = 0x11
+	respInSync  = 0x14
+	respNoSync  = 0x15
+	syncCRCEOP  = 0x20
+	cmdGetSync  = 0x30
+	cmdGetParam = 0x41
+	cmdEnter    = 0x50
+	cmdLeave    = 0x51
+	cmdLoadAddr = 0x55
+	cmdProgPage = 0x64
+	cmdReadPage = 0x74
+	cmdReadSign = 0x75
+	paramMajor  = 0x81
+	paramMinor  = 0x82
+	memFlash    = 'F'
+)
+
+// v1 is the protocol optiboot speaks: each command ends in CRC_EOP and
+// each response is framed by INSYNC and OK
+type v1 struct {
+	c   *conn
+	cfg *Config
+}
+
+// command sends cmd and returns the n bytes of its response
+func (v *v1) command(ctx context.Context, cmd []byte, n int) ([]byte, error) {
+	if err := v.c.write(append(cmd, syncCRCEOP)); err != nil {
+		return nil, err
+	}
+	r, err := v.c.read(ctx, 1, v.cfg.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	switch r[0] {
+	case respInSync:
+	case respNoSync:
+		return nil, ErrNoSync
+	default:
+		return nil, ErrProtocol
+	}
+	data, err := v.c.read(ctx, n, v.cfg.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	r, err = v.c.read(ctx, 1, v.cfg.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	switch r[0] {
+	case respOK:
+		return data, nil
+	case respFailed:
+		return nil, ErrFailed
+	}
+	return nil, ErrProtocol
+}
+
+// sync sends GET_SYNC until the bootloader answers, then drops the
+// answers to earlier attempts
+func (v *v1) sync(ctx context.Context) error {
+	for attempt := 0; ; attempt++ {
+		v.c.p.Flush()
+		if err := v.c.write([]byte{cmdGetSync, syncCRCEOP}); err != nil {
+			return err
+		}
+		r, err := v.c.read(ctx, 2, 200*time.Millisecond)
+		if err == nil && r[0] == respInSync && r[1] == respOK {
+			break
+		}
+		if err != nil && err != ErrNoResponse {
+			return err
+		}
+		if attempt >= v.cfg.Retries {
+			return ErrNoResponse
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+	v.c.p.Flush()
+	// Confirm on a clean Line
+	_, err := v.command(ctx, []byte{cmdGetSync}, 0)
+	return err
+}
+
+func (v *v1) version(ctx context.Context) (major, minor byte, err error) {
+	r, err := v.command(ctx, []byte{cmdGetParam, paramMajor}, 1)
+	if err != nil {
+		return 0, 0, err
+	}
+	major = r[0]
+	if r, err = v.command(ctx, []byte{cmdGetParam, paramMinor}, 1); err != nil {
+		return 0, 0, err
+	}
+	return major, r[0], nil
+}
+
+func (v *v1) enter(ctx context.Context) error {
+	_, err := v.command(ctx, []byte{cmdEnter}, 0)
+	return err
+}
+
+func (v *v1) leave(ctx context.Context) error {
+	_, err := v.command(ctx, []byte{cmdLeave}, 0)
+	return err
+}
+
+func (v *v1) signature(ctx context.Context) ([3]byte, error) {
+	var sig [3]byte
+	r, err := v.command(ctx, []byte{cmdReadSign}, 3)
+	if err != nil {
+		return sig, err
+	}
+	copy(sig[:], r)
+	return sig, nil
+}
+
+// loadAddress sets the word address, little endian, of the next page
+func (v *v1) loadAddress(ctx context.Context, words uint32) error {
+	_, err := v.command(ctx, []byte{cmdLoadAddr, byte(words), byte(words >> 8)}, 0)
+	return err
+}
+
+func (v *v1) programPage(ctx context.Context, data []byte) error {
+	cmd := append([]byte{cmdProgPage, byte(len(data) >> 8), byte(len(data)), memFlash}, data...)
+	_, err := v.command(ctx, cmd, 0)
+	return err
+}
+
+func (v *v1) readPage(ctx context.Context, n int) ([]byte, error) {
+	return v.command(ctx, []byte{cmdReadPage, byte(n >> 8), byte(n), memFlash}, n)
+}
@@ -0,0 +1,196 @@
+package stk500
+
+import (
+	"context"
+	"time"
+)
+
+// STK500v2 Framing, Commands and Parameters
+const (
+	msgStart         = 0x1B
+	msgToken         = 0x0E
+	cmdSignOn        = 0x01
+	cmdGetParameter  = 0x03
+	cmdLoadAddress   = 0x06
+	cmdEnterProgISP  = 0x10
+	cmdLeaveProgISP  = 0x11
+	cmdProgFlashISP  = 0x13
+	cmdReadFlashISP  = 0x14
+	cmdReadSignISP   = 0x1B
+	statusCmdOK      = 0x00
+	paramSWMajor     = 0x91
+	paramSWMinor     = 0x92
+	maxMessage       = 275
+	extendedAddrFlag = 1 << 31
+)
+
+// v2 is the protocol of the Mega 2560 bootloader: numbered messages with
+// a length and an XOR checksum, answered by the first byte of the command
+// and a status
+type v2 struct {
+	c   *conn
+	cfg *Config
+	seq byte
+}
+
+// command sends body and returns the response after its status byte
+func (v *v2) command(ctx context.Context, body []byte) ([]byte, error) {
+	v.seq++
+	msg := []byte{msgStart, v.seq, byte(len(body) >> 8), byte(len(body)), msgToken}
+	msg = append(msg, body...)
+	var x byte
+	for _, c := range msg {
+		x ^= c
+	}
+	if err := v.c.write(append(msg, x)); err != nil {
+		return nil, err
+	}
+	for {
+		r, err := v.message(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if r.seq != v.seq {
+			// Late Answer to an earlier Attempt
+			continue
+		}
+		if len(r.body) < 2 || r.body[0] != body[0] {
+			return nil, ErrProtocol
+		}
+		if r.body[1] != statusCmdOK {
+			return nil, &StatusError{Cmd: body[0], Status: r.body[1]}
+		}
+		return r.body[2:], nil
+	}
+}
+
+type message struct {
+	seq  byte
+	body []byte
+}
+
+// message reads the next well formed message, skipping bytes before
+// MESSAGE_START and messages with a bad checksum
+func (v *v2) message(ctx context.Context) (message, error) {
+	for {
+		r, err := v.c.read(ctx, 1, v.cfg.Timeout)
+		if err != nil {
+			return message{}, err
+		}
+		if r[0] != msgStart {
+			continue
+		}
+		head, err := v.c.read(ctx, 4, v.cfg.Timeout)
+		if err != nil {
+			return message{}, err
+		}
+		size := int(head[1])<<8 | int(head[2])
+		if head[3] != msgToken || size > maxMessage {
+			continue
+		}
+		rest, err := v.c.read(ctx, size+1, v.cfg.Timeout)
+		if err != nil {
+			return message{}, err
+		}
+		x := byte(msgStart)
+		for _, c := range head {
+			x ^= c
+		}
+		for _, c := range rest {
+			x ^= c
+		}
+		if x != 0 {
+			continue
+		}
+		return message{seq: head[0], body: rest[:size]}, nil
+	}
+}
+
+// sync signs on until the bootloader answers
+func (v *v2) sync(ctx context.Context) error {
+	for attempt := 0; ; attempt++ {
+		v.c.p.Flush()
+		_, err := v.command(ctx, []byte{cmdSignOn})
+		if err != ErrNoResponse && err != ErrProtocol {
+			return err
+		}
+		if attempt >= v.cfg.Retries {
+			return ErrNoResponse
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func (v *v2) version(ctx context.Context) (major, minor byte, err error) {
+	r, err := v.command(ctx, []byte{cmdGetParameter, paramSWMajor})
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(r) < 1 {
+		return 0, 0, ErrProtocol
+	}
+	major = r[0]
+	if r, err = v.command(ctx, []byte{cmdGetParameter, paramSWMinor}); err != nil {
+		return 0, 0, err
+	}
+	if len(r) < 1 {
+		return 0, 0, ErrProtocol
+	}
+	return major, r[0], nil
+}
+
+// enter starts programming mode with avrdude's ISP timings, which the
+// bootloader accepts without using
+func (v *v2) enter(ctx context.Context) error {
+	_, err := v.command(ctx, []byte{cmdEnterProgISP, 200, 100, 25, 32, 0, 0x53, 3, 0xAC, 0x53, 0x00, 0x00})
+	return err
+}
+
+func (v *v2) leave(ctx context.Context) error {
+	_, err := v.command(ctx, []byte{cmdLeaveProgISP, 1, 1})
+	return err
+}
+
+func (v *v2) signature(ctx context.Context) ([3]byte, error) {
+	var sig [3]byte
+	for i := range sig {
+		r, err := v.command(ctx, []byte{cmdReadSignISP, 4, 0x30, 0x00, byte(i), 0x00})
+		if err != nil {
+			return sig, err
+		}
+		if len(r) < 1 {
+			return sig, ErrProtocol
+		}
+		sig[i] = r[0]
+	}
+	return sig, nil
+}
+
+// loadAddress sets the word address, big endian, with the top bit set past
+// 64K words so the bootloader loads the extended address
+func (v *v2) loadAddress(ctx context.Context, words uint32) error {
+	if words >= 0x10000 {
+		words |= extendedAddrFlag
+	}
+	_, err := v.command(ctx, []byte{cmdLoadAddress, byte(words >> 24), byte(words >> 16), byte(words >> 8), byte(words)})
+	return err
+}
+
+// programPage writes one page in page mode with avrdude's ISP opcodes
+func (v *v2) programPage(ctx context.Context, data []byte) error {
+	cmd := []byte{cmdProgFlashISP, byte(len(data) >> 8), byte(len(data)), 0xC1, 10, 0x40, 0x4C, 0x20, 0x00, 0x00}
+	_, err := v.command(ctx, append(cmd, data...))
+	return err
+}
+
+func (v *v2) readPage(ctx context.Context, n int) ([]byte, error) {
+	r, err := v.command(ctx, []byte{cmdReadFlashISP, byte(n >> 8), byte(n), 0x20})
+	if err != nil {
+		return nil, err
+	}
+	// Data is followed by a second Status
+	if len(r) < n+1 {
+		return nil, ErrProtocol
+	}
+	return r[:n], nil
+}
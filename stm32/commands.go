@@ -0,0 +1,215 @@
+package stm32
+
+import (
+	"context"
+	"errors"
+)
+
+// get runs Get for the version and supported commands
+func (b *Bootloader) get(ctx context.Context) error {
+	if err := b.command(ctx, CmdGet); err != nil {
+		return err
+	}
+	r, err := b.read(ctx, 1, b.cfg.Timeout)
+	if err != nil {
+		return err
+	}
+	// N Bytes follow after N itself, less one
+	r, err = b.read(ctx, int(r[0])+1, b.cfg.Timeout)
+	if err != nil {
+		return err
+	}
+	b.version, b.commands = r[0], r[1:]
+	return b.ack(ctx, b.cfg.Timeout)
+}
+
+// ID returns the product ID, such as 0x413 for the STM32F40x
+func (b *Bootloader) ID(ctx context.Context) (uint16, error) {
+	if err := b.command(ctx, CmdGetID); err != nil {
+		return 0, err
+	}
+	r, err := b.read(ctx, 1, b.cfg.Timeout)
+	if err != nil {
+		return 0, err
+	}
+	r, err = b.read(ctx, int(r[0])+1, b.cfg.Timeout)
+	if err != nil {
+		return 0, err
+	}
+	if len(r) < 2 {
+		return 0, ErrProtocol
+	}
+	if err := b.ack(ctx, b.cfg.Timeout); err != nil {
+		return 0, err
+	}
+	return uint16(r[0])<<8 | uint16(r[1]), nil
+}
+
+// ReadMemory returns n bytes of memory from addr
+func (b *Bootloader) ReadMemory(ctx context.Context, addr uint32, n int) ([]byte, error) {
+	out := make([]byte, 0, n)
+	for len(out) < n {
+		size := n - len(out)
+		if size > maxBlock {
+			size = maxBlock
+		}
+		if err := b.command(ctx, CmdReadMemory); err != nil {
+			return out, err
+		}
+		if err := b.address(ctx, addr); err != nil {
+			return out, err
+		}
+		if err := b.send(ctx, []byte{byte(size - 1)}, b.cfg.Timeout); err != nil {
+			return out, err
+		}
+		r, err := b.read(ctx, size, b.cfg.Timeout)
+		if err != nil {
+			return out, err
+		}
+		out = append(out, r...)
+		addr += uint32(size)
+	}
+	return out, nil
+}
+
+// WriteMemory programs data at addr, which should be word aligned, in
+// blocks of 256 bytes. The flash must have been erased first. Blocks are
+// padded to whole words with 0xFF.
+func (b *Bootloader) WriteMemory(ctx context.Context, addr uint32, data []byte) error {
+	for done := 0; done < len(data); {
+		block := data[done:]
+		if len(block) > maxBlock {
+			block = block[:maxBlock]
+		}
+		if err := b.command(ctx, CmdWriteMemory); err != nil {
+			return err
+		}
+		if err := b.address(ctx, addr+uint32(done)); err != nil {
+			return err
+		}
+		frame := make([]byte, 0, 1+maxBlock)
+		frame = append(frame, 0)
+		frame = append(frame, block...)
+		for (len(frame)-1)%4 != 0 {
+			frame = append(frame, 0xFF)
+		}
+		frame[0] = byte(len(frame) - 2)
+		if err := b.send(ctx, frame, b.cfg.Timeout); err != nil {
+			return err
+		}
+		done += len(block)
+		if b.cfg.OnProgress != nil {
+			b.cfg.OnProgress(done, len(data))
+		}
+	}
+	return nil
+}
+
+// Erase erases the given flash pages, or the whole flash when pages is
+// nil, with Extended Erase where the bootloader offers it. An empty but
+// non-nil pages is an error, never a mass erase.
+func (b *Bootloader) Erase(ctx context.Context, pages []uint16) error {
+	// Checked before the Command goes out, as the Bootloader would be left
+	// waiting for the Page List
+	if pages != nil && len(pages) == 0 {
+		return errors.New("stm32: no pages to erase")
+	}
+	if b.Supports(CmdExtendedErase) {
+		return b.extendedErase(ctx, pages)
+	}
+	for _, pg := range pages {
+		if pg > 0xFF {
+			return errors.New("stm32: page number too large for Erase")
+		}
+	}
+	if err := b.command(ctx, CmdErase); err != nil {
+		return err
+	}
+	if pages == nil {
+		return b.send(ctx, []byte{0xFF}, b.cfg.EraseTimeout)
+	}
+	// Erase takes at most 255 Pages of Numbers below 256 at a Time
+	for len(pages) > 0 {
+		chunk := pages
+		if len(chunk) > 255 {
+			chunk = chunk[:255]
+		}
+		frame := []byte{byte(len(chunk) - 1)}
+		for _, pg := range chunk {
+			frame = append(frame, byte(pg))
+		}
+		if err := b.send(ctx, frame, b.cfg.EraseTimeout); err != nil {
+			return err
+		}
+		if pages = pages[len(chunk):]; len(pages) > 0 {
+			if err := b.command(ctx, CmdErase); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (b *Bootloader) extendedErase(ctx context.Context, pages []uint16) error {
+	if err := b.command(ctx, CmdExtendedErase); err != nil {
+		return err
+	}
+	if pages == nil {
+		// Mass Erase
+		return b.send(ctx, []byte{0xFF, 0xFF}, b.cfg.EraseTimeout)
+	}
+	for len(pages) > 0 {
+		chunk := pages
+		if len(chunk) > 512 {
+			chunk = chunk[:512]
+		}
+		n := len(chunk) - 1
+		frame := []byte{byte(n >> 8), byte(n)}
+		for _, pg := range chunk {
+			frame = append(frame, byte(pg>>8), byte(pg))
+		}
+		if err := b.send(ctx, frame, b.cfg.EraseTimeout); err != nil {
+			return err
+		}
+		if pages = pages[len(chunk):]; len(pages) > 0 {
+			if err := b.command(ctx, CmdExtendedErase); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Go starts the code at addr, usually the flash base 0x08000000. The
+// session ends with it.
+func (b *Bootloader) Go(ctx context.Context, addr uint32) error {
+	if err := b.command(ctx, CmdGo); err != nil {
+		return err
+	}
+	return b.address(ctx, addr)
+}
+
+// WriteUnprotect removes the write protection of all sectors. The device
+// resets afterwards, so Connect again.
+func (b *Bootloader) WriteUnprotect(ctx context.Context) error {
+	return b.protection(ctx, CmdWriteUnprotect)
+}
+
+// ReadoutProtect enables readout protection. The device resets afterwards.
+func (b *Bootloader) ReadoutProtect(ctx context.Context) error {
+	return b.protection(ctx, CmdReadoutProtect)
+}
+
+// ReadoutUnprotect disables readout protection, which mass erases the
+// flash. The device resets afterwards, so Connect again.
+func (b *Bootloader) ReadoutUnprotect(ctx context.Context) error {
+	return b.protection(ctx, CmdReadoutUnprotect)
+}
+
+// protection runs a command answered by a second ACK once it is done
+func (b *Bootloader) protection(ctx context.Context, cmd byte) error {
+	if err := b.command(ctx, cmd); err != nil {
+		return err
+	}
+	return b.ack(ctx, b.cfg.EraseTimeout)
+}
@@ -0,0 +1,226 @@
+// Package stm32 talks to the STM32 system memory bootloader over a USART
+// (AN3155) to read, erase and program flash and start the application.
+package stm32
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/packing/xserial"
+)
+
+// Bootloader Commands
+const (
+	CmdGet              = 0x00
+	CmdGetVersion       = 0x01
+	CmdGetID            = 0x02
+	CmdReadMemory       = 0x11
+	CmdGo               = 0x21
+	CmdWriteMemory      = 0x31
+	CmdErase            = 0x43
+	CmdExtendedErase    = 0x44
+	CmdWriteProtect     = 0x63
+	CmdWriteUnprotect   = 0x73
+	CmdReadoutProtect   = 0x82
+	CmdReadoutUnprotect = 0x92
+)
+
+const (
+	sync = 0x7F
+	ack  = 0x79
+	nack = 0x1F
+	// Most Bytes one Read or Write Memory Command carries
+	maxBlock = 256
+)
+
+var (
+	// ErrNack - the bootloader refused a command or its arguments, for
+	// example while readout protection is active
+	ErrNack = errors.New("stm32: command refused (NACK)")
+	// ErrNoResponse - the bootloader did not answer in time
+	ErrNoResponse = errors.New("stm32: no response")
+	// ErrUnsupported - the bootloader does not offer the command
+	ErrUnsupported = errors.New("stm32: command not supported by bootloader")
+	// ErrProtocol - the bootloader sent something other than ACK or NACK
+	ErrProtocol = errors.New("stm32: unexpected response")
+)
+
+// Config configures a Bootloader
+type Config struct {
+	// How long to wait for each acknowledgement, defaults to 1 second
+	Timeout time.Duration
+	// How long an erase may take, defaults to 35 seconds as a mass erase
+	// of a large part is slow
+	EraseTimeout time.Duration
+	// Further sync attempts while the bootloader does not answer, defaults
+	// to 5; negative for none
+	Retries int
+	// Keep the parity the Port was opened with instead of switching to
+	// the even parity the bootloader expects
+	KeepParity bool
+	// Optional - Called after each block written with the bytes done so
+	// far and the total
+	OnProgress func(done, total int)
+}
+
+// Bootloader is a synchronised session with the ROM bootloader
+type Bootloader struct {
+	p   xserial.Port
+	cfg Config
+	// Bootloader Version, 0x31 for 3.1
+	version  byte
+	commands []byte
+}
+
+// Connect switches p to even parity, synchronises with the bootloader
+// with 0x7F and reads its version and commands. The device must have been
+// reset into the bootloader already, with BOOT0 high. cfg may be nil.
+func Connect(ctx context.Context, p xserial.Port, cfg *Config) (*Bootloader, error) {
+	var c Config
+	if cfg != nil {
+		c = *cfg
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = time.Second
+	}
+	if c.EraseTimeout <= 0 {
+		c.EraseTimeout = 35 * time.Second
+	}
+	if c.Retries < 0 {
+		c.Retries = 0
+	} else if c.Retries == 0 {
+		c.Retries = 5
+	}
+	if !c.KeepParity {
+		if err := p.SetParity("E", 1); err != nil {
+			return nil, err
+		}
+	}
+	b := &Bootloader{p: p, cfg: c}
+	if err := b.sync(ctx); err != nil {
+		return nil, err
+	}
+	if err := b.get(ctx); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// sync sends 0x7F until the bootloader has measured the baud rate
+func (b *Bootloader) sync(ctx context.Context) error {
+	b.p.Flush()
+	for attempt := 0; ; attempt++ {
+		if _, err := b.p.Write([]byte{sync}); err != nil {
+			return err
+		}
+		r, err := b.read(ctx, 1, b.cfg.Timeout)
+		if err == nil {
+			switch r[0] {
+			case ack:
+				return nil
+			case nack:
+				// Synchronised before, the 0x7F was taken as a Command
+				return nil
+			}
+			err = ErrProtocol
+		}
+		if err != ErrNoResponse || attempt >= b.cfg.Retries {
+			return err
+		}
+	}
+}
+
+// read returns exactly n bytes, or ErrNoResponse after timeout
+func (b *Bootloader) read(ctx context.Context, n int, timeout time.Duration) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	buf := make([]byte, n)
+	got := 0
+	for got < n {
+		if err := ctx.Err(); err != nil {
+			if err == context.DeadlineExceeded {
+				return nil, ErrNoResponse
+			}
+			return nil, err
+		}
+		m, err := xserial.ReadContext(ctx, b.p, buf[got:])
+		got += m
+		if err != nil && err != xserial.ErrReadTimeout && ctx.Err() == nil {
+			return nil, err
+		}
+	}
+	return buf, nil
+}
+
+// ack waits for ACK, returning ErrNack for NACK
+func (b *Bootloader) ack(ctx context.Context, timeout time.Duration) error {
+	r, err := b.read(ctx, 1, timeout)
+	if err != nil {
+		return err
+	}
+	switch r[0] {
+	case ack:
+		return nil
+	case nack:
+		return ErrNack
+	}
+	return ErrProtocol
+}
+
+// command sends cmd with its complement and waits for ACK
+func (b *Bootloader) command(ctx context.Context, cmd byte) error {
+	if b.commands != nil && !b.Supports(cmd) {
+		return ErrUnsupported
+	}
+	if _, err := b.p.Write([]byte{cmd, ^cmd}); err != nil {
+		return err
+	}
+	return b.ack(ctx, b.cfg.Timeout)
+}
+
+// send writes data followed by its XOR checksum and waits for ACK
+func (b *Bootloader) send(ctx context.Context, data []byte, timeout time.Duration) error {
+	var x byte
+	for _, v := range data {
+		x ^= v
+	}
+	if len(data) == 1 {
+		// A single Byte is followed by its Complement
+		x = ^data[0]
+	}
+	if _, err := b.p.Write(append(data, x)); err != nil {
+		return err
+	}
+	return b.ack(ctx, timeout)
+}
+
+func (b *Bootloader) address(ctx context.Context, addr uint32) error {
+	return b.send(ctx, []byte{byte(addr >> 24), byte(addr >> 16), byte(addr >> 8), byte(addr)}, b.cfg.Timeout)
+}
+
+// Version returns the bootloader version, 0x31 for 3.1
+func (b *Bootloader) Version() byte {
+	return b.version
+}
+
+// Commands returns the commands the bootloader supports
+func (b *Bootloader) Commands() []byte {
+	return append([]byte(nil), b.commands...)
+}
+
+// Supports reports whether the bootloader offers cmd
+func (b *Bootloader) Supports(cmd byte) bool {
+	for _, c := range b.commands {
+		if c == cmd {
+			return true
+		}
+	}
+	return false
+}
+
+// String describes the bootloader, as "STM32 bootloader v3.1"
+func (b *Bootloader) String() string {
+	return fmt.Sprintf("STM32 bootloader v%d.%d", b.version>>4, b.version&0x0F)
+}
@@ -0,0 +1,79 @@
+package xserial
+
+import (
+	"context"
+)
+
+// Backpressure selects what Stream does when its consumer falls behind
+type Backpressure int
+
+const (
+	// BackpressureBlock stops reading until the consumer catches up
+	BackpressureBlock Backpressure = iota
+	// BackpressureDropNewest discards chunks that do not fit
+	BackpressureDropNewest
+	// BackpressureDropOldest discards the oldest queued chunk to make room
+	BackpressureDropOldest
+)
+
+// StreamConfig configures Stream
+type StreamConfig struct {
+	AsyncConfig
+	// Channel Capacity in Chunks, defaults to 16
+	Depth int
+	// Behaviour when the Channel is full
+	Policy Backpressure
+}
+
+// Stream reads p in the background and delivers each received chunk on the
+// returned channel. The channel is closed when ctx is done or reading fails.
+// cfg may be nil.
+func Stream(ctx context.Context, p Port, cfg *StreamConfig) <-chan []byte {
+	var c StreamConfig
+	if cfg != nil {
+		c = *cfg
+	}
+	if c.Depth <= 0 {
+		c.Depth = 16
+	}
+	ch := make(chan []byte, c.Depth)
+
+	deliver := func(b []byte) {
+		chunk := append([]byte(nil), b...)
+		switch c.Policy {
+		case BackpressureDropNewest:
+			select {
+			case ch <- chunk:
+			default:
+			}
+		case BackpressureDropOldest:
+			for {
+				select {
+				case ch <- chunk:
+					return
+				default:
+				}
+				select {
+				case <-ch:
+				default:
+				}
+			}
+		default:
+			select {
+			case ch <- chunk:
+			case <-ctx.Done():
+			}
+		}
+	}
+
+	r := OnData(p, deliver, &c.AsyncConfig)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-r.Done():
+		}
+		r.Stop()
+		close(ch)
+	}()
+	return ch
+}
@@ -0,0 +1,184 @@
+// Package stuffing implements the byte stuffing many serial protocols use
+// to keep delimiters out of their data: each special byte is sent as an
+// escape character followed by the byte, optionally XORed with a mask.
+// Protocols that differ only in these rules share one Rule.
+package stuffing
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/packing/xserial"
+)
+
+// ErrEscape - an escape sequence stands for a byte the Rule does not
+// escape, reported by Strict rules only
+var ErrEscape = errors.New("stuffing: invalid escape sequence")
+
+// Config describes a stuffing rule
+type Config struct {
+	// Byte introducing an escape sequence, itself always escaped
+	Escape byte
+	// Bytes escaped besides Escape
+	Special []byte
+	// Escaped bytes are sent XORed with this; zero sends them unchanged, so
+	// Escape is doubled
+	XOR byte
+	// Report escape sequences for bytes outside Special. Otherwise any
+	// escaped byte is accepted, as PPP receivers must.
+	Strict bool
+}
+
+// Common Rules
+var (
+	// HDLC - RFC 1662 async HDLC, flag 0x7E
+	HDLC = Config{Escape: 0x7D, Special: []byte{0x7E}, XOR: 0x20}
+	// XBee - XBee API mode 2, also escaping XON and XOFF
+	XBee = Config{Escape: 0x7D, Special: []byte{0x7E, 0x11, 0x13}, XOR: 0x20}
+	// DLE - DLE doubling, as in DF1 and the 3964 procedure
+	DLE = Config{Escape: 0x10}
+)
+
+// Rule stuffs and unstuffs data. It holds no state and may be shared.
+type Rule struct {
+	esc     byte
+	xor     byte
+	strict  bool
+	special [256]bool
+}
+
+// NewRule returns the Rule described by cfg
+func NewRule(cfg Config) *Rule {
+	r := &Rule{esc: cfg.Escape, xor: cfg.XOR, strict: cfg.Strict}
+	r.special[cfg.Escape] = true
+	for _, c := range cfg.Special {
+		r.special[c] = true
+	}
+	return r
+}
+
+// Stuff appends src to dst with its special bytes escaped
+func (r *Rule) Stuff(dst, src []byte) []byte {
+	for _, c := range src {
+		if r.special[c] {
+			dst = append(dst, r.esc, c^r.xor)
+		} else {
+			dst = append(dst, c)
+		}
+	}
+	return dst
+}
+
+// Unstuff appends src, a whole stuffed block, to dst with its escape
+// sequences undone. A trailing escape character is ErrEscape.
+func (r *Rule) Unstuff(dst, src []byte) ([]byte, error) {
+	u := Unstuffer{r: r}
+	dst, err := u.Unstuff(dst, src)
+	if u.esc {
+		err = ErrEscape
+	}
+	return dst, err
+}
+
+// Unstuffer undoes escape sequences in a stream, which may split them
+// across calls
+type Unstuffer struct {
+	r   *Rule
+	esc bool
+}
+
+// NewUnstuffer returns an Unstuffer for the Rule
+func (r *Rule) NewUnstuffer() *Unstuffer {
+	return &Unstuffer{r: r}
+}
+
+// Unstuff appends src to dst with its escape sequences undone. dst may be
+// src[:0], as the output is never longer. Invalid sequences of a Strict
+// Rule are dropped and reported with ErrEscape once the rest is done.
+func (u *Unstuffer) Unstuff(dst, src []byte) ([]byte, error) {
+	var err error
+	r := u.r
+	for _, c := range src {
+		if u.esc {
+			u.esc = false
+			c ^= r.xor
+			if r.strict && !r.special[c] {
+				err = ErrEscape
+				continue
+			}
+		} else if c == r.esc {
+			u.esc = true
+			continue
+		}
+		dst = append(dst, c)
+	}
+	return dst, err
+}
+
+// Pending reports whether an escape character awaits the byte it escapes
+func (u *Unstuffer) Pending() bool {
+	return u.esc
+}
+
+// Reset drops a pending escape character
+func (u *Unstuffer) Reset() {
+	u.esc = false
+}
+
+// Port stuffs what is written to the wrapped Port and unstuffs what is
+// read from it, for protocols escaping the whole stream
+type Port struct {
+	xserial.Port
+	r   *Rule
+	wmx sync.Mutex
+	buf []byte
+	rmx sync.Mutex
+	u   Unstuffer
+}
+
+// NewPort wraps p with r
+func NewPort(p xserial.Port, r *Rule) *Port {
+	return &Port{Port: p, r: r, u: Unstuffer{r: r}}
+}
+
+// Unwrap returns the wrapped Port
+func (p *Port) Unwrap() xserial.Port {
+	return p.Port
+}
+
+// Write stuffs b and writes it, returning len(b) once all of it is sent
+func (p *Port) Write(b []byte) (int, error) {
+	p.wmx.Lock()
+	defer p.wmx.Unlock()
+	p.buf = p.r.Stuff(p.buf[:0], b)
+	if _, err := p.Port.Write(p.buf); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Read reads into b and unstuffs it, reading again while all that arrived
+// was an escape character. Only that character is held back, so Select
+// still sees all data not yet returned.
+func (p *Port) Read(b []byte) (int, error) {
+	p.rmx.Lock()
+	defer p.rmx.Unlock()
+	for {
+		n, err := p.Port.Read(b)
+		out, uerr := p.u.Unstuff(b[:0], b[:n])
+		if err == nil {
+			err = uerr
+		}
+		if len(out) > 0 || n == 0 || err != nil {
+			return len(out), err
+		}
+	}
+}
+
+// Flush discards buffered data and a pending escape character
+func (p *Port) Flush() error {
+	p.rmx.Lock()
+	p.u.Reset()
+	p.rmx.Unlock()
+	return p.Port.Flush()
+}
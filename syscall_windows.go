@@ -0,0 +1,38 @@
+// Copyright 2021 Abhijit Bose. All rights reserved.
+
+package xserial
+
+// dcb mirrors the Win32 DCB structure
+type dcb struct {
+	DCBlength  uint32
+	BaudRate   uint32
+	Flags      uint32
+	wReserved  uint16
+	XonLim     uint16
+	XoffLim    uint16
+	ByteSize   byte
+	Parity     byte
+	StopBits   byte
+	XonChar    byte
+	XoffChar   byte
+	ErrorChar  byte
+	EofChar    byte
+	EvtChar    byte
+	wReserved1 uint16
+}
+
+// comStat mirrors the Win32 COMSTAT structure
+type comStat struct {
+	Flags    uint32
+	CbInQue  uint32
+	CbOutQue uint32
+}
+
+//sys	getCommState(handle windows.Handle, d *dcb) (err error) = kernel32.GetCommState
+//sys	setCommState(handle windows.Handle, d *dcb) (err error) = kernel32.SetCommState
+//sys	purgeComm(handle windows.Handle, flags uint32) (err error) = kernel32.PurgeComm
+//sys	clearCommError(handle windows.Handle, errors *uint32, stat *comStat) (err error) = kernel32.ClearCommError
+//sys	escapeCommFunction(handle windows.Handle, function uint32) (err error) = kernel32.EscapeCommFunction
+//sys	getCommModemStatus(handle windows.Handle, status *uint32) (err error) = kernel32.GetCommModemStatus
+//sys	peekNamedPipe(handle windows.Handle, buf *byte, size uint32, read *uint32, avail *uint32, left *uint32) (err error) = kernel32.PeekNamedPipe
+//sys	waitNamedPipe(name *uint16, timeout uint32) (err error) = kernel32.WaitNamedPipeW
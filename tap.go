@@ -0,0 +1,97 @@
+package xserial
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// TapRecord is one chunk observed by a Tap
+type TapRecord struct {
+	Time time.Time
+	// Label of the Port the chunk was received on
+	Source string
+	Data   []byte
+}
+
+// Tap listens on two Ports wired to both sides of a conversation between
+// other devices (a Y-cable or two adapters) and merges what each side sends
+// into one timestamped stream. It never transmits.
+type Tap struct {
+	ports   [2]Port
+	cancel  context.CancelFunc
+	records chan TapRecord
+	wg      sync.WaitGroup
+}
+
+// OpenTap opens both Ports and starts capturing; records are labelled with
+// the Port names
+func OpenTap(a, b *Config) (*Tap, error) {
+	pa, err := OpenPort(a)
+	if err != nil {
+		return nil, err
+	}
+	pb, err := OpenPort(b)
+	if err != nil {
+		pa.Close()
+		return nil, err
+	}
+	return NewTap(pa, a.Name, pb, b.Name), nil
+}
+
+// NewTap starts capturing on two already open Ports
+func NewTap(a Port, labelA string, b Port, labelB string) *Tap {
+	ctx, cancel := context.WithCancel(context.Background())
+	t := &Tap{
+		ports:   [2]Port{a, b},
+		cancel:  cancel,
+		records: make(chan TapRecord, 64),
+	}
+	t.wg.Add(2)
+	go t.capture(ctx, a, labelA)
+	go t.capture(ctx, b, labelB)
+	go func() {
+		t.wg.Wait()
+		close(t.records)
+	}()
+	return t
+}
+
+func (t *Tap) capture(ctx context.Context, p Port, label string) {
+	defer t.wg.Done()
+	buf := make([]byte, 4096)
+	for ctx.Err() == nil {
+		if _, err := Select(ctx, p); err != nil && err != ErrNotPollable {
+			return
+		}
+		n, at, err := ReadTimestamped(p, buf)
+		if n > 0 {
+			r := TapRecord{Time: at, Source: label, Data: append([]byte(nil), buf[:n]...)}
+			select {
+			case t.records <- r:
+			case <-ctx.Done():
+				return
+			}
+		}
+		if err != nil && err != ErrReadTimeout {
+			return
+		}
+	}
+}
+
+// Records returns the merged capture, closed once the Tap stops
+func (t *Tap) Records() <-chan TapRecord {
+	return t.records
+}
+
+// Close stops capturing and closes both Ports
+func (t *Tap) Close() error {
+	t.cancel()
+	t.wg.Wait()
+	errA := t.ports[0].Close()
+	errB := t.ports[1].Close()
+	if errA != nil {
+		return errA
+	}
+	return errB
+}
@@ -0,0 +1,81 @@
+package xserial
+
+import (
+	"sync"
+)
+
+// Tee reads a Port in the background and hands everything received to each
+// of its Readers, so a protocol handler, a raw logger and a dashboard each
+// see the full stream instead of taking turns at Read. Every Reader buffers
+// on its own; one falling behind overruns only its own ring.
+type Tee struct {
+	p      Port
+	reader *AsyncReader
+
+	mx      sync.Mutex
+	readers []*BufferedPort
+	err     error
+}
+
+// NewTee starts reading p. Bytes arriving while no Reader is attached are
+// dropped.
+func NewTee(p Port) *Tee {
+	t := &Tee{p: p}
+	t.reader = OnData(p, t.fill, &AsyncConfig{OnError: t.fail})
+	return t
+}
+
+func (t *Tee) fill(p []byte) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	for _, r := range t.readers {
+		r.fill(p)
+	}
+}
+
+func (t *Tee) fail(err error) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	t.err = err
+	for _, r := range t.readers {
+		r.fail(err)
+	}
+}
+
+// NewReader attaches a Reader receiving everything read from now on. It is
+// a BufferedPort with Peek and Discard; its Writes go to the Port, Flush
+// empties only its own ring and Close detaches it. cfg may be nil.
+func (t *Tee) NewReader(cfg *BufferConfig) *BufferedPort {
+	b := newBufferedPort(t.p, cfg)
+	b.reader = t.reader
+	b.detach = func() {
+		t.remove(b)
+	}
+	t.mx.Lock()
+	b.err = t.err
+	t.readers = append(t.readers, b)
+	t.mx.Unlock()
+	return b
+}
+
+func (t *Tee) remove(b *BufferedPort) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	for i, r := range t.readers {
+		if r == b {
+			t.readers = append(t.readers[:i], t.readers[i+1:]...)
+			return
+		}
+	}
+}
+
+// Unwrap returns the Port read by the Tee
+func (t *Tee) Unwrap() Port {
+	return t.p
+}
+
+// Close stops reading and closes the Port; its Readers then return
+// ErrPortClosed once drained
+func (t *Tee) Close() error {
+	return t.reader.stopClosing(t.p)
+}
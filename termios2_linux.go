@@ -0,0 +1,12 @@
+//go:build linux && !ppc && !ppc64 && !ppc64le
+// +build linux,!ppc,!ppc64,!ppc64le
+
+package xserial
+
+import "golang.org/x/sys/unix"
+
+// Termios with the Line Speeds, for Rates outside the B Constants
+const (
+	ioctlGetTermios2 = unix.TCGETS2
+	ioctlSetTermios2 = unix.TCSETS2
+)
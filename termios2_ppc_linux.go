@@ -0,0 +1,13 @@
+//go:build linux && (ppc || ppc64 || ppc64le)
+// +build linux
+// +build ppc ppc64 ppc64le
+
+package xserial
+
+import "golang.org/x/sys/unix"
+
+// Termios on PowerPC carries the Line Speeds already
+const (
+	ioctlGetTermios2 = unix.TCGETS
+	ioctlSetTermios2 = unix.TCSETS
+)
@@ -0,0 +1,21 @@
+package xserial
+
+import (
+	"time"
+)
+
+// TimestampedReader is implemented by Ports that can report when received
+// data became readable, which is closer to its arrival than the Read return
+type TimestampedReader interface {
+	ReadTimestamped(p []byte) (n int, at time.Time, err error)
+}
+
+// ReadTimestamped reads from p and returns the arrival time of the data.
+// Ports that cannot timestamp are stamped when Read returns.
+func ReadTimestamped(p Port, buf []byte) (n int, at time.Time, err error) {
+	if t, ok := p.(TimestampedReader); ok {
+		return t.ReadTimestamped(buf)
+	}
+	n, err = p.Read(buf)
+	return n, time.Now(), err
+}
@@ -0,0 +1,97 @@
+package xserial
+
+import (
+	"context"
+)
+
+// Span is the part of a tracing span xserial reports to. OpenTelemetry
+// spans satisfy it through a small adapter mapping SetAttribute to
+// SetAttributes and RecordError to RecordError plus an error status.
+type Span interface {
+	SetAttribute(key string, value interface{})
+	RecordError(err error)
+	End()
+}
+
+// Tracer starts Spans for Port operations
+type Tracer interface {
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// tracedPort records a Span around every operation of the wrapped Port
+type tracedPort struct {
+	Port
+	tracer Tracer
+	ctx    context.Context
+	name   string
+}
+
+func openTraced(cfg *Config) (Port, error) {
+	ctx, span := cfg.Tracer.Start(context.Background(), "xserial.Open")
+	defer span.End()
+	span.SetAttribute("serial.port", cfg.Name)
+	span.SetAttribute("serial.baud", cfg.Baud)
+
+	p, err := openPort(cfg)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	return WithTracing(ctx, p, cfg.Name, cfg.Tracer), nil
+}
+
+// WithTracing wraps p so each Read, Write, Flush and Close records a Span
+// under ctx, tagged with name and the byte count
+func WithTracing(ctx context.Context, p Port, name string, t Tracer) Port {
+	return &tracedPort{Port: p, tracer: t, ctx: ctx, name: name}
+}
+
+func (t *tracedPort) start(op string) Span {
+	_, span := t.tracer.Start(t.ctx, op)
+	span.SetAttribute("serial.port", t.name)
+	return span
+}
+
+func (t *tracedPort) finish(span Span, n int, err error) {
+	span.SetAttribute("serial.bytes", n)
+	switch {
+	case err == ErrReadTimeout:
+		span.SetAttribute("serial.timeout", true)
+	case err != nil:
+		span.RecordError(err)
+	}
+	span.End()
+}
+
+// Unwrap returns the traced Port
+func (t *tracedPort) Unwrap() Port {
+	return t.Port
+}
+
+func (t *tracedPort) Read(p []byte) (int, error) {
+	span := t.start("xserial.Read")
+	n, err := t.Port.Read(p)
+	t.finish(span, n, err)
+	return n, err
+}
+
+func (t *tracedPort) Write(p []byte) (int, error) {
+	span := t.start("xserial.Write")
+	n, err := t.Port.Write(p)
+	t.finish(span, n, err)
+	return n, err
+}
+
+func (t *tracedPort) Flush() error {
+	span := t.start("xserial.Flush")
+	err := t.Port.Flush()
+	t.finish(span, 0, err)
+	return err
+}
+
+func (t *tracedPort) Close() error {
+	span := t.start("xserial.Close")
+	err := t.Port.Close()
+	t.finish(span, 0, err)
+	return err
+}
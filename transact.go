@@ -0,0 +1,140 @@
+package xserial
+
+import (
+	"bytes"
+	"context"
+	"time"
+)
+
+// Matcher inspects the bytes received so far and returns the length of a
+// complete response at their start, or 0 if more data is needed
+type Matcher func(buf []byte) int
+
+// MatchLength completes once n bytes have arrived
+func MatchLength(n int) Matcher {
+	return func(buf []byte) int {
+		if len(buf) >= n {
+			return n
+		}
+		return 0
+	}
+}
+
+// MatchDelimiter completes at the first delim, which is included
+func MatchDelimiter(delim []byte) Matcher {
+	return func(buf []byte) int {
+		if i := bytes.Index(buf, delim); i >= 0 {
+			return i + len(delim)
+		}
+		return 0
+	}
+}
+
+// MatchFunc completes once done reports true for everything received
+func MatchFunc(done func(buf []byte) bool) Matcher {
+	return func(buf []byte) int {
+		if done(buf) {
+			return len(buf)
+		}
+		return 0
+	}
+}
+
+// TransactConfig configures Transact
+type TransactConfig struct {
+	// Time allowed for each Response, defaults to 1 second
+	Timeout time.Duration
+	// Additional Attempts after the first one times out
+	Retries int
+}
+
+// Transact discards pending input, writes req and collects the response
+// selected by match, retrying on timeout. Output queued by other writers is
+// kept where p is an InputFlusher; other Ports are Flushed. cfg may be nil.
+func Transact(p Port, req []byte, match Matcher, cfg *TransactConfig) ([]byte, error) {
+	var c TransactConfig
+	if cfg != nil {
+		c = *cfg
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = time.Second
+	}
+	var err error
+	for attempt := 0; attempt <= c.Retries; attempt++ {
+		var resp []byte
+		if resp, err = transactOnce(p, req, match, c.Timeout); err != ErrReadTimeout {
+			return resp, err
+		}
+	}
+	return nil, err
+}
+
+func transactOnce(p Port, req []byte, match Matcher, timeout time.Duration) ([]byte, error) {
+	if err := flushInput(p); err != nil {
+		return nil, err
+	}
+	if _, err := p.Write(req); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	var resp []byte
+	buf := make([]byte, 256)
+	for {
+		n, err := ReadContext(ctx, p, buf)
+		resp = append(resp, buf[:n]...)
+		if n > 0 {
+			if l := match(resp); l > 0 {
+				return resp[:l], nil
+			}
+		}
+		if err == context.DeadlineExceeded {
+			return nil, ErrReadTimeout
+		}
+		if err != nil && err != ErrReadTimeout {
+			return nil, err
+		}
+		if ctx.Err() != nil {
+			return nil, ErrReadTimeout
+		}
+	}
+}
+
+// flushInput discards stale input, and output too where p cannot tell them
+// apart. Wrappers are not looked through, as they may hold input of their
+// own.
+func flushInput(p Port) error {
+	if f, ok := p.(InputFlusher); ok {
+		return f.FlushInput()
+	}
+	return p.Flush()
+}
+
+// ReadContext reads into buf once p has data, waiting with Select so Ports
+// without a ReadTimeout do not spin. It returns ctx.Err() if ctx is done
+// first and any other error Select reports, such as ErrNotOpen once p has
+// been closed. Ports that can be neither polled nor waited on are read
+// directly, pausing briefly after a Read that returned nothing.
+func ReadContext(ctx context.Context, p Port, buf []byte) (int, error) {
+	return readWaiting(ctx, p, func() (int, error) { return p.Read(buf) })
+}
+
+// readWaiting is ReadContext with read in place of p.Read
+func readWaiting(ctx context.Context, p Port, read func() (int, error)) (int, error) {
+	_, err := Select(ctx, p)
+	if err != nil && err != ErrNotPollable {
+		return 0, err
+	}
+	n, rerr := read()
+	if err == ErrNotPollable && n == 0 && (rerr == nil || rerr == ErrReadTimeout) {
+		// Ports opened without a ReadTimeout return at once
+		t := time.NewTimer(time.Millisecond)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+		}
+	}
+	return n, rerr
+}
@@ -0,0 +1,17 @@
+// Copyright 2021 Abhijit Bose. All rights reserved.
+
+//go:build darwin
+// +build darwin
+
+package xserial
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// outputEmpty reports whether fd's output queue is empty; Darwin does not
+// report the Shift Register
+func outputEmpty(fd int) (bool, error) {
+	out, err := unix.IoctlGetInt(fd, unix.TIOCOUTQ)
+	return out == 0, err
+}
@@ -0,0 +1,21 @@
+// Copyright 2021 Abhijit Bose. All rights reserved.
+
+//go:build linux
+// +build linux
+
+package xserial
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// outputEmpty reports whether fd has sent all its output. UARTs with a Line
+// Status Register report the Shift Register too; USB adapters only their
+// queue.
+func outputEmpty(fd int) (bool, error) {
+	if lsr, err := unix.IoctlGetInt(fd, unix.TIOCSERGETLSR); err == nil {
+		return lsr&unix.TIOCSER_TEMT != 0, nil
+	}
+	out, err := unix.IoctlGetInt(fd, unix.TIOCOUTQ)
+	return out == 0, err
+}
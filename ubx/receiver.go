@@ -0,0 +1,210 @@
+package ubx
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/packing/xserial"
+	"github.com/packing/xserial/nmea"
+)
+
+var (
+	// ErrNak - the receiver rejected a configuration message
+	ErrNak = errors.New("ubx: message not acknowledged")
+	// ErrNoResponse - the receiver did not answer in time
+	ErrNoResponse = errors.New("ubx: no response")
+)
+
+// Config configures a Receiver
+type Config struct {
+	// How long Poll and Configure wait for an answer, defaults to 1 second
+	Timeout time.Duration
+	// Optional - Called with NMEA sentences that arrive while Poll and
+	// Configure wait
+	OnNMEA func(s *nmea.Sentence)
+}
+
+// Receiver talks UBX to a u-blox receiver on a Port. Its methods must not
+// be called concurrently.
+type Receiver struct {
+	p   xserial.Port
+	cfg Config
+	rd  *xserial.FrameReader
+}
+
+// NewReceiver returns a Receiver on p. cfg may be nil.
+func NewReceiver(p xserial.Port, cfg *Config) *Receiver {
+	var c Config
+	if cfg != nil {
+		c = *cfg
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = time.Second
+	}
+	return &Receiver{p: p, cfg: c, rd: xserial.NewFrameReader(p, NewDecoder())}
+}
+
+// Next returns the next UBX message or NMEA sentence; the other is nil
+func (r *Receiver) Next(ctx context.Context) (*Message, *nmea.Sentence, error) {
+	for {
+		b, err := r.rd.ReadFrameContext(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		if b[0] == '$' {
+			if s, err := nmea.Parse(string(b)); err == nil {
+				return nil, s, nil
+			}
+			continue
+		}
+		if m, err := ParseMessage(b); err == nil {
+			return m, nil, nil
+		}
+	}
+}
+
+// Send writes m
+func (r *Receiver) Send(m *Message) error {
+	_, err := r.p.Write(m.Marshal())
+	return err
+}
+
+// wait returns the first message accepted by match within the Timeout
+func (r *Receiver) wait(ctx context.Context, match func(m *Message) (bool, error)) (*Message, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.cfg.Timeout)
+	defer cancel()
+	for {
+		m, s, err := r.Next(ctx)
+		if err == context.DeadlineExceeded || err == xserial.ErrReadTimeout {
+			return nil, ErrNoResponse
+		}
+		if err != nil {
+			return nil, err
+		}
+		if s != nil {
+			if r.cfg.OnNMEA != nil {
+				r.cfg.OnNMEA(s)
+			}
+			continue
+		}
+		if ok, err := match(m); ok || err != nil {
+			return m, err
+		}
+	}
+}
+
+// isAck reports whether m acknowledges class and id, returning ErrNak for
+// a rejection
+func isAck(m *Message, class, id byte) (bool, error) {
+	if m.Class != ClassACK || len(m.Payload) < 2 || m.Payload[0] != class || m.Payload[1] != id {
+		return false, nil
+	}
+	if m.ID == IDAckNak {
+		return true, ErrNak
+	}
+	return m.ID == IDAckAck, nil
+}
+
+// Poll requests message class and id, with payload if the poll needs one,
+// and returns the answer
+func (r *Receiver) Poll(ctx context.Context, class, id byte, payload []byte) (*Message, error) {
+	if err := r.Send(&Message{Class: class, ID: id, Payload: payload}); err != nil {
+		return nil, err
+	}
+	return r.wait(ctx, func(m *Message) (bool, error) {
+		if ok, err := isAck(m, class, id); ok && err != nil {
+			return true, err
+		}
+		return m.Class == class && m.ID == id, nil
+	})
+}
+
+// Configure sends a CFG message and waits for its acknowledgement
+func (r *Receiver) Configure(ctx context.Context, m *Message) error {
+	if err := r.Send(m); err != nil {
+		return err
+	}
+	_, err := r.wait(ctx, func(a *Message) (bool, error) {
+		return isAck(a, m.Class, m.ID)
+	})
+	return err
+}
+
+// Version returns the software and hardware version from MON-VER
+func (r *Receiver) Version(ctx context.Context) (sw, hw string, err error) {
+	m, err := r.Poll(ctx, ClassMON, IDMonVer, nil)
+	if err != nil {
+		return "", "", err
+	}
+	if len(m.Payload) < 40 {
+		return "", "", errBadFrame
+	}
+	return cstring(m.Payload[:30]), cstring(m.Payload[30:40]), nil
+}
+
+// cstring returns b up to its first NUL
+func cstring(b []byte) string {
+	for i, c := range b {
+		if c == 0 {
+			return string(b[:i])
+		}
+	}
+	return string(b)
+}
+
+// SetMessageRate makes the receiver send message class and id on every
+// rate-th navigation solution on the current port, 0 to stop it
+func (r *Receiver) SetMessageRate(ctx context.Context, class, id, rate byte) error {
+	return r.Configure(ctx, &Message{Class: ClassCFG, ID: IDCfgMsg, Payload: []byte{class, id, rate}})
+}
+
+// SetMeasurementRate sets the interval between navigation solutions
+func (r *Receiver) SetMeasurementRate(ctx context.Context, d time.Duration) error {
+	p := make([]byte, 6)
+	binary.LittleEndian.PutUint16(p[0:], uint16(d/time.Millisecond))
+	// One Measurement per Solution, aligned to GPS Time
+	binary.LittleEndian.PutUint16(p[2:], 1)
+	binary.LittleEndian.PutUint16(p[4:], 1)
+	return r.Configure(ctx, &Message{Class: ClassCFG, ID: IDCfgRate, Payload: p})
+}
+
+// SetPort sends CFG-PRT for UART portID, switching it to baud 8N1 with the
+// given Proto* masks for input and output. The receiver changes speed at once
+// and answers at the new one, so no acknowledgement is awaited: open the Port
+// again at baud afterwards.
+func (r *Receiver) SetPort(portID byte, baud int, inProto, outProto uint16) error {
+	if err := r.Send(PortConfig(portID, baud, inProto, outProto)); err != nil {
+		return err
+	}
+	return r.p.Drain()
+}
+
+// PortConfig returns the CFG-PRT message for UART portID at baud 8N1
+func PortConfig(portID byte, baud int, inProto, outProto uint16) *Message {
+	p := make([]byte, 20)
+	p[0] = portID
+	// 8 Data Bits, No Parity, 1 Stop Bit
+	binary.LittleEndian.PutUint32(p[4:], 0x000008D0)
+	binary.LittleEndian.PutUint32(p[8:], uint32(baud))
+	binary.LittleEndian.PutUint16(p[12:], inProto)
+	binary.LittleEndian.PutUint16(p[14:], outProto)
+	return &Message{Class: ClassCFG, ID: IDCfgPrt, Payload: p}
+}
+
+// PUBX41 returns the proprietary NMEA sentence setting the protocols and
+// baud rate of portID, for receivers that only listen to NMEA yet. Send it
+// with its CR LF; like SetPort it takes effect at once.
+func PUBX41(portID byte, inProto, outProto uint16, baud int) string {
+	s := nmea.Sentence{Talker: "P", Type: "UBX", Fields: []string{
+		"41",
+		fmt.Sprint(portID),
+		fmt.Sprintf("%04X", inProto),
+		fmt.Sprintf("%04X", outProto),
+		fmt.Sprint(baud),
+		"0",
+	}}
+	return s.String() + "\r\n"
+}
@@ -0,0 +1,194 @@
+// Package ubx implements the u-blox UBX binary protocol: frames with their
+// sync characters, class and ID and Fletcher checksum, decoded alongside
+// the NMEA sentences u-blox receivers interleave with them, and helpers to
+// poll and configure a receiver.
+package ubx
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/packing/xserial/checksum"
+	"github.com/packing/xserial/nmea"
+)
+
+// Sync Characters
+const (
+	sync1 = 0xB5
+	sync2 = 0x62
+)
+
+// Message Classes
+const (
+	ClassNAV = 0x01
+	ClassRXM = 0x02
+	ClassINF = 0x04
+	ClassACK = 0x05
+	ClassCFG = 0x06
+	ClassUPD = 0x09
+	ClassMON = 0x0A
+	ClassTIM = 0x0D
+	ClassESF = 0x10
+	ClassMGA = 0x13
+	ClassLOG = 0x21
+	ClassSEC = 0x27
+)
+
+// Message IDs used by this package
+const (
+	IDAckNak  = 0x00
+	IDAckAck  = 0x01
+	IDCfgPrt  = 0x00
+	IDCfgMsg  = 0x01
+	IDCfgRate = 0x08
+	IDMonVer  = 0x04
+	IDNavPvt  = 0x07
+)
+
+// Protocol Mask Bits of CFG-PRT and PUBX,41
+const (
+	ProtoUBX   = 0x01
+	ProtoNMEA  = 0x02
+	ProtoRTCM  = 0x04
+	ProtoRTCM3 = 0x20
+)
+
+// MaxPayload is the largest payload a Decoder accepts
+const MaxPayload = 8192
+
+var errBadFrame = errors.New("ubx: malformed frame")
+
+// Message is one UBX message
+type Message struct {
+	Class   byte
+	ID      byte
+	Payload []byte
+}
+
+// Marshal encodes m with its sync characters and checksum
+func (m *Message) Marshal() []byte {
+	b := make([]byte, 6, 8+len(m.Payload))
+	b[0], b[1], b[2], b[3] = sync1, sync2, m.Class, m.ID
+	binary.LittleEndian.PutUint16(b[4:], uint16(len(m.Payload)))
+	b = append(b, m.Payload...)
+	ckA, ckB := checksum.Fletcher8(b[2:])
+	return append(b, ckA, ckB)
+}
+
+func (m *Message) String() string {
+	return fmt.Sprintf("UBX %02X-%02X (%d bytes)", m.Class, m.ID, len(m.Payload))
+}
+
+// ParseMessage decodes a complete UBX frame as returned by a Decoder
+func ParseMessage(b []byte) (*Message, error) {
+	if len(b) < 8 || b[0] != sync1 || b[1] != sync2 {
+		return nil, errBadFrame
+	}
+	n := int(binary.LittleEndian.Uint16(b[4:]))
+	if len(b) != 8+n {
+		return nil, errBadFrame
+	}
+	if ckA, ckB := checksum.Fletcher8(b[2 : 6+n]); ckA != b[6+n] || ckB != b[7+n] {
+		return nil, errBadFrame
+	}
+	return &Message{Class: b[2], ID: b[3], Payload: append([]byte(nil), b[6:6+n]...)}, nil
+}
+
+// Decoder is an xserial.FrameDecoder for the output of a u-blox receiver.
+// It returns UBX frames whole, ready for ParseMessage, and NMEA sentences
+// without CR LF, told apart by their first byte. Anything else, and frames
+// or sentences failing their checksum, count as resyncs.
+type Decoder struct {
+	buf     []byte
+	stray   bool
+	resyncs uint64
+}
+
+// NewDecoder returns an empty Decoder
+func NewDecoder() *Decoder {
+	return &Decoder{}
+}
+
+// frame returns the length of the frame at the start of b, 0 if more input
+// is needed, or -1 if b does not start one. The sentence length excludes
+// the line end, skip includes it.
+func frame(b []byte) (n, skip int) {
+	switch b[0] {
+	case sync1:
+		if len(b) < 2 {
+			return 0, 0
+		}
+		if b[1] != sync2 {
+			return -1, 0
+		}
+		if len(b) < 6 {
+			return 0, 0
+		}
+		l := int(binary.LittleEndian.Uint16(b[4:]))
+		if l > MaxPayload {
+			return -1, 0
+		}
+		if len(b) < 8+l {
+			return 0, 0
+		}
+		if _, err := ParseMessage(b[:8+l]); err != nil {
+			return -1, 0
+		}
+		return 8 + l, 8 + l
+	case '$':
+		for i, c := range b {
+			if i > nmea.MaxLength {
+				return -1, 0
+			}
+			if c == '\r' || c == '\n' {
+				if _, err := nmea.Parse(string(b[:i])); err != nil {
+					return -1, 0
+				}
+				return i, i + 1
+			}
+		}
+		return 0, 0
+	}
+	return -1, 0
+}
+
+func (d *Decoder) Feed(p []byte) [][]byte {
+	var frames [][]byte
+	d.buf = append(d.buf, p...)
+	i := 0
+	for i < len(d.buf) {
+		b := d.buf[i:]
+		// Line Ends between Sentences
+		if b[0] == '\r' || b[0] == '\n' {
+			i++
+			continue
+		}
+		n, skip := frame(b)
+		if n == 0 {
+			break
+		}
+		if n > 0 {
+			frames = append(frames, append([]byte(nil), b[:n]...))
+			d.stray = false
+			i += skip
+			continue
+		}
+		if !d.stray {
+			d.stray = true
+			d.resyncs++
+		}
+		i++
+	}
+	d.buf = append(d.buf[:0], d.buf[i:]...)
+	return frames
+}
+
+func (d *Decoder) Resyncs() uint64 {
+	return d.resyncs
+}
+
+func (d *Decoder) Reset() {
+	d.buf = d.buf[:0]
+	d.stray = false
+}
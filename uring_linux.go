@@ -0,0 +1,213 @@
+// Copyright 2021 Abhijit Bose. All rights reserved.
+
+//go:build linux
+// +build linux
+
+package xserial
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// io_uring ABI constants (include/uapi/linux/io_uring.h)
+const (
+	uringOffSQRing = 0
+	uringOffCQRing = 0x8000000
+	uringOffSQEs   = 0x10000000
+
+	uringOpWrite       = 23
+	uringOpRead        = 22
+	uringOpPollAdd     = 6
+	uringOpLinkTimeout = 15
+
+	uringSQEIOLink       = 1 << 2
+	uringEnterGetEvents  = 1
+	uringSQESize         = 64
+	uringCQESize         = 16
+	uringUserDataIO      = 1
+	uringUserDataTimeout = 2
+)
+
+type uringSQOffsets struct {
+	head, tail, ringMask, ringEntries, flags, dropped, array, resv1 uint32
+	resv2                                                           uint64
+}
+
+type uringCQOffsets struct {
+	head, tail, ringMask, ringEntries, overflow, cqes, flags, resv1 uint32
+	resv2                                                           uint64
+}
+
+type uringParams struct {
+	sqEntries, cqEntries, flags, sqThreadCPU, sqThreadIdle, features, wqFd uint32
+	resv                                                                   [3]uint32
+	sqOff                                                                  uringSQOffsets
+	cqOff                                                                  uringCQOffsets
+}
+
+// ioURing is a minimal single-issuer io_uring used for one read or write
+// at a time, a timed read waiting first in a poll linked with a timeout
+type ioURing struct {
+	// Serialises Submission and Completion
+	mx sync.Mutex
+	// Ring Handle
+	fd int
+	// Mapped Memory
+	sq, cq, sqes []byte
+	params       uringParams
+	// Kernel Owned Buffers - Kept on the Heap so they never move
+	buf []byte
+	ts  unix.Timespec
+}
+
+func newIOURing() (*ioURing, error) {
+	r := &ioURing{}
+	fd, _, e1 := unix.Syscall(unix.SYS_IO_URING_SETUP, 4, uintptr(unsafe.Pointer(&r.params)), 0)
+	if e1 != 0 {
+		return nil, fmt.Errorf("io_uring not available - %v", e1)
+	}
+	r.fd = int(fd)
+
+	var err error
+	p := &r.params
+	r.sq, err = unix.Mmap(r.fd, uringOffSQRing, int(p.sqOff.array+p.sqEntries*4),
+		unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED|unix.MAP_POPULATE)
+	if err == nil {
+		r.cq, err = unix.Mmap(r.fd, uringOffCQRing, int(p.cqOff.cqes+p.cqEntries*uringCQESize),
+			unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED|unix.MAP_POPULATE)
+	}
+	if err == nil {
+		r.sqes, err = unix.Mmap(r.fd, uringOffSQEs, int(p.sqEntries*uringSQESize),
+			unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED|unix.MAP_POPULATE)
+	}
+	if err != nil {
+		r.close()
+		return nil, fmt.Errorf("io_uring mmap failed - %v", err)
+	}
+	return r, nil
+}
+
+func (r *ioURing) close() {
+	for _, m := range [][]byte{r.sq, r.cq, r.sqes} {
+		if m != nil {
+			unix.Munmap(m)
+		}
+	}
+	r.sq, r.cq, r.sqes = nil, nil, nil
+	unix.Close(r.fd)
+}
+
+func (r *ioURing) u32(ring []byte, off uint32) *uint32 {
+	return (*uint32)(unsafe.Pointer(&ring[off]))
+}
+
+// push fills in and queues one SQE. For a poll n holds the events.
+func (r *ioURing) push(op uint8, flags uint8, fd int, addr unsafe.Pointer, n uint32, userData uint64) {
+	p := &r.params
+	tail := atomic.LoadUint32(r.u32(r.sq, p.sqOff.tail))
+	idx := tail & *r.u32(r.sq, p.sqOff.ringMask)
+	sqe := r.sqes[idx*uringSQESize : (idx+1)*uringSQESize]
+	for i := range sqe {
+		sqe[i] = 0
+	}
+	sqe[0] = op
+	sqe[1] = flags
+	*(*int32)(unsafe.Pointer(&sqe[4])) = int32(fd)
+	if op == uringOpPollAdd {
+		// poll_events - the Kernel reads poll32_events with its Halfwords
+		// swapped on Big Endian, which lands this in the right Place
+		*(*uint16)(unsafe.Pointer(&sqe[28])) = uint16(n)
+	} else {
+		if op != uringOpLinkTimeout {
+			*(*uint64)(unsafe.Pointer(&sqe[8])) = ^uint64(0) // Current File Position
+		}
+		*(*uint64)(unsafe.Pointer(&sqe[16])) = uint64(uintptr(addr))
+		*(*uint32)(unsafe.Pointer(&sqe[24])) = n
+	}
+	*(*uint64)(unsafe.Pointer(&sqe[32])) = userData
+	*r.u32(r.sq, p.sqOff.array+idx*4) = idx
+	atomic.StoreUint32(r.u32(r.sq, p.sqOff.tail), tail+1)
+}
+
+// reap waits for want completions and returns the result of the I/O entry
+func (r *ioURing) reap(submit, want uint32) (res int32, err error) {
+	p := &r.params
+	for want > 0 {
+		_, _, e1 := unix.Syscall6(unix.SYS_IO_URING_ENTER, uintptr(r.fd), uintptr(submit), 1, uringEnterGetEvents, 0, 0)
+		if e1 == unix.EINTR {
+			continue
+		}
+		if e1 != 0 {
+			return 0, e1
+		}
+		submit = 0
+		head := atomic.LoadUint32(r.u32(r.cq, p.cqOff.head))
+		tail := atomic.LoadUint32(r.u32(r.cq, p.cqOff.tail))
+		mask := *r.u32(r.cq, p.cqOff.ringMask)
+		for ; head != tail; head++ {
+			off := p.cqOff.cqes + (head&mask)*uringCQESize
+			if *(*uint64)(unsafe.Pointer(&r.cq[off])) == uringUserDataIO {
+				res = *(*int32)(unsafe.Pointer(&r.cq[off+8]))
+			}
+			want--
+		}
+		atomic.StoreUint32(r.u32(r.cq, p.cqOff.head), head)
+	}
+	return res, nil
+}
+
+// do performs a single read or write on fd. A read with a non-zero timeout
+// first waits up to timeout for input, as the Port's VMIN=0 makes the read
+// itself return at once; revents is then the poll result, else -1.
+func (r *ioURing) do(op uint8, fd int, p []byte, timeout time.Duration) (n int, revents int16, err error) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	if len(p) == 0 {
+		return 0, -1, nil
+	}
+	if cap(r.buf) < len(p) {
+		r.buf = make([]byte, len(p))
+	}
+	buf := r.buf[:len(p)]
+	if op == uringOpWrite {
+		copy(buf, p)
+	}
+
+	revents = -1
+	if op == uringOpRead && timeout > 0 {
+		r.ts = unix.NsecToTimespec(timeout.Nanoseconds())
+		r.push(uringOpPollAdd, uringSQEIOLink, fd, nil, unix.POLLIN, uringUserDataIO)
+		r.push(uringOpLinkTimeout, 0, -1, unsafe.Pointer(&r.ts), 1, uringUserDataTimeout)
+		res, err := r.reap(2, 2)
+		if err != nil {
+			return 0, revents, err
+		}
+		switch {
+		case res == -int32(unix.ECANCELED) || res == -int32(unix.EINTR):
+			return 0, revents, ErrReadTimeout
+		case res < 0:
+			return 0, revents, unix.Errno(-res)
+		}
+		revents = int16(res)
+	}
+
+	r.push(op, 0, fd, unsafe.Pointer(&buf[0]), uint32(len(buf)), uringUserDataIO)
+	res, err := r.reap(1, 1)
+	if err != nil {
+		return 0, revents, err
+	}
+	if res < 0 {
+		return 0, revents, unix.Errno(-res)
+	}
+	if op == uringOpRead {
+		copy(p, buf[:res])
+	}
+	return int(res), revents, nil
+}
@@ -0,0 +1,38 @@
+package xserial_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/packing/xserial"
+	"github.com/packing/xserial/xserialtest"
+)
+
+func TestIOURingReadTimeout(t *testing.T) {
+	port, device, err := xserialtest.NewPTYPair(&xserial.Config{Baud: 115200, Parity: "N", ReadTimeout: 200, IOUring: true})
+	if err != nil {
+		t.Skip("io_uring port not available:", err)
+	}
+	defer device.Close()
+	defer port.Close()
+
+	buf := make([]byte, 16)
+	start := time.Now()
+	n, err := port.Read(buf)
+	elapsed := time.Since(start)
+	if n != 0 || err != xserial.ErrReadTimeout {
+		t.Fatalf("idle Read returned %d, %v, want ErrReadTimeout", n, err)
+	}
+	if elapsed < 150*time.Millisecond || elapsed > time.Second {
+		t.Fatalf("idle Read returned after %v, want about 200ms", elapsed)
+	}
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		device.Write([]byte("hello"))
+	}()
+	n, err = port.Read(buf)
+	if err != nil || string(buf[:n]) != "hello" {
+		t.Fatalf("Read returned %q, %v, want hello", buf[:n], err)
+	}
+}
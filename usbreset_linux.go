@@ -0,0 +1,48 @@
+// Copyright 2021 Abhijit Bose. All rights reserved.
+
+//go:build linux
+// +build linux
+
+package xserial
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+// USBDEVFS_RESET - _IO('U', 20)
+const usbdevfsReset = 0x5514
+
+// ResetUSBDevice resets the USB adapter behind the serial device name, as
+// unplugging it would, to recover an adapter that stopped responding. The
+// device node goes away and comes back once the adapter has enumerated
+// again, so close its Port first. Needs write access to /dev/bus/usb.
+func ResetUSBDevice(name string) error {
+	dev, err := ResolvePort(name)
+	if err != nil {
+		return err
+	}
+	iface, err := filepath.EvalSymlinks(filepath.Join(sysClassTTY, filepath.Base(dev), "device"))
+	if err != nil {
+		return ErrNotUSB
+	}
+	// Walk up from the Interface to the USB Device
+	for d := iface; d != "/" && d != "."; d = filepath.Dir(d) {
+		bus, _ := strconv.Atoi(readSys(filepath.Join(d, "busnum")))
+		num, _ := strconv.Atoi(readSys(filepath.Join(d, "devnum")))
+		if bus == 0 || num == 0 {
+			continue
+		}
+		f, err := os.OpenFile(fmt.Sprintf("/dev/bus/usb/%03d/%03d", bus, num), os.O_WRONLY, 0)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return unix.IoctlSetInt(int(f.Fd()), usbdevfsReset, 0)
+	}
+	return ErrNotUSB
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+// +build !linux
+
+package xserial
+
+// ResetUSBDevice returns ErrNotImplemented outside Linux
+func ResetUSBDevice(name string) error {
+	return ErrNotImplemented
+}
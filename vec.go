@@ -0,0 +1,29 @@
+package xserial
+
+import (
+	"net"
+)
+
+// VectorWriter is implemented by Ports that can send several buffers in a
+// single atomic write
+type VectorWriter interface {
+	WriteVec(bufs net.Buffers) (int64, error)
+}
+
+// WriteVec sends bufs on p as one write. Ports without vectored I/O get a
+// single Write of the joined buffers so atomicity is preserved.
+func WriteVec(p Port, bufs net.Buffers) (int64, error) {
+	if v, ok := p.(VectorWriter); ok {
+		return v.WriteVec(bufs)
+	}
+	size := 0
+	for _, b := range bufs {
+		size += len(b)
+	}
+	joined := make([]byte, 0, size)
+	for _, b := range bufs {
+		joined = append(joined, b...)
+	}
+	n, err := p.Write(joined)
+	return int64(n), err
+}
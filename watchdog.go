@@ -0,0 +1,313 @@
+package xserial
+
+import (
+	"sync"
+	"time"
+)
+
+// WatchdogReason tells why a Watchdog found its Port stuck
+type WatchdogReason int
+
+const (
+	// WatchdogRXSilent - nothing was received for RXTimeout although a
+	// response or a stream was expected
+	WatchdogRXSilent WatchdogReason = iota
+	// WatchdogTXStuck - output stayed queued in the driver for TXTimeout
+	WatchdogTXStuck
+)
+
+func (r WatchdogReason) String() string {
+	if r == WatchdogRXSilent {
+		return "rx silent"
+	}
+	return "tx stuck"
+}
+
+// RecoverFunc brings a stuck Port back and returns the Port to carry on
+// with, which may be a new one
+type RecoverFunc func(p Port, reason WatchdogReason) (Port, error)
+
+// RecoverFlush discards both directions of the driver buffers
+func RecoverFlush(p Port, reason WatchdogReason) (Port, error) {
+	return p, p.Flush()
+}
+
+// RecoverReopen closes the Port and opens cfg again
+func RecoverReopen(cfg *Config) RecoverFunc {
+	return func(p Port, reason WatchdogReason) (Port, error) {
+		p.Close()
+		return OpenPort(cfg)
+	}
+}
+
+// How long RecoverUSBReset waits for the adapter to enumerate again
+const usbReenumerateTimeout = 5 * time.Second
+
+// RecoverUSBReset closes the Port, resets its USB adapter with
+// ResetUSBDevice and opens cfg again once the adapter is back, for adapters
+// wedged beyond what a reopen cures. Should the reset fail, cfg is opened
+// again anyway and the reset's error reported.
+func RecoverUSBReset(cfg *Config) RecoverFunc {
+	return func(p Port, reason WatchdogReason) (Port, error) {
+		p.Close()
+		if rerr := ResetUSBDevice(cfg.Name); rerr != nil {
+			np, err := OpenPort(cfg)
+			if err != nil {
+				return nil, rerr
+			}
+			return np, rerr
+		}
+		deadline := time.Now().Add(usbReenumerateTimeout)
+		for {
+			// Failures are expected until the Device Node is back
+			np, err := open(cfg)
+			if err == nil {
+				logOpened(cfg)
+				return np, nil
+			}
+			if time.Now().After(deadline) {
+				loggerFor(cfg).Log(LevelDebug, "serial port open failed", "port", cfg.Name, "err", err)
+				return nil, err
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+	}
+}
+
+// WatchdogConfig configures a Watchdog
+type WatchdogConfig struct {
+	// Stuck when nothing arrives this long after a Write, zero disables
+	RXTimeout time.Duration
+	// Expect RX all the time rather than only in response to a Write
+	ExpectStream bool
+	// Stuck when the driver's output queue does not shrink for this long,
+	// zero disables. Needs a Port implementing QueueReporter.
+	TXTimeout time.Duration
+	// How often to check, defaults to a quarter of the shorter timeout
+	Interval time.Duration
+	// Recovery Action, defaults to RecoverFlush
+	Recover RecoverFunc
+	// Optional - Called before and after each Recovery
+	OnStuck     func(reason WatchdogReason)
+	OnRecovered func(reason WatchdogReason, err error)
+}
+
+// Watchdog is a Port wrapper that notices when the line has gone stuck and
+// runs a recovery action. Reads and Writes that fail because recovery
+// replaced the Port are retried on the new one; Events follow the Port that
+// was current when Events was called.
+type Watchdog struct {
+	cfg  WatchdogConfig
+	stop chan struct{}
+	done chan struct{}
+
+	mx         sync.Mutex
+	p          Port
+	waiting    time.Time // First unanswered Write, or the last RX for a Stream
+	outQueued  int
+	outSince   time.Time
+	recoveries uint64
+	closed     bool
+}
+
+// NewWatchdog starts watching p. cfg may be nil, which only watches TX
+// with a one second timeout.
+func NewWatchdog(p Port, cfg *WatchdogConfig) *Watchdog {
+	c := WatchdogConfig{TXTimeout: time.Second}
+	if cfg != nil {
+		c = *cfg
+	}
+	if c.Recover == nil {
+		c.Recover = RecoverFlush
+	}
+	if c.Interval <= 0 {
+		shortest := c.RXTimeout
+		if shortest <= 0 || (c.TXTimeout > 0 && c.TXTimeout < shortest) {
+			shortest = c.TXTimeout
+		}
+		c.Interval = shortest / 4
+		if c.Interval <= 0 {
+			c.Interval = time.Second
+		}
+	}
+	w := &Watchdog{cfg: c, p: p, stop: make(chan struct{}), done: make(chan struct{})}
+	if c.ExpectStream {
+		w.waiting = time.Now()
+	}
+	go w.run()
+	return w
+}
+
+func (w *Watchdog) port() Port {
+	w.mx.Lock()
+	defer w.mx.Unlock()
+	return w.p
+}
+
+// Unwrap returns the current Port
+func (w *Watchdog) Unwrap() Port {
+	return w.port()
+}
+
+// Recoveries returns how often the recovery action has run
+func (w *Watchdog) Recoveries() uint64 {
+	w.mx.Lock()
+	defer w.mx.Unlock()
+	return w.recoveries
+}
+
+// replaced reports whether recovery swapped the Port after p failed
+func (w *Watchdog) replaced(p Port) bool {
+	w.mx.Lock()
+	defer w.mx.Unlock()
+	return !w.closed && w.p != p
+}
+
+func (w *Watchdog) Read(b []byte) (int, error) {
+	for {
+		p := w.port()
+		n, err := p.Read(b)
+		if n > 0 {
+			w.mx.Lock()
+			w.waiting = time.Time{}
+			if w.cfg.ExpectStream {
+				w.waiting = time.Now()
+			}
+			w.mx.Unlock()
+		}
+		if err != nil && n == 0 && w.replaced(p) {
+			continue
+		}
+		return n, err
+	}
+}
+
+func (w *Watchdog) Write(b []byte) (int, error) {
+	for {
+		p := w.port()
+		n, err := p.Write(b)
+		if n > 0 {
+			w.mx.Lock()
+			if w.waiting.IsZero() {
+				w.waiting = time.Now()
+			}
+			w.mx.Unlock()
+		}
+		if err != nil && n == 0 && w.replaced(p) {
+			continue
+		}
+		return n, err
+	}
+}
+
+func (w *Watchdog) run() {
+	defer close(w.done)
+	t := time.NewTicker(w.cfg.Interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-t.C:
+		}
+		if reason, stuck := w.check(time.Now()); stuck {
+			w.recover(reason)
+		}
+	}
+}
+
+// check looks for a stuck line
+func (w *Watchdog) check(now time.Time) (WatchdogReason, bool) {
+	w.mx.Lock()
+	p := w.p
+	if w.cfg.RXTimeout > 0 && !w.waiting.IsZero() && now.Sub(w.waiting) >= w.cfg.RXTimeout {
+		w.mx.Unlock()
+		return WatchdogRXSilent, true
+	}
+	w.mx.Unlock()
+
+	if w.cfg.TXTimeout <= 0 {
+		return 0, false
+	}
+	q, ok := As[QueueReporter](p)
+	if !ok {
+		return 0, false
+	}
+	_, out, err := q.Queued()
+	if err != nil {
+		return 0, false
+	}
+	w.mx.Lock()
+	defer w.mx.Unlock()
+	switch {
+	case out == 0 || out < w.outQueued:
+		// Draining
+		w.outSince = time.Time{}
+	case w.outSince.IsZero():
+		w.outSince = now
+	case now.Sub(w.outSince) >= w.cfg.TXTimeout:
+		w.outQueued = out
+		return WatchdogTXStuck, true
+	}
+	w.outQueued = out
+	return 0, false
+}
+
+func (w *Watchdog) recover(reason WatchdogReason) {
+	if w.cfg.OnStuck != nil {
+		w.cfg.OnStuck(reason)
+	}
+	p := w.port()
+	np, err := w.cfg.Recover(p, reason)
+
+	w.mx.Lock()
+	if np != nil && !w.closed {
+		w.p = np
+	}
+	w.recoveries++
+	// Start Timing Afresh on the Recovered Port
+	w.waiting = time.Time{}
+	if w.cfg.ExpectStream {
+		w.waiting = time.Now()
+	}
+	w.outQueued, w.outSince = 0, time.Time{}
+	w.mx.Unlock()
+
+	if w.cfg.OnRecovered != nil {
+		w.cfg.OnRecovered(reason, err)
+	}
+}
+
+// Close stops watching and closes the current Port
+func (w *Watchdog) Close() error {
+	w.mx.Lock()
+	if w.closed {
+		w.mx.Unlock()
+		return ErrPortClosed
+	}
+	w.closed = true
+	w.mx.Unlock()
+	close(w.stop)
+	<-w.done
+	return w.port().Close()
+}
+
+func (w *Watchdog) SetParity(parity string, stopbits int) error {
+	return w.port().SetParity(parity, stopbits)
+}
+
+func (w *Watchdog) Flush() error {
+	return w.port().Flush()
+}
+
+func (w *Watchdog) Drain() error {
+	return w.port().Drain()
+}
+
+func (w *Watchdog) Events() <-chan Event {
+	return w.port().Events()
+}
+
+func (w *Watchdog) Stats() Stats {
+	return w.port().Stats()
+}
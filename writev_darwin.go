@@ -0,0 +1,27 @@
+// Copyright 2021 Abhijit Bose. All rights reserved.
+
+//go:build darwin
+// +build darwin
+
+package xserial
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// IOV_MAX on Darwin
+const maxIOV = 1024
+
+// writev gathers into one buffer as x/sys has no Writev for Darwin; it
+// still costs a single write system call
+func writev(fd int, bufs [][]byte) (int, error) {
+	size := 0
+	for _, b := range bufs {
+		size += len(b)
+	}
+	p := make([]byte, 0, size)
+	for _, b := range bufs {
+		p = append(p, b...)
+	}
+	return unix.Write(fd, p)
+}
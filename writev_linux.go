@@ -0,0 +1,17 @@
+// Copyright 2021 Abhijit Bose. All rights reserved.
+
+//go:build linux
+// +build linux
+
+package xserial
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// IOV_MAX on Linux
+const maxIOV = 1024
+
+func writev(fd int, bufs [][]byte) (int, error) {
+	return unix.Writev(fd, bufs)
+}
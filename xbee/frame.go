@@ -0,0 +1,147 @@
+// Package xbee implements the API mode of Digi XBee radios: frames with
+// their start delimiter, length and checksum in unescaped (AP=1) or escaped
+// (AP=2) form, the common transmit, receive and AT command frame types and
+// a Radio driving a module on a Port.
+package xbee
+
+import (
+	"errors"
+
+	"github.com/packing/xserial/checksum"
+)
+
+// Special Characters
+const (
+	Start  = 0x7E
+	Escape = 0x7D
+	XON    = 0x11
+	XOFF   = 0x13
+	// Escaped bytes are sent XORed with this
+	escapeXOR = 0x20
+)
+
+// MaxLength is the largest frame data, API identifier included, a Decoder
+// accepts
+const MaxLength = 1024
+
+var (
+	// ErrFormat - the frame data is too short for its type
+	ErrFormat = errors.New("xbee: malformed frame")
+	// ErrTooLarge - the frame data exceeds MaxLength
+	ErrTooLarge = errors.New("xbee: frame too large")
+)
+
+// Encode returns frame data, starting with its API identifier, as one API
+// frame, escaped for AP=2 when escaped is set
+func Encode(data []byte, escaped bool) []byte {
+	b := make([]byte, 0, len(data)+len(data)/8+5)
+	b = append(b, Start)
+	put := func(c byte) {
+		if escaped && (c == Start || c == Escape || c == XON || c == XOFF) {
+			b = append(b, Escape, c^escapeXOR)
+		} else {
+			b = append(b, c)
+		}
+	}
+	put(byte(len(data) >> 8))
+	put(byte(len(data)))
+	for _, c := range data {
+		put(c)
+	}
+	put(0xFF - checksum.Sum(data))
+	return b
+}
+
+// Decoder is an xserial.FrameDecoder returning the frame data of API
+// frames, unescaped and without length and checksum. Frames failing their
+// checksum and bytes between frames count as resyncs.
+type Decoder struct {
+	escaped bool
+	buf     []byte
+	stray   bool
+	resyncs uint64
+}
+
+// NewDecoder returns an empty Decoder, for escaped (AP=2) frames when
+// escaped is set
+func NewDecoder(escaped bool) *Decoder {
+	return &Decoder{escaped: escaped}
+}
+
+// frame decodes the frame starting b, returning its data and the bytes it
+// took, 0 if more input is needed or -1 if b does not start a valid frame
+func (d *Decoder) frame(b []byte) ([]byte, int) {
+	var out []byte
+	i := 1
+	// Length, Frame Data and Checksum
+	need := 2
+	for len(out) < need {
+		if i == len(b) {
+			return nil, 0
+		}
+		c := b[i]
+		i++
+		if d.escaped {
+			// The Start Delimiter is never escaped, so it always begins a Frame
+			if c == Start {
+				return nil, -1
+			}
+			if c == Escape {
+				if i == len(b) {
+					return nil, 0
+				}
+				c = b[i] ^ escapeXOR
+				i++
+			}
+		}
+		out = append(out, c)
+		if len(out) == 2 {
+			n := int(out[0])<<8 | int(out[1])
+			if n == 0 || n > MaxLength {
+				return nil, -1
+			}
+			need = 2 + n + 1
+		}
+	}
+	data := out[2 : need-1]
+	if checksum.Sum(data)+out[need-1] != 0xFF {
+		return nil, -1
+	}
+	return data, i
+}
+
+func (d *Decoder) Feed(p []byte) [][]byte {
+	var frames [][]byte
+	d.buf = append(d.buf, p...)
+	i := 0
+	for i < len(d.buf) {
+		if d.buf[i] == Start {
+			data, n := d.frame(d.buf[i:])
+			if n == 0 {
+				break
+			}
+			if n > 0 {
+				frames = append(frames, data)
+				d.stray = false
+				i += n
+				continue
+			}
+		}
+		if !d.stray {
+			d.stray = true
+			d.resyncs++
+		}
+		i++
+	}
+	d.buf = append(d.buf[:0], d.buf[i:]...)
+	return frames
+}
+
+func (d *Decoder) Resyncs() uint64 {
+	return d.resyncs
+}
+
+func (d *Decoder) Reset() {
+	d.buf = d.buf[:0]
+	d.stray = false
+}
@@ -0,0 +1,183 @@
+package xbee
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/packing/xserial"
+)
+
+// ErrNoResponse - the module did not answer in time
+var ErrNoResponse = errors.New("xbee: no response")
+
+// DeliveryError is the delivery status of a failed transmission
+type DeliveryError struct {
+	Status byte
+}
+
+func (e *DeliveryError) Error() string {
+	return fmt.Sprintf("xbee: delivery failed, status 0x%02X", e.Status)
+}
+
+// ATError is the status of a failed AT command
+type ATError struct {
+	Command string
+	Status  byte
+}
+
+func (e *ATError) Error() string {
+	return fmt.Sprintf("xbee: AT%s failed, status %d", e.Command, e.Status)
+}
+
+// Config configures a Radio
+type Config struct {
+	// The module runs with AP=2
+	Escaped bool
+	// How long Send and AT wait for their status, defaults to 5 seconds as
+	// mesh deliveries take several retries
+	Timeout time.Duration
+}
+
+// Radio drives an XBee module in API mode on a Port. Frames arriving while
+// Send or AT wait for their answer are kept for ReadFrame and Receive. Its
+// methods must not be called concurrently.
+type Radio struct {
+	p       xserial.Port
+	cfg     Config
+	rd      *xserial.FrameReader
+	frameID byte
+	pending [][]byte
+}
+
+// NewRadio returns a Radio on p. cfg may be nil.
+func NewRadio(p xserial.Port, cfg *Config) *Radio {
+	var c Config
+	if cfg != nil {
+		c = *cfg
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = 5 * time.Second
+	}
+	return &Radio{p: p, cfg: c, rd: xserial.NewFrameReader(p, NewDecoder(c.Escaped))}
+}
+
+// WriteFrame sends frame data, starting with its API identifier
+func (r *Radio) WriteFrame(data []byte) error {
+	if len(data) > MaxLength {
+		return ErrTooLarge
+	}
+	_, err := r.p.Write(Encode(data, r.cfg.Escaped))
+	return err
+}
+
+// ReadFrame returns the next frame data received
+func (r *Radio) ReadFrame(ctx context.Context) ([]byte, error) {
+	if len(r.pending) > 0 {
+		b := r.pending[0]
+		r.pending = r.pending[1:]
+		return b, nil
+	}
+	return r.rd.ReadFrameContext(ctx)
+}
+
+// Receive returns the next ReceivePacket, dropping other frames
+func (r *Radio) Receive(ctx context.Context) (*ReceivePacket, error) {
+	for {
+		b, err := r.ReadFrame(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if b[0] == TypeReceivePacket {
+			if rp, err := ParseReceivePacket(b); err == nil {
+				return rp, nil
+			}
+		}
+	}
+}
+
+// nextID returns the next FrameID, skipping 0 which asks for no answer
+func (r *Radio) nextID() byte {
+	r.frameID++
+	if r.frameID == 0 {
+		r.frameID = 1
+	}
+	return r.frameID
+}
+
+// exchange sends data and returns the frame of type typ answering frame id
+func (r *Radio) exchange(ctx context.Context, data []byte, typ, id byte) ([]byte, error) {
+	if err := r.WriteFrame(data); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, r.cfg.Timeout)
+	defer cancel()
+	for {
+		b, err := r.rd.ReadFrameContext(ctx)
+		if err == context.DeadlineExceeded || err == xserial.ErrReadTimeout {
+			return nil, ErrNoResponse
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(b) > 1 && b[0] == typ && b[1] == id {
+			return b, nil
+		}
+		r.pending = append(r.pending, b)
+	}
+}
+
+// Send transmits data to the node at dest64 and waits for its delivery
+// status
+func (r *Radio) Send(ctx context.Context, dest64 uint64, data []byte) error {
+	t := &TransmitRequest{FrameID: r.nextID(), Dest64: dest64, Dest16: UnknownAddress, Data: data}
+	b, err := r.exchange(ctx, t.Marshal(), TypeTransmitStatus, t.FrameID)
+	if err != nil {
+		return err
+	}
+	s, err := ParseTransmitStatus(b)
+	if err != nil {
+		return err
+	}
+	if s.Delivery != 0 {
+		return &DeliveryError{Status: s.Delivery}
+	}
+	return nil
+}
+
+// AT runs an AT command on the local module, querying the parameter when
+// param is empty, and returns the response data
+func (r *Radio) AT(ctx context.Context, cmd string, param []byte) ([]byte, error) {
+	a := &ATCommand{FrameID: r.nextID(), Command: cmd, Param: param}
+	b, err := r.exchange(ctx, a.Marshal(), TypeATResponse, a.FrameID)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := ParseATResponse(b)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Status != StatusOK {
+		return nil, &ATError{Command: cmd, Status: resp.Status}
+	}
+	return resp.Data, nil
+}
+
+// RemoteAT runs an AT command on the node at dest64, applying changes at
+// once
+func (r *Radio) RemoteAT(ctx context.Context, dest64 uint64, cmd string, param []byte) ([]byte, error) {
+	a := &RemoteATCommand{FrameID: r.nextID(), Dest64: dest64, Dest16: UnknownAddress, Options: 0x02, Command: cmd, Param: param}
+	b, err := r.exchange(ctx, a.Marshal(), TypeRemoteATResponse, a.FrameID)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := ParseRemoteATResponse(b)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Status != StatusOK {
+		return nil, &ATError{Command: cmd, Status: resp.Status}
+	}
+	return resp.Data, nil
+}
@@ -0,0 +1,203 @@
+package xbee
+
+import "encoding/binary"
+
+// API Identifiers
+const (
+	TypeTxRequest64          = 0x00
+	TypeTxRequest16          = 0x01
+	TypeATCommand            = 0x08
+	TypeATQueue              = 0x09
+	TypeTransmitRequest      = 0x10
+	TypeExplicitTransmit     = 0x11
+	TypeRemoteATCommand      = 0x17
+	TypeRx64                 = 0x80
+	TypeRx16                 = 0x81
+	TypeATResponse           = 0x88
+	TypeTxStatus             = 0x89
+	TypeModemStatus          = 0x8A
+	TypeTransmitStatus       = 0x8B
+	TypeReceivePacket        = 0x90
+	TypeExplicitReceive      = 0x91
+	TypeRemoteATResponse     = 0x97
+	TypeNodeIdentification   = 0x95
+	TypeRouteRecordIndicator = 0xA1
+)
+
+// Addresses
+const (
+	// 64 bit Broadcast Address
+	Broadcast = 0x000000000000FFFF
+	// 64 bit Address of the Coordinator
+	Coordinator = 0
+	// 16 bit Address to use when the Network Address is not known
+	UnknownAddress = 0xFFFE
+)
+
+// AT Command Status
+const (
+	StatusOK               = 0
+	StatusError            = 1
+	StatusInvalidCommand   = 2
+	StatusInvalidParameter = 3
+	StatusTxFailure        = 4
+)
+
+// TransmitRequest (0x10) sends data to a node of a Zigbee or DigiMesh network
+type TransmitRequest struct {
+	// Non-zero to be answered by a TransmitStatus with the same FrameID
+	FrameID byte
+	Dest64  uint64
+	// UnknownAddress unless known
+	Dest16 uint16
+	// Broadcast Hops, 0 for the network maximum
+	Radius  byte
+	Options byte
+	Data    []byte
+}
+
+// Marshal returns the frame data
+func (t *TransmitRequest) Marshal() []byte {
+	b := make([]byte, 14, 14+len(t.Data))
+	b[0], b[1] = TypeTransmitRequest, t.FrameID
+	binary.BigEndian.PutUint64(b[2:], t.Dest64)
+	binary.BigEndian.PutUint16(b[10:], t.Dest16)
+	b[12], b[13] = t.Radius, t.Options
+	return append(b, t.Data...)
+}
+
+// TransmitStatus (0x8B) reports the outcome of a TransmitRequest
+type TransmitStatus struct {
+	FrameID byte
+	Dest16  uint16
+	Retries byte
+	// 0 for Success
+	Delivery  byte
+	Discovery byte
+}
+
+// ParseTransmitStatus decodes the frame data of a TransmitStatus
+func ParseTransmitStatus(b []byte) (*TransmitStatus, error) {
+	if len(b) < 7 || b[0] != TypeTransmitStatus {
+		return nil, ErrFormat
+	}
+	return &TransmitStatus{
+		FrameID:   b[1],
+		Dest16:    binary.BigEndian.Uint16(b[2:]),
+		Retries:   b[4],
+		Delivery:  b[5],
+		Discovery: b[6],
+	}, nil
+}
+
+// ReceivePacket (0x90) carries data received from another node
+type ReceivePacket struct {
+	Src64   uint64
+	Src16   uint16
+	Options byte
+	Data    []byte
+}
+
+// ParseReceivePacket decodes the frame data of a ReceivePacket
+func ParseReceivePacket(b []byte) (*ReceivePacket, error) {
+	if len(b) < 12 || b[0] != TypeReceivePacket {
+		return nil, ErrFormat
+	}
+	return &ReceivePacket{
+		Src64:   binary.BigEndian.Uint64(b[1:]),
+		Src16:   binary.BigEndian.Uint16(b[9:]),
+		Options: b[11],
+		Data:    append([]byte(nil), b[12:]...),
+	}, nil
+}
+
+// ATCommand (0x08) queries or sets a parameter of the local module
+type ATCommand struct {
+	// Non-zero to be answered by an ATResponse with the same FrameID
+	FrameID byte
+	// Two Characters such as "NI"
+	Command string
+	// Empty to query
+	Param []byte
+	// Queue the change until AC or another ATCommand applies it (0x09)
+	Queue bool
+}
+
+// Marshal returns the frame data
+func (a *ATCommand) Marshal() []byte {
+	typ := byte(TypeATCommand)
+	if a.Queue {
+		typ = TypeATQueue
+	}
+	b := append([]byte{typ, a.FrameID}, a.Command...)
+	return append(b, a.Param...)
+}
+
+// ATResponse (0x88) answers an ATCommand
+type ATResponse struct {
+	FrameID byte
+	Command string
+	// One of the Status* values
+	Status byte
+	Data   []byte
+}
+
+// ParseATResponse decodes the frame data of an ATResponse
+func ParseATResponse(b []byte) (*ATResponse, error) {
+	if len(b) < 5 || b[0] != TypeATResponse {
+		return nil, ErrFormat
+	}
+	return &ATResponse{
+		FrameID: b[1],
+		Command: string(b[2:4]),
+		Status:  b[4],
+		Data:    append([]byte(nil), b[5:]...),
+	}, nil
+}
+
+// RemoteATCommand (0x17) queries or sets a parameter of another node
+type RemoteATCommand struct {
+	FrameID byte
+	Dest64  uint64
+	Dest16  uint16
+	// 0x02 applies changes at once
+	Options byte
+	Command string
+	Param   []byte
+}
+
+// Marshal returns the frame data
+func (a *RemoteATCommand) Marshal() []byte {
+	b := make([]byte, 13, 15+len(a.Param))
+	b[0], b[1] = TypeRemoteATCommand, a.FrameID
+	binary.BigEndian.PutUint64(b[2:], a.Dest64)
+	binary.BigEndian.PutUint16(b[10:], a.Dest16)
+	b[12] = a.Options
+	b = append(b, a.Command...)
+	return append(b, a.Param...)
+}
+
+// RemoteATResponse (0x97) answers a RemoteATCommand
+type RemoteATResponse struct {
+	FrameID byte
+	Src64   uint64
+	Src16   uint16
+	Command string
+	Status  byte
+	Data    []byte
+}
+
+// ParseRemoteATResponse decodes the frame data of a RemoteATResponse
+func ParseRemoteATResponse(b []byte) (*RemoteATResponse, error) {
+	if len(b) < 15 || b[0] != TypeRemoteATResponse {
+		return nil, ErrFormat
+	}
+	return &RemoteATResponse{
+		FrameID: b[1],
+		Src64:   binary.BigEndian.Uint64(b[2:]),
+		Src16:   binary.BigEndian.Uint16(b[10:]),
+		Command: string(b[12:14]),
+		Status:  b[14],
+		Data:    append([]byte(nil), b[15:]...),
+	}, nil
+}
@@ -0,0 +1,501 @@
+// Package xmodem transfers files with XMODEM, XMODEM-CRC and XMODEM-1K,
+// and in batches with YMODEM, as taken by bootloaders and controllers
+// that offer nothing else.
+package xmodem
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/packing/xserial"
+	"github.com/packing/xserial/checksum"
+)
+
+// Control Characters
+const (
+	SOH = 0x01
+	STX = 0x02
+	EOT = 0x04
+	ACK = 0x06
+	NAK = 0x15
+	CAN = 0x18
+	SUB = 0x1A
+	// Sent by a receiver asking for CRC mode
+	CRC = 'C'
+)
+
+var (
+	// ErrCanceled - the other end cancelled the transfer
+	ErrCanceled = errors.New("xmodem: transfer cancelled by peer")
+	// ErrRetries - a block failed more often than Retries allows
+	ErrRetries = errors.New("xmodem: too many errors")
+	// ErrSequence - the sender skipped a block
+	ErrSequence = errors.New("xmodem: block out of sequence")
+	// ErrNoResponse - the other end never started the transfer
+	ErrNoResponse = errors.New("xmodem: no response")
+
+	errTimeout = errors.New("xmodem: timeout")
+)
+
+// Config configures a transfer
+type Config struct {
+	// Send 1024 byte blocks (XMODEM-1K); receivers take either size.
+	// YMODEM always sends them.
+	Block1K bool
+	// Receive with the 8 bit checksum instead of asking for CRC
+	Checksum bool
+	// Strip trailing SUB padding from the last block received, as XMODEM
+	// carries no file size
+	TrimPadding bool
+	// How long to wait for each response, defaults to 10 seconds
+	Timeout time.Duration
+	// Errors tolerated per block before giving up, defaults to 10;
+	// negative for none
+	Retries int
+	// Optional - Called after each block with the bytes done so far and
+	// the total, -1 when unknown
+	OnProgress func(done, total int64)
+}
+
+func (c *Config) defaults() Config {
+	var cfg Config
+	if c != nil {
+		cfg = *c
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	if cfg.Retries < 0 {
+		cfg.Retries = 0
+	} else if cfg.Retries == 0 {
+		cfg.Retries = 10
+	}
+	return cfg
+}
+
+func (c *Config) progress(done, total int64) {
+	if c.OnProgress != nil {
+		c.OnProgress(done, total)
+	}
+}
+
+// conn reads a Port with timeouts, keeping what it read ahead
+type conn struct {
+	p   xserial.Port
+	buf []byte
+	r   int
+	n   int
+}
+
+func newConn(p xserial.Port) *conn {
+	return &conn{p: p, buf: make([]byte, 4096)}
+}
+
+// fill reads more input, returning errTimeout after timeout
+func (c *conn) fill(ctx context.Context, timeout time.Duration) error {
+	tctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	for {
+		if err := tctx.Err(); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return errTimeout
+		}
+		n, err := xserial.ReadContext(tctx, c.p, c.buf)
+		if n > 0 {
+			c.r, c.n = 0, n
+			return nil
+		}
+		if err != nil && err != xserial.ErrReadTimeout && tctx.Err() == nil {
+			return err
+		}
+	}
+}
+
+func (c *conn) readByte(ctx context.Context, timeout time.Duration) (byte, error) {
+	if c.r == c.n {
+		if err := c.fill(ctx, timeout); err != nil {
+			return 0, err
+		}
+	}
+	b := c.buf[c.r]
+	c.r++
+	return b, nil
+}
+
+func (c *conn) readFull(ctx context.Context, b []byte, timeout time.Duration) error {
+	for i := range b {
+		v, err := c.readByte(ctx, timeout)
+		if err != nil {
+			return err
+		}
+		b[i] = v
+	}
+	return nil
+}
+
+// purge drops input until the line has been quiet for a second, so a
+// retransmission starts clean
+func (c *conn) purge(ctx context.Context) error {
+	c.r, c.n = 0, 0
+	for {
+		err := c.fill(ctx, time.Second)
+		if err == errTimeout {
+			c.r, c.n = 0, 0
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (c *conn) write(b ...byte) error {
+	_, err := c.p.Write(b)
+	return err
+}
+
+// cancel aborts the transfer at the other end
+func (c *conn) cancel() {
+	c.p.Write([]byte{CAN, CAN, CAN, CAN, CAN})
+}
+
+// canceled reports whether a CAN just read is confirmed by a second one
+func (c *conn) canceled(ctx context.Context) bool {
+	b, err := c.readByte(ctx, time.Second)
+	return err == nil && b == CAN
+}
+
+// sender sends blocks once the receiver has started
+type sender struct {
+	c   *conn
+	cfg Config
+	crc bool
+	seq byte
+}
+
+// start waits for the receiver to ask for CRC or checksum mode
+func (s *sender) start(ctx context.Context) error {
+	// Receivers poke every few seconds for up to a minute
+	deadline := time.Now().Add(s.cfg.Timeout * 6)
+	for time.Now().Before(deadline) {
+		b, err := s.c.readByte(ctx, s.cfg.Timeout)
+		if err == errTimeout {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		switch b {
+		case CRC:
+			s.crc = true
+			return nil
+		case NAK:
+			s.crc = false
+			return nil
+		case CAN:
+			if s.c.canceled(ctx) {
+				return ErrCanceled
+			}
+		}
+	}
+	return ErrNoResponse
+}
+
+// block sends data padded with pad to size bytes until it is acknowledged
+func (s *sender) block(ctx context.Context, data []byte, size int, pad byte) error {
+	b := make([]byte, 0, 3+size+2)
+	head := byte(SOH)
+	if size == 1024 {
+		head = STX
+	}
+	b = append(b, head, s.seq, ^s.seq)
+	b = append(b, data...)
+	for len(b) < 3+size {
+		b = append(b, pad)
+	}
+	if s.crc {
+		crc := checksum.XModem.Checksum(b[3:])
+		b = append(b, byte(crc>>8), byte(crc))
+	} else {
+		b = append(b, checksum.Sum(b[3:]))
+	}
+	for errs := 0; errs <= s.cfg.Retries; {
+		if _, err := s.c.p.Write(b); err != nil {
+			return err
+		}
+		for {
+			r, err := s.c.readByte(ctx, s.cfg.Timeout)
+			if err == errTimeout {
+				errs++
+				break
+			}
+			if err != nil {
+				s.c.cancel()
+				return err
+			}
+			if r == ACK {
+				s.seq++
+				return nil
+			}
+			if r == NAK || r == CRC && s.seq <= 1 {
+				errs++
+				break
+			}
+			if r == CAN && s.c.canceled(ctx) {
+				return ErrCanceled
+			}
+		}
+	}
+	s.c.cancel()
+	return ErrRetries
+}
+
+// data sends r in blocks, returning the bytes sent
+func (s *sender) data(ctx context.Context, r io.Reader, total int64) (int64, error) {
+	size := 128
+	if s.cfg.Block1K {
+		size = 1024
+	}
+	buf := make([]byte, size)
+	var done int64
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			bs := size
+			// Short Tails go in small Blocks, with less Padding
+			if n <= 128 {
+				bs = 128
+			}
+			if berr := s.block(ctx, buf[:n], bs, SUB); berr != nil {
+				return done, berr
+			}
+			done += int64(n)
+			s.cfg.progress(done, total)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return done, nil
+		}
+		if err != nil {
+			s.c.cancel()
+			return done, err
+		}
+		if ctx.Err() != nil {
+			s.c.cancel()
+			return done, ctx.Err()
+		}
+	}
+}
+
+// eot ends the file, sending EOT again to receivers that NAK the first
+func (s *sender) eot(ctx context.Context) error {
+	for errs := 0; errs <= s.cfg.Retries; errs++ {
+		if err := s.c.write(EOT); err != nil {
+			return err
+		}
+		r, err := s.c.readByte(ctx, s.cfg.Timeout)
+		if err == errTimeout {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if r == ACK {
+			return nil
+		}
+	}
+	return ErrRetries
+}
+
+// Send transfers r to the receiver on p, which must be started first, and
+// returns the bytes sent. Cancelling ctx cancels the transfer at the
+// receiver too. cfg may be nil.
+func Send(ctx context.Context, p xserial.Port, r io.Reader, cfg *Config) (int64, error) {
+	s := &sender{c: newConn(p), cfg: cfg.defaults(), seq: 1}
+	if err := s.start(ctx); err != nil {
+		return 0, err
+	}
+	n, err := s.data(ctx, r, -1)
+	if err != nil {
+		return n, err
+	}
+	return n, s.eot(ctx)
+}
+
+// receiver takes blocks in sequence
+type receiver struct {
+	c       *conn
+	cfg     Config
+	crc     bool
+	seq     byte
+	started bool
+	pokes   int
+	// YMODEM needs CRC mode
+	crcOnly bool
+}
+
+// poke asks the sender to start, falling back from CRC to checksum mode
+// for senders that never answer C
+func (r *receiver) poke() error {
+	if r.crc && !r.crcOnly && r.pokes >= 3 {
+		r.crc = false
+	}
+	r.pokes++
+	if r.crc {
+		return r.c.write(CRC)
+	}
+	return r.c.write(NAK)
+}
+
+// restart makes the next block wait for a start poke, as YMODEM does after
+// its header block
+func (r *receiver) restart() {
+	r.started, r.pokes = false, 0
+}
+
+// next returns the data of the next block, or eot at the end of the file
+func (r *receiver) next(ctx context.Context) (data []byte, eot bool, err error) {
+	for errs := 0; ; {
+		if !r.started {
+			if r.pokes > r.cfg.Retries+3 {
+				return nil, false, ErrNoResponse
+			}
+			if err := r.poke(); err != nil {
+				return nil, false, err
+			}
+		}
+		timeout := r.cfg.Timeout
+		if !r.started {
+			timeout = 3 * time.Second
+		}
+		b, err := r.c.readByte(ctx, timeout)
+		if err == errTimeout {
+			if r.started {
+				if errs++; errs > r.cfg.Retries {
+					r.c.cancel()
+					return nil, false, ErrRetries
+				}
+				r.c.write(NAK)
+			}
+			continue
+		}
+		if err != nil {
+			r.c.cancel()
+			return nil, false, err
+		}
+		switch b {
+		case SOH, STX:
+			size := 128
+			if b == STX {
+				size = 1024
+			}
+			block := make([]byte, 2+size+1)
+			if r.crc {
+				block = append(block, 0)
+			}
+			if err := r.c.readFull(ctx, block, time.Second); err != nil && err != errTimeout {
+				r.c.cancel()
+				return nil, false, err
+			} else if err == nil && r.valid(block, size) {
+				switch block[0] {
+				case r.seq:
+					r.started = true
+					r.seq++
+					if err := r.c.write(ACK); err != nil {
+						return nil, false, err
+					}
+					return block[2 : 2+size], false, nil
+				case r.seq - 1:
+					// The ACK was lost, so the Sender repeats the Block
+					r.c.write(ACK)
+					continue
+				}
+				r.c.cancel()
+				return nil, false, ErrSequence
+			}
+			if errs++; errs > r.cfg.Retries {
+				r.c.cancel()
+				return nil, false, ErrRetries
+			}
+			if err := r.c.purge(ctx); err != nil {
+				return nil, false, err
+			}
+			// Before the first Block the next Poke asks again, as a NAK
+			// would switch the Sender to checksum mode
+			if r.started {
+				r.c.write(NAK)
+			}
+		case EOT:
+			return nil, true, nil
+		case CAN:
+			if r.c.canceled(ctx) {
+				return nil, false, ErrCanceled
+			}
+		}
+	}
+}
+
+// valid checks the sequence complement and checksum of a block
+func (r *receiver) valid(block []byte, size int) bool {
+	if block[0] != ^block[1] {
+		return false
+	}
+	data := block[2 : 2+size]
+	if r.crc {
+		return checksum.XModem.Checksum(data) == uint16(block[2+size])<<8|uint16(block[3+size])
+	}
+	return checksum.Sum(data) == block[2+size]
+}
+
+func newReceiver(p xserial.Port, cfg *Config) *receiver {
+	c := cfg.defaults()
+	return &receiver{c: newConn(p), cfg: c, crc: !c.Checksum, seq: 1}
+}
+
+// Receive takes a file from the sender on p into w, returning the bytes
+// written. Cancelling ctx cancels the transfer at the sender too. cfg may
+// be nil.
+func Receive(ctx context.Context, p xserial.Port, w io.Writer, cfg *Config) (int64, error) {
+	r := newReceiver(p, cfg)
+	p.Flush()
+	var done int64
+	// The last Block is held back to strip its Padding
+	var held []byte
+	for {
+		data, eot, err := r.next(ctx)
+		if err != nil {
+			return done, err
+		}
+		if eot {
+			r.c.write(ACK)
+			if r.cfg.TrimPadding {
+				for len(held) > 0 && held[len(held)-1] == SUB {
+					held = held[:len(held)-1]
+				}
+			}
+			n, err := w.Write(held)
+			done += int64(n)
+			if err == nil {
+				r.cfg.progress(done, -1)
+			}
+			return done, err
+		}
+		if held != nil {
+			n, err := w.Write(held)
+			done += int64(n)
+			if err != nil {
+				r.c.cancel()
+				return done, err
+			}
+			r.cfg.progress(done, -1)
+		}
+		held = data
+		if ctx.Err() != nil {
+			r.c.cancel()
+			return done, ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package xmodem
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/packing/xserial"
+)
+
+var (
+	// ErrHeader - a YMODEM header block could not be read
+	ErrHeader = errors.New("xmodem: invalid file header")
+	// ErrNameTooLong - a file name does not fit a header block
+	ErrNameTooLong = errors.New("xmodem: file name too long")
+)
+
+// File describes a file of a YMODEM batch
+type File struct {
+	// As sent, the receiver should not trust it as a path
+	Name string
+	// Length in bytes, -1 when unknown
+	Size int64
+	// Zero when unknown
+	ModTime time.Time
+	// Unix permission bits, zero when unknown
+	Mode uint32
+	// Contents, read by SendBatch
+	Data io.Reader
+}
+
+// header encodes the block 0 contents of f
+func (f *File) header() ([]byte, error) {
+	b := append([]byte(f.Name), 0)
+	if f.Size >= 0 {
+		b = strconv.AppendInt(b, f.Size, 10)
+		if !f.ModTime.IsZero() || f.Mode != 0 {
+			var mtime int64
+			if !f.ModTime.IsZero() {
+				mtime = f.ModTime.Unix()
+			}
+			b = append(b, ' ')
+			b = strconv.AppendInt(b, mtime, 8)
+			if f.Mode != 0 {
+				b = append(b, ' ')
+				b = strconv.AppendUint(b, uint64(f.Mode), 8)
+			}
+		}
+	}
+	b = append(b, 0)
+	if len(b) > 1024 {
+		return nil, ErrNameTooLong
+	}
+	return b, nil
+}
+
+// parseHeader decodes block 0, returning nil for the empty block ending a
+// batch
+func parseHeader(data []byte) (*File, error) {
+	i := bytes.IndexByte(data, 0)
+	if i < 0 {
+		return nil, ErrHeader
+	}
+	if i == 0 {
+		return nil, nil
+	}
+	f := &File{Name: string(data[:i]), Size: -1}
+	rest := data[i+1:]
+	if j := bytes.IndexByte(rest, 0); j >= 0 {
+		rest = rest[:j]
+	}
+	fields := strings.Fields(string(rest))
+	var err error
+	if len(fields) > 0 {
+		if f.Size, err = strconv.ParseInt(fields[0], 10, 64); err != nil || f.Size < 0 {
+			return nil, ErrHeader
+		}
+	}
+	if len(fields) > 1 {
+		mtime, err := strconv.ParseInt(fields[1], 8, 64)
+		if err != nil {
+			return nil, ErrHeader
+		}
+		if mtime > 0 {
+			f.ModTime = time.Unix(mtime, 0)
+		}
+	}
+	if len(fields) > 2 {
+		mode, err := strconv.ParseUint(fields[2], 8, 32)
+		if err != nil {
+			return nil, ErrHeader
+		}
+		f.Mode = uint32(mode)
+	}
+	return f, nil
+}
+
+// SendBatch sends files with YMODEM, as taken by u-boot loady, and ends
+// the batch. Data is sent in 1024 byte blocks. cfg may be nil.
+func SendBatch(ctx context.Context, p xserial.Port, files []*File, cfg *Config) error {
+	s := &sender{c: newConn(p), cfg: cfg.defaults()}
+	s.cfg.Block1K = true
+	for _, f := range files {
+		head, err := f.header()
+		if err != nil {
+			s.c.cancel()
+			return err
+		}
+		if err := s.header(ctx, head); err != nil {
+			return err
+		}
+		if err := s.start(ctx); err != nil {
+			return err
+		}
+		if _, err := s.data(ctx, f.Data, f.Size); err != nil {
+			return err
+		}
+		if err := s.eot(ctx); err != nil {
+			return err
+		}
+	}
+	return s.header(ctx, nil)
+}
+
+// header sends block 0 once the receiver asks for it
+func (s *sender) header(ctx context.Context, head []byte) error {
+	if err := s.start(ctx); err != nil {
+		return err
+	}
+	size := 128
+	if len(head) > 128 {
+		size = 1024
+	}
+	s.seq = 0
+	return s.block(ctx, head, size, 0)
+}
+
+// ReceiveBatch takes a YMODEM batch from the sender on p. open is called
+// with each file header and returns where the contents go; a Writer that
+// is also a Closer is closed at the end of its file. An error from open
+// cancels the transfer. Files of known size are cut to it, others keep
+// their padding unless TrimPadding is set. cfg may be nil.
+func ReceiveBatch(ctx context.Context, p xserial.Port, open func(f *File) (io.Writer, error), cfg *Config) error {
+	r := newReceiver(p, cfg)
+	r.crc, r.crcOnly = true, true
+	p.Flush()
+	for {
+		r.restart()
+		r.seq = 0
+		data, eot, err := r.next(ctx)
+		if err != nil {
+			return err
+		}
+		if eot {
+			// Repeated EOT of the previous File
+			r.c.write(ACK)
+			continue
+		}
+		f, err := parseHeader(data)
+		if err != nil {
+			r.c.cancel()
+			return err
+		}
+		if f == nil {
+			return nil
+		}
+		w, err := open(f)
+		if err != nil {
+			r.c.cancel()
+			return err
+		}
+		r.restart()
+		err = r.file(ctx, w, f.Size)
+		if c, ok := w.(io.Closer); ok {
+			if cerr := c.Close(); err == nil {
+				err = cerr
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// file receives the contents of a file of size bytes, -1 when unknown
+func (r *receiver) file(ctx context.Context, w io.Writer, size int64) error {
+	var done int64
+	var held []byte
+	nak := true
+	for {
+		data, eot, err := r.next(ctx)
+		if err != nil {
+			return err
+		}
+		if eot {
+			// The first EOT is NAKed so a stray one cannot end the File
+			if nak {
+				nak = false
+				r.c.write(NAK)
+				continue
+			}
+			r.c.write(ACK)
+			if size < 0 && r.cfg.TrimPadding {
+				for len(held) > 0 && held[len(held)-1] == SUB {
+					held = held[:len(held)-1]
+				}
+			}
+			if _, err := w.Write(held); err != nil {
+				return err
+			}
+			done += int64(len(held))
+			r.cfg.progress(done, size)
+			return nil
+		}
+		nak = true
+		if size >= 0 {
+			if rest := size - done - int64(len(held)); int64(len(data)) > rest {
+				if rest < 0 {
+					rest = 0
+				}
+				data = data[:rest]
+			}
+		}
+		if held != nil {
+			if _, err := w.Write(held); err != nil {
+				r.c.cancel()
+				return err
+			}
+			done += int64(len(held))
+			r.cfg.progress(done, size)
+		}
+		held = data
+		if ctx.Err() != nil {
+			r.c.cancel()
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package xserialtest
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/packing/xserial"
+)
+
+// CheckFrameDecoder feeds data to fresh decoders from newDecoder whole and
+// one byte at a time and reports any difference in the frames or resync
+// counts. It suits native fuzz targets:
+//
+//	f.Fuzz(func(t *testing.T, data []byte) {
+//		if err := xserialtest.CheckFrameDecoder(newDecoder, data); err != nil {
+//			t.Fatal(err)
+//		}
+//	})
+func CheckFrameDecoder(newDecoder func() xserial.FrameDecoder, data []byte) error {
+	whole := newDecoder()
+	want := whole.Feed(data)
+
+	single := newDecoder()
+	var got [][]byte
+	for i := range data {
+		got = append(got, single.Feed(data[i:i+1])...)
+	}
+
+	if len(got) != len(want) {
+		return fmt.Errorf("xserialtest: %d frames fed whole, %d fed bytewise", len(want), len(got))
+	}
+	for i := range want {
+		if !bytes.Equal(got[i], want[i]) {
+			return fmt.Errorf("xserialtest: frame %d is %x fed whole, %x fed bytewise", i, want[i], got[i])
+		}
+	}
+	if whole.Resyncs() != single.Resyncs() {
+		return fmt.Errorf("xserialtest: %d resyncs fed whole, %d fed bytewise", whole.Resyncs(), single.Resyncs())
+	}
+	return nil
+}
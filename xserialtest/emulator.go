@@ -0,0 +1,258 @@
+package xserialtest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Emulator is a scripted device on the far end of an in-memory Pipe. Tests
+// declare Rules such as "on receiving AT\r reply OK\r\n after 20ms" and hand
+// Port() to the driver under test.
+//
+// Input is matched against Rules as it arrives: the Rule whose pattern occurs
+// earliest wins, ties going to the Rule declared first. Bytes in front of a
+// match, and bytes no Rule ever matches, are reported by Verify. Replies
+// are delivered in order of their due time, so a slow reply to one request
+// can arrive after a quick reply to a later one.
+type Emulator struct {
+	app, dev *Port
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+
+	mx      sync.Mutex
+	rules   []*Rule
+	input   []byte // Received and not yet Matched
+	skipped []byte // Discarded in front of a Match
+	queue   []step // Pending Output ordered by due
+	wake    chan struct{}
+}
+
+// Rule is one expect/respond pair of an Emulator script
+type Rule struct {
+	e       *Emulator
+	pattern []byte
+	steps   []step
+	times   int // Zero for Unlimited
+	used    int
+}
+
+// step is one scheduled action of the device
+type step struct {
+	delay  time.Duration // Relative to the previous step of the Rule
+	due    time.Time
+	data   []byte
+	hangup bool
+}
+
+// NewEmulator starts an Emulator. cfg applies to both ends of the Pipe and
+// may be nil.
+func NewEmulator(cfg *Config) *Emulator {
+	app, dev := NewPipe(cfg)
+	ctx, cancel := context.WithCancel(context.Background())
+	e := &Emulator{app: app, dev: dev, cancel: cancel, wake: make(chan struct{}, 1)}
+	e.wg.Add(2)
+	go e.receive(ctx)
+	go e.transmit(ctx)
+	return e
+}
+
+// Port returns the application side, to be used by the code under test
+func (e *Emulator) Port() *Port {
+	return e.app
+}
+
+// On adds a Rule matching pattern. A Rule without replies swallows the
+// request, which is how a device that never answers is simulated.
+func (e *Emulator) On(pattern []byte) *Rule {
+	r := &Rule{e: e, pattern: append([]byte(nil), pattern...)}
+	e.mx.Lock()
+	e.rules = append(e.rules, r)
+	e.mx.Unlock()
+	return r
+}
+
+// Reply sends data as soon as the Rule matches, or after its previous step
+func (r *Rule) Reply(data []byte) *Rule {
+	return r.ReplyAfter(0, data)
+}
+
+// ReplyAfter sends data d after the match or after the previous step
+func (r *Rule) ReplyAfter(d time.Duration, data []byte) *Rule {
+	r.e.mx.Lock()
+	r.steps = append(r.steps, step{delay: d, data: append([]byte(nil), data...)})
+	r.e.mx.Unlock()
+	return r
+}
+
+// HangupAfter closes the device side d after the previous step, so the
+// application reads io.EOF once it has drained the replies
+func (r *Rule) HangupAfter(d time.Duration) *Rule {
+	r.e.mx.Lock()
+	r.steps = append(r.steps, step{delay: d, hangup: true})
+	r.e.mx.Unlock()
+	return r
+}
+
+// Times limits the Rule to n matches, which Verify then requires. Zero,
+// the default, allows any number of matches.
+func (r *Rule) Times(n int) *Rule {
+	r.e.mx.Lock()
+	r.times = n
+	r.e.mx.Unlock()
+	return r
+}
+
+// Emit sends unsolicited data d from now, such as an unprompted status
+// message arriving in the middle of an exchange
+func (e *Emulator) Emit(d time.Duration, data []byte) {
+	e.mx.Lock()
+	e.schedule(time.Now(), []step{{delay: d, data: append([]byte(nil), data...)}})
+	e.mx.Unlock()
+}
+
+// schedule queues steps relative to base with mx held
+func (e *Emulator) schedule(base time.Time, steps []step) {
+	for _, s := range steps {
+		base = base.Add(s.delay)
+		s.due = base
+		// Equal due times keep their scheduling order
+		i := len(e.queue)
+		for i > 0 && e.queue[i-1].due.After(s.due) {
+			i--
+		}
+		e.queue = append(e.queue, step{})
+		copy(e.queue[i+1:], e.queue[i:])
+		e.queue[i] = s
+	}
+	select {
+	case e.wake <- struct{}{}:
+	default:
+	}
+}
+
+// match consumes Rule matches from the input with mx held
+func (e *Emulator) match(now time.Time) {
+	for {
+		var best *Rule
+		at := -1
+		for _, r := range e.rules {
+			if len(r.pattern) == 0 || (r.times > 0 && r.used >= r.times) {
+				continue
+			}
+			if i := bytes.Index(e.input, r.pattern); i >= 0 && (at < 0 || i < at) {
+				best, at = r, i
+			}
+		}
+		if best == nil {
+			return
+		}
+		e.skipped = append(e.skipped, e.input[:at]...)
+		e.input = e.input[at+len(best.pattern):]
+		best.used++
+		e.schedule(now, best.steps)
+	}
+}
+
+func (e *Emulator) receive(ctx context.Context) {
+	defer e.wg.Done()
+	buf := make([]byte, 4096)
+	for {
+		if err := e.dev.WaitReadable(ctx); err != nil {
+			return
+		}
+		n, err := e.dev.Read(buf)
+		if n > 0 {
+			e.mx.Lock()
+			e.input = append(e.input, buf[:n]...)
+			e.match(time.Now())
+			e.mx.Unlock()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (e *Emulator) transmit(ctx context.Context) {
+	defer e.wg.Done()
+	t := time.NewTimer(time.Hour)
+	defer t.Stop()
+	for {
+		e.mx.Lock()
+		var next *step
+		if len(e.queue) > 0 {
+			next = &e.queue[0]
+		}
+		if next != nil && !time.Now().Before(next.due) {
+			s := *next
+			e.queue = e.queue[1:]
+			e.mx.Unlock()
+			if s.hangup {
+				e.dev.Close()
+				return
+			}
+			if _, err := e.dev.Write(s.data); err != nil {
+				return
+			}
+			continue
+		}
+		wait := time.Hour
+		if next != nil {
+			wait = time.Until(next.due)
+		}
+		e.mx.Unlock()
+
+		if !t.Stop() {
+			select {
+			case <-t.C:
+			default:
+			}
+		}
+		t.Reset(wait)
+		select {
+		case <-t.C:
+		case <-e.wake:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Pending returns the number of replies not yet sent
+func (e *Emulator) Pending() int {
+	e.mx.Lock()
+	defer e.mx.Unlock()
+	return len(e.queue)
+}
+
+// Verify reports input that matched no Rule and Rules limited with Times
+// that have not been matched that often
+func (e *Emulator) Verify() error {
+	e.mx.Lock()
+	defer e.mx.Unlock()
+	if len(e.skipped) > 0 {
+		return fmt.Errorf("xserialtest: unexpected input %q", e.skipped)
+	}
+	if len(e.input) > 0 {
+		return fmt.Errorf("xserialtest: unmatched input %q", e.input)
+	}
+	for _, r := range e.rules {
+		if r.times > 0 && r.used < r.times {
+			return fmt.Errorf("xserialtest: rule %q matched %d of %d times", r.pattern, r.used, r.times)
+		}
+	}
+	return nil
+}
+
+// Close stops the Emulator and closes the device side; the application
+// side reads io.EOF once drained and must still be closed by its owner
+func (e *Emulator) Close() error {
+	e.cancel()
+	// Already Closed if a Rule hung up
+	e.dev.Close()
+	e.wg.Wait()
+	return nil
+}
@@ -0,0 +1,437 @@
+// Package xserialtest provides in-memory and pseudo-terminal Ports so code
+// built on xserial can be tested without serial hardware.
+package xserialtest
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/packing/xserial"
+)
+
+// Config configures in-memory Ports
+type Config struct {
+	// How long Read waits for data; zero returns at once like a real Port
+	// opened without a ReadTimeout
+	ReadTimeout time.Duration
+	// Simulated Line Speed - written bytes become readable one character
+	// time apart, zero delivers them at once
+	Baud int
+	// Upper bound of a random delay added before each Write reaches the
+	// line, like the scheduling latency of a USB adapter
+	Jitter time.Duration
+}
+
+// buffer is the receive side of an in-memory Port
+type buffer struct {
+	mx     sync.Mutex
+	data   []byte
+	eof    bool // Writer has gone away
+	notify chan struct{}
+	// Paced Bytes still on the Line and when each one arrives
+	line     []byte
+	arrivals []time.Time
+}
+
+func newBuffer() *buffer {
+	return &buffer{notify: make(chan struct{})}
+}
+
+// wake releases all waiters with mx held
+func (b *buffer) wake() {
+	close(b.notify)
+	b.notify = make(chan struct{})
+}
+
+// release moves bytes that have arrived by now off the line with mx held
+func (b *buffer) release(now time.Time) {
+	n := 0
+	for n < len(b.arrivals) && !b.arrivals[n].After(now) {
+		n++
+	}
+	if n == 0 {
+		return
+	}
+	b.data = append(b.data, b.line[:n]...)
+	b.line = b.line[n:]
+	b.arrivals = b.arrivals[n:]
+}
+
+// nextArrival returns a channel firing when the next byte on the line
+// arrives, or nil if the line is idle, with mx held
+func (b *buffer) nextArrival() (<-chan time.Time, func()) {
+	if len(b.arrivals) == 0 {
+		return nil, func() {}
+	}
+	t := time.NewTimer(time.Until(b.arrivals[0]))
+	return t.C, func() { t.Stop() }
+}
+
+// lineIdle returns when the last byte on the line arrives with mx held
+func (b *buffer) lineIdle() time.Time {
+	if len(b.arrivals) == 0 {
+		return time.Time{}
+	}
+	return b.arrivals[len(b.arrivals)-1]
+}
+
+// Port is an in-memory xserial.Port. Bytes written to it appear on its peer,
+// or on itself for a loopback.
+type Port struct {
+	cfg  Config
+	rx   *buffer
+	peer *Port
+
+	mx       sync.Mutex
+	closed   bool
+	parity   string
+	stopBits int
+	stats    xserial.Stats
+	events   chan xserial.Event
+	rxQueued bool // RX Event delivered and not yet Read
+	done     chan struct{}
+}
+
+var _ xserial.Port = (*Port)(nil)
+
+func newPort(cfg Config) *Port {
+	return &Port{cfg: cfg, rx: newBuffer(), parity: "N", done: make(chan struct{})}
+}
+
+// Pipe returns two connected in-memory Ports with default Config
+func Pipe() (*Port, *Port) {
+	return NewPipe(nil)
+}
+
+// NewPipe returns two connected in-memory Ports, like a null-modem cable.
+// cfg may be nil.
+func NewPipe(cfg *Config) (*Port, *Port) {
+	var c Config
+	if cfg != nil {
+		c = *cfg
+	}
+	a, b := newPort(c), newPort(c)
+	a.peer, b.peer = b, a
+	return a, b
+}
+
+// Loopback returns an in-memory Port that receives what it sends. cfg may
+// be nil.
+func Loopback(cfg *Config) *Port {
+	var c Config
+	if cfg != nil {
+		c = *cfg
+	}
+	p := newPort(c)
+	p.peer = p
+	return p
+}
+
+// Read returns received bytes, waiting up to ReadTimeout for some to
+// arrive. Once the peer is closed and the data consumed it returns io.EOF.
+func (p *Port) Read(b []byte) (int, error) {
+	var deadline <-chan time.Time
+	if p.cfg.ReadTimeout > 0 {
+		t := time.NewTimer(p.cfg.ReadTimeout)
+		defer t.Stop()
+		deadline = t.C
+	}
+	for {
+		p.mx.Lock()
+		closed := p.closed
+		p.rxQueued = false
+		p.mx.Unlock()
+		if closed {
+			return 0, xserial.ErrNotOpen
+		}
+
+		p.rx.mx.Lock()
+		p.rx.release(time.Now())
+		if len(p.rx.data) > 0 {
+			n := copy(b, p.rx.data)
+			p.rx.data = p.rx.data[n:]
+			p.rx.mx.Unlock()
+			p.count(func(s *xserial.Stats) { s.BytesRead += uint64(n) })
+			return n, nil
+		}
+		if p.rx.eof && len(p.rx.line) == 0 {
+			p.rx.mx.Unlock()
+			return 0, io.EOF
+		}
+		notify := p.rx.notify
+		arrival, stop := p.rx.nextArrival()
+		p.rx.mx.Unlock()
+
+		if deadline == nil {
+			stop()
+			return 0, nil
+		}
+		select {
+		case <-notify:
+		case <-arrival:
+		case <-p.done:
+		case <-deadline:
+			stop()
+			p.count(func(s *xserial.Stats) { s.ReadTimeouts++ })
+			return 0, xserial.ErrReadTimeout
+		}
+		stop()
+	}
+}
+
+// WaitReadable blocks until Read would return data or EOF
+func (p *Port) WaitReadable(ctx context.Context) error {
+	for {
+		p.rx.mx.Lock()
+		p.rx.release(time.Now())
+		ready := len(p.rx.data) > 0 || (p.rx.eof && len(p.rx.line) == 0)
+		notify := p.rx.notify
+		arrival, stop := p.rx.nextArrival()
+		p.rx.mx.Unlock()
+		if ready {
+			stop()
+			return nil
+		}
+		select {
+		case <-notify:
+		case <-arrival:
+		case <-p.done:
+			stop()
+			return xserial.ErrNotOpen
+		case <-ctx.Done():
+			stop()
+			return ctx.Err()
+		}
+		stop()
+	}
+}
+
+// Write delivers b to the peer
+func (p *Port) Write(b []byte) (int, error) {
+	p.mx.Lock()
+	closed := p.closed
+	p.mx.Unlock()
+	if closed {
+		return 0, xserial.ErrNotOpen
+	}
+	if err := p.peer.deliver(b, p.charTime()); err != nil {
+		p.count(func(s *xserial.Stats) { s.WriteErrors++ })
+		return 0, err
+	}
+	p.count(func(s *xserial.Stats) { s.BytesWritten += uint64(len(b)) })
+	return len(b), nil
+}
+
+// charTime returns how long one character takes on the line at the
+// configured Baud and current framing, zero when pacing is off
+func (p *Port) charTime() time.Duration {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	if p.cfg.Baud <= 0 {
+		return 0
+	}
+	// Start Bit, 8 Data Bits, Parity and Stop Bits
+	bits := 9 + p.stopBits
+	if p.stopBits == 0 {
+		bits++
+	}
+	if p.parity != "N" && p.parity != "" {
+		bits++
+	}
+	return time.Duration(bits) * time.Second / time.Duration(p.cfg.Baud)
+}
+
+// deliver queues b for reading on p, one character time apart if paced
+func (p *Port) deliver(b []byte, char time.Duration) error {
+	p.mx.Lock()
+	if p.closed {
+		p.mx.Unlock()
+		return io.ErrClosedPipe
+	}
+	p.mx.Unlock()
+
+	p.rx.mx.Lock()
+	if char == 0 && p.cfg.Jitter <= 0 {
+		p.rx.data = append(p.rx.data, b...)
+		p.rx.wake()
+		p.rx.mx.Unlock()
+		p.event(xserial.Event{Type: xserial.EventRXAvailable})
+		return nil
+	}
+	// The Line is busy until the previous Write has arrived
+	at := time.Now()
+	if p.cfg.Jitter > 0 {
+		at = at.Add(time.Duration(rand.Int63n(int64(p.cfg.Jitter))))
+	}
+	if idle := p.rx.lineIdle(); idle.After(at) {
+		at = idle
+	}
+	first := at.Add(char)
+	for range b {
+		at = at.Add(char)
+		p.rx.arrivals = append(p.rx.arrivals, at)
+	}
+	p.rx.line = append(p.rx.line, b...)
+	p.rx.wake()
+	p.rx.mx.Unlock()
+	time.AfterFunc(time.Until(first), func() {
+		p.event(xserial.Event{Type: xserial.EventRXAvailable})
+	})
+	return nil
+}
+
+// event sends e if Events has been called; RX Events are not repeated
+// until the next Read
+func (p *Port) event(e xserial.Event) {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	if p.events == nil || p.closed {
+		return
+	}
+	if e.Type == xserial.EventRXAvailable {
+		if p.rxQueued {
+			return
+		}
+		p.rxQueued = true
+	}
+	e.Time = time.Now()
+	select {
+	case p.events <- e:
+	default:
+	}
+}
+
+func (p *Port) count(f func(s *xserial.Stats)) {
+	p.mx.Lock()
+	f(&p.stats)
+	p.mx.Unlock()
+}
+
+// Close closes p; its peer reads io.EOF once drained
+func (p *Port) Close() error {
+	p.mx.Lock()
+	if p.closed {
+		p.mx.Unlock()
+		return xserial.ErrPortNotInitialized
+	}
+	p.closed = true
+	close(p.done)
+	if p.events != nil {
+		close(p.events)
+	}
+	p.mx.Unlock()
+
+	if p.peer != p {
+		p.peer.rx.mx.Lock()
+		p.peer.rx.eof = true
+		p.peer.rx.wake()
+		p.peer.rx.mx.Unlock()
+		p.peer.event(xserial.Event{Type: xserial.EventDisconnect, Err: xserial.ErrPortClosed})
+	}
+	return nil
+}
+
+// SetParity records the framing; in-memory Ports do not check it
+func (p *Port) SetParity(parity string, stopbits int) error {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	p.parity, p.stopBits = parity, stopbits
+	return nil
+}
+
+// SetBaudRate changes the Baud used for pacing; in-memory Ports do not
+// check that both ends agree
+func (p *Port) SetBaudRate(baud int) error {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	p.cfg.Baud = baud
+	return nil
+}
+
+// Parity returns the framing last set with SetParity
+func (p *Port) Parity() (parity string, stopbits int) {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	return p.parity, p.stopBits
+}
+
+// Flush discards unread input, including bytes still on a paced line, and
+// output the peer has not received yet
+func (p *Port) Flush() error {
+	p.rx.mx.Lock()
+	p.rx.data = nil
+	p.rx.line, p.rx.arrivals = nil, nil
+	p.rx.wake()
+	p.rx.mx.Unlock()
+	if p.peer != p {
+		p.peer.rx.mx.Lock()
+		// Bytes already across the Line are the Peer's to keep
+		p.peer.rx.release(time.Now())
+		p.peer.rx.line, p.peer.rx.arrivals = nil, nil
+		p.peer.rx.wake()
+		p.peer.rx.mx.Unlock()
+	}
+	return nil
+}
+
+// Drain waits until everything written has reached the peer, which is
+// immediate unless the line is paced
+func (p *Port) Drain() error {
+	for {
+		p.peer.rx.mx.Lock()
+		wait := time.Until(p.peer.rx.lineIdle())
+		notify := p.peer.rx.notify
+		p.peer.rx.mx.Unlock()
+		if wait <= 0 {
+			return nil
+		}
+		t := time.NewTimer(wait)
+		select {
+		case <-t.C:
+		case <-notify:
+		}
+		t.Stop()
+	}
+}
+
+// Events returns RX and disconnect Events; the channel is closed by Close
+func (p *Port) Events() <-chan xserial.Event {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	if p.events == nil {
+		p.events = make(chan xserial.Event, 16)
+		if p.closed {
+			close(p.events)
+		}
+	}
+	return p.events
+}
+
+// Stats returns the traffic counters
+func (p *Port) Stats() xserial.Stats {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	return p.stats
+}
+
+// Queued implements xserial.QueueReporter: received bytes not yet read, and
+// written bytes still on a paced line to the peer
+func (p *Port) Queued() (in, out int, err error) {
+	in = p.Buffered()
+	p.peer.rx.mx.Lock()
+	p.peer.rx.release(time.Now())
+	out = len(p.peer.rx.line)
+	p.peer.rx.mx.Unlock()
+	return in, out, nil
+}
+
+// Buffered returns the number of received bytes not yet read
+func (p *Port) Buffered() int {
+	p.rx.mx.Lock()
+	defer p.rx.mx.Unlock()
+	p.rx.release(time.Now())
+	return len(p.rx.data)
+}
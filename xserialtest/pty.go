@@ -0,0 +1,74 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package xserialtest
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/packing/xserial"
+)
+
+// NewPTYPair creates a pseudo-terminal and opens its slave end with
+// xserial.OpenPort, so tests exercise the real termios and I/O paths. The
+// master end is returned as the simulated device. cfg may be nil; its Name
+// is replaced by the slave device.
+func NewPTYPair(cfg *xserial.Config) (port xserial.Port, device *os.File, err error) {
+	c := xserial.Config{Baud: 115200, Parity: "N"}
+	if cfg != nil {
+		c = *cfg
+	}
+	master, name, err := openPTY()
+	if err != nil {
+		return nil, nil, err
+	}
+	c.Name = name
+	port, err = xserial.OpenPort(&c)
+	if err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+	return port, master, nil
+}
+
+// CheckHangupClose opens a pseudo-terminal Port, hangs up its device end
+// and closes the Port with xserial.CloseWithTimeout, as an application does
+// once an adapter is unplugged. It reports an error if the close fails or
+// leaves a descriptor open. Descriptors opened concurrently by other
+// goroutines are counted too, so run it on its own.
+func CheckHangupClose(cfg *xserial.Config) error {
+	before, err := openFDs()
+	if err != nil {
+		return err
+	}
+	port, device, err := NewPTYPair(cfg)
+	if err != nil {
+		return err
+	}
+	device.Close()
+	if err := xserial.CloseWithTimeout(port, time.Second); err != nil {
+		return fmt.Errorf("xserialtest: close after hangup: %w", err)
+	}
+	after, err := openFDs()
+	if err != nil {
+		return err
+	}
+	if after > before {
+		return fmt.Errorf("xserialtest: %d descriptors left open after hangup and close", after-before)
+	}
+	return nil
+}
+
+// openFDs counts the descriptors open in the process
+func openFDs() (int, error) {
+	f, err := os.Open("/dev/fd")
+	if err != nil {
+		return 0, err
+	}
+	names, err := f.Readdirnames(-1)
+	f.Close()
+	// The Directory itself was listed
+	return len(names) - 1, err
+}
@@ -0,0 +1,40 @@
+//go:build darwin
+// +build darwin
+
+package xserialtest
+
+import (
+	"bytes"
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// openPTY opens a new master and returns it with the slave device path
+func openPTY() (*os.File, string, error) {
+	fd, err := unix.Open("/dev/ptmx", unix.O_RDWR|unix.O_NOCTTY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, "", err
+	}
+	var name [128]byte
+	// grantpt, unlockpt and ptsname
+	for _, req := range []struct {
+		req uintptr
+		arg uintptr
+	}{
+		{unix.TIOCPTYGRANT, 0},
+		{unix.TIOCPTYUNLK, 0},
+		{unix.TIOCPTYGNAME, uintptr(unsafe.Pointer(&name[0]))},
+	} {
+		if _, _, e1 := unix.Syscall(unix.SYS_IOCTL, uintptr(fd), req.req, req.arg); e1 != 0 {
+			unix.Close(fd)
+			return nil, "", e1
+		}
+	}
+	if i := bytes.IndexByte(name[:], 0); i >= 0 {
+		return os.NewFile(uintptr(fd), "/dev/ptmx"), string(name[:i]), nil
+	}
+	unix.Close(fd)
+	return nil, "", unix.EINVAL
+}
@@ -0,0 +1,262 @@
+package zmodem
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/packing/xserial"
+)
+
+// receiver takes files offered by the sender
+type receiver struct {
+	c   *conn
+	cfg Config
+}
+
+// zrinit announces a full duplex receiver that streams without a buffer
+// limit and takes CRC-32
+func (r *receiver) zrinit() header {
+	flags := byte(CANFDX | CANOVIO | CANFC32)
+	if r.cfg.EscapeControl {
+		flags |= ESCCTL
+	}
+	return header{typ: ZRINIT, d: [4]byte{0, 0, 0, flags}}
+}
+
+// Receive takes the files sent on p. open is called with each file offered
+// and returns where its contents go and how many bytes of them are there
+// already, nonzero to resume an interrupted transfer; ErrSkip skips the
+// file and other errors cancel the transfer. A Writer that is also a
+// Closer is closed at the end of its file. cfg may be nil.
+func Receive(ctx context.Context, p xserial.Port, open func(f *File) (io.Writer, int64, error), cfg *Config) error {
+	r := &receiver{c: newConn(p), cfg: cfg.defaults()}
+	r.c.escCtl = r.cfg.EscapeControl
+	err := r.session(ctx, open)
+	if err != nil && err != ErrCanceled {
+		r.c.cancel()
+	}
+	return err
+}
+
+func (r *receiver) session(ctx context.Context, open func(f *File) (io.Writer, int64, error)) error {
+	if err := r.c.writeHex(r.zrinit()); err != nil {
+		return err
+	}
+	started := false
+	for errs := 0; ; {
+		h, err := r.c.readHeader(ctx, r.cfg.Timeout)
+		if err == errTimeout || err == errFormat {
+			if errs++; errs > r.cfg.Retries {
+				if !started {
+					return ErrNoResponse
+				}
+				return ErrRetries
+			}
+			r.c.writeHex(r.zrinit())
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		switch h.typ {
+		case ZRQINIT, ZEOF:
+			// The Sender missed ZRINIT
+			r.c.writeHex(r.zrinit())
+		case ZSINIT:
+			if h.zf0()&ESCCTL != 0 {
+				r.c.escCtl = true
+			}
+			if _, _, err := r.c.readSubpacket(ctx, r.cfg.Timeout); err != nil {
+				r.c.writeHex(header{typ: ZNAK})
+				continue
+			}
+			r.c.writeHex(header{typ: ZACK})
+		case ZFILE:
+			data, _, err := r.c.readSubpacket(ctx, r.cfg.Timeout)
+			if err == ErrCanceled {
+				return err
+			}
+			if err != nil {
+				r.c.writeHex(header{typ: ZNAK})
+				continue
+			}
+			f, err := parseInfo(data)
+			if err != nil {
+				return err
+			}
+			f.Resume = h.zf0() == ZCRESUM
+			started = true
+			skipped, err := r.file(ctx, f, open)
+			if err != nil {
+				return err
+			}
+			errs = 0
+			if !skipped {
+				r.c.writeHex(r.zrinit())
+			}
+		case ZCOMMAND:
+			// Commands from the Sender are refused, not run
+			r.c.readSubpacket(ctx, r.cfg.Timeout)
+			r.c.writeHex(posHeader(ZCOMPL, 1))
+		case ZFIN:
+			r.c.writeHex(header{typ: ZFIN})
+			// Sender closes with "OO"
+			for i := 0; i < 2; i++ {
+				if _, err := r.c.readByte(ctx, time.Second); err != nil {
+					break
+				}
+			}
+			return nil
+		case ZCAN, ZABORT:
+			return ErrCanceled
+		}
+	}
+}
+
+// parseInfo decodes a ZFILE data subpacket
+func parseInfo(data []byte) (*File, error) {
+	i := bytes.IndexByte(data, 0)
+	if i <= 0 {
+		return nil, ErrHeader
+	}
+	f := &File{Name: string(data[:i]), Size: -1}
+	rest := data[i+1:]
+	if j := bytes.IndexByte(rest, 0); j >= 0 {
+		rest = rest[:j]
+	}
+	fields := strings.Fields(string(rest))
+	if len(fields) > 0 {
+		size, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil || size < 0 {
+			return nil, ErrHeader
+		}
+		f.Size = size
+	}
+	if len(fields) > 1 {
+		if mtime, err := strconv.ParseInt(fields[1], 8, 64); err == nil && mtime > 0 {
+			f.ModTime = time.Unix(mtime, 0)
+		}
+	}
+	if len(fields) > 2 {
+		if mode, err := strconv.ParseUint(fields[2], 8, 32); err == nil {
+			f.Mode = uint32(mode)
+		}
+	}
+	return f, nil
+}
+
+// file receives f, asking the sender to go back with ZRPOS after errors
+func (r *receiver) file(ctx context.Context, f *File, open func(f *File) (io.Writer, int64, error)) (skipped bool, err error) {
+	w, pos, err := open(f)
+	if err == ErrSkip {
+		return true, r.c.writeHex(header{typ: ZSKIP})
+	}
+	if err != nil {
+		return false, err
+	}
+	if c, ok := w.(io.Closer); ok {
+		defer func() {
+			if cerr := c.Close(); err == nil {
+				err = cerr
+			}
+		}()
+	}
+	if pos < 0 {
+		pos = 0
+	}
+	if err := r.c.writeHex(posHeader(ZRPOS, pos)); err != nil {
+		return false, err
+	}
+	errs := &retries{max: r.cfg.Retries, bad: -1}
+	// Frames sent before the Sender saw ZRPOS are not asked for again
+	asked := pos
+	for {
+		h, err := r.c.readHeader(ctx, r.cfg.Timeout)
+		if err == errTimeout || err == errFormat {
+			if err := errs.fail(pos); err != nil {
+				return false, err
+			}
+			r.c.writeHex(posHeader(ZRPOS, pos))
+			asked = pos
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		switch h.typ {
+		case ZDATA:
+			if h.pos() > pos {
+				// Data after a lost Subpacket: Ask again
+				if asked != pos {
+					if err := errs.fail(pos); err != nil {
+						return false, err
+					}
+					r.c.writeHex(posHeader(ZRPOS, pos))
+					asked = pos
+				}
+				continue
+			}
+			n, err := r.data(ctx, w, f, h.pos(), pos)
+			if err != nil && err != errTimeout && err != errFormat && err != errCRC {
+				return false, err
+			}
+			pos = n
+			if err != nil {
+				if err := errs.fail(pos); err != nil {
+					return false, err
+				}
+				r.c.writeHex(posHeader(ZRPOS, pos))
+				asked = pos
+			}
+		case ZEOF:
+			if h.pos() == pos {
+				return false, nil
+			}
+			if h.pos() > pos && asked != pos {
+				r.c.writeHex(posHeader(ZRPOS, pos))
+				asked = pos
+			}
+		case ZFILE:
+			// The Sender missed ZRPOS
+			r.c.readSubpacket(ctx, r.cfg.Timeout)
+			r.c.writeHex(posHeader(ZRPOS, pos))
+		case ZCAN, ZABORT, ZFIN:
+			return false, ErrCanceled
+		}
+	}
+}
+
+// data writes the subpackets of a ZDATA frame starting at at until the
+// frame ends or fails, returning the position reached. Data before pos,
+// sent again after a repeated ZRPOS, is dropped.
+func (r *receiver) data(ctx context.Context, w io.Writer, f *File, at, pos int64) (int64, error) {
+	for {
+		data, end, err := r.c.readSubpacket(ctx, r.cfg.Timeout)
+		if err != nil {
+			return pos, err
+		}
+		next := at + int64(len(data))
+		if next > pos {
+			if _, err := w.Write(data[pos-at:]); err != nil {
+				return pos, err
+			}
+			pos = next
+			r.cfg.progress(f, pos)
+		}
+		at = next
+		switch end {
+		case ZCRCW:
+			return pos, r.c.writeHex(posHeader(ZACK, pos))
+		case ZCRCQ:
+			if err := r.c.writeHex(posHeader(ZACK, pos)); err != nil {
+				return pos, err
+			}
+		case ZCRCE:
+			return pos, nil
+		}
+	}
+}
@@ -0,0 +1,331 @@
+package zmodem
+
+import (
+	"context"
+	"hash/crc32"
+	"io"
+	"strconv"
+
+	"github.com/packing/xserial"
+)
+
+// sender sends files once the receiver has started
+type sender struct {
+	c   *conn
+	cfg Config
+	// Receiver Capabilities from ZRINIT
+	flags byte
+	rxbuf int
+	crc32 bool
+}
+
+// Send sends files to the receiver on p, starting it with "rz" as
+// terminals do, and ends the session. Files the receiver skips are not
+// sent; cancelling ctx cancels the transfer at the receiver too. cfg may
+// be nil.
+func Send(ctx context.Context, p xserial.Port, files []*File, cfg *Config) error {
+	s := &sender{c: newConn(p), cfg: cfg.defaults()}
+	if _, err := p.Write([]byte("rz\r")); err != nil {
+		return err
+	}
+	if err := s.init(ctx); err != nil {
+		return err
+	}
+	var left int64
+	for _, f := range files {
+		if f.Size > 0 {
+			left += f.Size
+		}
+	}
+	for i, f := range files {
+		if err := s.file(ctx, f, len(files)-i, left); err != nil {
+			if err != ErrCanceled {
+				s.c.cancel()
+			}
+			return err
+		}
+		if f.Size > 0 {
+			left -= f.Size
+		}
+	}
+	return s.finish(ctx)
+}
+
+// init waits for ZRINIT, asking for it with ZRQINIT
+func (s *sender) init(ctx context.Context) error {
+	for errs := 0; errs <= s.cfg.Retries; {
+		if err := s.c.writeHex(header{typ: ZRQINIT}); err != nil {
+			return err
+		}
+		h, err := s.c.readHeader(ctx, s.cfg.Timeout)
+		if err == errTimeout || err == errFormat {
+			errs++
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		switch h.typ {
+		case ZRINIT:
+			s.flags = h.zf0()
+			s.rxbuf = int(h.d[0]) | int(h.d[1])<<8
+			s.crc32 = s.flags&CANFC32 != 0
+			s.c.escCtl = s.cfg.EscapeControl || s.flags&ESCCTL != 0
+			return nil
+		case ZCHALLENGE:
+			s.c.writeHex(header{ZACK, h.d})
+		case ZCAN, ZABORT:
+			return ErrCanceled
+		}
+	}
+	return ErrNoResponse
+}
+
+// info encodes the ZFILE data subpacket of f
+func info(f *File, files int, left int64) []byte {
+	b := append([]byte(f.Name), 0)
+	size := f.Size
+	if size < 0 {
+		size = 0
+	}
+	b = strconv.AppendInt(b, size, 10)
+	b = append(b, ' ')
+	if !f.ModTime.IsZero() {
+		b = strconv.AppendInt(b, f.ModTime.Unix(), 8)
+	} else {
+		b = append(b, '0')
+	}
+	b = append(b, ' ')
+	b = strconv.AppendUint(b, uint64(f.Mode), 8)
+	b = append(b, " 0 "...)
+	b = strconv.AppendInt(b, int64(files), 10)
+	b = append(b, ' ')
+	b = strconv.AppendInt(b, left, 10)
+	return append(b, 0)
+}
+
+// send writes a binary header, with a data subpacket unless data is nil
+func (s *sender) send(h header, data []byte, end byte) error {
+	out := s.c.binary(nil, h, s.crc32)
+	if data != nil {
+		out = s.c.subpacket(out, data, end, s.crc32)
+	}
+	_, err := s.c.p.Write(out)
+	return err
+}
+
+// file offers f and sends it from where the receiver asks
+func (s *sender) file(ctx context.Context, f *File, files int, left int64) error {
+	conv := byte(ZCBIN)
+	if f.Resume {
+		conv = ZCRESUM
+	}
+	zfile := header{typ: ZFILE, d: [4]byte{0, 0, 0, conv}}
+	for errs := 0; ; {
+		if err := s.send(zfile, info(f, files, left), ZCRCW); err != nil {
+			return err
+		}
+	reply:
+		h, err := s.c.readHeader(ctx, s.cfg.Timeout)
+		if err == errTimeout || err == errFormat {
+			if errs++; errs > s.cfg.Retries {
+				return ErrRetries
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		switch h.typ {
+		case ZRPOS:
+			return s.data(ctx, f, h.pos())
+		case ZSKIP:
+			return nil
+		case ZCRC:
+			// Receiver compares a partial copy before resuming
+			crc, err := fileCRC(f.Data, h.pos())
+			if err != nil {
+				return err
+			}
+			if err := s.c.writeHex(posHeader(ZCRC, int64(crc))); err != nil {
+				return err
+			}
+			goto reply
+		case ZCAN, ZABORT, ZFERR:
+			return ErrCanceled
+		case ZRINIT:
+			// Answer to a repeated ZRQINIT, the reply to ZFILE follows
+			goto reply
+		case ZNAK:
+			if errs++; errs > s.cfg.Retries {
+				return ErrRetries
+			}
+		}
+	}
+}
+
+// fileCRC returns the CRC-32 of the first n bytes of r, or all when n is 0
+func fileCRC(r io.ReadSeeker, n int64) (uint32, error) {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	h := crc32.NewIEEE()
+	var err error
+	if n > 0 {
+		_, err = io.CopyN(h, r, n)
+	} else {
+		_, err = io.Copy(h, r)
+	}
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+	return h.Sum32(), nil
+}
+
+// data streams f from pos, going back whenever the receiver reports an
+// error with ZRPOS
+func (s *sender) data(ctx context.Context, f *File, pos int64) error {
+	buf := make([]byte, s.cfg.Subpacket)
+	// Receivers without full duplex or with a limited Buffer acknowledge
+	// each Window
+	window := int64(s.rxbuf)
+	stream := s.flags&(CANFDX|CANOVIO) == CANFDX|CANOVIO
+	errs := &retries{max: s.cfg.Retries, bad: -1}
+resume:
+	if _, err := f.Data.Seek(pos, io.SeekStart); err != nil {
+		return err
+	}
+	first, acked := true, pos
+	for {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		n, err := io.ReadFull(f.Data, buf)
+		eof := err == io.EOF || err == io.ErrUnexpectedEOF
+		if err != nil && !eof {
+			return err
+		}
+		end := byte(ZCRCG)
+		switch {
+		case eof:
+			end = ZCRCE
+		case !stream || window > 0 && pos+int64(n)-acked >= window:
+			end = ZCRCW
+		}
+		out := []byte(nil)
+		if first {
+			out = s.c.binary(out, posHeader(ZDATA, pos), s.crc32)
+			first = false
+		}
+		out = s.c.subpacket(out, buf[:n], end, s.crc32)
+		if eof {
+			out = s.c.binary(out, posHeader(ZEOF, pos+int64(n)), s.crc32)
+		}
+		if _, err := s.c.p.Write(out); err != nil {
+			return err
+		}
+		pos += int64(n)
+		s.cfg.progress(f, pos)
+		if eof {
+			// The Timeout for ZRINIT starts once the Data is out
+			if err := s.c.p.Drain(); err != nil {
+				return err
+			}
+			break
+		}
+		var h header
+		if end == ZCRCW {
+			if err := s.c.p.Drain(); err != nil {
+				return err
+			}
+			if h, err = s.wait(ctx, ZACK); err == errTimeout || err == errFormat {
+				// Send the Window again
+				h, err = posHeader(ZRPOS, acked), nil
+			}
+		} else if s.c.ready(ctx) {
+			// Streaming is interrupted only by a Receiver in trouble
+			if h, err = s.c.readHeader(ctx, s.cfg.Timeout); err == errTimeout || err == errFormat {
+				continue
+			}
+		} else {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		switch h.typ {
+		case ZACK:
+			acked = pos
+		case ZRPOS:
+			if err := errs.fail(h.pos()); err != nil {
+				return err
+			}
+			pos = h.pos()
+			goto resume
+		case ZSKIP:
+			return nil
+		case ZCAN, ZABORT, ZFERR:
+			return ErrCanceled
+		}
+	}
+	for {
+		h, err := s.wait(ctx, ZRINIT)
+		if err == errTimeout || err == errFormat {
+			if err := errs.fail(pos); err != nil {
+				return err
+			}
+			if err := s.send(posHeader(ZEOF, pos), nil, 0); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if h.typ != ZRPOS {
+			return nil
+		}
+		if err := errs.fail(h.pos()); err != nil {
+			return err
+		}
+		pos = h.pos()
+		goto resume
+	}
+}
+
+// wait reads headers until want, ZRPOS or ZSKIP arrives
+func (s *sender) wait(ctx context.Context, want byte) (header, error) {
+	for {
+		h, err := s.c.readHeader(ctx, s.cfg.Timeout)
+		if err != nil {
+			return h, err
+		}
+		switch h.typ {
+		case want, ZRPOS, ZSKIP:
+			return h, nil
+		case ZCAN, ZABORT, ZFERR:
+			return h, ErrCanceled
+		}
+	}
+}
+
+// finish ends the session with ZFIN and "OO"
+func (s *sender) finish(ctx context.Context) error {
+	for errs := 0; errs <= s.cfg.Retries; errs++ {
+		if err := s.c.writeHex(header{typ: ZFIN}); err != nil {
+			return err
+		}
+		h, err := s.c.readHeader(ctx, s.cfg.Timeout)
+		if err == errTimeout || err == errFormat {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if h.typ == ZFIN {
+			_, err := s.c.p.Write([]byte("OO"))
+			return err
+		}
+	}
+	return ErrRetries
+}
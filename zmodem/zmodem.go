@@ -0,0 +1,539 @@
+// Package zmodem transfers files with ZMODEM: data streams without waiting
+// for acknowledgements, errors are recovered by resuming from the last
+// good position, and an interrupted transfer can be resumed.
+package zmodem
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"hash/crc32"
+	"io"
+	"time"
+
+	"github.com/packing/xserial"
+	"github.com/packing/xserial/checksum"
+)
+
+// Frame Characters
+const (
+	ZPAD   = '*'
+	ZDLE   = 0x18
+	ZBIN   = 'A'
+	ZHEX   = 'B'
+	ZBIN32 = 'C'
+)
+
+// Header Types
+const (
+	ZRQINIT    = 0
+	ZRINIT     = 1
+	ZSINIT     = 2
+	ZACK       = 3
+	ZFILE      = 4
+	ZSKIP      = 5
+	ZNAK       = 6
+	ZABORT     = 7
+	ZFIN       = 8
+	ZRPOS      = 9
+	ZDATA      = 10
+	ZEOF       = 11
+	ZFERR      = 12
+	ZCRC       = 13
+	ZCHALLENGE = 14
+	ZCOMPL     = 15
+	ZCAN       = 16
+	ZFREECNT   = 17
+	ZCOMMAND   = 18
+)
+
+// Data Subpacket Ends
+const (
+	// Frame ends, a header follows
+	ZCRCE = 'h'
+	// Frame continues without acknowledgement
+	ZCRCG = 'i'
+	// Frame continues, ZACK expected
+	ZCRCQ = 'j'
+	// Frame ends, ZACK expected
+	ZCRCW = 'k'
+)
+
+// ZRINIT Capabilities in ZF0
+const (
+	CANFDX  = 0x01
+	CANOVIO = 0x02
+	CANBRK  = 0x04
+	CANFC32 = 0x20
+	ESCCTL  = 0x40
+	ESC8    = 0x80
+)
+
+// ZFILE Conversion Options in ZF0
+const (
+	ZCBIN    = 1
+	ZCNL     = 2
+	ZCRESUM  = 3
+	zrub0    = 'l'
+	zrub1    = 'm'
+	frameEnd = 0x100
+)
+
+var (
+	// ErrCanceled - the other end cancelled the transfer
+	ErrCanceled = errors.New("zmodem: transfer cancelled by peer")
+	// ErrRetries - the transfer failed more often than Retries allows
+	ErrRetries = errors.New("zmodem: too many errors")
+	// ErrNoResponse - the other end never started the transfer
+	ErrNoResponse = errors.New("zmodem: no response")
+	// ErrSkip - returned by a Receive open function to skip the file
+	ErrSkip = errors.New("zmodem: skip file")
+	// ErrHeader - a ZFILE header could not be read
+	ErrHeader = errors.New("zmodem: invalid file header")
+
+	errTimeout = errors.New("zmodem: timeout")
+	errCRC     = errors.New("zmodem: bad crc")
+	errFormat  = errors.New("zmodem: bad frame")
+)
+
+// Config configures a transfer
+type Config struct {
+	// How long to wait for each response, defaults to 10 seconds
+	Timeout time.Duration
+	// Errors tolerated before giving up, defaults to 10; negative for none
+	Retries int
+	// Bytes per data subpacket sent, defaults to 1024; at most 8192
+	Subpacket int
+	// Escape all control characters, for links that eat some of them. A
+	// receiver asks its sender to do the same.
+	EscapeControl bool
+	// Optional - Called after each data subpacket with the bytes of f done
+	// so far
+	OnProgress func(f *File, done int64)
+}
+
+func (c *Config) defaults() Config {
+	var cfg Config
+	if c != nil {
+		cfg = *c
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	if cfg.Retries < 0 {
+		cfg.Retries = 0
+	} else if cfg.Retries == 0 {
+		cfg.Retries = 10
+	}
+	if cfg.Subpacket <= 0 {
+		cfg.Subpacket = 1024
+	} else if cfg.Subpacket > 8192 {
+		cfg.Subpacket = 8192
+	}
+	return cfg
+}
+
+func (c *Config) progress(f *File, done int64) {
+	if c.OnProgress != nil {
+		c.OnProgress(f, done)
+	}
+}
+
+// File describes a file of a transfer
+type File struct {
+	// As sent, the receiver should not trust it as a path
+	Name string
+	// Length in bytes, -1 when unknown
+	Size int64
+	// Zero when unknown
+	ModTime time.Time
+	// Unix permission bits, zero when unknown
+	Mode uint32
+	// Ask the receiver to append to a partial copy left by an interrupted
+	// transfer
+	Resume bool
+	// Contents, read by Send. Seeking resumes data after errors.
+	Data io.ReadSeeker
+}
+
+// retries counts errors at a position, starting again once the other end
+// gets further
+type retries struct {
+	max int
+	n   int
+	bad int64
+}
+
+func (r *retries) fail(pos int64) error {
+	if pos > r.bad {
+		r.n, r.bad = 0, pos
+	}
+	if r.n++; r.n > r.max {
+		return ErrRetries
+	}
+	return nil
+}
+
+// header is a frame header: ZP0..ZP3 hold a position, least significant
+// first, or the flags ZF3..ZF0
+type header struct {
+	typ byte
+	d   [4]byte
+}
+
+func posHeader(typ byte, pos int64) header {
+	return header{typ, [4]byte{byte(pos), byte(pos >> 8), byte(pos >> 16), byte(pos >> 24)}}
+}
+
+func (h header) pos() int64 {
+	return int64(h.d[0]) | int64(h.d[1])<<8 | int64(h.d[2])<<16 | int64(h.d[3])<<24
+}
+
+// zf0 returns the first flags byte
+func (h header) zf0() byte {
+	return h.d[3]
+}
+
+// conn frames and reads ZMODEM on a Port
+type conn struct {
+	p   xserial.Port
+	buf []byte
+	r   int
+	n   int
+	// Port cannot be polled, so streaming is not interrupted
+	blocking bool
+	// Escape all control characters sent
+	escCtl bool
+	// Last header received was ZBIN32, so its subpackets carry CRC-32
+	crc32 bool
+	// Last byte sent, for escaping CR after @
+	last byte
+}
+
+func newConn(p xserial.Port) *conn {
+	return &conn{p: p, buf: make([]byte, 4096), blocking: !pollable(p)}
+}
+
+// pollable reports whether xserial.Select can wait on p
+func pollable(p xserial.Port) bool {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := xserial.Select(ctx, p)
+	return err != xserial.ErrNotPollable
+}
+
+// fill reads more input, returning errTimeout after timeout
+func (c *conn) fill(ctx context.Context, timeout time.Duration) error {
+	tctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	for {
+		if err := tctx.Err(); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return errTimeout
+		}
+		n, err := xserial.ReadContext(tctx, c.p, c.buf)
+		if n > 0 {
+			c.r, c.n = 0, n
+			return nil
+		}
+		if err != nil && err != xserial.ErrReadTimeout && tctx.Err() == nil {
+			return err
+		}
+	}
+}
+
+// ready reports without blocking whether a header may be arriving,
+// dropping other input such as the XON after hex headers
+func (c *conn) ready(ctx context.Context) bool {
+	for {
+		for ; c.r < c.n; c.r++ {
+			if b := c.buf[c.r]; b == ZPAD || b == ZDLE {
+				return true
+			}
+		}
+		if q, ok := xserial.As[xserial.QueueReporter](c.p); ok {
+			if in, _, err := q.Queued(); err == nil && in == 0 {
+				return false
+			}
+		} else if c.blocking {
+			return false
+		}
+		if c.fill(ctx, time.Millisecond) != nil {
+			return false
+		}
+	}
+}
+
+func (c *conn) readByte(ctx context.Context, timeout time.Duration) (byte, error) {
+	if c.r == c.n {
+		if err := c.fill(ctx, timeout); err != nil {
+			return 0, err
+		}
+	}
+	b := c.buf[c.r]
+	c.r++
+	return b, nil
+}
+
+// readEscaped returns the next byte of a binary header or subpacket, with
+// frameEnd set for subpacket ends
+func (c *conn) readEscaped(ctx context.Context, timeout time.Duration) (int, error) {
+	for {
+		b, err := c.readByte(ctx, timeout)
+		if err != nil {
+			return 0, err
+		}
+		switch b {
+		case 0x11, 0x13, 0x91, 0x93:
+			// Flow Control inserted by the Link
+			continue
+		case ZDLE:
+		default:
+			return int(b), nil
+		}
+		cans := 1
+	escaped:
+		b, err = c.readByte(ctx, timeout)
+		if err != nil {
+			return 0, err
+		}
+		switch b {
+		case ZDLE:
+			// Five CANs cancel the Transfer
+			if cans++; cans >= 5 {
+				return 0, ErrCanceled
+			}
+			goto escaped
+		case ZCRCE, ZCRCG, ZCRCQ, ZCRCW:
+			return int(b) | frameEnd, nil
+		case zrub0:
+			return 0x7F, nil
+		case zrub1:
+			return 0xFF, nil
+		case 0x11, 0x13, 0x91, 0x93:
+			goto escaped
+		}
+		if b&0x60 != 0x40 {
+			return 0, errFormat
+		}
+		return int(b ^ 0x40), nil
+	}
+}
+
+// readHeader hunts for the next header, skipping other input
+func (c *conn) readHeader(ctx context.Context, timeout time.Duration) (header, error) {
+	var h header
+	cans := 0
+	for {
+		b, err := c.readByte(ctx, timeout)
+		if err != nil {
+			return h, err
+		}
+		if b == ZDLE {
+			if cans++; cans >= 5 {
+				return h, ErrCanceled
+			}
+		} else {
+			cans = 0
+		}
+		if b != ZPAD {
+			// A Sender streaming Data can be a long way ahead of its
+			// next Header
+			continue
+		}
+		for b == ZPAD {
+			if b, err = c.readByte(ctx, timeout); err != nil {
+				return h, err
+			}
+		}
+		if b != ZDLE {
+			continue
+		}
+		if b, err = c.readByte(ctx, timeout); err != nil {
+			return h, err
+		}
+		switch b {
+		case ZHEX:
+			h, err = c.readHex(ctx, timeout)
+		case ZBIN, ZBIN32:
+			h, err = c.readBinary(ctx, timeout, b == ZBIN32)
+		default:
+			continue
+		}
+		if err == errCRC || err == errFormat {
+			continue
+		}
+		return h, err
+	}
+}
+
+func (c *conn) readHex(ctx context.Context, timeout time.Duration) (header, error) {
+	var h header
+	raw := make([]byte, 14)
+	for i := range raw {
+		b, err := c.readByte(ctx, timeout)
+		if err != nil {
+			return h, err
+		}
+		raw[i] = b &^ 0x80
+	}
+	var v [7]byte
+	if _, err := hex.Decode(v[:], raw); err != nil {
+		return h, errFormat
+	}
+	if checksum.XModem.Checksum(v[:5]) != uint16(v[5])<<8|uint16(v[6]) {
+		return h, errCRC
+	}
+	// Throw away CR LF, XON is skipped with the Garbage
+	if b, err := c.readByte(ctx, timeout); err == nil && b&^0x80 == '\r' {
+		c.readByte(ctx, timeout)
+	}
+	h.typ = v[0]
+	copy(h.d[:], v[1:5])
+	c.crc32 = false
+	return h, nil
+}
+
+func (c *conn) readBinary(ctx context.Context, timeout time.Duration, long bool) (header, error) {
+	var h header
+	n := 7
+	if long {
+		n = 9
+	}
+	v := make([]byte, n)
+	for i := range v {
+		b, err := c.readEscaped(ctx, timeout)
+		if err != nil {
+			return h, err
+		}
+		if b&frameEnd != 0 {
+			return h, errFormat
+		}
+		v[i] = byte(b)
+	}
+	if !checkCRC(v[:5], v[5:], long) {
+		return h, errCRC
+	}
+	h.typ = v[0]
+	copy(h.d[:], v[1:5])
+	c.crc32 = long
+	return h, nil
+}
+
+// readSubpacket returns the data of the next subpacket and how it ends
+func (c *conn) readSubpacket(ctx context.Context, timeout time.Duration) ([]byte, byte, error) {
+	var data []byte
+	for {
+		b, err := c.readEscaped(ctx, timeout)
+		if err != nil {
+			return nil, 0, err
+		}
+		if b&frameEnd == 0 {
+			if len(data) >= 8192 {
+				return nil, 0, errFormat
+			}
+			data = append(data, byte(b))
+			continue
+		}
+		end := byte(b)
+		n := 2
+		if c.crc32 {
+			n = 4
+		}
+		crc := make([]byte, n)
+		for i := range crc {
+			v, err := c.readEscaped(ctx, timeout)
+			if err != nil {
+				return nil, 0, err
+			}
+			if v&frameEnd != 0 {
+				return nil, 0, errFormat
+			}
+			crc[i] = byte(v)
+		}
+		if !checkCRC(append(data, end), crc, c.crc32) {
+			return nil, 0, errCRC
+		}
+		return data, end, nil
+	}
+}
+
+// checkCRC checks CRC-16 sent most significant byte first, or with long
+// CRC-32 sent least significant first
+func checkCRC(data, crc []byte, long bool) bool {
+	if long {
+		return crc32.ChecksumIEEE(data) == uint32(crc[0])|uint32(crc[1])<<8|uint32(crc[2])<<16|uint32(crc[3])<<24
+	}
+	return checksum.XModem.Checksum(data) == uint16(crc[0])<<8|uint16(crc[1])
+}
+
+// escape appends b to out with ZDLE escapes
+func (c *conn) escape(out []byte, b ...byte) []byte {
+	for _, v := range b {
+		switch {
+		case v == ZDLE, v&0x7F == 0x10, v&0x7F == 0x11, v&0x7F == 0x13:
+		case v&0x7F == '\r' && c.last&0x7F == '@':
+			// Telenet takes CR after @ as a Command
+		case c.escCtl && v&0x60 == 0:
+		default:
+			out = append(out, v)
+			c.last = v
+			continue
+		}
+		out = append(out, ZDLE, v^0x40)
+		c.last = v ^ 0x40
+	}
+	return out
+}
+
+// appendCRC appends the escaped CRC of data to out
+func (c *conn) appendCRC(out, data []byte, long bool) []byte {
+	if long {
+		v := crc32.ChecksumIEEE(data)
+		return c.escape(out, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+	}
+	v := checksum.XModem.Checksum(data)
+	return c.escape(out, byte(v>>8), byte(v))
+}
+
+// writeHex sends a hex header, as used before the link is known
+func (c *conn) writeHex(h header) error {
+	v := append([]byte{h.typ}, h.d[:]...)
+	crc := checksum.XModem.Checksum(v)
+	v = append(v, byte(crc>>8), byte(crc))
+	out := []byte{ZPAD, ZPAD, ZDLE, ZHEX}
+	out = append(out, hex.EncodeToString(v)...)
+	out = append(out, '\r', 0x8A)
+	if h.typ != ZFIN && h.typ != ZACK {
+		out = append(out, 0x11)
+	}
+	c.last = 0
+	_, err := c.p.Write(out)
+	return err
+}
+
+// binary appends a binary header to out
+func (c *conn) binary(out []byte, h header, long bool) []byte {
+	format := byte(ZBIN)
+	if long {
+		format = ZBIN32
+	}
+	out = append(out, ZPAD, ZDLE, format)
+	v := append([]byte{h.typ}, h.d[:]...)
+	out = c.escape(out, v...)
+	return c.appendCRC(out, v, long)
+}
+
+// subpacket appends a data subpacket to out
+func (c *conn) subpacket(out, data []byte, end byte, long bool) []byte {
+	out = c.escape(out, data...)
+	out = append(out, ZDLE, end)
+	return c.appendCRC(out, append(append([]byte(nil), data...), end), long)
+}
+
+// cancel aborts the transfer at the other end
+func (c *conn) cancel() {
+	c.p.Write([]byte{ZDLE, ZDLE, ZDLE, ZDLE, ZDLE, ZDLE, ZDLE, ZDLE, 8, 8, 8, 8, 8, 8, 8, 8, 8, 8})
+}